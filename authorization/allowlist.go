@@ -0,0 +1,68 @@
+package authorization
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// AllowListEntry allows a requester to compute the listed programs on the listed
+// datasets; an empty list allows all of them.
+type AllowListEntry struct {
+	Requester string   `json:"requester"`
+	Programs  []string `json:"programs"`
+	Datasets  []string `json:"datasets"`
+}
+
+// AllowList is an authorizer given by a static list of allowed computations.
+type AllowList struct {
+	entries []AllowListEntry
+}
+
+// LoadAllowList reads the allow-list from a JSON file with a list of entries.
+func LoadAllowList(file string) (*AllowList, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AllowListEntry
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AllowList{entries: entries}, nil
+}
+
+func (l *AllowList) Authorize(req Request) (Decision, string) {
+	for _, e := range l.entries {
+		if e.Requester != req.Requester || !contains(e.Programs, req.Program) {
+			continue
+		}
+		allowed := true
+		for _, data := range req.Datasets {
+			if !contains(e.Datasets, data) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return Allow, ""
+		}
+	}
+
+	return Deny, "requester " + req.Requester + " not allowed to compute " + req.Program + " on the datasets"
+}
+
+// contains returns true if the list is empty or includes the value.
+func contains(list []string, val string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, e := range list {
+		if e == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package authorization
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Decision is the outcome of an authorization check.
+type Decision int
+
+const (
+	Allow Decision = iota
+	Deny
+	Pending
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	case Pending:
+		return "pending"
+	}
+	return "unknown"
+}
+
+// Request describes a computation that the manager, an MPC node or a data provider
+// is asked to take part in.
+type Request struct {
+	Requester string
+	Program   string
+	Datasets  []string
+	Params    string
+	Voucher   string
+}
+
+// Authorizer decides if a computation may be executed. The reason explains a decision
+// other than Allow.
+type Authorizer interface {
+	Authorize(req Request) (decision Decision, reason string)
+}
+
+// AllowAll is the authorizer used when no authorization is configured.
+type AllowAll struct{}
+
+func (AllowAll) Authorize(req Request) (Decision, string) {
+	return Allow, ""
+}
+
+// Chain allows a computation only if all of its authorizers allow it.
+type Chain []Authorizer
+
+func (c Chain) Authorize(req Request) (Decision, string) {
+	pending := ""
+	for _, a := range c {
+		decision, reason := a.Authorize(req)
+		switch decision {
+		case Deny:
+			return Deny, reason
+		case Pending:
+			pending = reason
+		}
+	}
+	if pending != "" {
+		return Pending, pending
+	}
+	return Allow, ""
+}
+
+// New returns the authorizer given by a comma separated list of specifications
+// "allowlist:<file>" and "voucher:<certificate or public key file>". An empty
+// specification allows everything.
+func New(spec string) (Authorizer, error) {
+	if spec == "" {
+		return AllowAll{}, nil
+	}
+
+	var chain Chain
+	for _, e := range strings.Split(spec, ",") {
+		parts := strings.SplitN(e, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid authorizer %s", e)
+		}
+		switch parts[0] {
+		case "allowlist":
+			a, err := LoadAllowList(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, a)
+		case "voucher":
+			a, err := LoadVoucherVerifier(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, a)
+		default:
+			return nil, fmt.Errorf("unknown authorizer %s", parts[0])
+		}
+	}
+
+	return chain, nil
+}
+
+// Wait asks the authorizer until the decision is not Pending or the timeout passes,
+// in which case the computation is denied.
+func Wait(a Authorizer, req Request, interval, timeout time.Duration) (Decision, string) {
+	return WaitCancel(a, req, interval, timeout, nil)
+}
+
+// WaitCancel is Wait that also denies the computation once cancel is closed, for example
+// when the computation is cancelled or the service stops.
+func WaitCancel(a Authorizer, req Request, interval, timeout time.Duration, cancel <-chan struct{}) (Decision,
+	string) {
+	deadline := time.Now().Add(timeout)
+	for {
+		decision, reason := a.Authorize(req)
+		if decision != Pending {
+			return decision, reason
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return Deny, "approval timed out: " + reason
+		}
+		select {
+		case <-time.After(interval):
+		case <-cancel:
+			return Deny, "approval cancelled: " + reason
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package authorization_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/krakenh2020/MPCService/authorization"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowList(t *testing.T) {
+	f, err := ioutil.TempFile("", "allowlist*.json")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"requester": "analyst", "programs": ["avg", "stats"]},
+		{"requester": "auditor", "datasets": ["framingham_heart_study_dataset1.csv"]}]`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	a, err := authorization.New("allowlist:" + f.Name())
+	assert.NoError(t, err)
+
+	decision, _ := a.Authorize(authorization.Request{Requester: "analyst", Program: "avg",
+		Datasets: []string{"breast_cancer_dataset.csv"}})
+	assert.Equal(t, authorization.Allow, decision)
+	decision, _ = a.Authorize(authorization.Request{Requester: "analyst", Program: "k-means"})
+	assert.Equal(t, authorization.Deny, decision)
+	decision, _ = a.Authorize(authorization.Request{Requester: "auditor", Program: "k-means",
+		Datasets: []string{"breast_cancer_dataset.csv"}})
+	assert.Equal(t, authorization.Deny, decision)
+}
+
+func TestVoucher(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	verifier := authorization.NewVoucherVerifier(&key.PublicKey)
+
+	v := authorization.Voucher{Requester: "buyer", Program: "max",
+		Datasets: []string{"framingham_heart_study_dataset1.csv"}, Expires: time.Now().Add(time.Hour).Unix()}
+	token, err := authorization.SignVoucher(v, key)
+	assert.NoError(t, err)
+
+	req := authorization.Request{Requester: "buyer", Program: "max",
+		Datasets: []string{"framingham_heart_study_dataset1.csv"}, Voucher: token}
+	decision, reason := verifier.Authorize(req)
+	assert.Equal(t, authorization.Allow, decision, reason)
+
+	req.Program = "stats"
+	decision, _ = verifier.Authorize(req)
+	assert.Equal(t, authorization.Deny, decision)
+
+	// voucher signed by an untrusted key
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	req.Program = "max"
+	req.Voucher, err = authorization.SignVoucher(v, otherKey)
+	assert.NoError(t, err)
+	decision, _ = verifier.Authorize(req)
+	assert.Equal(t, authorization.Deny, decision)
+
+	// voucher that is not yet valid
+	v.NotBefore = time.Now().Add(time.Hour).Unix()
+	req.Voucher, err = authorization.SignVoucher(v, key)
+	assert.NoError(t, err)
+	decision, _ = verifier.Authorize(req)
+	assert.Equal(t, authorization.Pending, decision)
+	decision, _ = authorization.Wait(verifier, req, 10*time.Millisecond, 30*time.Millisecond)
+	assert.Equal(t, authorization.Deny, decision)
+	cancel := make(chan struct{})
+	close(cancel)
+	decision, reason = authorization.WaitCancel(verifier, req, time.Hour, 2*time.Hour, cancel)
+	assert.Equal(t, authorization.Deny, decision)
+	assert.Contains(t, reason, "approval cancelled")
+
+	// vouchers must expire, even if their issuer signs them without SignVoucher
+	v.NotBefore, v.Expires = 0, 0
+	_, err = authorization.SignVoucher(v, key)
+	assert.Error(t, err)
+	payload, err := json.Marshal(v)
+	assert.NoError(t, err)
+	hash := sha256.Sum256(payload)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	assert.NoError(t, err)
+	req.Voucher = base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig)
+	decision, reason = verifier.Authorize(req)
+	assert.Equal(t, authorization.Deny, decision)
+	assert.Equal(t, "voucher without expiry", reason)
+}
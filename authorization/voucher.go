@@ -0,0 +1,152 @@
+package authorization
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Voucher grants a requester the right to compute a program on the listed datasets,
+// for example after paying for it in a data market. A voucher is valid from NotBefore
+// until Expires, unix times; a voucher must expire, vouchers without Expires are refused.
+// An empty list of datasets allows all of them.
+type Voucher struct {
+	Requester string   `json:"requester"`
+	Program   string   `json:"program"`
+	Datasets  []string `json:"datasets"`
+	NotBefore int64    `json:"not_before"`
+	Expires   int64    `json:"expires"`
+}
+
+// SignVoucher encodes the voucher and signs it with the key of the issuer. The token
+// has the form base64(voucher).base64(signature). The voucher must expire.
+func SignVoucher(v Voucher, key *rsa.PrivateKey) (string, error) {
+	if v.Expires == 0 {
+		return "", fmt.Errorf("voucher without expiry")
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(payload)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VoucherVerifier is an authorizer allowing computations with a voucher signed by a
+// trusted issuer.
+type VoucherVerifier struct {
+	issuer *rsa.PublicKey
+}
+
+func NewVoucherVerifier(issuer *rsa.PublicKey) *VoucherVerifier {
+	return &VoucherVerifier{issuer: issuer}
+}
+
+// LoadVoucherVerifier reads the trusted key of the issuer from a PEM certificate or
+// public key file.
+func LoadVoucherVerifier(file string) (*VoucherVerifier, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key of the voucher issuer is not an RSA key")
+	}
+
+	return NewVoucherVerifier(rsaKey), nil
+}
+
+// Verify checks the signature of the token and returns the voucher in it.
+func (vv *VoucherVerifier) Verify(token string) (*Voucher, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed voucher")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(payload)
+	err = rsa.VerifyPKCS1v15(vv.issuer, crypto.SHA256, hash[:], sig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature of the voucher")
+	}
+
+	var v Voucher
+	err = json.Unmarshal(payload, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+func (vv *VoucherVerifier) Authorize(req Request) (Decision, string) {
+	if req.Voucher == "" {
+		return Deny, "voucher required"
+	}
+	v, err := vv.Verify(req.Voucher)
+	if err != nil {
+		return Deny, err.Error()
+	}
+
+	if v.Requester != req.Requester || v.Program != req.Program {
+		return Deny, "voucher not issued for this requester and program"
+	}
+	for _, data := range req.Datasets {
+		if !contains(v.Datasets, data) {
+			return Deny, "voucher not issued for dataset " + data
+		}
+	}
+
+	now := time.Now().Unix()
+	if v.Expires == 0 {
+		return Deny, "voucher without expiry"
+	}
+	if now > v.Expires {
+		return Deny, "voucher expired"
+	}
+	if now < v.NotBefore {
+		return Pending, "voucher not yet valid"
+	}
+
+	return Allow, ""
+}
@@ -0,0 +1,384 @@
+// Package client drives the MPC service through the REST API of the manager: it lists the
+// nodes and datasets, requests computations, waits for them and decrypts and joins the
+// shares of the results returned by the MPC nodes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/data_provider"
+	"github.com/krakenh2020/MPCService/key_management"
+	"github.com/krakenh2020/MPCService/manager"
+)
+
+// Client is a client of the manager's REST API at Addr, for example
+// "http://localhost:5000". If Token is set, it is sent as the requester's API token.
+type Client struct {
+	Addr         string
+	Token        string
+	HTTPClient   *http.Client
+	PollInterval time.Duration
+}
+
+// Accepted is the response of the manager to a computation request.
+type Accepted struct {
+	JobId     string `json:"job_id"`
+	Nodes     string `json:"nodes"`
+	Selection string `json:"selection"`
+}
+
+// Keypair is the key of the receiver of the results; the MPC nodes encrypt their shares of
+// the results with PubKey.
+type Keypair struct {
+	PubKey []byte `json:"pub_key"`
+	SecKey []byte `json:"sec_key"`
+}
+
+// Result is the joined result of a computation; Values are laid out as described by
+// data_management.ResultsToCsvText. Cheaters are the nodes whose shares were wrong and
+// were corrected when joining them. Inputs are the inputs the result was computed on.
+type Result struct {
+	JobId    string    `json:"job_id"`
+	Program  string    `json:"program"`
+	Cols     []string  `json:"cols"`
+	Values   []float64 `json:"values"`
+	Cheaters []string  `json:"cheaters,omitempty"`
+	Inputs   []Input   `json:"inputs,omitempty"`
+}
+
+// Input is an input of a computation: the dataset, its version, the commitment of its data
+// and the commitments of the shares of the nodes, in the order of the nodes, see
+// data_management.InputCommitment.
+type Input struct {
+	Dataset    string   `json:"dataset"`
+	Version    string   `json:"version,omitempty"`
+	Commitment string   `json:"commitment,omitempty"`
+	Shares     []string `json:"shares"`
+}
+
+// APIError is an error returned by the manager.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("manager returned %d: %s", e.Status, e.Message)
+}
+
+// New returns a client of the manager at addr using the default HTTP client. The HTTP
+// client can be replaced, for example to authenticate with a client certificate.
+func New(addr, token string) *Client {
+	return &Client{Addr: strings.TrimSuffix(addr, "/"), Token: token, HTTPClient: http.DefaultClient,
+		PollInterval: 2 * time.Second}
+}
+
+// GenerateKeypair returns a new key of the receiver of results.
+func GenerateKeypair() Keypair {
+	pubKey, secKey := key_management.GenerateKeypair()
+	return Keypair{PubKey: pubKey, SecKey: secKey}
+}
+
+// do sends a request to the manager and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body *bytes.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Addr+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 300 {
+		apiErr := &APIError{Status: res.StatusCode, Message: strings.TrimSpace(string(b))}
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error != "" {
+			apiErr.Message = e.Error
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+// Nodes returns the MPC nodes connected to the manager.
+func (c *Client) Nodes(ctx context.Context) ([]manager.MPCNode, error) {
+	var nodes []manager.MPCNode
+	err := c.do(ctx, "GET", "/nodes", nil, &nodes)
+	return nodes, err
+}
+
+// Datasets returns the datasets offered for computations.
+func (c *Client) Datasets(ctx context.Context) ([]data_provider.Dataset, error) {
+	var datasets []data_provider.Dataset
+	err := c.do(ctx, "GET", "/datasets", nil, &datasets)
+	return datasets, err
+}
+
+// Submit requests a computation whose results are encrypted for pubKey and returns the
+// id of its job.
+func (c *Client) Submit(ctx context.Context, req manager.ComputationRequest, pubKey []byte) (Accepted, error) {
+	req.ReceiverPubKey = base64.StdEncoding.EncodeToString(pubKey)
+	var accepted Accepted
+	err := c.do(ctx, "POST", "/compute", req, &accepted)
+	return accepted, err
+}
+
+// Job returns the state of the job.
+func (c *Client) Job(ctx context.Context, id string) (manager.Job, error) {
+	var job manager.Job
+	err := c.do(ctx, "GET", "/jobs/"+id, nil, &job)
+	return job, err
+}
+
+// Wait polls the job until it is finished. A failed job is returned together with an
+// error.
+func (c *Client) Wait(ctx context.Context, id string) (manager.Job, error) {
+	ticker := time.NewTicker(c.PollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil {
+			return job, err
+		}
+		switch job.State {
+		case manager.JobDone:
+			return job, nil
+		case manager.JobFailed:
+			return job, fmt.Errorf("computation failed: %s", job.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fetch returns the decrypted and joined results of a finished job. Nodes found returning
+// wrong shares are reported to the manager; if the report fails, the result is returned
+// together with the error.
+func (c *Client) Fetch(ctx context.Context, id string, key Keypair) (*Result, error) {
+	job, err := c.Job(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.State != manager.JobDone {
+		if job.State == manager.JobFailed {
+			return nil, fmt.Errorf("computation failed: %s", job.Error)
+		}
+		return nil, fmt.Errorf("computation not finished, it is %s", job.State)
+	}
+
+	res, err := Decrypt(job, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Cheaters) > 0 {
+		err = c.ReportCheaters(ctx, id, res.Cheaters)
+		if err != nil {
+			return res, fmt.Errorf("reporting nodes %s failed: %v", strings.Join(res.Cheaters, ","), err)
+		}
+	}
+
+	return res, nil
+}
+
+// ReportCheaters records in the job the nodes that returned wrong shares of its results.
+func (c *Client) ReportCheaters(ctx context.Context, id string, nodes []string) error {
+	return c.do(ctx, "POST", "/jobs/"+id+"/cheaters", manager.CheatersReport{Nodes: nodes}, nil)
+}
+
+// Compute requests a computation with a fresh receiver key, waits for it and returns its
+// results.
+func (c *Client) Compute(ctx context.Context, req manager.ComputationRequest) (*Result, error) {
+	key := GenerateKeypair()
+	accepted, err := c.Submit(ctx, req, key.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.Wait(ctx, accepted.JobId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Fetch(ctx, accepted.JobId, key)
+}
+
+// Decrypt decrypts the shares of the results of the job returned by the MPC nodes and
+// joins them with the protocol of the job, Shamir sharing if it is empty, correcting wrong
+// shares if there are enough nodes for the threshold of the job; the largest threshold
+// allowed is used if it is 0. The values are decoded with the fixed point parameters of the
+// job. A node that failed, returned a result that cannot be decrypted or returned other
+// columns or another number of shares than most nodes is left out of the reconstruction
+// and reported among the cheaters with the nodes whose shares were found wrong. It fails
+// if the nodes left out and the wrong shares are too many to reconstruct the results, or
+// the nodes report different inputs.
+func Decrypt(job manager.Job, key Keypair) (*Result, error) {
+	results := job.Results
+	protocol := job.Protocol
+	if protocol == "" {
+		protocol = data_management.ProtocolShamir
+	}
+	threshold := job.Threshold
+	if threshold == 0 {
+		threshold = data_management.ProtocolThreshold(protocol, len(results))
+	}
+	err := data_management.CheckProtocol(protocol, len(results), threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([][]*big.Int, len(results))
+	var failed []string
+	cols := make([]string, 0, len(results))
+	for _, e := range results {
+		if e.Error == "" {
+			cols = append(cols, e.Cols)
+		}
+	}
+	common := majority(cols)
+	lengths := make([]string, 0, len(results))
+	for i, e := range results {
+		switch {
+		case e.Error != "":
+			failed = append(failed, fmt.Sprintf("%s failed: %s", nodeName(job, i), e.Error))
+		case e.Cols != common:
+			failed = append(failed, fmt.Sprintf("%s returned results for other columns", nodeName(job, i)))
+		default:
+			shares[i], err = data_management.DecVec(e.Result, key.PubKey, key.SecKey)
+			if err != nil {
+				shares[i] = nil
+				failed = append(failed, fmt.Sprintf("decrypting the result of %s: %v", nodeName(job, i), err))
+				continue
+			}
+			lengths = append(lengths, strconv.Itoa(len(shares[i])))
+		}
+	}
+	length := majority(lengths)
+	for i := range shares {
+		if shares[i] != nil && strconv.Itoa(len(shares[i])) != length {
+			shares[i] = nil
+			failed = append(failed, fmt.Sprintf("%s returned results of another length", nodeName(job, i)))
+		}
+	}
+
+	values, cheaters, err := data_management.JoinSharesFloat(shares, protocol, threshold, job.FixedPoint.OrDefault())
+	if err != nil && len(failed) > 0 {
+		return nil, fmt.Errorf("%v, %s", err, strings.Join(failed, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{JobId: job.Id, Program: job.Program, Values: values}
+	for _, i := range cheaters {
+		res.Cheaters = append(res.Cheaters, nodeName(job, i))
+	}
+	if common != "" {
+		res.Cols = strings.Split(common, ",")
+	}
+	res.Inputs, err = joinInputs(results, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// nodeName returns the name of the i-th node of the job.
+func nodeName(job manager.Job, i int) string {
+	if i < len(job.Nodes) {
+		return job.Nodes[i].Name
+	}
+	return fmt.Sprint("node ", i)
+}
+
+// majority returns the value given most often, the first of them if several are.
+func majority(vals []string) string {
+	counts := make(map[string]int)
+	res := ""
+	for _, e := range vals {
+		counts[e]++
+		if counts[e] > counts[res] {
+			res = e
+		}
+	}
+	return res
+}
+
+// joinInputs joins the commitments of the inputs returned by the nodes whose shares of
+// the results were joined, the ones that are not nil.
+func joinInputs(results []manager.ReturnMsg, shares [][]*big.Int) ([]Input, error) {
+	var inputs []Input
+	first := true
+	for i, e := range results {
+		if shares[i] == nil {
+			continue
+		}
+		if first {
+			for _, in := range e.Inputs {
+				inputs = append(inputs, Input{Dataset: in.Dataset, Version: in.Version, Commitment: in.Commitment,
+					Shares: make([]string, len(results))})
+			}
+			first = false
+		}
+		if len(e.Inputs) != len(inputs) {
+			return nil, fmt.Errorf("nodes returned results for different inputs")
+		}
+		for j, in := range e.Inputs {
+			if in.Dataset != inputs[j].Dataset || in.Version != inputs[j].Version ||
+				in.Commitment != inputs[j].Commitment {
+				return nil, fmt.Errorf("nodes returned results for different inputs")
+			}
+			inputs[j].Shares[i] = in.Share
+		}
+	}
+	return inputs, nil
+}
+
+// CSV formats the result as the GUI does.
+func (r *Result) CSV() (string, error) {
+	return data_management.ResultsToCsvText(r.Values, r.Cols, r.Program)
+}
@@ -0,0 +1,199 @@
+package client_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krakenh2020/MPCService/client"
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/key_management"
+	"github.com/krakenh2020/MPCService/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeManager answers like a manager whose n nodes compute the maximum 3.5 of column age;
+// if tamper is set, the second node returns a wrong share. The reported cheaters are sent
+// to the channel.
+func fakeManager(t *testing.T, n int, tamper bool, reported chan<- []string) *httptest.Server {
+	var job manager.Job
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		b, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		var req manager.ComputationRequest
+		assert.NoError(t, json.Unmarshal(b, &req))
+		if req.Program != "max" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error": "program not supported"}`))
+			return
+		}
+		pubKey, err := base64.StdEncoding.DecodeString(req.ReceiverPubKey)
+		assert.NoError(t, err)
+
+		val, err := data_management.FloatToFixInt(3.5)
+		assert.NoError(t, err)
+		shares, err := data_management.CreateSharesShamirN([]*big.Int{big.NewInt(val)}, n, 1)
+		assert.NoError(t, err)
+		if tamper {
+			shares[1][0].Add(shares[1][0], big.NewInt(1))
+		}
+		job = manager.Job{Id: "job1", Program: req.Program, State: manager.JobRunning, Threshold: 1}
+		for i := 0; i < n; i++ {
+			job.Nodes = append(job.Nodes, manager.NodeProgress{Name: fmt.Sprint("node", i)})
+			enc, err := data_management.EncryptVec(shares[i], pubKey)
+			assert.NoError(t, err)
+			job.Results = append(job.Results, manager.ReturnMsg{Result: enc, Cols: "age",
+				Inputs: []data_management.InputCommitment{{Dataset: "data", Version: "v1", Commitment: "c",
+					Share: fmt.Sprint("s", i)}}})
+		}
+		_, _ = w.Write([]byte(`{"job_id": "job1"}`))
+	})
+	mux.HandleFunc("/jobs/job1", func(w http.ResponseWriter, r *http.Request) {
+		// the job is finished at the second poll
+		ret := job
+		if job.State == manager.JobRunning {
+			ret.Results = nil
+			job.State = manager.JobDone
+		}
+		b, err := json.Marshal(ret)
+		assert.NoError(t, err)
+		_, _ = w.Write(b)
+	})
+	mux.HandleFunc("/jobs/job1/cheaters", func(w http.ResponseWriter, r *http.Request) {
+		var report manager.CheatersReport
+		b, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(b, &report))
+		reported <- report.Nodes
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestCompute(t *testing.T) {
+	server := fakeManager(t, 3, false, nil)
+	defer server.Close()
+
+	c := client.New(server.URL, "token")
+	c.PollInterval = 10 * time.Millisecond
+	res, err := c.Compute(context.Background(), manager.ComputationRequest{Program: "max",
+		DatasetNames: "framingham_heart_study_dataset1.csv"})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{3.5}, res.Values)
+	assert.Equal(t, []string{"age"}, res.Cols)
+	assert.Equal(t, []client.Input{{Dataset: "data", Version: "v1", Commitment: "c", Shares: []string{"s0", "s1", "s2"}}},
+		res.Inputs)
+	csv, err := res.CSV()
+	assert.NoError(t, err)
+	assert.Equal(t, ",age\r\nmax value,3.5\r\n", csv)
+
+	_, err = c.Compute(context.Background(), manager.ComputationRequest{Program: "unknown"})
+	apiErr, ok := err.(*client.APIError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Status)
+	assert.Equal(t, "program not supported", apiErr.Message)
+}
+
+func TestInconsistentShares(t *testing.T) {
+	server := fakeManager(t, 3, true, nil)
+	defer server.Close()
+
+	c := client.New(server.URL, "token")
+	c.PollInterval = 10 * time.Millisecond
+	_, err := c.Compute(context.Background(), manager.ComputationRequest{Program: "max"})
+	assert.Error(t, err)
+}
+
+func TestCheaterIdentification(t *testing.T) {
+	reported := make(chan []string, 1)
+	server := fakeManager(t, 5, true, reported)
+	defer server.Close()
+
+	c := client.New(server.URL, "token")
+	c.PollInterval = 10 * time.Millisecond
+	res, err := c.Compute(context.Background(), manager.ComputationRequest{Program: "max"})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{3.5}, res.Values)
+	assert.Equal(t, []string{"node1"}, res.Cheaters)
+	assert.Equal(t, []string{"node1"}, <-reported)
+}
+
+func TestDifferentInputs(t *testing.T) {
+	pubKey, secKey := key_management.GenerateKeypair()
+	val, err := data_management.FloatToFixInt(3.5)
+	assert.NoError(t, err)
+	shares, err := data_management.CreateSharesShamirN([]*big.Int{big.NewInt(val)}, 3, 1)
+	assert.NoError(t, err)
+	job := manager.Job{Id: "job1", Program: "max", State: manager.JobDone, Threshold: 1}
+	for i := 0; i < 3; i++ {
+		enc, err := data_management.EncryptVec(shares[i], pubKey)
+		assert.NoError(t, err)
+		job.Results = append(job.Results, manager.ReturnMsg{Result: enc, Cols: "age",
+			Inputs: []data_management.InputCommitment{{Dataset: "data", Commitment: "c", Share: fmt.Sprint("s", i)}}})
+	}
+	key := client.Keypair{PubKey: pubKey, SecKey: secKey}
+	res, err := client.Decrypt(job, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", res.Inputs[0].Commitment)
+
+	// the nodes must report the same commitments of the data
+	job.Results[2].Inputs[0].Commitment = "d"
+	_, err = client.Decrypt(job, key)
+	assert.Error(t, err)
+	job.Results[2].Inputs = nil
+	_, err = client.Decrypt(job, key)
+	assert.Error(t, err)
+}
+
+func TestFailedNodes(t *testing.T) {
+	pubKey, secKey := key_management.GenerateKeypair()
+	val, err := data_management.FloatToFixInt(3.5)
+	assert.NoError(t, err)
+	shares, err := data_management.CreateSharesShamirN([]*big.Int{big.NewInt(val), big.NewInt(val)}, 5, 1)
+	assert.NoError(t, err)
+	job := manager.Job{Id: "job1", Program: "max", State: manager.JobDone, Threshold: 1}
+	for i := 0; i < 5; i++ {
+		enc, err := data_management.EncryptVec(shares[i], pubKey)
+		assert.NoError(t, err)
+		job.Nodes = append(job.Nodes, manager.NodeProgress{Name: fmt.Sprint("node", i)})
+		job.Results = append(job.Results, manager.ReturnMsg{Result: enc, Cols: "age,bmi",
+			Inputs: []data_management.InputCommitment{{Dataset: "data", Commitment: "c", Share: fmt.Sprint("s", i)}}})
+	}
+	key := client.Keypair{PubKey: pubKey, SecKey: secKey}
+
+	// the nodes that failed or returned unusable results are left out and reported
+	job.Results[0] = manager.ReturnMsg{Error: "scale crashed"}
+	job.Results[2].Result = "garbage"
+	job.Results[4].Cols = "age"
+	res, err := client.Decrypt(job, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{3.5, 3.5}, res.Values)
+	assert.Equal(t, []string{"age", "bmi"}, res.Cols)
+	assert.Equal(t, []string{"node0", "node2", "node4"}, res.Cheaters)
+	assert.Equal(t, []string{"", "s1", "", "s3", ""}, res.Inputs[0].Shares)
+
+	// a node returning another number of shares is left out too
+	job.Results[4].Cols = "age,bmi"
+	enc, err := data_management.EncryptVec(shares[4][:1], pubKey)
+	assert.NoError(t, err)
+	job.Results[4].Result = enc
+	res, err = client.Decrypt(job, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"node0", "node2", "node4"}, res.Cheaters)
+
+	// the results cannot be reconstructed from a single node
+	job.Results[1].Error = "timeout"
+	_, err = client.Decrypt(job, key)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "node1 failed: timeout")
+}
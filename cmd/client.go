@@ -0,0 +1,507 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/krakenh2020/MPCService/client"
+	"github.com/krakenh2020/MPCService/config"
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/key_management"
+	"github.com/krakenh2020/MPCService/manager"
+	"github.com/urfave/cli"
+)
+
+var ClientCmd = cli.Command{
+	Name:  "client",
+	Usage: "A client requesting computations from the manager",
+	Subcommands: cli.Commands{
+		cli.Command{
+			Name:  "nodes",
+			Usage: "Lists the MPC nodes connected to the manager",
+			Flags: clientFlags,
+			Action: func(ctx *cli.Context) error {
+				c, err := newClient(ctx)
+				if err != nil {
+					return err
+				}
+				nodes, err := c.Nodes(context.Background())
+				if err != nil {
+					return err
+				}
+				return printJSON(nodes)
+			},
+		},
+		cli.Command{
+			Name:  "datasets",
+			Usage: "Lists the datasets offered for computations",
+			Flags: clientFlags,
+			Action: func(ctx *cli.Context) error {
+				c, err := newClient(ctx)
+				if err != nil {
+					return err
+				}
+				datasets, err := c.Datasets(context.Background())
+				if err != nil {
+					return err
+				}
+				return printJSON(datasets)
+			},
+		},
+		cli.Command{
+			Name:   "compute",
+			Usage:  "Requests a computation and writes its decrypted results",
+			Flags:  append(append([]cli.Flag{}, clientFlags...), computeFlags...),
+			Action: compute,
+		},
+		cli.Command{
+			Name:      "status",
+			Usage:     "Shows the state of a job",
+			ArgsUsage: "JOB_ID",
+			Flags:     clientFlags,
+			Action: func(ctx *cli.Context) error {
+				c, err := newClient(ctx)
+				if err != nil {
+					return err
+				}
+				if ctx.NArg() != 1 {
+					return fmt.Errorf("job id required")
+				}
+				job, err := c.Job(context.Background(), ctx.Args().First())
+				if err != nil {
+					return err
+				}
+				job.Results = nil
+				return printJSON(job)
+			},
+		},
+		cli.Command{
+			Name:      "split",
+			Usage:     "Splits a csv file into a streamed share file for MPC nodes, to be offered with a link",
+			ArgsUsage: "CSV_FILE",
+			Flags:     append(append([]cli.Flag{}, clientFlags...), splitFlags...),
+			Action:    split,
+		},
+		cli.Command{
+			Name:      "fetch",
+			Usage:     "Writes the decrypted results of a job started with compute -detach",
+			ArgsUsage: "JOB_ID",
+			Flags:     append(append([]cli.Flag{}, clientFlags...), outputFlags...),
+			Action: func(ctx *cli.Context) error {
+				c, err := newClient(ctx)
+				if err != nil {
+					return err
+				}
+				if ctx.NArg() != 1 {
+					return fmt.Errorf("job id required")
+				}
+				id := ctx.Args().First()
+				keyFile := ctx.String("keyFile")
+				if keyFile == "" {
+					keyFile = id + ".key"
+				}
+				key, err := loadKeypair(keyFile)
+				if err != nil {
+					return err
+				}
+				return fetch(ctx, c, id, key)
+			},
+		},
+	},
+}
+
+// clientFlags are the flags used by all the client commands.
+var clientFlags = []cli.Flag{
+	// manager indicates the URL of the GUI port of the manager.
+	&cli.StringFlag{
+		Name:  "manager",
+		Value: config.LoadManagerURL(),
+		Usage: "`URL` of the manager's GUI port",
+	},
+	// token indicates the API token of the requester.
+	&cli.StringFlag{
+		Name:   "token",
+		Value:  config.LoadToken(),
+		Usage:  "API token of the requester",
+		EnvVar: "MPC_TOKEN",
+	},
+	// certLocation indicates the location of the RootCA certificate trusted for an https manager.
+	&cli.StringFlag{
+		Name:  "certLocation",
+		Value: config.LoadCertLocation(),
+		Usage: "location of the RootCA certificate of the manager",
+	},
+}
+
+// outputFlags are the flags of the commands writing results.
+var outputFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "format",
+		Value: "csv",
+		Usage: "format of the results: csv or json",
+	},
+	&cli.StringFlag{
+		Name:  "out",
+		Usage: "`FILE` where the results are written; if empty they are written to the standard output",
+	},
+	// keyFile indicates where the key decrypting the results of a detached computation is saved.
+	&cli.StringFlag{
+		Name:  "keyFile",
+		Usage: "`FILE` with the key decrypting the results of a detached computation, JOB_ID.key by default",
+	},
+}
+
+// computeFlags are the flags of the compute command.
+var computeFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "program",
+		Usage: "program to compute, for example avg, max, stats or k-means",
+	},
+	&cli.StringFlag{
+		Name:  "datasets",
+		Usage: "comma separated names of the datasets",
+	},
+	&cli.StringFlag{
+		Name:  "cols",
+		Usage: "comma separated columns to compute on",
+	},
+	&cli.StringFlag{
+		Name:  "params",
+		Usage: `parameters of the program as a JSON object, for example {"NUM_CLUSTERS": "3"}`,
+	},
+	&cli.StringFlag{
+		Name:  "nodes",
+		Usage: "comma separated names of the MPC nodes; if empty the manager chooses them",
+	},
+	// protocol, numNodes and threshold indicate the sharing of the computation; if they are
+	// not given, the defaults of the manager are used.
+	&cli.StringFlag{
+		Name:  "protocol",
+		Usage: "MPC protocol: shamir, replicated or full-threshold; shamir by default",
+	},
+	&cli.IntFlag{
+		Name:  "numNodes",
+		Usage: "number of MPC nodes the manager chooses, 3 by default",
+	},
+	&cli.IntFlag{
+		Name:  "threshold",
+		Usage: "threshold of the sharing; if 0, the largest one the protocol allows for the number of nodes",
+	},
+	&cli.StringFlag{
+		Name:  "backend",
+		Usage: "MPC backend of the nodes; if empty the manager chooses nodes with the same backend",
+	},
+	// fixK and fixF indicate the fixed point representation of the values; if they are not
+	// given, the one of the datasets or the default one is used.
+	&cli.IntFlag{
+		Name:  "fixK",
+		Usage: "number of bits k of the fixed point values, which must be below 2^(k-f-1)",
+	},
+	&cli.IntFlag{
+		Name:  "fixF",
+		Usage: "number of fractional bits f of the fixed point values",
+	},
+	&cli.StringFlag{
+		Name:  "requireNodes",
+		Usage: "comma separated names of nodes the manager must choose",
+	},
+	&cli.StringFlag{
+		Name:  "excludeNodes",
+		Usage: "comma separated names of nodes the manager must not choose",
+	},
+	&cli.StringFlag{
+		Name:  "voucher",
+		Usage: "voucher proving that the computation may be requested",
+	},
+	// detach makes compute return the job id at once; the results are read with fetch.
+	&cli.BoolFlag{
+		Name:  "detach",
+		Usage: "print the job id without waiting for the results, which are read with fetch",
+	},
+}, outputFlags...)
+
+// splitFlags are the flags of the split command.
+var splitFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "nodes",
+		Usage: "comma separated names of the MPC nodes receiving the shares, in the order of the computations",
+	},
+	&cli.StringFlag{
+		Name:  "out",
+		Usage: "`FILE` where the share file is written, CSV_FILE with the extension .shares by default",
+	},
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "name of the dataset the shares are made for, the base name of CSV_FILE by default",
+	},
+	&cli.StringFlag{
+		Name:  "version",
+		Usage: "version of the dataset the shares are made for",
+	},
+	&cli.StringFlag{
+		Name:  "protocol",
+		Usage: "MPC protocol the shares are made for: shamir, replicated or full-threshold; shamir by default",
+	},
+	&cli.IntFlag{
+		Name:  "threshold",
+		Usage: "threshold of the sharing; if 0, the largest one the protocol allows for the number of nodes",
+	},
+	&cli.IntFlag{
+		Name:  "fixK",
+		Usage: "number of bits k of the fixed point values, which must be below 2^(k-f-1)",
+	},
+	&cli.IntFlag{
+		Name:  "fixF",
+		Usage: "number of fractional bits f of the fixed point values",
+	},
+	// signingKey indicates the key signing the share file; its public key is given as the
+	// signer_key of the dataset.
+	&cli.StringFlag{
+		Name:  "signingKey",
+		Usage: "`FILE` with the base64 encoded Ed25519 key signing the share file; if empty, a fresh key is used",
+	},
+	// cert indicates the certificate of the provider endorsing the signing key, so that the
+	// nodes can verify the origin of the shares.
+	&cli.StringFlag{
+		Name:  "cert",
+		Usage: "`NAME` of the certificate and key in certLocation endorsing the signing key",
+	},
+	// nonce indicates the nonce of the commitment of the data, which is kept for auditors.
+	&cli.StringFlag{
+		Name:  "nonce",
+		Usage: "hex encoded nonce of the commitment of the data; if empty, a fresh one is chosen and printed",
+	},
+}
+
+// split writes the shares of a csv file for the MPC nodes connected to the manager and
+// prints the public key signing them. The nonce of the commitment of the data is printed
+// to the standard error, to be kept for auditors.
+func split(ctx *cli.Context) error {
+	c, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("csv file required")
+	}
+	if ctx.String("nodes") == "" {
+		return fmt.Errorf("nodes required")
+	}
+	nodes, err := c.Nodes(context.Background())
+	if err != nil {
+		return err
+	}
+	names := strings.Split(ctx.String("nodes"), ",")
+	pubKeys := make([][]byte, len(names))
+	for i, name := range names {
+		for _, e := range nodes {
+			if e.Name == name {
+				pubKeys[i] = e.MpcPubKey
+			}
+		}
+		if pubKeys[i] == nil {
+			return fmt.Errorf("node %s not connected to the manager", name)
+		}
+	}
+
+	name := ctx.String("name")
+	if name == "" {
+		name = filepath.Base(ctx.Args().First())
+	}
+	opts := data_management.ShareFileOptions{DatasetId: name, DatasetVersion: ctx.String("version"),
+		NodesNames: names, Protocol: ctx.String("protocol"), Threshold: ctx.Int("threshold"),
+		FixedPoint: data_management.FixedPoint{K: ctx.Int("fixK"), F: ctx.Int("fixF")}}
+	if ctx.String("nonce") != "" {
+		opts.CommitmentNonce, err = hex.DecodeString(ctx.String("nonce"))
+		if err != nil {
+			return fmt.Errorf("nonce is not hex encoded: %v", err)
+		}
+	} else {
+		opts.CommitmentNonce, err = data_management.NewNonce()
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.String("cert") != "" {
+		opts.Cert, opts.CertKey, err = key_management.LoadCertKey(ctx.String("certLocation"), ctx.String("cert"))
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.String("signingKey") != "" {
+		b, err := ioutil.ReadFile(ctx.String("signingKey"))
+		if err != nil {
+			return err
+		}
+		opts.SigningKey, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil {
+			return fmt.Errorf("signing key is not base64 encoded: %v", err)
+		}
+	}
+	out := ctx.String("out")
+	if out == "" {
+		out = strings.TrimSuffix(ctx.Args().First(), filepath.Ext(ctx.Args().First())) + ".shares"
+	}
+
+	header, err := data_management.SplitCsvFileStream(ctx.Args().First(), out, pubKeys, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Wrote", header.Rows, "rows of", strings.Join(header.Cols, ","), "to", out)
+	fmt.Fprintln(os.Stderr, "Sharing of the shares:", header.Sharing)
+	fmt.Fprintln(os.Stderr, "Commitment of the data:", header.Commitment)
+	fmt.Fprintln(os.Stderr, "Nonce of the commitment, to keep for auditors:", hex.EncodeToString(opts.CommitmentNonce))
+	fmt.Println(base64.StdEncoding.EncodeToString(header.SignerKey))
+	return nil
+}
+
+func compute(ctx *cli.Context) error {
+	c, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{}
+	if ctx.String("params") != "" {
+		err = json.Unmarshal([]byte(ctx.String("params")), &params)
+		if err != nil {
+			return fmt.Errorf("params are not a JSON object of strings: %v", err)
+		}
+	}
+	if ctx.String("cols") != "" {
+		params["cols"] = ctx.String("cols")
+	}
+	paramsBytes, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req := manager.ComputationRequest{NodesNames: ctx.String("nodes"), Program: ctx.String("program"),
+		DatasetNames: ctx.String("datasets"), Params: string(paramsBytes), Voucher: ctx.String("voucher"),
+		RequireNodes: ctx.String("requireNodes"), ExcludeNodes: ctx.String("excludeNodes"),
+		NumNodes: ctx.Int("numNodes"), Protocol: ctx.String("protocol"), Threshold: ctx.Int("threshold"),
+		FixedPoint: data_management.FixedPoint{K: ctx.Int("fixK"), F: ctx.Int("fixF")}, Backend: ctx.String("backend")}
+
+	key := client.GenerateKeypair()
+	accepted, err := c.Submit(context.Background(), req, key.PubKey)
+	if err != nil {
+		return err
+	}
+	if accepted.Selection != "" {
+		fmt.Fprintln(os.Stderr, "Nodes", accepted.Selection)
+	}
+
+	if ctx.Bool("detach") {
+		keyFile := ctx.String("keyFile")
+		if keyFile == "" {
+			keyFile = accepted.JobId + ".key"
+		}
+		err = saveKeypair(keyFile, key)
+		if err != nil {
+			return err
+		}
+		fmt.Println(accepted.JobId)
+		return nil
+	}
+
+	_, err = c.Wait(context.Background(), accepted.JobId)
+	if err != nil {
+		return err
+	}
+	return fetch(ctx, c, accepted.JobId, key)
+}
+
+// fetch writes the results of the job; the nodes that returned wrong shares are printed
+// together with the results corrected without them.
+func fetch(ctx *cli.Context, c *client.Client, id string, key client.Keypair) error {
+	res, err := c.Fetch(context.Background(), id, key)
+	if res == nil {
+		return err
+	}
+	if len(res.Cheaters) > 0 {
+		fmt.Fprintln(os.Stderr, "Nodes", strings.Join(res.Cheaters, ","), "returned wrong shares, the results were corrected")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return writeResult(ctx, res)
+}
+
+// newClient returns a client of the manager given by the flags; an https manager is
+// trusted if its certificate is signed by the RootCA.
+func newClient(ctx *cli.Context) (*client.Client, error) {
+	c := client.New(ctx.String("manager"), ctx.String("token"))
+	if strings.HasPrefix(ctx.String("manager"), "https://") {
+		caCert, err := ioutil.ReadFile(ctx.String("certLocation") + "/RootCA.crt")
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		c.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	return c, nil
+}
+
+func saveKeypair(file string, key client.Keypair) error {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+func loadKeypair(file string) (client.Keypair, error) {
+	var key client.Keypair
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return key, err
+	}
+	err = json.Unmarshal(b, &key)
+	return key, err
+}
+
+// writeResult writes the result in the format given by the flags.
+func writeResult(ctx *cli.Context, res *client.Result) error {
+	var text string
+	switch ctx.String("format") {
+	case "csv":
+		var err error
+		text, err = res.CSV()
+		if err != nil {
+			return err
+		}
+	case "json":
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		text = string(b) + "\n"
+	default:
+		return fmt.Errorf("unknown format %s", ctx.String("format"))
+	}
+
+	if ctx.String("out") == "" {
+		_, err := fmt.Print(text)
+		return err
+	}
+	return ioutil.WriteFile(ctx.String("out"), []byte(text), 0644)
+}
+
+func printJSON(val interface{}) error {
+	b, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/krakenh2020/MPCService/client"
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// outputContext returns the context of a command writing results with the flags format and
+// out.
+func outputContext(format, out string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("format", format, "")
+	set.String("out", out, "")
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestWriteResult(t *testing.T) {
+	res := &client.Result{JobId: "job1", Program: "max", Values: []float64{3.5, 70}, Cols: []string{"bmi", "age"},
+		Cheaters: []string{"node2"}}
+	csv, err := res.CSV()
+	assert.NoError(t, err)
+	b, err := json.MarshalIndent(res, "", "  ")
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		format string
+		text   string
+		err    string
+	}{
+		{format: "csv", text: csv},
+		{format: "json", text: string(b) + "\n"},
+		{format: "xml", err: "unknown format xml"},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			out := t.TempDir() + "/result"
+			err := writeResult(outputContext(test.format, out), res)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				_, err = os.Stat(out)
+				assert.True(t, os.IsNotExist(err))
+				return
+			}
+			assert.NoError(t, err)
+			text, err := ioutil.ReadFile(out)
+			assert.NoError(t, err)
+			assert.Equal(t, test.text, string(text))
+		})
+	}
+
+	// the json results are read back
+	out := t.TempDir() + "/result.json"
+	assert.NoError(t, writeResult(outputContext("json", out), res))
+	text, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	var read client.Result
+	assert.NoError(t, json.Unmarshal(text, &read))
+	assert.Equal(t, *res, read)
+}
+
+func TestKeypairFile(t *testing.T) {
+	file := t.TempDir() + "/job1.key"
+	key := client.GenerateKeypair()
+	assert.NoError(t, saveKeypair(file, key))
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := loadKeypair(file)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	_, err = loadKeypair(t.TempDir() + "/missing.key")
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, ioutil.WriteFile(file, []byte("not a key"), 0600))
+	_, err = loadKeypair(file)
+	assert.Error(t, err)
+}
+
+// detachedManager answers like a manager whose 3 nodes compute the maximum 3.5 of column
+// age, finished as soon as it is requested.
+func detachedManager(t *testing.T) *httptest.Server {
+	var job manager.Job
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute", func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		var req manager.ComputationRequest
+		assert.NoError(t, json.Unmarshal(b, &req))
+		pubKey, err := base64.StdEncoding.DecodeString(req.ReceiverPubKey)
+		assert.NoError(t, err)
+
+		val, err := data_management.FloatToFixInt(3.5)
+		assert.NoError(t, err)
+		shares, err := data_management.CreateSharesShamirN([]*big.Int{big.NewInt(val)}, 3, 1)
+		assert.NoError(t, err)
+		job = manager.Job{Id: "job1", Program: req.Program, State: manager.JobDone, Threshold: 1}
+		for i := range shares {
+			job.Nodes = append(job.Nodes, manager.NodeProgress{Name: fmt.Sprint("node", i)})
+			enc, err := data_management.EncryptVec(shares[i], pubKey)
+			assert.NoError(t, err)
+			job.Results = append(job.Results, manager.ReturnMsg{Result: enc, Cols: "age"})
+		}
+		_, _ = w.Write([]byte(`{"job_id": "job1"}`))
+	})
+	mux.HandleFunc("/jobs/job1", func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.Marshal(job)
+		assert.NoError(t, err)
+		_, _ = w.Write(b)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestComputeDetachFetch(t *testing.T) {
+	server := detachedManager(t)
+	defer server.Close()
+	dir := t.TempDir()
+	app := cli.NewApp()
+	app.Commands = []cli.Command{ClientCmd}
+
+	// the key of a detached computation is saved for fetch
+	err := app.Run([]string{"MPCService", "client", "compute", "-manager", server.URL, "-program", "max",
+		"-datasets", "data", "-detach", "-keyFile", dir + "/job1.key"})
+	assert.NoError(t, err)
+	_, err = loadKeypair(dir + "/job1.key")
+	assert.NoError(t, err)
+
+	err = app.Run([]string{"MPCService", "client", "fetch", "-manager", server.URL, "-keyFile", dir + "/job1.key",
+		"-format", "json", "-out", dir + "/result.json", "job1"})
+	assert.NoError(t, err)
+	b, err := ioutil.ReadFile(dir + "/result.json")
+	assert.NoError(t, err)
+	var res client.Result
+	assert.NoError(t, json.Unmarshal(b, &res))
+	assert.Equal(t, []float64{3.5}, res.Values)
+	assert.Equal(t, []string{"age"}, res.Cols)
+
+	// the results cannot be fetched without the key
+	err = app.Run([]string{"MPCService", "client", "fetch", "-manager", server.URL, "-keyFile", dir + "/other.key",
+		"job1"})
+	assert.Error(t, err)
+}
@@ -24,6 +24,7 @@ var DataProviderCMD = cli.Command{
 					ctx.String("manAddr"),
 					ctx.String("certLocation"),
 					strings.Split(ctx.String("shareWith"), ","),
+					ctx.String("authorizer"),
 				)
 				return nil
 			},
@@ -71,4 +72,10 @@ var dataProviderFlags = []cli.Flag{
 		Value: config.LoadShareWith(),
 		Usage: "location of the certificate",
 	},
+	// authorizer indicates how requests for computations are approved.
+	&cli.StringFlag{
+		Name:  "authorizer",
+		Value: config.LoadAuthorizer(),
+		Usage: "approval of computations: comma separated allowlist:FILE and voucher:CERT; if empty all are allowed",
+	},
 }
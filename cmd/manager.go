@@ -16,10 +16,41 @@ var ManagerCmd = cli.Command{
 			Flags: mangerFlags,
 			Action: func(ctx *cli.Context) error {
 				manager.RunManager(ctx.Int("guiPort"), ctx.Int("managerPort"), ctx.String("assets"), ctx.String("logLevel"),
-					ctx.String("logFile"), ctx.String("certLocation"))
+					ctx.String("logFile"), ctx.String("certLocation"), ctx.Bool("guiTLS") || config.LoadGuiTLS(),
+					ctx.String("authFile"), ctx.String("authorizer"), ctx.String("store"))
 				return nil
 			},
 		},
+		cli.Command{
+			Name:  "export",
+			Usage: "Exports the saved state of the manager to a file",
+			Flags: stateFlags,
+			Action: func(ctx *cli.Context) error {
+				return manager.ExportState(ctx.String("store"), ctx.String("file"))
+			},
+		},
+		cli.Command{
+			Name:  "import",
+			Usage: "Imports a file written by export into the saved state of a stopped manager",
+			Flags: stateFlags,
+			Action: func(ctx *cli.Context) error {
+				return manager.ImportState(ctx.String("store"), ctx.String("file"))
+			},
+		},
+	},
+}
+
+// stateFlags are the flags used by the export and import commands.
+var stateFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "store",
+		Value: config.LoadStore(),
+		Usage: "folder where the manager saves its state",
+	},
+	&cli.StringFlag{
+		Name:  "file",
+		Value: "manager_state.json",
+		Usage: "exported state",
 	},
 }
 
@@ -61,4 +92,27 @@ var mangerFlags = []cli.Flag{
 		Value: config.LoadCertLocation(),
 		Usage: "location of the certificate",
 	},
+	// guiTLS indicates if the GUI is served over TLS with the manager's certificate.
+	&cli.BoolFlag{
+		Name:  "guiTLS",
+		Usage: "serve the GUI over TLS with the certificate of the manager",
+	},
+	// authFile indicates the file listing the requesters allowed to use the GUI.
+	&cli.StringFlag{
+		Name:  "authFile",
+		Value: config.LoadAuthFile(),
+		Usage: "JSON file with requesters, their API tokens and roles; if empty everyone can use the GUI",
+	},
+	// authorizer indicates how requests for computations are approved.
+	&cli.StringFlag{
+		Name:  "authorizer",
+		Value: config.LoadAuthorizer(),
+		Usage: "approval of computations: comma separated allowlist:FILE and voucher:CERT; if empty all are allowed",
+	},
+	// store indicates the folder where link datasets, jobs and their history are saved.
+	&cli.StringFlag{
+		Name:  "store",
+		Value: config.LoadStore(),
+		Usage: "folder where the manager saves its state; if empty the state is lost on restart",
+	},
 }
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/krakenh2020/MPCService/config"
+	"github.com/krakenh2020/MPCService/mpc_engine"
 	"github.com/krakenh2020/MPCService/mpc_node"
 	"github.com/urfave/cli"
 )
@@ -23,7 +26,12 @@ var MpcNodeCmd = cli.Command{
 					ctx.String("logLevel"),
 					ctx.String("logFile"),
 					ctx.String("manAddr"),
-					ctx.String("description"))
+					ctx.String("description"),
+					ctx.String("authorizer"),
+					ctx.String("protocols"),
+					ctx.String("backend"),
+					ctx.String("providers"),
+					ctx.Bool("allowUnsigned") || config.LoadAllowUnsigned())
 				return nil
 			},
 		},
@@ -81,4 +89,33 @@ var mpcNodeFlags = []cli.Flag{
 		Value: config.LoadDescription(),
 		Usage: "Description of the MPC node",
 	},
+	// authorizer indicates how requests for computations are approved.
+	&cli.StringFlag{
+		Name:  "authorizer",
+		Value: config.LoadAuthorizer(),
+		Usage: "approval of computations: comma separated allowlist:FILE and voucher:CERT; if empty all are allowed",
+	},
+	// protocols indicates the sharings the SCALE-MAMBA setup of the node can evaluate.
+	&cli.StringFlag{
+		Name:  "protocols",
+		Value: config.LoadProtocols(),
+		Usage: "comma separated sharings the node can evaluate as protocol:parties:threshold, for example shamir:3:1",
+	},
+	// backend indicates the MPC engine evaluating the computations.
+	&cli.StringFlag{
+		Name:  "backend",
+		Value: config.LoadBackend(),
+		Usage: "MPC backend evaluating the computations, installed in sm: " + strings.Join(mpc_engine.Backends(), ", "),
+	},
+	// providers indicates the data providers whose shares the node uses.
+	&cli.StringFlag{
+		Name:  "providers",
+		Value: config.LoadProviders(),
+		Usage: "comma separated common names of the certificates of the data providers whose shares the node uses",
+	},
+	// allowUnsigned indicates if shares not signed by a provider are used.
+	&cli.BoolFlag{
+		Name:  "allowUnsigned",
+		Usage: "use shares of datasets without a provider that are not signed by a trusted provider",
+	},
 }
@@ -2,113 +2,99 @@ package computation
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var programs = []string{"avg", "max", "stats", "linear_regression", "k-means"}
-var allowedParams = map[string]bool{"COLS": true, "LEN": true, "NUM_CLUSTERS": true}
+// nodeParams are the parameters of all the functions, set by the nodes: the number of
+// values and of columns of the inputs, the number of parties giving private inputs and
+// the fixed point parameters, see PrepareMambaProgram.
+var nodeParams = []Param{
+	{Name: "LEN", Type: ParamInt, Min: 1, Max: math.MaxInt32},
+	{Name: "COLS", Type: ParamInt, Min: 1, Max: math.MaxInt32},
+	{Name: "INPUT_PARTIES", Type: ParamInt, Min: 0, Max: 1000, Default: "0"},
+	{Name: "FIX_K", Type: ParamInt, Min: 2, Max: 63, Default: "41"},
+	{Name: "FIX_F", Type: ParamInt, Min: 1, Max: 62, Default: "20"},
+}
 
-func PrepareMambaProgram(nodeId int, funcName string, paramsMap map[string]string, sm string) error {
-	var err error
-	check := true
-	for _, e := range programs {
-		if funcName == e {
-			check = false
-			break
-		}
-	}
-	if check {
+// withParams returns the parameters of a function accepting params besides nodeParams.
+func withParams(params ...Param) []Param {
+	return append(append([]Param{}, nodeParams...), params...)
+}
+
+// Functions are the MAMBA programs evaluated with SCALE, by name, with the parameters
+// they accept.
+var Functions = map[string]Function{
+	"avg":               {Params: withParams()},
+	"max":               {Params: withParams()},
+	"stats":             {Params: withParams()},
+	"linear_regression": {Params: withParams()},
+	"k-means": {Params: withParams(
+		Param{Name: "NUM_CLUSTERS", Type: ParamInt, Min: 1, Max: 100, Required: true})},
+}
+
+// CheckProgram returns an error if the function is not supported or the parameters are not
+// accepted by it, see Function.Check.
+func CheckProgram(funcName string, paramsMap map[string]string) error {
+	f, ok := Functions[funcName]
+	if !ok {
 		return fmt.Errorf("function not supported")
 	}
 
-	check = true
-	for key, element := range paramsMap {
-		if _, ok := allowedParams[key]; ok {
-			_, err = strconv.Atoi(element)
-			if err != nil {
-				check = false
-				break
-			}
-		} else {
-			check = false
-			break
-		}
-	}
-	if check == false {
-		return fmt.Errorf("parameters not supported")
-	}
+	return f.Check(paramsMap)
+}
 
-	// remove previous compiled program if there
-	log.Debug("Cleaning files.")
-	cmd := exec.Command("rm", "Programs/MPCService/node"+strconv.Itoa(nodeId)+
-		"/node"+strconv.Itoa(nodeId)+".mpc")
-	cmd.Dir = sm
-	_ = cmd.Run()
+// PrepareMambaProgram writes the parameters to the MAMBA program of the function, see
+// Function.Render, and compiles it. INPUT_PARTIES is the number of parties giving additive shares of the
+// inputs as private inputs, 0 by default, see input_output.set_input_parties. FIX_K and
+// FIX_F are the fixed point parameters of sfix, 41 and 20 by default, see
+// input_output.set_precision.
+func PrepareMambaProgram(nodeId int, funcName string, paramsMap map[string]string, sm string) error {
+	return PrepareMambaProgramIn(nodeId, funcName, paramsMap, sm, sm)
+}
+
+// PrepareMambaProgramIn is PrepareMambaProgram writing the program to the working
+// directory dir, for example a sandbox, and compiling it with SCALE installed in sm.
+func PrepareMambaProgramIn(nodeId int, funcName string, paramsMap map[string]string, sm, dir string) error {
+	err := CheckProgram(funcName, paramsMap)
+	if err != nil {
+		return err
+	}
 
 	// prepare the MAMBA program in the proper folder
-	cmd = exec.Command("/bin/sh", "-c", "cp  Programs/MPCService/functions/"+funcName+
-		".mpc Programs/MPCService/node"+strconv.Itoa(nodeId)+"/node"+strconv.Itoa(nodeId)+"new.mpc")
-	cmd.Dir = sm
-	if log.GetLevel() == log.DebugLevel {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	b, err := ioutil.ReadFile(sm + "/Programs/MPCService/functions/" + funcName + ".mpc")
+	if err != nil {
+		return err
 	}
-	err = cmd.Run()
+	program, err := filepath.Abs(dir + "/Programs/MPCService/node" + strconv.Itoa(nodeId))
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(program, 0700)
 	if err != nil {
 		return err
 	}
 
 	// write all the parameters to the MAMBA program
 	log.Debug("Setting parameters.")
-	s := "new"
-	for key, val := range paramsMap {
-		cmd = exec.Command("/bin/sh", "-c", "sed 's/"+key+"/"+val+
-			"/g' Programs/MPCService/node"+
-			strconv.Itoa(nodeId)+"/node"+strconv.Itoa(nodeId)+s+".mpc >> Programs/MPCService/node"+
-			strconv.Itoa(nodeId)+"/node"+strconv.Itoa(nodeId)+s+"new"+".mpc")
-		cmd.Dir = sm
-		if log.GetLevel() == log.DebugLevel {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		}
-		err = cmd.Run()
-		if err != nil {
-			return err
-		}
-
-		cmd = exec.Command("rm", "Programs/MPCService/node"+strconv.Itoa(nodeId)+
-			"/node"+strconv.Itoa(nodeId)+s+".mpc")
-		cmd.Dir = sm
-		_ = cmd.Run()
-
-		s = s + "new"
-	}
-
-	log.Debug("Setting the file.")
-	cmd = exec.Command("/bin/sh", "-c", "cp Programs/MPCService/node"+
-		strconv.Itoa(nodeId)+"/node"+strconv.Itoa(nodeId)+s+".mpc  Programs/MPCService/node"+
-		strconv.Itoa(nodeId)+"/node"+strconv.Itoa(nodeId)+".mpc")
-	cmd.Dir = sm
-	err = cmd.Run()
+	text, err := Functions[funcName].Render(string(b), paramsMap)
 	if err != nil {
 		return err
 	}
-
-	cmd = exec.Command("rm", "Programs/MPCService/node"+strconv.Itoa(nodeId)+
-		"/node"+strconv.Itoa(nodeId)+s+".mpc")
-	cmd.Dir = sm
-	err = cmd.Run()
+	err = ioutil.WriteFile(program+"/node"+strconv.Itoa(nodeId)+".mpc", []byte(text), 0600)
 	if err != nil {
 		return err
 	}
 
 	// compile the MAMBA program
 	log.Debug("Compiling.")
-	cmd = exec.Command("./compile.sh", "Programs/MPCService/node"+strconv.Itoa(nodeId))
+	cmd := exec.Command("./compile.sh", program)
 	cmd.Dir = sm
 	if log.GetLevel() == log.DebugLevel {
 		cmd.Stdout = os.Stdout
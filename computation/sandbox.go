@@ -0,0 +1,126 @@
+package computation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SandboxRoot is the folder in which the sandboxes of the jobs are created; if it is
+// empty, the default folder for temporary files is used.
+var SandboxRoot = ""
+
+// sandboxFolders are the folders of a working directory of SCALE used by a job.
+var sandboxFolders = []string{"Data", "Cert-Store", "Input", "Programs/MPCService"}
+
+// NewSandbox creates a working directory of SCALE for one job of the node, laid out as
+// SCALE installed in sm, and copies the set up of sm/Data to it, apart from the addresses
+// of the nodes. The job writes its set up, certificates, program and inputs to the
+// sandbox instead of sm with SetUpProtocolIn, SetUpScale, PrepareMambaProgramIn and
+// NewInputWriter, runs SCALE in it with RunPlayerIn and reads its results with
+// LoadResultShares, so that the jobs do not share files and SCALE can run for several
+// jobs at the same time, for example of the nodes on a host. The sandbox is removed with
+// RemoveSandbox.
+func NewSandbox(nodeId int, sm string) (string, error) {
+	dir, err := ioutil.TempDir(SandboxRoot, "scale-node"+strconv.Itoa(nodeId)+"-")
+	if err != nil {
+		return "", err
+	}
+	for _, folder := range sandboxFolders {
+		err = os.MkdirAll(dir+"/"+folder, 0700)
+		if err != nil {
+			RemoveSandbox(dir)
+			return "", err
+		}
+	}
+	err = copyFiles(sm+"/Data", dir+"/Data", "NetworkData.txt")
+	if err != nil {
+		RemoveSandbox(dir)
+		return "", err
+	}
+	log.Debug("Scale: sandbox ", dir, " created")
+
+	return dir, nil
+}
+
+// copyFiles copies the files of the folder src to the folder dst, apart from the ones
+// named skip.
+func copyFiles(src, dst string, skip ...string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || contains(skip, file.Name()) {
+			continue
+		}
+		b, err := ioutil.ReadFile(src + "/" + file.Name())
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(dst+"/"+file.Name(), b, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, e := range names {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveSandbox removes the sandbox of a job. The files, among them the private key of the
+// node and the shares of the inputs and of the results, are overwritten with zeros before
+// they are removed.
+func RemoveSandbox(dir string) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		return wipeFile(path, info.Size())
+	})
+	errRemove := os.RemoveAll(dir)
+	if err == nil {
+		err = errRemove
+	}
+	if err == nil {
+		log.Debug("Scale: sandbox ", dir, " removed")
+	}
+
+	return err
+}
+
+// wipeFile overwrites the size bytes of the file with zeros.
+func wipeFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, 32*1024)
+	for size > 0 {
+		n := int64(len(zeros))
+		if size < n {
+			n = size
+		}
+		_, err = f.Write(zeros[:n])
+		if err != nil {
+			f.Close()
+			return err
+		}
+		size -= n
+	}
+	err = f.Sync()
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
@@ -2,9 +2,11 @@ package computation
 
 import (
 	"bufio"
+	"fmt"
 	"math/big"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -13,43 +15,113 @@ import (
 )
 
 // InputPrepare is a helping function that loads the data shares provided to
-// the node for the MPC computation.
-func InputPrepare(nodeId int, shares, privateIn []*big.Int, sm string) error {
-	f, err := os.Create(sm + "/Input/input_shares" + strconv.Itoa(nodeId) + ".txt")
+// the node for the MPC computation. Each share of a value consists of width field
+// elements, written on one line. The private inputs are given to SCALE on the standard
+// input.
+func InputPrepare(nodeId int, shares []*big.Int, width int, privateIn []*big.Int, sm string) error {
+	w, err := NewInputWriter(nodeId, width, sm)
 	if err != nil {
 		return err
 	}
-
-	for j := 0; j < len(shares); j++ {
-		_, err = f.WriteString(strconv.Itoa(nodeId) + " " + shares[j].String() + "\n")
-		if err != nil {
-			return err
-		}
+	err = w.WriteShares(shares)
+	if err == nil {
+		err = w.WritePrivate(privateIn)
 	}
-	err = f.Close()
 	if err != nil {
+		w.Close()
 		return err
 	}
 
-	f2, err := os.Create(sm + "/Input/private_input" + strconv.Itoa(nodeId) + ".txt")
+	return w.Close()
+}
+
+// InputWriter writes the inputs of a node for SCALE as they are given, see InputPrepare.
+type InputWriter struct {
+	nodeId     int
+	width      int
+	shares     *os.File
+	private    *os.File
+	sharesBuf  *bufio.Writer
+	privateBuf *bufio.Writer
+	pending    []*big.Int
+	NumShares  int // number of shares of values written
+	NumPrivate int // number of private inputs written
+}
+
+// NewInputWriter creates the input files of the node for shares of width field elements.
+func NewInputWriter(nodeId int, width int, sm string) (*InputWriter, error) {
+	shares, err := os.Create(sm + "/Input/input_shares" + strconv.Itoa(nodeId) + ".txt")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	private, err := os.Create(privateInputFile(nodeId, sm))
+	if err != nil {
+		shares.Close()
+		return nil, err
+	}
+
+	return &InputWriter{nodeId: nodeId, width: width, shares: shares, private: private,
+		sharesBuf: bufio.NewWriter(shares), privateBuf: bufio.NewWriter(private)}, nil
+}
 
-	for j := 0; j < len(privateIn); j++ {
-		_, err = f2.WriteString(strconv.Itoa(nodeId) + " " + privateIn[j].String() + "\n")
+// WriteShares writes the shares, following the ones written before; an incomplete share
+// is kept until the rest of its field elements are written.
+func (w *InputWriter) WriteShares(shares []*big.Int) error {
+	prefix := strconv.Itoa(w.nodeId)
+	for _, e := range shares {
+		w.pending = append(w.pending, e)
+		if len(w.pending) < w.width {
+			continue
+		}
+		line := prefix
+		for _, e := range w.pending {
+			line = line + " " + e.String()
+		}
+		_, err := w.sharesBuf.WriteString(line + "\n")
 		if err != nil {
 			return err
 		}
+		w.pending = w.pending[:0]
+		w.NumShares++
 	}
-	err = f2.Close()
+	return nil
+}
 
+// WritePrivate writes the private inputs, following the ones written before.
+func (w *InputWriter) WritePrivate(privateIn []*big.Int) error {
+	for _, e := range privateIn {
+		_, err := w.privateBuf.WriteString(e.String() + "\n")
+		if err != nil {
+			return err
+		}
+		w.NumPrivate++
+	}
 	return nil
 }
 
+// Close writes the inputs to the files; the elements of an incomplete share are dropped.
+func (w *InputWriter) Close() error {
+	err := w.sharesBuf.Flush()
+	if errClose := w.shares.Close(); err == nil {
+		err = errClose
+	}
+	if errFlush := w.privateBuf.Flush(); err == nil {
+		err = errFlush
+	}
+	if errClose := w.private.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func privateInputFile(nodeId int, sm string) string {
+	return sm + "/Input/private_input" + strconv.Itoa(nodeId) + ".txt"
+}
+
 // LoadResultShares is a helping function that loads the result obtained by
-// MPC computation.
-func LoadResultShares(nodeId int, sm string) ([]*big.Int, error) {
+// MPC computation. Each share of a value consists of width field elements; the MACs
+// written after them by full threshold protocols are skipped.
+func LoadResultShares(nodeId int, sm string, width int) ([]*big.Int, error) {
 	f, err := os.Open(sm + "/Input/output_shares" + strconv.Itoa(nodeId) + ".txt")
 	if err != nil {
 		return nil, err
@@ -62,9 +134,14 @@ func LoadResultShares(nodeId int, sm string) ([]*big.Int, error) {
 	for scanner.Scan() {
 		countLines++
 		text := scanner.Text()
-		vals := strings.Split(text, " ")
-		val, _ := new(big.Int).SetString(vals[1], 10)
-		res = append(res, val)
+		vals := strings.Fields(text)
+		if len(vals) < width+1 {
+			return nil, fmt.Errorf("share %d has %d values, %d expected", countLines, len(vals)-1, width)
+		}
+		for _, e := range vals[1 : width+1] {
+			val, _ := new(big.Int).SetString(e, 10)
+			res = append(res, val)
+		}
 	}
 	if err := scanner.Err(); err != nil {
 		log.Fatal(err)
@@ -108,20 +185,57 @@ func RunScale(nodeId int, funcName string, paramsMap map[string]string, mpcPorts
 		return err
 	}
 
-	// start SCALE node that will prepare itself for future computation
-	cmdStr := "./Player.x " + strconv.Itoa(nodeId) + " -dOT -pns " + mpcPorts + " Programs/MPCService/node" + strconv.Itoa(nodeId)
+	return RunPlayer(nodeId, mpcPorts, sm)
+}
+
+// RunPlayer runs SCALE on the program previously prepared by PrepareMambaProgram.
+func RunPlayer(nodeId int, mpcPorts string, sm string) error {
+	return RunPlayerIn(nodeId, mpcPorts, sm, sm)
+}
 
-	cmd := exec.Command("bash", "-c", cmdStr)
-	cmd.Dir = sm
+// RunPlayerIn runs SCALE installed in sm in the working directory dir, for example a
+// sandbox, on the program previously prepared by PrepareMambaProgramIn.
+func RunPlayerIn(nodeId int, mpcPorts string, sm, dir string) error {
+	return RunPlayerInCancel(nodeId, mpcPorts, sm, dir, nil)
+}
+
+// RunPlayerInCancel runs SCALE like RunPlayerIn, killing the player if cancel is closed
+// before it finishes.
+func RunPlayerInCancel(nodeId int, mpcPorts string, sm, dir string, cancel <-chan struct{}) error {
+	player, err := filepath.Abs(sm + "/Player.x")
+	if err != nil {
+		return err
+	}
+	// start SCALE node that will prepare itself for future computation
+	cmd := exec.Command(player, strconv.Itoa(nodeId), "-dOT", "-pns", mpcPorts,
+		"Programs/MPCService/node"+strconv.Itoa(nodeId))
+	cmd.Dir = dir
+	// SCALE reads the private inputs of the node on the standard input
+	in, err := os.Open(privateInputFile(nodeId, dir))
+	if err == nil {
+		defer in.Close()
+		cmd.Stdin = in
+	}
 
 	if log.GetLevel() == log.DebugLevel {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	}
 
-	start = time.Now()
-	err = cmd.Run()
-	elapsed = time.Since(start)
+	start := time.Now()
+	err = cmd.Start()
+	if err == nil {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err = <-done:
+		case <-cancel:
+			_ = cmd.Process.Kill()
+			<-done
+			err = fmt.Errorf("computation cancelled")
+		}
+	}
+	elapsed := time.Since(start)
 	log.Info("Scale: computation took ", elapsed.Seconds(), " seconds")
 	if err != nil {
 		log.Error(err)
@@ -131,7 +245,32 @@ func RunScale(nodeId int, funcName string, paramsMap map[string]string, mpcPorts
 	return nil
 }
 
-// SetUpScale defines all the settings needed to start SCALE
+// SetUpProtocol selects the set up of SCALE for the protocol among n parties with
+// threshold t. A node supporting several protocols keeps the files created by SCALE's
+// Setup.x for each of them in the folder Setups/<protocol>-<n>-<t>, which are copied to
+// Data; if the folder does not exist, Data is expected to hold the set up.
+func SetUpProtocol(protocol string, n, t int, sm string) error {
+	return SetUpProtocolIn(protocol, n, t, sm, sm)
+}
+
+// SetUpProtocolIn is SetUpProtocol copying the set up to the working directory dir, for
+// example a sandbox.
+func SetUpProtocolIn(protocol string, n, t int, sm, dir string) error {
+	setup := sm + "/Setups/" + protocol + "-" + strconv.Itoa(n) + "-" + strconv.Itoa(t)
+	err := copyFiles(setup, dir+"/Data")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug("Scale: set up for ", protocol, " with ", n, " parties and threshold ", t)
+
+	return nil
+}
+
+// SetUpScale defines all the settings needed to start SCALE in the working directory sm,
+// SCALE's folder or a sandbox.
 func SetUpScale(nodeId int, nodeNames, nodesAddrs []string, sm string, scaleCerts [][]byte, certPrivate, certLoc string) error {
 	// set up addresses of all MPC nodes
 	w, err := os.Create(sm + "/Data/NetworkData.txt")
@@ -139,11 +278,11 @@ func SetUpScale(nodeId int, nodeNames, nodesAddrs []string, sm string, scaleCert
 		return err
 	}
 
-	_, err = w.Write([]byte("RootCA.crt\n3\n"))
+	_, err = w.Write([]byte("RootCA.crt\n" + strconv.Itoa(len(nodeNames)) + "\n"))
 	if err != nil {
 		return err
 	}
-	for i := 0; i < 3; i++ {
+	for i := range nodeNames {
 		iS := strconv.Itoa(i)
 		ip1S := strconv.Itoa(i + 1)
 		_, err = w.Write([]byte(iS + " " + nodesAddrs[i] + " Player" + ip1S + ".crt " + nodeNames[i] + "\n"))
@@ -161,7 +300,7 @@ func SetUpScale(nodeId int, nodeNames, nodesAddrs []string, sm string, scaleCert
 	}
 
 	// set up public certificates of all the MPC nodes
-	for i := 0; i < 3; i++ {
+	for i := range nodeNames {
 		w, err = os.Create(sm + "/Cert-Store/Player" + strconv.Itoa(i+1) + ".crt")
 		if err != nil {
 			return err
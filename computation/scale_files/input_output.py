@@ -1,6 +1,27 @@
 from Compiler.library import *
 
+# number of players giving the inputs as additive shares in their private inputs, which
+# is used by full threshold protocols since the players cannot input shares with MACs;
+# if 0, the inputs are shares of the protocol
+input_parties = 0
+
+def set_input_parties(n):
+    global input_parties
+    input_parties = n
+
+# the inputs and the outputs are fixed point numbers with f fractional bits of k bits,
+# which must match the representation of the data
+def set_precision(f, k):
+    sfix.set_precision(f, k)
+    cfix.set_precision(f, k)
+
 def load_sint():
+    if input_parties > 0:
+        v = sint(0)
+        for p in range(input_parties):
+            v = v + sint.get_private_input_from(p)
+        return v
+
     v = [sint()]
     input_shares(regint(0), *v)
 
@@ -1,12 +1,15 @@
 package computation_test
 
 import (
+	"io/ioutil"
 	"math/big"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/krakenh2020/MPCService/computation"
 )
@@ -19,7 +22,7 @@ func TestInputPrepare(t *testing.T) {
 			in[i] = big.NewInt(int64(i))
 		}
 
-		err := computation.InputPrepare(nodeId, in, nil, os.Getenv("SCALE_MAMBA_PATH"))
+		err := computation.InputPrepare(nodeId, in, 1, nil, os.Getenv("SCALE_MAMBA_PATH"))
 		if err != nil {
 			t.Fatal("Preparing input failed", err)
 		}
@@ -35,3 +38,145 @@ func TestRunScale(t *testing.T) {
 
 	time.Sleep(5 * time.Second)
 }
+
+// fakeScale writes files in sm standing in for SCALE: the set up, a function, a compiler
+// marking the programs compiled and a player writing one share of 5 as the result.
+func fakeScale(t *testing.T, sm string) {
+	files := map[string]string{
+		"Data/SharingData.txt":                  "default",
+		"Data/NetworkData.txt":                  "shared",
+		"Setups/shamir-3-1/SharingData.txt":     "shamir",
+		"Programs/MPCService/functions/max.mpc": "x = LEN + COLS",
+		"compile.sh":                            "#!/bin/sh\ntouch \"$1/compiled\"\n",
+		"Player.x":                              "#!/bin/sh\necho \"$1 5\" > Input/output_shares$1.txt\n",
+	}
+	for name, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(sm+"/"+name), 0700))
+		assert.NoError(t, ioutil.WriteFile(sm+"/"+name, []byte(content), 0700))
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	sm := t.TempDir()
+	fakeScale(t, sm)
+	computation.SandboxRoot = t.TempDir()
+	defer func() { computation.SandboxRoot = "" }()
+
+	dir, err := computation.NewSandbox(1, sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := computation.NewSandbox(1, sm)
+	assert.NoError(t, err)
+	assert.NotEqual(t, dir, other)
+	assert.NoError(t, computation.RemoveSandbox(other))
+
+	// the set up and the settings of the job are written to the sandbox only
+	assert.NoError(t, computation.SetUpProtocolIn("shamir", 3, 1, sm, dir))
+	certLoc := "../key_management/keys_certificates"
+	assert.NoError(t, computation.SetUpScale(1, []string{"a", "b", "c"}, []string{"localhost", "localhost", "localhost"},
+		dir, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, "Ljubljana_node", certLoc))
+	for _, name := range []string{"Cert-Store/Player2.crt", "Cert-Store/Player2.key", "Cert-Store/RootCA.crt"} {
+		_, err = os.Stat(dir + "/" + name)
+		assert.NoError(t, err, name)
+	}
+	b, err := ioutil.ReadFile(dir + "/Data/SharingData.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "shamir", string(b))
+	b, err = ioutil.ReadFile(sm + "/Data/SharingData.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "default", string(b))
+	b, err = ioutil.ReadFile(sm + "/Data/NetworkData.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "shared", string(b))
+
+	// the program is compiled and run in the sandbox
+	assert.NoError(t, computation.InputPrepare(1, []*big.Int{big.NewInt(3)}, 1, nil, dir))
+	assert.NoError(t, computation.PrepareMambaProgramIn(1, "max", map[string]string{"LEN": "4", "COLS": "2"}, sm, dir))
+	b, err = ioutil.ReadFile(dir + "/Programs/MPCService/node1/node1.mpc")
+	assert.NoError(t, err)
+	assert.Equal(t, "x = 4 + 2", string(b))
+	_, err = os.Stat(dir + "/Programs/MPCService/node1/compiled")
+	assert.NoError(t, err)
+	assert.NoError(t, computation.RunPlayerIn(1, "5000,5001,5002", sm, dir))
+	res, err := computation.LoadResultShares(1, dir, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*big.Int{big.NewInt(5)}, res)
+	_, err = os.Stat(sm + "/Input")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, computation.RemoveSandbox(dir))
+	files, err := ioutil.ReadDir(computation.SandboxRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestRunPlayerCancel(t *testing.T) {
+	sm := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(sm+"/Player.x", []byte("#!/bin/sh\nexec sleep 30\n"), 0700))
+
+	// a player still running when the computation is cancelled is killed
+	cancel := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- computation.RunPlayerInCancel(1, "5000,5001,5002", sm, sm, cancel) }()
+	time.Sleep(100 * time.Millisecond)
+	close(cancel)
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "computation cancelled")
+	case <-time.After(5 * time.Second):
+		t.Fatal("player not killed")
+	}
+
+	assert.NoError(t, ioutil.WriteFile(sm+"/Player.x", []byte("#!/bin/sh\nexit 1\n"), 0700))
+	assert.Error(t, computation.RunPlayerInCancel(1, "5000,5001,5002", sm, sm, make(chan struct{})))
+}
+
+func TestCheckProgram(t *testing.T) {
+	assert.NoError(t, computation.CheckProgram("avg", map[string]string{"LEN": "10", "COLS": "5"}))
+	assert.NoError(t, computation.CheckProgram("k-means", map[string]string{"NUM_CLUSTERS": "3"}))
+	assert.Error(t, computation.CheckProgram("unknown", nil))
+	assert.Error(t, computation.CheckProgram("k-means", nil))
+	assert.Error(t, computation.CheckProgram("avg", map[string]string{"NUM_CLUSTERS": "3"}))
+	assert.Error(t, computation.CheckProgram("k-means", map[string]string{"NUM_CLUSTERS": "0"}))
+	assert.Error(t, computation.CheckProgram("avg", map[string]string{"FIX_K": "64"}))
+	assert.Error(t, computation.CheckProgram("avg", map[string]string{"LEN": "1/g"}))
+	assert.Error(t, computation.CheckProgram("avg", map[string]string{"LEN": "1\nimport os"}))
+}
+
+func TestRender(t *testing.T) {
+	f := computation.Function{Params: []computation.Param{
+		{Name: "LEN", Type: computation.ParamInt, Min: 1, Max: 100},
+		{Name: "RATE", Type: computation.ParamFloat, Min: 0, Max: 1, Default: "0.5"},
+		{Name: "MODE", Type: computation.ParamEnum, Values: []string{"fast", "exact"}, Default: "exact"},
+		{Name: "WEIGHTS", Type: computation.ParamList, Elem: computation.ParamInt, Min: -10, Max: 10, MaxLen: 3},
+	}}
+	template := "l = LEN\nLENGTH = LEN_2 + MAX_LEN\nr = RATE\nm = MODE\nw = WEIGHTS\n"
+
+	text, err := f.Render(template, map[string]string{"LEN": "007", "RATE": "1", "WEIGHTS": "1, -2,3"})
+	assert.NoError(t, err)
+	assert.Equal(t, "l = 7\nLENGTH = LEN_2 + MAX_LEN\nr = 1.0\nm = \"exact\"\nw = [1, -2, 3]\n", text)
+
+	// values are checked against the declaration of their parameter
+	for _, params := range []map[string]string{
+		{"LEN": "0", "WEIGHTS": ""},
+		{"LEN": "1", "WEIGHTS": "1,2,3,4"},
+		{"LEN": "1", "WEIGHTS": "11"},
+		{"LEN": "1", "WEIGHTS": "0.5"},
+		{"LEN": "1", "WEIGHTS": "", "RATE": "NaN"},
+		{"LEN": "1", "WEIGHTS": "", "MODE": "exact\"; import os"},
+	} {
+		assert.Error(t, f.Check(params), params)
+		_, err = f.Render(template, params)
+		assert.Error(t, err, params)
+	}
+	assert.Error(t, f.Check(map[string]string{"OTHER": "1"}))
+
+	// parameters without a default are only needed if they appear in the program
+	assert.NoError(t, f.Check(map[string]string{}))
+	_, err = f.Render(template, map[string]string{})
+	assert.Error(t, err)
+	text, err = f.Render("r = RATE", map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "r = 0.5", text)
+}
@@ -0,0 +1,170 @@
+package computation
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParamType is the type of the values of a parameter of a MAMBA program.
+type ParamType string
+
+const (
+	ParamInt   ParamType = "int"
+	ParamFloat ParamType = "float"
+	ParamEnum  ParamType = "enum"
+	ParamList  ParamType = "list"
+)
+
+// Param declares a parameter of a MAMBA program. Its value is written to the program in
+// place of the identifiers equal to its name. Integers and floats must lie between Min
+// and Max, unless both are 0; an enum value must be one of Values and is written as a
+// string; a list is given as comma separated values of type Elem, at most MaxLen unless it
+// is 0, and is written as a Python list. A parameter that is not given takes the value
+// Default; it must be given if it is Required.
+type Param struct {
+	Name     string
+	Type     ParamType
+	Min      float64
+	Max      float64
+	Values   []string
+	Elem     ParamType
+	MaxLen   int
+	Default  string
+	Required bool
+}
+
+// format returns the value as written in a MAMBA program, or an error if it is not a
+// value of the parameter.
+func (p Param) format(val string) (string, error) {
+	switch p.Type {
+	case ParamEnum:
+		for _, e := range p.Values {
+			if val == e {
+				return strconv.Quote(val), nil
+			}
+		}
+		return "", fmt.Errorf("parameter %s must be one of %s", p.Name, strings.Join(p.Values, ","))
+	case ParamList:
+		elem := Param{Name: p.Name, Type: p.Elem, Min: p.Min, Max: p.Max}
+		if elem.Type != ParamInt && elem.Type != ParamFloat {
+			return "", fmt.Errorf("parameter %s has elements of type %s", p.Name, p.Elem)
+		}
+		vals := strings.Split(val, ",")
+		if strings.TrimSpace(val) == "" {
+			vals = nil
+		}
+		if p.MaxLen != 0 && len(vals) > p.MaxLen {
+			return "", fmt.Errorf("parameter %s has more than %d values", p.Name, p.MaxLen)
+		}
+		for i, e := range vals {
+			s, err := elem.format(strings.TrimSpace(e))
+			if err != nil {
+				return "", err
+			}
+			vals[i] = s
+		}
+		return "[" + strings.Join(vals, ", ") + "]", nil
+	case ParamInt:
+		x, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s is not an integer", p.Name)
+		}
+		if err = p.checkBounds(float64(x)); err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(x, 10), nil
+	case ParamFloat:
+		x, err := strconv.ParseFloat(val, 64)
+		if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+			return "", fmt.Errorf("parameter %s is not a number", p.Name)
+		}
+		if err = p.checkBounds(x); err != nil {
+			return "", err
+		}
+		s := strconv.FormatFloat(x, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s = s + ".0"
+		}
+		return s, nil
+	}
+	return "", fmt.Errorf("parameter %s has type %s", p.Name, p.Type)
+}
+
+func (p Param) checkBounds(x float64) error {
+	if (p.Min != 0 || p.Max != 0) && (x < p.Min || x > p.Max) {
+		return fmt.Errorf("parameter %s must be between %v and %v", p.Name, p.Min, p.Max)
+	}
+	return nil
+}
+
+// Function is a MAMBA program of SCALE, Programs/MPCService/functions/<name>.mpc, with the
+// parameters it accepts.
+type Function struct {
+	Params []Param
+}
+
+func (f Function) param(name string) (Param, bool) {
+	for _, p := range f.Params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Param{}, false
+}
+
+// Check returns an error if a parameter is not accepted by the function or its value is
+// not allowed, or if a required parameter is missing.
+func (f Function) Check(params map[string]string) error {
+	for key, val := range params {
+		p, ok := f.param(key)
+		if !ok {
+			return fmt.Errorf("parameter %s not supported", key)
+		}
+		if _, err := p.format(val); err != nil {
+			return err
+		}
+	}
+	for _, p := range f.Params {
+		if _, ok := params[p.Name]; p.Required && !ok {
+			return fmt.Errorf("parameter %s required", p.Name)
+		}
+	}
+
+	return nil
+}
+
+// identifier matches the words of a program, among them the names of the parameters.
+var identifier = regexp.MustCompile(`\w+`)
+
+// Render writes the values of the parameters to the template of the program, in place of
+// the words equal to their names, so that a name within another identifier is kept. The
+// parameters must be checked with Check; a parameter found in the template without a
+// value or a default is an error.
+func (f Function) Render(template string, params map[string]string) (string, error) {
+	var err error
+	text := identifier.ReplaceAllStringFunc(template, func(word string) string {
+		p, ok := f.param(word)
+		if !ok || err != nil {
+			return word
+		}
+		val, ok := params[p.Name]
+		if !ok {
+			val = p.Default
+		}
+		if !ok && val == "" {
+			err = fmt.Errorf("parameter %s not set", p.Name)
+			return word
+		}
+		var s string
+		s, err = p.format(val)
+		return s
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return text, nil
+}
@@ -53,6 +53,16 @@ func setDefaults() {
 	viper.SetDefault("assets", "manager/assets")
 	viper.SetDefault("shareWith", "all")
 	viper.SetDefault("description", "")
+	viper.SetDefault("guiTLS", false)
+	viper.SetDefault("authFile", "")
+	viper.SetDefault("authorizer", "")
+	viper.SetDefault("store", "")
+	viper.SetDefault("managerURL", "http://localhost:5000")
+	viper.SetDefault("token", "")
+	viper.SetDefault("protocols", "shamir:3:1")
+	viper.SetDefault("backend", "scale-mamba")
+	viper.SetDefault("providers", "")
+	viper.SetDefault("allowUnsigned", false)
 }
 
 // LoadServerName returns the name of the server.
@@ -122,3 +132,53 @@ func LoadShareWith() string {
 func LoadDescription() string {
 	return viper.GetString("description")
 }
+
+// LoadGuiTLS returns true if the GUI of the manager should be served over TLS.
+func LoadGuiTLS() bool {
+	return viper.GetBool("guiTLS")
+}
+
+// LoadAuthFile returns the file listing the requesters allowed to use the GUI of the manager.
+func LoadAuthFile() string {
+	return viper.GetString("authFile")
+}
+
+// LoadAuthorizer returns the specification of the authorizer approving computations.
+func LoadAuthorizer() string {
+	return viper.GetString("authorizer")
+}
+
+// LoadStore returns the folder where the manager saves its state.
+func LoadStore() string {
+	return viper.GetString("store")
+}
+
+// LoadManagerURL returns the URL of the GUI port of the manager used by the client.
+func LoadManagerURL() string {
+	return viper.GetString("managerURL")
+}
+
+// LoadToken returns the API token of the requester used by the client.
+func LoadToken() string {
+	return viper.GetString("token")
+}
+
+// LoadProtocols returns the sharings the MPC node can evaluate.
+func LoadProtocols() string {
+	return viper.GetString("protocols")
+}
+
+// LoadBackend returns the MPC backend of the MPC node.
+func LoadBackend() string {
+	return viper.GetString("backend")
+}
+
+// LoadProviders returns the data providers trusted by the MPC node.
+func LoadProviders() string {
+	return viper.GetString("providers")
+}
+
+// LoadAllowUnsigned returns true if the MPC node should use shares not signed by a provider.
+func LoadAllowUnsigned() bool {
+	return viper.GetBool("allowUnsigned")
+}
@@ -0,0 +1,139 @@
+package data_management
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"os"
+)
+
+// Input commitments. The provider of a dataset publishes for each version a commitment to
+// its data, the hex encoded SHA-256 hash of a random nonce followed by its csv file (see
+// CommitData). The provider keeps the nonce and gives it only to auditors with the data,
+// so that the commitment does not let others test guesses of the data. The share of
+// each node is bound to this commitment by a share commitment (see ShareCommitter), which
+// is signed together with the shares, so that a node checks the share it decrypts. The
+// nodes return the commitments of their inputs with the result (see InputCommitment): the
+// receiver can then show on which version of the data a result was computed, and an
+// auditor holding the data can check its commitment and run the computation again.
+
+// InputCommitment is the commitment of an input of a computation returned by a node:
+// the dataset, its version, the commitment of the data of the version and the commitment
+// of the share of the node computed from the decrypted share. Commitment is empty for
+// datasets whose provider does not publish one.
+type InputCommitment struct {
+	Dataset    string `json:"dataset"`
+	Version    string `json:"version,omitempty"`
+	Commitment string `json:"commitment,omitempty"`
+	Share      string `json:"share"`
+}
+
+// NonceSize is the size in bytes of the nonce of the commitment of data.
+const NonceSize = 32
+
+// NewNonce returns a fresh random nonce for the commitment of data.
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// CommitData returns the commitment of the data read from r with the nonce, which must be
+// NonceSize bytes.
+func CommitData(r io.Reader, nonce []byte) (string, error) {
+	if len(nonce) != NonceSize {
+		return "", fmt.Errorf("nonce of the commitment must be %d bytes", NonceSize)
+	}
+	hash := sha256.New()
+	hash.Write(nonce)
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CommitFile returns the commitment of the data of the file with the nonce.
+func CommitFile(file string, nonce []byte) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return CommitData(f, nonce)
+}
+
+// CheckCommitment checks that the commitment is a hex encoded SHA-256 hash.
+func CheckCommitment(commitment string) error {
+	b, err := hex.DecodeString(commitment)
+	if err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("commitment is not a hex encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// ShareCommitter computes the commitment of the share of a node as the values are given
+// chunk by chunk: the hex encoded SHA-256 hash of the dataset, the commitment of its data
+// and the position of the node, followed by the values reduced modulo MPCPrime as
+// ElementSize+1 byte big endian numbers.
+type ShareCommitter struct {
+	hash hash.Hash
+	buf  []byte
+	val  *big.Int
+}
+
+// NewShareCommitter returns a committer of the share of the node at position node of the
+// dataset with the commitment.
+func NewShareCommitter(dataset, commitment string, node int) *ShareCommitter {
+	c := &ShareCommitter{hash: sha256.New(), val: new(big.Int)}
+	prefix, _ := json.Marshal(struct {
+		Dataset    string `json:"dataset"`
+		Commitment string `json:"commitment"`
+		Node       int    `json:"node"`
+	}{dataset, commitment, node})
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(prefix)))
+	c.hash.Write(length[:])
+	c.hash.Write(prefix)
+	return c
+}
+
+// Write adds the values following the ones written before.
+func (c *ShareCommitter) Write(chunk []*big.Int) error {
+	c.buf = c.buf[:0]
+	for _, x := range chunk {
+		if x == nil {
+			return fmt.Errorf("commitment failed, missing element")
+		}
+		c.val.Mod(x, MPCPrime)
+		c.buf = append(c.buf, make([]byte, ElementSize+1)...)
+		c.val.FillBytes(c.buf[len(c.buf)-ElementSize-1:])
+	}
+	c.hash.Write(c.buf)
+	return nil
+}
+
+// Sum returns the commitment of the values written.
+func (c *ShareCommitter) Sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// CommitShare returns the commitment of the share of the node at position node of the
+// dataset with the commitment.
+func CommitShare(share []*big.Int, dataset, commitment string, node int) (string, error) {
+	c := NewShareCommitter(dataset, commitment, node)
+	err := c.Write(share)
+	if err != nil {
+		return "", err
+	}
+	return c.Sum(), nil
+}
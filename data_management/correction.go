@@ -0,0 +1,246 @@
+package data_management
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrInconsistentShares is returned when the shares do not lie on a polynomial of the
+// degree of the threshold, i.e. some party returned a wrong share.
+var ErrInconsistentShares = errors.New("joining failed, inconsistent shares")
+
+// MaxCorrectable returns the largest number e of wrong shares among n shares with
+// threshold t that can be corrected and identified, i.e. n >= t + 1 + 2e.
+func MaxCorrectable(n, t int) int {
+	if n < t+1 {
+		return 0
+	}
+	return (n - t - 1) / 2
+}
+
+// JoinSharesShamirCorrect reconstructs the vector from the shares f(1), ..., f(n) of a
+// polynomial of degree t like JoinSharesShamirN, but corrects up to MaxCorrectable(n, t)
+// wrong shares with the Berlekamp-Welch algorithm. A nil share is missing, for example
+// because its party failed, and is an erasure: the vector is reconstructed from the m
+// given shares if at least t+1 are given, correcting up to MaxCorrectable(m, t) wrong
+// ones. It returns the sorted indexes of the shares found wrong or missing. If the wrong
+// shares cannot be corrected, for example because n = 2t+1 only allows to detect them, an
+// error wrapping ErrInconsistentShares is returned.
+func JoinSharesShamirCorrect(input [][]*big.Int, t int) ([]*big.Int, []int, error) {
+	present := make([]int, 0, len(input))
+	for i := range input {
+		if input[i] != nil {
+			present = append(present, i)
+		}
+	}
+	if len(present) == len(input) {
+		res, err := JoinSharesShamirN(input, t)
+		if !errors.Is(err, ErrInconsistentShares) {
+			return res, nil, err
+		}
+	} else if t < 1 || len(present) < t+1 {
+		return nil, nil, fmt.Errorf("joining failed, %d of %d shares cannot be joined with threshold %d",
+			len(present), len(input), t)
+	}
+	for _, i := range present {
+		if len(input[i]) != len(input[present[0]]) {
+			return nil, nil, fmt.Errorf("joining failed, shares of different lengths")
+		}
+	}
+
+	n := len(present)
+	e := MaxCorrectable(n, t)
+	xs := make([]int64, n)
+	for k, i := range present {
+		xs[k] = int64(i + 1)
+	}
+	wrong := make(map[int]bool)
+	res := make([]*big.Int, len(input[present[0]]))
+	ys := make([]*big.Int, n)
+	for j := range res {
+		for k, i := range present {
+			ys[k] = new(big.Int).Mod(input[i][j], MPCPrime)
+		}
+		poly, err := berlekampWelch(xs, ys, t, e)
+		if err != nil && e == 0 {
+			return nil, nil, fmt.Errorf("%w: %d shares with threshold %d cannot identify the wrong ones",
+				ErrInconsistentShares, n, t)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: more than %d shares are wrong", ErrInconsistentShares, e)
+		}
+		for k, i := range present {
+			if evaluate(poly, xs[k]).Cmp(ys[k]) != 0 {
+				wrong[i] = true
+			}
+		}
+
+		res[j] = poly[0]
+		if res[j].Cmp(MPCPrimeHalf) > 0 {
+			res[j].Sub(res[j], MPCPrime)
+		}
+	}
+	// the same parties must be wrong in all the values
+	if len(wrong) > e {
+		return nil, nil, fmt.Errorf("%w: more than %d shares are wrong", ErrInconsistentShares, e)
+	}
+
+	return res, cheaters(input, wrong), nil
+}
+
+// cheaters returns the sorted indexes of the wrong and of the missing shares.
+func cheaters(input [][]*big.Int, wrong map[int]bool) []int {
+	res := make([]int, 0, len(wrong))
+	for i := range input {
+		if wrong[i] || input[i] == nil {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// berlekampWelch returns the coefficients of the polynomial P of degree at most t that
+// passes through all but at most e of the points (xs[i], ys[i]). It finds a polynomial Q
+// of degree t+e and a monic error locator E of degree e with Q(x_i) = y_i E(x_i) for all
+// points, so that P = Q/E.
+func berlekampWelch(xs []int64, ys []*big.Int, t, e int) ([]*big.Int, error) {
+	n := len(xs)
+	// unknowns q_0, ..., q_{t+e}, e_0, ..., e_{e-1}:
+	// sum_k q_k x_i^k - y_i sum_k e_k x_i^k = y_i x_i^e
+	numQ := t + e + 1
+	matrix := make([][]*big.Int, n)
+	for i := range xs {
+		row := make([]*big.Int, numQ+e+1)
+		x := big.NewInt(xs[i])
+		pow := big.NewInt(1)
+		for k := 0; k < numQ; k++ {
+			row[k] = new(big.Int).Set(pow)
+			if k < e {
+				row[numQ+k] = new(big.Int).Mul(ys[i], pow)
+				row[numQ+k].Neg(row[numQ+k]).Mod(row[numQ+k], MPCPrime)
+			}
+			if k == e {
+				row[numQ+e] = new(big.Int).Mul(ys[i], pow)
+				row[numQ+e].Mod(row[numQ+e], MPCPrime)
+			}
+			pow = new(big.Int).Mul(pow, x)
+			pow.Mod(pow, MPCPrime)
+		}
+		matrix[i] = row
+	}
+
+	sol, ok := solveMod(matrix, numQ+e)
+	if !ok {
+		return nil, fmt.Errorf("no solution")
+	}
+	q := sol[:numQ]
+	locator := append(append([]*big.Int{}, sol[numQ:]...), big.NewInt(1))
+
+	poly, rem := divide(q, locator)
+	for _, c := range rem {
+		if c.Sign() != 0 {
+			return nil, fmt.Errorf("error locator does not divide")
+		}
+	}
+	numWrong := 0
+	for i := range xs {
+		if evaluate(poly, xs[i]).Cmp(ys[i]) != 0 {
+			numWrong++
+		}
+	}
+	if numWrong > e {
+		return nil, fmt.Errorf("too many errors")
+	}
+
+	return poly, nil
+}
+
+// solveMod solves the linear system given by the augmented matrix with Gaussian
+// elimination modulo MPCPrime; free unknowns are set to 0. It returns false if the
+// system has no solution. The matrix is modified.
+func solveMod(matrix [][]*big.Int, numVars int) ([]*big.Int, bool) {
+	pivots := make([]int, 0, numVars)
+	row := 0
+	for col := 0; col < numVars && row < len(matrix); col++ {
+		pivot := -1
+		for i := row; i < len(matrix); i++ {
+			if matrix[i][col].Sign() != 0 {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			continue
+		}
+		matrix[row], matrix[pivot] = matrix[pivot], matrix[row]
+
+		inv := new(big.Int).ModInverse(matrix[row][col], MPCPrime)
+		for k := col; k <= numVars; k++ {
+			matrix[row][k].Mul(matrix[row][k], inv).Mod(matrix[row][k], MPCPrime)
+		}
+		for i := range matrix {
+			if i == row || matrix[i][col].Sign() == 0 {
+				continue
+			}
+			factor := new(big.Int).Set(matrix[i][col])
+			for k := col; k <= numVars; k++ {
+				matrix[i][k].Sub(matrix[i][k], new(big.Int).Mul(factor, matrix[row][k])).Mod(matrix[i][k], MPCPrime)
+			}
+		}
+		pivots = append(pivots, col)
+		row++
+	}
+	// a remaining row 0 = c with c != 0 means there is no solution
+	for i := row; i < len(matrix); i++ {
+		if matrix[i][numVars].Sign() != 0 {
+			return nil, false
+		}
+	}
+
+	res := make([]*big.Int, numVars)
+	for i := range res {
+		res[i] = new(big.Int)
+	}
+	for i, col := range pivots {
+		res[col].Set(matrix[i][numVars])
+	}
+
+	return res, true
+}
+
+// divide returns the quotient and the remainder of the polynomial a divided by the monic
+// polynomial b, both given by their coefficients from the lowest degree.
+func divide(a, b []*big.Int) ([]*big.Int, []*big.Int) {
+	rem := make([]*big.Int, len(a))
+	for i := range a {
+		rem[i] = new(big.Int).Set(a[i])
+	}
+	degB := len(b) - 1
+	if len(a) <= degB {
+		return []*big.Int{new(big.Int)}, rem
+	}
+
+	quot := make([]*big.Int, len(a)-degB)
+	for d := len(a) - 1; d >= degB; d-- {
+		c := new(big.Int).Set(rem[d])
+		quot[d-degB] = c
+		for k := 0; k <= degB; k++ {
+			rem[d-degB+k].Sub(rem[d-degB+k], new(big.Int).Mul(c, b[k])).Mod(rem[d-degB+k], MPCPrime)
+		}
+	}
+
+	return quot, rem[:degB]
+}
+
+// evaluate returns the value of the polynomial with the given coefficients at x.
+func evaluate(poly []*big.Int, x int64) *big.Int {
+	bigX := big.NewInt(x)
+	res := new(big.Int)
+	for k := len(poly) - 1; k >= 0; k-- {
+		res.Mul(res, bigX)
+		res.Add(res, poly[k])
+		res.Mod(res, MPCPrime)
+	}
+	return res
+}
@@ -0,0 +1,326 @@
+package data_management
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+)
+
+// Binary encoding of vectors of field elements. An encoded vector starts with a header
+//
+//	magic "MPCV" | version (1 byte) | flags (1 byte) | number of elements (uint64)
+//
+// followed by chunks of at most Encoding.ChunkSize (and MaxChunkSize) elements, each given by
+//
+//	number of elements (uint32) | number of overflows (uint32) | length of the payload (uint32) |
+//	indexes of the overflows (uint32 each) | payload
+//
+// where the payload holds the elements reduced modulo MPCPrime as ElementSize byte big
+// endian numbers, compressed with DEFLATE if FlagCompressed is set. Since MPCPrime is
+// slightly larger than 2^128, the few elements of at least 2^128 are written reduced
+// modulo 2^128 and their indexes in the chunk are listed as overflows. All the integers
+// are big endian. Vectors encoded as JSON arrays of numbers by earlier versions are still
+// decoded. Vectors are written and read chunk by chunk with VecEncoder and VecDecoder.
+const (
+	EncodingVersion = 1
+	ElementSize     = 16
+	FlagCompressed  = 1
+	MaxChunkSize    = 1 << 20
+)
+
+var encodingMagic = []byte("MPCV")
+
+// elementBound is 2^(8 ElementSize), the bound of the values of ElementSize bytes.
+var elementBound = new(big.Int).Lsh(big.NewInt(1), 8*ElementSize)
+
+// Encoding gives the options of the binary encoding of vectors.
+type Encoding struct {
+	Compress  bool
+	ChunkSize int
+}
+
+// DefaultEncoding is used to encode the shares and the results. Shares are uniformly
+// random and are not compressed.
+var DefaultEncoding = Encoding{ChunkSize: 1 << 16}
+
+// EncodeVec returns the binary encoding of the vector of field elements.
+func EncodeVec(input []*big.Int, enc Encoding) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(encodingMagic) + 10 + len(input)*ElementSize + (len(input)/enc.chunkSize()+1)*12)
+	e, err := NewVecEncoder(&buf, uint64(len(input)), enc)
+	if err != nil {
+		return nil, err
+	}
+	err = e.Write(input)
+	if err == nil {
+		err = e.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// chunkSize returns the number of elements of the chunks, at most MaxChunkSize.
+func (enc Encoding) chunkSize() int {
+	if enc.ChunkSize <= 0 {
+		return DefaultEncoding.ChunkSize
+	}
+	if enc.ChunkSize > MaxChunkSize {
+		return MaxChunkSize
+	}
+	return enc.ChunkSize
+}
+
+// VecEncoder writes the binary encoding of a vector of a known number of elements chunk by
+// chunk, holding at most a chunk in memory.
+type VecEncoder struct {
+	w         io.Writer
+	enc       Encoding
+	count     uint64
+	written   uint64
+	pending   []*big.Int
+	payload   []byte
+	overflows []uint32
+	val       *big.Int
+}
+
+// NewVecEncoder writes the header of the encoding of count elements to w and returns an
+// encoder of the elements.
+func NewVecEncoder(w io.Writer, count uint64, enc Encoding) (*VecEncoder, error) {
+	flags := byte(0)
+	if enc.Compress {
+		flags |= FlagCompressed
+	}
+	header := make([]byte, len(encodingMagic)+10)
+	copy(header, encodingMagic)
+	header[len(encodingMagic)], header[len(encodingMagic)+1] = EncodingVersion, flags
+	binary.BigEndian.PutUint64(header[len(encodingMagic)+2:], count)
+	_, err := w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// short vectors do not need a whole chunk
+	size := enc.chunkSize()
+	if count < uint64(size) {
+		size = int(count)
+	}
+	return &VecEncoder{w: w, enc: enc, count: count, pending: make([]*big.Int, 0, size), val: new(big.Int)}, nil
+}
+
+// Write encodes the elements, writing the chunks that are full.
+func (e *VecEncoder) Write(input []*big.Int) error {
+	if e.written+uint64(len(e.pending))+uint64(len(input)) > e.count {
+		return fmt.Errorf("encoding failed, more than %d elements", e.count)
+	}
+	for len(input) > 0 {
+		k := cap(e.pending) - len(e.pending)
+		if k > len(input) {
+			k = len(input)
+		}
+		e.pending = append(e.pending, input[:k]...)
+		input = input[k:]
+		if len(e.pending) == cap(e.pending) {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes the last chunk; it fails if fewer elements than announced were written.
+func (e *VecEncoder) Close() error {
+	if len(e.pending) > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	if e.written != e.count {
+		return fmt.Errorf("encoding failed, %d elements of %d written", e.written, e.count)
+	}
+	return nil
+}
+
+// flush writes the pending elements as a chunk.
+func (e *VecEncoder) flush() error {
+	e.payload, e.overflows = e.payload[:0], e.overflows[:0]
+	for i, x := range e.pending {
+		if x == nil {
+			return fmt.Errorf("encoding failed, missing element")
+		}
+		e.val.Mod(x, MPCPrime)
+		if e.val.Cmp(elementBound) >= 0 {
+			e.val.Sub(e.val, elementBound)
+			e.overflows = append(e.overflows, uint32(i))
+		}
+		e.payload = append(e.payload, make([]byte, ElementSize)...)
+		e.val.FillBytes(e.payload[len(e.payload)-ElementSize:])
+	}
+	data := e.payload
+	if e.enc.Compress {
+		var compressed bytes.Buffer
+		w, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(e.payload)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return err
+		}
+		data = compressed.Bytes()
+	}
+
+	chunk := make([]byte, 12+4*len(e.overflows), 12+4*len(e.overflows)+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(e.pending)))
+	binary.BigEndian.PutUint32(chunk[4:], uint32(len(e.overflows)))
+	binary.BigEndian.PutUint32(chunk[8:], uint32(len(data)))
+	for j, i := range e.overflows {
+		binary.BigEndian.PutUint32(chunk[12+4*j:], i)
+	}
+	chunk = append(chunk, data...)
+	_, err := e.w.Write(chunk)
+	if err != nil {
+		return err
+	}
+	e.written += uint64(len(e.pending))
+	e.pending = e.pending[:0]
+	return nil
+}
+
+// DecodeVec decodes a vector encoded by EncodeVec or as a JSON array of numbers.
+func DecodeVec(b []byte) ([]*big.Int, error) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || bytes.Equal(trimmed, []byte("null"))) {
+		var res []*big.Int
+		err := json.Unmarshal(trimmed, &res)
+		return res, err
+	}
+
+	r := bytes.NewReader(b)
+	d, err := NewVecDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	if d.Count() > uint64(r.Len())/ElementSize && !d.compressed {
+		return nil, fmt.Errorf("decoding failed, %d elements announced in %d bytes", d.Count(), r.Len())
+	}
+	// the number of compressed elements is only checked when decompressing them
+	capacity := d.Count()
+	if d.compressed {
+		capacity = 0
+	}
+	res := make([]*big.Int, 0, capacity)
+	for {
+		chunk, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, chunk...)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("decoding failed, trailing data")
+	}
+
+	return res, nil
+}
+
+// VecDecoder reads a vector encoded by a VecEncoder chunk by chunk.
+type VecDecoder struct {
+	r          io.Reader
+	compressed bool
+	count      uint64
+	read       uint64
+}
+
+// NewVecDecoder reads the header of an encoded vector from r.
+func NewVecDecoder(r io.Reader) (*VecDecoder, error) {
+	header := make([]byte, len(encodingMagic)+10)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(encodingMagic)], encodingMagic) {
+		return nil, fmt.Errorf("decoding failed, unknown format")
+	}
+	version, flags := header[len(encodingMagic)], header[len(encodingMagic)+1]
+	if version != EncodingVersion {
+		return nil, fmt.Errorf("decoding failed, version %d not supported", version)
+	}
+
+	return &VecDecoder{r: r, compressed: flags&FlagCompressed != 0,
+		count: binary.BigEndian.Uint64(header[len(encodingMagic)+2:])}, nil
+}
+
+// Count returns the number of elements of the vector.
+func (d *VecDecoder) Count() uint64 {
+	return d.count
+}
+
+// Next returns the elements of the next chunk, or io.EOF after the last one.
+func (d *VecDecoder) Next() ([]*big.Int, error) {
+	if d.read == d.count {
+		return nil, io.EOF
+	}
+	var chunkHeader [3]uint32
+	err := binary.Read(d.r, binary.BigEndian, &chunkHeader)
+	if err != nil {
+		return nil, fmt.Errorf("decoding failed, truncated chunk")
+	}
+	chunkCount, numOverflows, length := chunkHeader[0], chunkHeader[1], chunkHeader[2]
+	if chunkCount == 0 || chunkCount > MaxChunkSize || numOverflows > chunkCount ||
+		uint64(chunkCount) > d.count-d.read {
+		return nil, fmt.Errorf("decoding failed, malformed chunk")
+	}
+	if length > chunkCount*ElementSize+chunkCount/64+1024 {
+		// DEFLATE expands incompressible data by a few bytes per block
+		return nil, fmt.Errorf("decoding failed, chunk of wrong length")
+	}
+	overflows := make([]uint32, numOverflows)
+	data := make([]byte, length)
+	err = binary.Read(d.r, binary.BigEndian, overflows)
+	if err == nil {
+		_, err = io.ReadFull(d.r, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding failed, truncated chunk")
+	}
+
+	if d.compressed {
+		// the size of the decompressed chunk is known, more data is an error
+		limit := int64(chunkCount)*ElementSize + 1
+		data, err = ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), limit))
+		if err != nil {
+			return nil, fmt.Errorf("decoding failed, %v", err)
+		}
+	}
+	if len(data) != int(chunkCount)*ElementSize {
+		return nil, fmt.Errorf("decoding failed, chunk of wrong length")
+	}
+
+	chunk := make([]*big.Int, chunkCount)
+	for i := range chunk {
+		chunk[i] = new(big.Int).SetBytes(data[i*ElementSize : (i+1)*ElementSize])
+	}
+	for _, i := range overflows {
+		if i >= chunkCount || chunk[i].Cmp(elementBound) >= 0 {
+			return nil, fmt.Errorf("decoding failed, wrong overflow")
+		}
+		chunk[i].Add(chunk[i], elementBound)
+		if chunk[i].Cmp(MPCPrime) >= 0 {
+			return nil, fmt.Errorf("decoding failed, element not in the field")
+		}
+	}
+	d.read += uint64(chunkCount)
+
+	return chunk, nil
+}
@@ -3,24 +3,117 @@ package data_management
 import (
 	"fmt"
 	"math"
+	"math/big"
 )
 
-// FloatToFixInt changes a float to a fix precision representation of a number used in SCALE-MAMBA
-func FloatToFixInt(x float64) (int64, error) {
-	k := 41
-	f := 20
+// FixedPoint gives the parameters of the fixed point numbers (sfix) of SCALE-MAMBA: a value
+// x is represented by the integer round(x * 2^F) of K bits, so that |x| < 2^(K-F-1) and
+// the precision is 2^-F.
+type FixedPoint struct {
+	K int `json:"k"`
+	F int `json:"f"`
+}
+
+// DefaultFixedPoint is the precision of SCALE-MAMBA used if none is chosen.
+var DefaultFixedPoint = FixedPoint{K: 41, F: 20}
+
+// statisticalSecurity is the statistical security parameter of the truncation of SCALE-MAMBA
+// after a fixed point multiplication, which needs k + f + statisticalSecurity bits of
+// MPCPrime.
+const statisticalSecurity = 40
 
-	if math.Abs(x) >= math.Pow(2, float64(k-f-1)) {
+// OrDefault returns DefaultFixedPoint if the parameters are not set.
+func (p FixedPoint) OrDefault() FixedPoint {
+	if p == (FixedPoint{}) {
+		return DefaultFixedPoint
+	}
+	return p
+}
+
+// Check returns an error if SCALE-MAMBA cannot compute with the parameters: 0 < f < k,
+// the values must fit into 63 bits and the truncation needs k + f + 40 < log2(MPCPrime).
+func (p FixedPoint) Check() error {
+	if p.F <= 0 || p.K <= p.F {
+		return fmt.Errorf("fixed point parameters k=%d, f=%d not supported, 0 < f < k is needed", p.K, p.F)
+	}
+	if p.K > 63 {
+		return fmt.Errorf("fixed point parameters k=%d, f=%d not supported, k <= 63 is needed", p.K, p.F)
+	}
+	if p.K+p.F+statisticalSecurity >= MPCPrime.BitLen()-1 {
+		return fmt.Errorf("fixed point parameters k=%d, f=%d not supported, k + f < %d is needed",
+			p.K, p.F, MPCPrime.BitLen()-1-statisticalSecurity)
+	}
+	return nil
+}
+
+// Max returns the bound on the absolute values that can be represented.
+func (p FixedPoint) Max() float64 {
+	return math.Pow(2, float64(p.K-p.F-1))
+}
+
+// CheckRange returns an error if some of the values cannot be represented, suggesting the
+// k needed for them.
+func (p FixedPoint) CheckRange(vals []float64) error {
+	max := 0.
+	for _, e := range vals {
+		if math.IsNaN(e) || math.IsInf(e, 0) {
+			return fmt.Errorf("value %f cannot be represented as a fixed point number", e)
+		}
+		max = math.Max(max, math.Abs(e))
+	}
+	if max < p.Max() {
+		return nil
+	}
+
+	needed := p.F + 1 + int(math.Floor(math.Log2(max))) + 1
+	return fmt.Errorf("values up to %g do not fit fixed point parameters k=%d, f=%d, which allow "+
+		"values below %g; k=%d is needed", max, p.K, p.F, p.Max(), needed)
+}
+
+// Encode changes a float to its fixed point representation.
+func (p FixedPoint) Encode(x float64) (int64, error) {
+	if math.Abs(x) >= p.Max() {
 		return 0, fmt.Errorf("float too big or to small %f", x)
 	}
 
-	v := int64(math.Round(x * math.Pow(2, 20)))
+	return int64(math.Round(x * math.Pow(2, float64(p.F)))), nil
+}
+
+// EncodeVec checks that the values fit the fixed point parameters and returns their
+// representations.
+func (p FixedPoint) EncodeVec(vals []float64) ([]*big.Int, error) {
+	err := p.Check()
+	if err != nil {
+		return nil, err
+	}
+	err = p.CheckRange(vals)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*big.Int, len(vals))
+	for i, e := range vals {
+		v, err := p.Encode(e)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = big.NewInt(v)
+	}
 
-	return v, nil
+	return res, nil
 }
 
-func FixIntToFloat(i int64) (x float64) {
-	v := float64(i) / math.Pow(2, 20)
+// Decode changes a fixed point representation to a float.
+func (p FixedPoint) Decode(i int64) float64 {
+	return float64(i) / math.Pow(2, float64(p.F))
+}
+
+// FloatToFixInt changes a float to a fix precision representation of a number used in SCALE-MAMBA
+// with DefaultFixedPoint.
+func FloatToFixInt(x float64) (int64, error) {
+	return DefaultFixedPoint.Encode(x)
+}
 
-	return v
+func FixIntToFloat(i int64) (x float64) {
+	return DefaultFixedPoint.Decode(i)
 }
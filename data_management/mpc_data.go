@@ -5,8 +5,9 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
 	"os"
 	"os/exec"
@@ -38,80 +39,166 @@ func NewUniformRandomVector(n int, max *big.Int) ([]*big.Int, error) {
 // input into 3 random parts x_1, x_2, x_3, such that
 // f(i) = x_i and f(0) = x, for a linear f
 func CreateSharesShamir(input []*big.Int) ([][]*big.Int, error) {
-	// f(i) = ai + x
-	a, err := NewUniformRandomVector(len(input), MPCPrime)
+	return CreateSharesShamirN(input, 3, 1)
+}
+
+// DefaultThreshold returns the largest threshold t for which n parties can evaluate
+// a Shamir based MPC protocol with an honest majority, i.e. n > 2t.
+func DefaultThreshold(n int) int {
+	return (n - 1) / 2
+}
+
+// CheckThreshold returns an error if n parties cannot share with threshold t: the
+// sharing needs 1 <= t and n > 2t.
+func CheckThreshold(n, t int) error {
+	if t < 1 || n <= 2*t {
+		return fmt.Errorf("threshold %d not supported for %d parties, 1 <= t < n/2 is needed", t, n)
+	}
+	return nil
+}
+
+// CreateSharesShamirN splits a vector input into n shares with threshold t: the
+// i-th share is f(i+1) for a random polynomial f of degree t with f(0) = x, so that
+// any t+1 shares reveal x and t shares reveal nothing.
+func CreateSharesShamirN(input []*big.Int, n, t int) ([][]*big.Int, error) {
+	err := CheckThreshold(n, t)
 	if err != nil {
 		return nil, err
 	}
 
-	res := make([][]*big.Int, 3)
-	for i := int64(0); i < 3; i++ {
+	// f(x) = input + a_1 x + ... + a_t x^t
+	coefs := make([][]*big.Int, t)
+	for k := 0; k < t; k++ {
+		coefs[k], err = NewUniformRandomVector(len(input), MPCPrime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([][]*big.Int, n)
+	for i := 0; i < n; i++ {
 		res[i] = make([]*big.Int, len(input))
-		// linear function going through input[j]
-		for j := 0; j < len(input); j++ {
-			res[i][j] = new(big.Int).Mul(a[j], big.NewInt(i+1))
-			val := new(big.Int).Set(input[j])
-			if new(big.Int).Abs(val).Cmp(MPCPrimeHalf) > 0 {
-				return nil, fmt.Errorf("error: input value too big")
-			}
-			// in case input is negative
-			if val.Sign() < 0 {
-				val.Add(MPCPrime, val)
+	}
+	for j := 0; j < len(input); j++ {
+		val := new(big.Int).Set(input[j])
+		if new(big.Int).Abs(val).Cmp(MPCPrimeHalf) > 0 {
+			return nil, fmt.Errorf("error: input value too big")
+		}
+		// in case input is negative
+		if val.Sign() < 0 {
+			val.Add(MPCPrime, val)
+		}
+		for i := 0; i < n; i++ {
+			// Horner's rule
+			x := big.NewInt(int64(i + 1))
+			y := new(big.Int)
+			for k := t - 1; k >= 0; k-- {
+				y.Add(y, coefs[k][j])
+				y.Mul(y, x)
+				y.Mod(y, MPCPrime)
 			}
-			res[i][j].Add(val, res[i][j])
-			res[i][j].Mod(res[i][j], MPCPrime)
+			y.Add(y, val)
+			res[i][j] = y.Mod(y, MPCPrime)
 		}
 	}
 
 	return res, nil
 }
 
-func JoinSharesShamir(input [][]*big.Int) ([]*big.Int, error) {
-	res := make([]*big.Int, len(input[0]))
-
-	for i, _ := range input[0] {
-		res[i] = new(big.Int).Mul(input[0][i], big.NewInt(2))
-		res[i].Sub(res[i], input[1][i])
+// LagrangeCoefficients returns the coefficients l_i such that f(x) = sum l_i f(points[i])
+// for every polynomial f of degree smaller than the number of points.
+func LagrangeCoefficients(points []int64, x int64) []*big.Int {
+	res := make([]*big.Int, len(points))
+	bigX := big.NewInt(x)
+	for i, xi := range points {
+		num := big.NewInt(1)
+		den := big.NewInt(1)
+		for j, xj := range points {
+			if i == j {
+				continue
+			}
+			num.Mul(num, new(big.Int).Sub(bigX, big.NewInt(xj)))
+			num.Mod(num, MPCPrime)
+			den.Mul(den, big.NewInt(xi-xj))
+			den.Mod(den, MPCPrime)
+		}
+		res[i] = num.Mul(num, den.ModInverse(den, MPCPrime))
 		res[i].Mod(res[i], MPCPrime)
+	}
+
+	return res
+}
+
+// interpolate returns f(x) for the polynomial f through the j-th values of the shares at
+// the points, given the Lagrange coefficients for x.
+func interpolate(shares [][]*big.Int, coefs []*big.Int, j int) *big.Int {
+	res := new(big.Int)
+	for i, c := range coefs {
+		res.Add(res, new(big.Int).Mul(c, shares[i][j]))
+	}
+	return res.Mod(res, MPCPrime)
+}
 
-		check := new(big.Int).Mul(input[1][i], big.NewInt(3))
-		check.Sub(check, new(big.Int).Mul(input[2][i], big.NewInt(2)))
-		check.Mod(check, MPCPrime)
-		if check.Cmp(res[i]) != 0 {
-			return nil, fmt.Errorf("joining faild, inconsistent shares")
+// JoinSharesShamir joins 3 shares with threshold 1, see JoinSharesShamirN.
+func JoinSharesShamir(input [][]*big.Int) ([]*big.Int, error) {
+	return JoinSharesShamirN(input, 1)
+}
+
+// JoinSharesShamirN reconstructs the vector from the shares f(1), ..., f(n) of a
+// polynomial of degree t with Lagrange interpolation. The first t+1 shares determine
+// the polynomial; all the other shares are checked to lie on it, so that
+// ErrInconsistentShares is returned if any share is wrong. JoinSharesShamirCorrect also
+// corrects wrong shares.
+func JoinSharesShamirN(input [][]*big.Int, t int) ([]*big.Int, error) {
+	n := len(input)
+	if t < 1 || n < t+1 {
+		return nil, fmt.Errorf("joining failed, %d shares cannot be joined with threshold %d", n, t)
+	}
+	for i := range input {
+		if len(input[i]) != len(input[0]) {
+			return nil, fmt.Errorf("joining failed, shares of different lengths")
 		}
+	}
+
+	points := make([]int64, t+1)
+	for i := range points {
+		points[i] = int64(i + 1)
+	}
+	coefs := LagrangeCoefficients(points, 0)
+	checks := make([][]*big.Int, n)
+	for k := t + 1; k < n; k++ {
+		checks[k] = LagrangeCoefficients(points, int64(k+1))
+	}
 
-		check2 := new(big.Int).Mul(input[0][i], big.NewInt(3))
-		check2.Sub(check2, input[2][i])
-		check2.Mod(check2, MPCPrime)
-		twiceRI := new(big.Int).Mul(res[i], big.NewInt(2))
-		twiceRI.Mod(twiceRI, MPCPrime)
-		if check2.Cmp(twiceRI) != 0 {
-			return nil, fmt.Errorf("joining faild, inconsistent shares")
+	res := make([]*big.Int, len(input[0]))
+	for j := range input[0] {
+		res[j] = interpolate(input, coefs, j)
+		for k := t + 1; k < n; k++ {
+			if interpolate(input, checks[k], j).Cmp(new(big.Int).Mod(input[k][j], MPCPrime)) != 0 {
+				return nil, ErrInconsistentShares
+			}
 		}
 
-		if res[i].Cmp(MPCPrimeHalf) > 0 {
-			res[i].Sub(res[i], MPCPrime)
+		if res[j].Cmp(MPCPrimeHalf) > 0 {
+			res[j].Sub(res[j], MPCPrime)
 		}
 	}
 
 	return res, nil
 }
 
-func JoinSharesShamirFloat(input [][]*big.Int) []float64 {
-	res := make([]float64, len(input[0]))
-
-	for i, _ := range input[0] {
-		f := new(big.Int).Mul(input[0][i], big.NewInt(2))
-		f.Sub(f, input[1][i])
-		f.Mod(f, MPCPrime)
-		if f.Cmp(MPCPrimeHalf) > 0 {
-			f.Sub(f, MPCPrime)
-		}
-		res[i] = FixIntToFloat(f.Int64())
-	}
+// JoinSharesShamirFloat joins 3 shares with threshold 1 of values in the default fixed
+// point representation, see JoinSharesShamirFloatN.
+func JoinSharesShamirFloat(input [][]*big.Int) ([]float64, error) {
+	res, _, err := JoinSharesShamirFloatN(input, 1, DefaultFixedPoint)
+	return res, err
+}
 
-	return res
+// JoinSharesShamirFloatN reconstructs the vector from the shares with
+// JoinSharesShamirCorrect and returns the values in the fixed point representation fp as
+// floats together with the indexes of the wrong shares, see JoinSharesFloat.
+func JoinSharesShamirFloatN(input [][]*big.Int, t int, fp FixedPoint) ([]float64, []int, error) {
+	return JoinSharesFloat(input, ProtocolShamir, t, fp)
 }
 
 type VecEnc struct {
@@ -120,34 +207,80 @@ type VecEnc struct {
 	Val []byte
 }
 
+// EncryptVec encrypts the vector of field elements with the public key, encoded with
+// DefaultEncoding, and returns it base64 encoded.
 func EncryptVec(input []*big.Int, pubKey []byte) (string, error) {
-	inputBytes, err := json.Marshal(input)
+	return EncryptVecWith(input, pubKey, DefaultEncoding)
+}
+
+// EncryptVecWith is EncryptVec with the given encoding options. The vector is encrypted as
+// a stream, see key_management.NewEncryptWriter, so that it can be decrypted chunk by chunk.
+func EncryptVecWith(input []*big.Int, pubKey []byte, enc Encoding) (string, error) {
+	var buf strings.Builder
+	w := base64.NewEncoder(base64.StdEncoding, &buf)
+	err := EncryptVecTo(w, input, pubKey, enc)
 	if err != nil {
 		return "", err
 	}
-	// encrypt vec
-	keyEncBytes, err := key_management.Encrypt(inputBytes, pubKey)
+	err = w.Close()
 	if err != nil {
 		return "", err
 	}
-	keyEnc := base64.StdEncoding.EncodeToString(keyEncBytes)
 
-	return keyEnc, nil
+	return buf.String(), nil
 }
 
-func DecVec(encVec string, pubKey, secKey []byte) ([]*big.Int, error) {
-	encVecBytes, err := base64.StdEncoding.DecodeString(encVec)
+// EncryptVecTo writes the vector encrypted with the public key to w, see EncryptVecWith.
+func EncryptVecTo(w io.Writer, input []*big.Int, pubKey []byte, enc Encoding) error {
+	encW, err := NewEncryptVecWriter(w, uint64(len(input)), pubKey, enc)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	err = encW.Write(input)
+	if err != nil {
+		return err
 	}
+	return encW.Close()
+}
+
+// EncryptVecWriter encrypts a vector of a known number of elements chunk by chunk.
+type EncryptVecWriter struct {
+	*VecEncoder
+	stream io.WriteCloser
+}
 
-	dec, err := key_management.Decrypt(encVecBytes, pubKey, secKey)
+// NewEncryptVecWriter returns a writer of count elements encrypted with the public key to
+// w, which holds at most a chunk of elements in memory.
+func NewEncryptVecWriter(w io.Writer, count uint64, pubKey []byte, enc Encoding) (*EncryptVecWriter, error) {
+	stream, err := key_management.NewEncryptWriter(w, pubKey)
 	if err != nil {
 		return nil, err
 	}
+	e, err := NewVecEncoder(stream, count, enc)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptVecWriter{VecEncoder: e, stream: stream}, nil
+}
+
+// Close writes the last chunk and ends the encrypted stream.
+func (w *EncryptVecWriter) Close() error {
+	err := w.VecEncoder.Close()
+	if err != nil {
+		return err
+	}
+	return w.stream.Close()
+}
 
-	var res []*big.Int
-	err = json.Unmarshal(dec, &res)
+// DecVec decrypts a vector encrypted by EncryptVec, or encrypted as a single box or encoded
+// as JSON by earlier versions.
+func DecVec(encVec string, pubKey, secKey []byte) ([]*big.Int, error) {
+	res := make([]*big.Int, 0)
+	err := DecVecChunks(base64.NewDecoder(base64.StdEncoding, strings.NewReader(encVec)), pubKey, secKey,
+		func(chunk []*big.Int) error {
+			res = append(res, chunk...)
+			return nil
+		})
 	if err != nil {
 		return nil, err
 	}
@@ -155,55 +288,156 @@ func DecVec(encVec string, pubKey, secKey []byte) ([]*big.Int, error) {
 	return res, nil
 }
 
+// DecVecChunks decrypts a vector encrypted by EncryptVecTo read from r and passes it to fn
+// chunk by chunk, so that only a chunk is held in memory. A vector encrypted as a single
+// box by earlier versions is passed in one chunk.
+func DecVecChunks(r io.Reader, pubKey, secKey []byte, fn func(chunk []*big.Int) error) error {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(5)
+	if !key_management.IsStream(header) {
+		encVecBytes, err := ioutil.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		dec, err := key_management.Decrypt(encVecBytes, pubKey, secKey)
+		if err != nil {
+			return err
+		}
+		vec, err := DecodeVec(dec)
+		if err != nil {
+			return err
+		}
+		return fn(vec)
+	}
+
+	stream, err := key_management.NewDecryptReader(br, pubKey, secKey)
+	if err != nil {
+		return err
+	}
+	d, err := NewVecDecoder(stream)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	// the end of the stream is authenticated
+	if n, err := stream.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("decoding failed, trailing data")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// CsvToVec reads the csv file and returns its values in the fixed point representation
+// DefaultFixedPoint, see CsvToVecFixed.
 func CsvToVec(file string) ([]*big.Int, []string, []float64, error) {
-	f, err := os.Open(file)
+	return CsvToVecFixed(file, DefaultFixedPoint)
+}
+
+// CsvToVecFixed reads the csv file and returns its values in the fixed point
+// representation fp, the columns and the values as floats. It fails if some value does not
+// fit fp.
+func CsvToVecFixed(file string, fp FixedPoint) ([]*big.Int, []string, []float64, error) {
+	vecFloat, cols, err := CsvToFloats(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	vec, err := fp.EncodeVec(vecFloat)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	countLines := 0
-	vec := make([]*big.Int, 0)
+	return vec, cols, vecFloat, nil
+}
+
+// CsvToFloats reads the values of the csv file, row by row, and its columns.
+func CsvToFloats(file string) ([]float64, []string, error) {
 	vecFloat := make([]float64, 0)
+	cols, err := ForEachCsvRow(file, func(vals []float64) error {
+		vecFloat = append(vecFloat, vals...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return vecFloat, cols, nil
+}
+
+// ForEachCsvRow reads the csv file line by line and passes the values of each row to fn,
+// which must not keep them. It returns the columns given by the first line; rows with a
+// different number of values are an error.
+func ForEachCsvRow(file string, fn func(vals []float64) error) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	countLines := 0
 	scan := bufio.NewScanner(f)
+	scan.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
 	var cols []string
+	var row []float64
 	for scan.Scan() {
 		countLines++
-		text := scan.Text()
+		text := strings.TrimRight(scan.Text(), "\r")
 		if countLines == 1 {
 			cols = strings.Split(text, ",")
+			row = make([]float64, len(cols))
+			continue
+		}
+		if text == "" {
 			continue
 		}
 
 		vals := strings.Split(text, ",")
-
-		for _, e := range vals {
-			f, err := strconv.ParseFloat(e, 64)
-			if err != nil {
-				return nil, nil, nil, err
-			}
-			vecFloat = append(vecFloat, f)
-			i, err := FloatToFixInt(f)
+		if len(vals) != len(cols) {
+			return nil, fmt.Errorf("line %d has %d values for %d columns", countLines, len(vals), len(cols))
+		}
+		for i, e := range vals {
+			row[i], err = strconv.ParseFloat(e, 64)
 			if err != nil {
-				return nil, nil, nil, err
+				return nil, err
 			}
-			val := new(big.Int).SetInt64(i)
-
-			vec = append(vec, val)
+		}
+		err = fn(row)
+		if err != nil {
+			return nil, err
 		}
 	}
 	if err = scan.Err(); err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
-	err = f.Close()
 
-	return vec, cols, vecFloat, nil
+	return cols, nil
 }
 
+// CsvTxtToVec reads the csv text like CsvToVec.
 func CsvTxtToVec(csvTxt string) ([]*big.Int, []string, error) {
+	return CsvTxtToVecFixed(csvTxt, DefaultFixedPoint)
+}
+
+// CsvTxtToVecFixed reads the csv text like CsvToVecFixed.
+func CsvTxtToVecFixed(csvTxt string, fp FixedPoint) ([]*big.Int, []string, error) {
 	lines := strings.Split(csvTxt, "\n")
 
 	countLines := 0
-	vec := make([]*big.Int, 0)
+	vecFloat := make([]float64, 0)
 	var cols []string
 	for _, e := range lines {
 		countLines++
@@ -222,55 +456,53 @@ func CsvTxtToVec(csvTxt string) ([]*big.Int, []string, error) {
 			if err != nil {
 				return nil, nil, err
 			}
-			i, err := FloatToFixInt(f)
-			if err != nil {
-				return nil, nil, err
-			}
-			val := new(big.Int).SetInt64(i)
-
-			vec = append(vec, val)
+			vecFloat = append(vecFloat, f)
 		}
 	}
+	vec, err := fp.EncodeVec(vecFloat)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return vec, cols, nil
 }
 
-func SplitCsvFile(file, output string, pubKeys [][]byte) ([]float64, [][]*big.Int, []string, error) {
-	vec, cols, vecFloat, err := CsvToVec(file)
-	if err != nil {
-		return nil, nil, nil, err
-	}
+// SplitCsvFile splits the data in the csv file into shares with threshold t, one for
+// each of the public keys, and writes them encrypted to the output file, see
+// SplitCsvFileWith.
+func SplitCsvFile(file, output string, pubKeys [][]byte, t int) ([]float64, [][]*big.Int, []string, error) {
+	return SplitCsvFileFixed(file, output, pubKeys, t, DefaultFixedPoint)
+}
 
-	shares, err := CreateSharesShamir(vec)
-	if err != nil {
-		return nil, nil, nil, err
-	}
+// SplitCsvFileFixed is SplitCsvFile with the values in the fixed point representation fp,
+// which must be given with the dataset.
+func SplitCsvFileFixed(file, output string, pubKeys [][]byte, t int, fp FixedPoint) ([]float64, [][]*big.Int,
+	[]string, error) {
+	return SplitCsvFileWith(file, output, pubKeys, ShareFileOptions{Threshold: t, FixedPoint: fp})
+}
 
-	w, err := os.Create(output)
+// SplitCsvFileWith splits the data in the csv file into shares, one for each of the
+// public keys, and writes them encrypted to the output file as a signed share file
+// described by the options, see NewShareFile. The commitment of the data is the one of the
+// file with opts.CommitmentNonce if it is not given.
+func SplitCsvFileWith(file, output string, pubKeys [][]byte, opts ShareFileOptions) ([]float64, [][]*big.Int,
+	[]string, error) {
+	vec, cols, vecFloat, err := CsvToVecFixed(file, opts.FixedPoint.OrDefault())
 	if err != nil {
 		return nil, nil, nil, err
 	}
-
-	for i := int64(0); i < 3; i++ {
-		msg, err := EncryptVec(shares[i], pubKeys[i])
-		if err != nil {
-			return nil, nil, nil, err
-		}
-
-		_, err = w.Write([]byte(msg))
-		if err != nil {
-			return nil, nil, nil, err
-		}
-		_, err = w.Write([]byte("\n"))
+	if opts.Commitment == "" && opts.CommitmentNonce != nil {
+		opts.Commitment, err = CommitFile(file, opts.CommitmentNonce)
 		if err != nil {
 			return nil, nil, nil, err
 		}
 	}
-	_, err = w.Write([]byte(strings.Join(cols, ",") + "\n"))
+
+	f, shares, err := NewShareFile(vec, cols, pubKeys, opts)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	err = w.Close()
+	err = WriteShareFile(f, output)
 
 	return vecFloat, shares, cols, err
 }
@@ -289,6 +521,15 @@ func DownloadShare(fileLink, filePath string) error {
 	return err
 }
 
+// shareTempFile creates an empty temporary file to download a share file of the node.
+func shareTempFile(nodeId int) (string, error) {
+	f, err := ioutil.TempFile("", "mpc_data"+strconv.Itoa(nodeId)+"-*.txt")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
 func DeleteShare(filePath string) error {
 	cmdStr := "rm " + filePath
 	cmd := exec.Command("bash", "-c", cmdStr)
@@ -318,44 +559,24 @@ func Readln(r *bufio.Reader) (string, error) {
 	return string(ln), err
 }
 
+// ReadShare reads the share of the node with the key pair from a file written by
+// SplitCsvFile and returns it with the columns of the dataset, see ReadShareFile; nodeId is
+// only used for files of version 1.
 func ReadShare(file string, pubKey, secKey []byte, nodeId int) ([]*big.Int, []string, error) {
-	f, err := os.Open(file)
+	share, header, _, err := ReadShareFile(file, pubKey, secKey, nil, nodeId)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	reader := bufio.NewReader(f)
-
-	countLines := 0
-	var decVec []*big.Int
-	for countLines < 3 {
-		text, err := Readln(reader)
-
-		if countLines != nodeId {
-			countLines++
-			continue
-		}
-
-		decVec, err = DecVec(text, pubKey, secKey)
-		if err != nil {
-			return nil, nil, err
-		}
-		countLines++
-	}
-	// columns info
-	text, err := Readln(reader)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	cols := strings.Split(text, ",")
-
-	err = f.Close()
-
-	return decVec, cols, err
+	return share, header.Cols, nil
 }
 
 func ReduceToCols(input []*big.Int, colsAll []string, val string) ([]*big.Int, []string, error) {
+	return ReduceToColsN(input, colsAll, val, 1)
+}
+
+// ReduceToColsN is ReduceToCols for shares of width field elements per value.
+func ReduceToColsN(input []*big.Int, colsAll []string, val string, width int) ([]*big.Int, []string, error) {
 	cols := strings.Split(val, ",")
 	colsMap := make(map[string]bool)
 	for _, e := range cols {
@@ -364,7 +585,7 @@ func ReduceToCols(input []*big.Int, colsAll []string, val string) ([]*big.Int, [
 	inputNew := make([]*big.Int, len(input)*len(cols)/len(colsAll))
 	count := 0
 	for i, e := range input {
-		index := i % len(colsAll)
+		index := (i / width) % len(colsAll)
 		if _, ok := colsMap[colsAll[index]]; ok {
 			inputNew[count] = e
 			count++
@@ -383,83 +604,279 @@ func ReduceToCols(input []*big.Int, colsAll []string, val string) ([]*big.Int, [
 	return inputNew, colsNew, nil
 }
 
-func PrepareData(inputsLinks []string, inputVecs []string, inputCols [][]string, nodeId int, sm string, params map[string]string, pubKey, secKey []byte) (int, int, int, []string, string) {
-	// download and read
-	allInputs := make([]*big.Int, 0)
+// PrepareData reads the shares of the node of the datasets given by links or encrypted
+// vectors and writes them as inputs of SCALE for the sharing of the computation; it
+// returns the number of links, of columns and of values. The share file of a link must be
+// signed with the base64 encoded key at the same position of signerKeys if it is given,
+// and its sharing and fixed point representation must be the ones of the computation.
+// The origin of the shares is checked by the verifier, if it is not nil, before they are
+// used, and the shares are checked against their commitments; the commitments of the
+// inputs are set in the verifier. The shares are read, reduced to the columns and written
+// chunk by chunk.
+func PrepareData(inputsLinks []string, signerKeys []string, inputVecs []string, inputCols [][]string, nodeId int, sm string, params map[string]string, pubKey, secKey []byte, sharing Sharing, fp FixedPoint, verifier *ShareVerifier) (int, int, int, []string, string) {
+	width := SharesPerValue(sharing.Protocol, sharing.Parties, sharing.Threshold)
+	w, err := computation.NewInputWriter(nodeId, width, sm)
+	if err != nil {
+		e := "error, computation failed, input error "
+		log.Error(e, err)
+		return 0, 0, 0, nil, e
+	}
+	return PrepareDataWith(w, inputsLinks, signerKeys, inputVecs, inputCols, nodeId, params, pubKey, secKey, sharing, fp,
+		verifier)
+}
 
-	var cols []string
-	var input []*big.Int
+// InputWriter receives the inputs of a node for an MPC backend: shares of values of
+// SharesPerValue field elements each, or private inputs; see computation.InputWriter.
+type InputWriter interface {
+	WriteShares(shares []*big.Int) error
+	WritePrivate(privateIn []*big.Int) error
+	Close() error
+}
+
+// PrepareDataWith is PrepareData writing the inputs to w, which is closed.
+func PrepareDataWith(w InputWriter, inputsLinks []string, signerKeys []string, inputVecs []string,
+	inputCols [][]string, nodeId int, params map[string]string, pubKey, secKey []byte, sharing Sharing,
+	fp FixedPoint, verifier *ShareVerifier) (int, int, int, []string, string) {
+	width := SharesPerValue(sharing.Protocol, sharing.Parties, sharing.Threshold)
 	var err error
-	for _, link := range inputsLinks {
-		err = DownloadShare(link, "mpc_data"+strconv.Itoa(nodeId)+".txt")
+	// additive shares of full threshold protocols are private inputs
+	in := &inputSink{w: w, private: sharing.Protocol == ProtocolFullThreshold, width: width}
+	if val, ok := params["cols"]; ok {
+		in.selected = val
+	}
+	inputs := make([]InputCommitment, 0, len(inputsLinks)+len(inputVecs))
+
+	// download and read
+	for i, link := range inputsLinks {
+		var signerKey []byte
+		if i < len(signerKeys) && signerKeys[i] != "" {
+			signerKey, err = base64.StdEncoding.DecodeString(signerKeys[i])
+			if err != nil {
+				w.Close()
+				e := "error, computation failed, signer key error "
+				log.Error(e, err)
+				return 0, 0, 0, nil, e
+			}
+		}
+		// each download has its own file, so that jobs running at the same time do not mix
+		var file string
+		file, err = shareTempFile(nodeId)
+		if err == nil {
+			err = DownloadShare(link, file)
+			if err != nil {
+				os.Remove(file)
+			}
+		}
 		if err != nil {
+			w.Close()
 			e := "error, computation failed, downloading data error "
 			log.Error(e, err)
 			return 0, 0, 0, nil, e
 		}
 		log.Info("Engine: Downloaded data from ", link)
 
-		input, cols, err = ReadShare("mpc_data"+strconv.Itoa(nodeId)+".txt", pubKey, secKey, nodeId)
-		if err != nil || len(input) == 0 {
+		var header *ShareHeader
+		err = in.readShareFile(file, pubKey, secKey, signerKey, nodeId, sharing, fp,
+			func(h *ShareHeader) error {
+				header = h
+				return verifier.checkLink(i, h)
+			})
+		// clean from memory
+		errDelete := DeleteShare(file)
+		if errDelete != nil {
+			log.Error("computation failed, deleting data error ", errDelete)
+		}
+		if err != nil {
+			w.Close()
 			e := "error, computation failed, input error "
 			log.Error("error, computation failed, input error ", err)
 			return 0, 0, 0, nil, e
 		}
-		// clean from memory
-		err = DeleteShare("mpc_data" + strconv.Itoa(nodeId) + ".txt")
-		if err != nil {
-			log.Error("computation failed, deleting data error ", err)
-		}
-		// reduce the input to specified columns
-		if val, ok := params["cols"]; ok {
-			inputNew, colsNew, err := ReduceToCols(input, cols, val)
-			if err != nil {
-				e := "error, computation failed, columns error "
-				log.Error(e, err)
-				return 0, 0, 0, nil, e
-			}
-			input = inputNew
-			cols = colsNew
-		}
-
-		allInputs = append(allInputs, input...)
+		input := verifier.linkInput(i, header)
+		input.Share = in.commit.Sum()
+		inputs = append(inputs, input)
 	}
 
 	for i, encText := range inputVecs {
-		input, err = DecVec(encText, pubKey, secKey)
+		err = verifier.checkVec(i, encText)
 		if err != nil {
+			w.Close()
+			e := "error, computation failed, origin of input not verified "
+			log.Error(e, err)
+			return 0, 0, 0, nil, e
+		}
+		commit, input, expected := verifier.vecCommitter(i, nodeId)
+		err = in.start(inputCols[i], commit)
+		if err == nil {
+			err = DecVecChunks(base64.NewDecoder(base64.StdEncoding, strings.NewReader(encText)), pubKey, secKey,
+				in.write)
+		}
+		if err == nil {
+			err = in.end()
+		}
+		if err != nil {
+			w.Close()
 			e := "error, computation failed, decrypting input "
 			log.Error(e, err)
 			return 0, 0, 0, nil, e
 		}
-		cols = inputCols[i]
-
-		// reduce the input to specified columns
-		if val, ok := params["cols"]; ok {
-			inputNew, colsNew, err := ReduceToCols(input, cols, val)
-			if err != nil {
-				e := "error, computation failed, columns error "
-				log.Error(e, err)
-				return 0, 0, 0, nil, e
-			}
-			input = inputNew
-			cols = colsNew
+		input.Share = commit.Sum()
+		if expected != "" && input.Share != expected {
+			w.Close()
+			e := "error, computation failed, input does not match its commitment "
+			log.Error(e, input.Dataset)
+			return 0, 0, 0, nil, e
 		}
-
-		allInputs = append(allInputs, input...)
+		inputs = append(inputs, input)
 	}
 
-	log.Info("MPC engine: data size: ", len(allInputs)/len(cols), " rows ", len(cols), " columns.")
-
-	// prepare data for Scale
-	err = computation.InputPrepare(nodeId, allInputs, nil, sm)
+	err = w.Close()
 	if err != nil {
 		e := "error, computation failed, input error "
 		log.Error(e, err)
 		return 0, 0, 0, nil, e
 	}
+	if len(in.cols) == 0 {
+		e := "error, computation failed, input error "
+		log.Error(e, "no input")
+		return 0, 0, 0, nil, e
+	}
+	log.Info("MPC engine: data size: ", in.count/width/len(in.cols), " rows ", len(in.cols), " columns.")
+	if verifier != nil {
+		verifier.Inputs = inputs
+	}
 
-	return len(inputsLinks), len(cols), len(allInputs), cols, ""
+	return len(inputsLinks), len(in.cols), in.count / width, in.cols, ""
+}
+
+// inputSink writes the shares of the datasets as inputs of SCALE, reduced to the selected
+// columns, keeping an incomplete row until the rest of it is read, and commits to the
+// shares of each dataset.
+type inputSink struct {
+	w        InputWriter
+	private  bool
+	width    int
+	selected string   // comma separated selected columns, all if empty
+	colsAll  []string // columns of the current dataset
+	cols     []string // selected columns of the current dataset
+	pending  []*big.Int
+	read     int // field elements read of the current dataset
+	count    int // field elements written
+	commit   *ShareCommitter
+}
+
+// start starts the shares of a dataset with the columns, committed to with commit.
+func (in *inputSink) start(cols []string, commit *ShareCommitter) error {
+	if len(cols) == 0 {
+		return fmt.Errorf("dataset without columns")
+	}
+	in.colsAll, in.cols, in.pending, in.read, in.commit = cols, cols, in.pending[:0], 0, commit
+	if in.selected != "" {
+		_, colsNew, err := ReduceToColsN(nil, cols, in.selected, in.width)
+		if err != nil {
+			return err
+		}
+		in.cols = colsNew
+	}
+	return nil
+}
+
+// write writes the complete rows of the chunk following the ones read before.
+func (in *inputSink) write(chunk []*big.Int) error {
+	err := in.commit.Write(chunk)
+	if err != nil {
+		return err
+	}
+	in.read += len(chunk)
+	in.pending = append(in.pending, chunk...)
+	rowLen := in.width * len(in.colsAll)
+	rows := in.pending[:len(in.pending)/rowLen*rowLen]
+	if len(rows) == 0 {
+		return nil
+	}
+	if in.selected != "" {
+		rows, _, err = ReduceToColsN(rows, in.colsAll, in.selected, in.width)
+		if err != nil {
+			return err
+		}
+	}
+
+	if in.private {
+		err = in.w.WritePrivate(rows)
+	} else {
+		err = in.w.WriteShares(rows)
+	}
+	if err != nil {
+		return err
+	}
+	in.count += len(rows)
+	in.pending = append(in.pending[:0], in.pending[len(in.pending)/rowLen*rowLen:]...)
+	return nil
+}
+
+// end checks that the shares of the dataset were complete rows.
+func (in *inputSink) end() error {
+	if in.read == 0 {
+		return fmt.Errorf("empty share")
+	}
+	if len(in.pending) != 0 {
+		return fmt.Errorf("share has an incomplete row")
+	}
+	return nil
+}
+
+// readShareFile writes the share of the node from a share file whose header is accepted by
+// check and checks the share against its commitment, see PrepareData.
+func (in *inputSink) readShareFile(file string, pubKey, secKey, signerKey []byte, nodeId int, sharing Sharing,
+	fp FixedPoint, check func(header *ShareHeader) error) error {
+	r, err := OpenShareFile(file, pubKey, secKey, signerKey, nodeId)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	err = checkShareHeader(r.Header, r.Index, nodeId, sharing, fp)
+	if err == nil {
+		err = check(r.Header)
+	}
+	if err == nil {
+		err = in.start(r.Header.Cols, NewShareCommitter(r.Header.DatasetId, r.Header.Commitment, r.Index))
+	}
+	for err == nil {
+		var chunk []*big.Int
+		chunk, err = r.Next()
+		if err == nil {
+			err = in.write(chunk)
+		}
+	}
+	if err != io.EOF {
+		return err
+	}
+	err = in.end()
+	if err != nil {
+		return err
+	}
+
+	return r.Header.checkShare(r.Index, in.commit.Sum())
+}
+
+// checkShareHeader checks that the share at position index of a share file can be used as
+// the share of the node in a computation with the sharing and the fixed point
+// representation. Files of version 1 give no sharing nor representation.
+func checkShareHeader(header *ShareHeader, index, nodeId int, sharing Sharing, fp FixedPoint) error {
+	if header.Version == 1 {
+		return nil
+	}
+	if header.Sharing != sharing {
+		return fmt.Errorf("share file made for sharing %s, the computation uses %s", header.Sharing, sharing)
+	}
+	if index != nodeId {
+		return fmt.Errorf("share file made for node %d at position %d", nodeId, index)
+	}
+	if header.FixedPoint != fp.OrDefault() {
+		return fmt.Errorf("share file made with fixed point parameters k=%d, f=%d, the computation uses k=%d, f=%d",
+			header.FixedPoint.K, header.FixedPoint.F, fp.OrDefault().K, fp.OrDefault().F)
+	}
+	return nil
 }
 
 func ResultsToCsvText(vec []float64, cols []string, funcName string) (string, error) {
@@ -1,11 +1,26 @@
 package data_management
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"math"
 	"math/big"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/krakenh2020/MPCService/computation"
 	"github.com/krakenh2020/MPCService/key_management"
 )
 
@@ -23,6 +38,223 @@ func TestSharesShamir(t *testing.T) {
 	assert.Equal(t, a, b)
 }
 
+func TestSharesShamirN(t *testing.T) {
+	a, err := NewUniformRandomVector(20, MPCPrimeHalf)
+	assert.NoError(t, err)
+	a[0] = big.NewInt(-5)
+
+	for _, e := range [][2]int{{3, 1}, {4, 1}, {5, 2}, {7, 3}} {
+		n, threshold := e[0], e[1]
+		shares, err := CreateSharesShamirN(a, n, threshold)
+		assert.NoError(t, err)
+		assert.Equal(t, n, len(shares))
+
+		b, err := JoinSharesShamirN(shares, threshold)
+		assert.NoError(t, err)
+		assert.Equal(t, a, b)
+
+		// the first t+1 shares suffice
+		b, err = JoinSharesShamirN(shares[:threshold+1], threshold)
+		assert.NoError(t, err)
+		assert.Equal(t, a, b)
+
+		// a changed share is detected
+		shares[n-1][3] = new(big.Int).Add(shares[n-1][3], big.NewInt(1))
+		_, err = JoinSharesShamirN(shares, threshold)
+		assert.Error(t, err)
+	}
+
+	_, err = CreateSharesShamirN(a, 4, 2)
+	assert.Error(t, err)
+	_, err = CreateSharesShamirN(a, 3, 0)
+	assert.Error(t, err)
+}
+
+func TestSharesShamirCorrect(t *testing.T) {
+	a, err := NewUniformRandomVector(10, MPCPrimeHalf)
+	assert.NoError(t, err)
+	a[0] = big.NewInt(-5)
+
+	for _, e := range [][2]int{{4, 1}, {5, 1}, {5, 2}, {7, 2}, {7, 3}} {
+		n, threshold := e[0], e[1]
+		shares, err := CreateSharesShamirN(a, n, threshold)
+		assert.NoError(t, err)
+
+		b, cheaters, err := JoinSharesShamirCorrect(shares, threshold)
+		assert.NoError(t, err)
+		assert.Equal(t, a, b)
+		assert.Empty(t, cheaters)
+
+		// the largest number of wrong shares that can be corrected
+		numWrong := MaxCorrectable(n, threshold)
+		wrong := make([]int, numWrong)
+		for i := range wrong {
+			wrong[i] = n - 1 - 2*i
+			shares[wrong[i]][i] = new(big.Int).Add(shares[wrong[i]][i], big.NewInt(int64(i+1)))
+			shares[wrong[i]][9] = big.NewInt(12345)
+		}
+		b, cheaters, err = JoinSharesShamirCorrect(shares, threshold)
+		assert.NoError(t, err)
+		assert.Equal(t, a, b)
+		assert.ElementsMatch(t, wrong, cheaters)
+
+		// one more wrong share is detected but cannot be corrected
+		shares[0][9] = big.NewInt(54321)
+		_, _, err = JoinSharesShamirCorrect(shares, threshold)
+		assert.ErrorIs(t, err, ErrInconsistentShares)
+	}
+
+	// with 2t+1 shares a wrong share is detected but not identified
+	shares, err := CreateSharesShamir(a)
+	assert.NoError(t, err)
+	shares[1][2] = new(big.Int).Add(shares[1][2], big.NewInt(1))
+	_, _, err = JoinSharesShamirCorrect(shares, 1)
+	assert.ErrorIs(t, err, ErrInconsistentShares)
+	_, err = JoinSharesShamirFloat(shares)
+	assert.Error(t, err)
+}
+
+func TestProtocols(t *testing.T) {
+	a, err := NewUniformRandomVector(10, MPCPrimeHalf)
+	assert.NoError(t, err)
+	a[0] = big.NewInt(-5)
+
+	for _, e := range []struct {
+		protocol     string
+		n, threshold int
+		perValue     int
+	}{{ProtocolShamir, 5, 2, 1}, {ProtocolReplicated, 3, 1, 2}, {ProtocolReplicated, 4, 1, 3},
+		{ProtocolReplicated, 5, 2, 6}, {ProtocolFullThreshold, 3, 2, 1}, {ProtocolFullThreshold, 4, 3, 1}} {
+		assert.Equal(t, e.perValue, SharesPerValue(e.protocol, e.n, e.threshold))
+		shares, err := CreateShares(a, e.protocol, e.n, e.threshold)
+		assert.NoError(t, err)
+		assert.Equal(t, e.n, len(shares))
+		assert.Equal(t, len(a)*e.perValue, len(shares[0]))
+
+		b, cheaters, err := JoinShares(shares, e.protocol, e.threshold)
+		assert.NoError(t, err)
+		assert.Equal(t, a, b)
+		assert.Empty(t, cheaters)
+	}
+
+	// a wrong part of a replicated share is corrected if enough parties hold it
+	shares, err := CreateShares(a, ProtocolReplicated, 4, 1)
+	assert.NoError(t, err)
+	shares[2][4] = new(big.Int).Add(shares[2][4], big.NewInt(1))
+	b, cheaters, err := JoinShares(shares, ProtocolReplicated, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.Equal(t, []int{2}, cheaters)
+
+	shares, err = CreateShares(a, ProtocolReplicated, 3, 1)
+	assert.NoError(t, err)
+	shares[2][4] = new(big.Int).Add(shares[2][4], big.NewInt(1))
+	_, _, err = JoinShares(shares, ProtocolReplicated, 1)
+	assert.ErrorIs(t, err, ErrInconsistentShares)
+
+	assert.Error(t, CheckProtocol(ProtocolFullThreshold, 3, 1))
+	assert.Error(t, CheckProtocol(ProtocolReplicated, 4, 2))
+	assert.Error(t, CheckProtocol("unknown", 3, 1))
+	assert.Equal(t, 2, ProtocolThreshold(ProtocolFullThreshold, 3))
+	assert.Equal(t, 1, ProtocolThreshold(ProtocolShamir, 3))
+}
+
+func TestJoinSharesErasures(t *testing.T) {
+	a, err := NewUniformRandomVector(10, MPCPrimeHalf)
+	assert.NoError(t, err)
+	a[0] = big.NewInt(-5)
+
+	// with 7 parties and threshold 2, a missing share leaves 6 shares correcting one wrong
+	for _, protocol := range []string{ProtocolShamir, ProtocolReplicated} {
+		shares, err := CreateShares(a, protocol, 7, 2)
+		assert.NoError(t, err)
+		shares[1] = nil
+		b, cheaters, err := JoinShares(shares, protocol, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, a, b)
+		assert.Equal(t, []int{1}, cheaters)
+
+		shares[4][3] = new(big.Int).Add(shares[4][3], big.NewInt(1))
+		b, cheaters, err = JoinShares(shares, protocol, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, a, b)
+		assert.Equal(t, []int{1, 4}, cheaters)
+
+		// a second wrong share is too many
+		shares[6][3] = new(big.Int).Add(shares[6][3], big.NewInt(1))
+		_, _, err = JoinShares(shares, protocol, 2)
+		assert.ErrorIs(t, err, ErrInconsistentShares)
+	}
+
+	// t+1 shares are joined but a wrong one among them is not detected, t shares are too few
+	shares, err := CreateShares(a, ProtocolShamir, 3, 1)
+	assert.NoError(t, err)
+	shares[0] = nil
+	b, cheaters, err := JoinShares(shares, ProtocolShamir, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.Equal(t, []int{0}, cheaters)
+	shares[2] = nil
+	_, _, err = JoinShares(shares, ProtocolShamir, 1)
+	assert.Error(t, err)
+
+	// with 4 parties and threshold 1, a missing share leaves 3 that only detect a wrong one
+	shares, err = CreateShares(a, ProtocolShamir, 4, 1)
+	assert.NoError(t, err)
+	shares[3] = nil
+	shares[0][2] = new(big.Int).Add(shares[0][2], big.NewInt(1))
+	_, _, err = JoinShares(shares, ProtocolShamir, 1)
+	assert.ErrorIs(t, err, ErrInconsistentShares)
+
+	shares, err = CreateShares(a, ProtocolFullThreshold, 3, 2)
+	assert.NoError(t, err)
+	shares[2] = nil
+	_, _, err = JoinShares(shares, ProtocolFullThreshold, 2)
+	assert.Error(t, err)
+}
+
+func TestFixedPoint(t *testing.T) {
+	assert.NoError(t, DefaultFixedPoint.Check())
+	assert.Equal(t, DefaultFixedPoint, FixedPoint{}.OrDefault())
+	assert.Error(t, FixedPoint{K: 20, F: 20}.Check())
+	assert.Error(t, FixedPoint{K: 63, F: 40}.Check())
+
+	// values of 2^20 or more need more than the default 41 bits
+	txt := "a,b\n3000000,0.5\n-2,1\n"
+	_, _, err := CsvTxtToVec(txt)
+	assert.Error(t, err)
+	fp := FixedPoint{K: 50, F: 20}
+	vec, cols, err := CsvTxtToVecFixed(txt, fp)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, cols)
+
+	shares, err := CreateShares(vec, ProtocolShamir, 3, 1)
+	assert.NoError(t, err)
+	res, _, err := JoinSharesFloat(shares, ProtocolShamir, 1, fp)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{3000000, 0.5, -2, 1}, res)
+
+	// more fractional bits give a better precision of small values
+	fine := FixedPoint{K: 45, F: 32}
+	assert.NoError(t, fine.Check())
+	v, err := fine.Encode(1e-9)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1e-9, fine.Decode(v), 2e-10)
+	v, err = DefaultFixedPoint.Encode(1e-9)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), v)
+
+	// the joined values are decoded with the parameters they were shared with
+	vec, _, err = CsvTxtToVecFixed("a\n1.5\n-0.25\n", fine)
+	assert.NoError(t, err)
+	shares, err = CreateShares(vec, ProtocolShamir, 3, 1)
+	assert.NoError(t, err)
+	res, cheaters, err := JoinSharesShamirFloatN(shares, 1, fine)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{1.5, -0.25}, res)
+	assert.Empty(t, cheaters)
+}
+
 func TestEncVec(t *testing.T) {
 	n := 100
 	a, err := NewUniformRandomVector(n, MPCPrime)
@@ -39,6 +271,52 @@ func TestEncVec(t *testing.T) {
 	assert.Equal(t, a, d)
 }
 
+func TestEncodeVec(t *testing.T) {
+	a, err := NewUniformRandomVector(1000, MPCPrime)
+	assert.NoError(t, err)
+	// elements of at least 2^128 are written as overflows
+	a[0] = new(big.Int).Sub(MPCPrime, big.NewInt(1))
+
+	for _, enc := range []Encoding{DefaultEncoding, {ChunkSize: 7}, {Compress: true, ChunkSize: 100}} {
+		b, err := EncodeVec(a, enc)
+		assert.NoError(t, err)
+		d, err := DecodeVec(b)
+		assert.NoError(t, err)
+		assert.Equal(t, a, d)
+	}
+
+	// fixed width elements take less than half of the JSON numbers
+	b, err := EncodeVec(a, DefaultEncoding)
+	assert.NoError(t, err)
+	j, err := json.Marshal(a)
+	assert.NoError(t, err)
+	assert.Less(t, 2*len(b), len(j))
+
+	// small values compress well
+	small := make([]*big.Int, 1000)
+	for i := range small {
+		small[i] = big.NewInt(int64(i % 10))
+	}
+	compressed, err := EncodeVec(small, Encoding{Compress: true})
+	assert.NoError(t, err)
+	assert.Less(t, len(compressed), len(small)*ElementSize/4)
+
+	// vectors encrypted as JSON by earlier versions are decrypted
+	pubKey, secKey := key_management.GenerateKeypair()
+	encBytes, err := key_management.Encrypt(j, pubKey)
+	assert.NoError(t, err)
+	d, err := DecVec(base64.StdEncoding.EncodeToString(encBytes), pubKey, secKey)
+	assert.NoError(t, err)
+	assert.Equal(t, a, d)
+
+	_, err = DecodeVec(b[:len(b)-1])
+	assert.Error(t, err)
+	_, err = DecodeVec(append(append([]byte{}, b...), 0))
+	assert.Error(t, err)
+	_, err = DecodeVec([]byte("MPCV\x02"))
+	assert.Error(t, err)
+}
+
 func TestCsvFileSplitJoin(t *testing.T) {
 	nodeNames := []string{"Berlin_node", "Paris_node", "Ljubljana_node"}
 	pubKeys := make([][]byte, 3)
@@ -48,16 +326,18 @@ func TestCsvFileSplitJoin(t *testing.T) {
 		pubKeys[i], secKeys[i], _, err = key_management.LoadKeysFromCertKey("../key_management/keys_certificates", nodeNames[i])
 		assert.NoError(t, err)
 	}
-	vec, _, _, err := SplitCsvFile("framingham_tiny.csv", "framingham_tiny_enc.txt", pubKeys)
+	file := t.TempDir() + "/framingham_tiny_enc.txt"
+	vec, _, _, err := SplitCsvFile("framingham_tiny.csv", file, pubKeys, 1)
 	assert.NoError(t, err)
 
 	shares := make([][]*big.Int, 3)
 	for i := 0; i < 3; i++ {
-		shares[i], _, err = ReadShare("framingham_tiny_enc.txt", pubKeys[i], secKeys[i], i)
+		shares[i], _, err = ReadShare(file, pubKeys[i], secKeys[i], i)
 		assert.NoError(t, err)
 	}
 
-	b := JoinSharesShamirFloat(shares)
+	b, err := JoinSharesShamirFloat(shares)
+	assert.NoError(t, err)
 	for i, _ := range vec {
 		assert.Equal(t, math.Trunc(vec[i]*10), math.Trunc(b[i]*10))
 	}
@@ -94,3 +374,473 @@ func TestResultsToCsvText(t *testing.T) {
 	_, err = ResultsToCsvText(b, cols, "linear_regression")
 	assert.NoError(t, err)
 }
+
+func TestShareFile(t *testing.T) {
+	nodeNames := []string{"Berlin_node", "Paris_node", "Ljubljana_node"}
+	pubKeys := make([][]byte, 3)
+	secKeys := make([][]byte, 3)
+	var err error
+	for i := 0; i < 3; i++ {
+		pubKeys[i], secKeys[i], _, err = key_management.LoadKeysFromCertKey("../key_management/keys_certificates", nodeNames[i])
+		assert.NoError(t, err)
+	}
+	signerKey, signingKey := key_management.GenerateSigningKeypair()
+	fp := FixedPoint{K: 45, F: 24}
+	file := t.TempDir() + "/shares.txt"
+	vec, _, cols, err := SplitCsvFileWith("framingham_tiny.csv", file, pubKeys, ShareFileOptions{DatasetId: "tiny",
+		NodesNames: nodeNames, FixedPoint: fp, Signer: "provider", SigningKey: signingKey})
+	assert.NoError(t, err)
+
+	// the shares are found by the keys, whatever the position given
+	shares := make([][]*big.Int, 3)
+	for i := 0; i < 3; i++ {
+		var header *ShareHeader
+		var index int
+		shares[i], header, index, err = ReadShareFile(file, pubKeys[i], secKeys[i], signerKey, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, i, index)
+		assert.Equal(t, "tiny", header.DatasetId)
+		assert.Equal(t, cols, header.Cols)
+		assert.Equal(t, len(vec)/len(cols), header.Rows)
+		assert.Equal(t, Sharing{Protocol: ProtocolShamir, Parties: 3, Threshold: 1}, header.Sharing)
+		assert.Equal(t, fp, header.FixedPoint)
+		assert.Equal(t, nodeNames[i], header.Nodes[i].Name)
+		assert.NoError(t, checkShareHeader(header, index, i, header.Sharing, fp))
+		assert.Error(t, checkShareHeader(header, index, i, header.Sharing, DefaultFixedPoint))
+		err = checkShareHeader(header, index, (i+1)%3, header.Sharing, fp)
+		assert.EqualError(t, err, fmt.Sprintf("share file made for node %d at position %d", (i+1)%3, index))
+	}
+	b, _, err := JoinSharesFloat(shares, ProtocolShamir, 1, fp)
+	assert.NoError(t, err)
+	for i := range vec {
+		assert.InDelta(t, vec[i], b[i], 1e-6)
+	}
+
+	otherKey, _ := key_management.GenerateSigningKeypair()
+	_, _, _, err = ReadShareFile(file, pubKeys[0], secKeys[0], otherKey, 0)
+	assert.Error(t, err)
+	otherPubKey, otherSecKey := key_management.GenerateKeypair()
+	_, _, _, err = ReadShareFile(file, otherPubKey, otherSecKey, nil, 0)
+	assert.Error(t, err)
+
+	// a modified header breaks the signature
+	text, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	var f ShareFile
+	assert.NoError(t, json.Unmarshal(text, &f))
+	f.Header.FixedPoint = DefaultFixedPoint
+	assert.NoError(t, WriteShareFile(&f, file))
+	_, _, _, err = ReadShareFile(file, pubKeys[0], secKeys[0], nil, 0)
+	assert.Error(t, err)
+
+	// files without a header are read by position, but not if a signer is expected
+	share, header, index, err := ReadShareFile("framingham_small_enc.txt", pubKeys[1], secKeys[1], nil, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, header.Version)
+	assert.Equal(t, 1, index)
+	assert.NotEmpty(t, share)
+	_, _, _, err = ReadShareFile("framingham_small_enc.txt", pubKeys[1], secKeys[1], signerKey, 1)
+	assert.Error(t, err)
+}
+
+func TestShareStream(t *testing.T) {
+	nodeNames := []string{"Berlin_node", "Paris_node", "Ljubljana_node"}
+	pubKeys := make([][]byte, 3)
+	secKeys := make([][]byte, 3)
+	var err error
+	for i := 0; i < 3; i++ {
+		pubKeys[i], secKeys[i], _, err = key_management.LoadKeysFromCertKey("../key_management/keys_certificates", nodeNames[i])
+		assert.NoError(t, err)
+	}
+
+	// a dataset of several chunks
+	dir := t.TempDir()
+	rows := 3 * streamBatch
+	csv := "a,b,c\n"
+	for i := 0; i < rows; i++ {
+		csv += fmt.Sprintf("%d,%d.5,-%d\n", i, i%7, i%13)
+	}
+	assert.NoError(t, ioutil.WriteFile(dir+"/data.csv", []byte(csv), 0644))
+	vec, cols, _, err := CsvToVecFixed(dir+"/data.csv", DefaultFixedPoint)
+	assert.NoError(t, err)
+
+	signerKey, signingKey := key_management.GenerateSigningKeypair()
+	file := dir + "/shares.bin"
+	header, err := SplitCsvFileStream(dir+"/data.csv", file, pubKeys, ShareFileOptions{DatasetId: "data",
+		NodesNames: nodeNames, SigningKey: signingKey})
+	assert.NoError(t, err)
+	assert.Equal(t, rows, header.Rows)
+	assert.Equal(t, cols, header.Cols)
+
+	shares := make([][]*big.Int, 3)
+	for i := 0; i < 3; i++ {
+		var h *ShareHeader
+		var index int
+		shares[i], h, index, err = ReadShareFile(file, pubKeys[i], secKeys[i], signerKey, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, i, index)
+		assert.Equal(t, ShareStreamVersion, h.Version)
+	}
+	b, _, err := JoinShares(shares, ProtocolShamir, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, len(vec), len(b))
+	for i := range vec {
+		assert.Equal(t, vec[i].Int64(), centered(b[i]).Int64())
+	}
+
+	// the shares are written as inputs of SCALE reduced to the columns
+	sm := dir + "/scale"
+	assert.NoError(t, os.MkdirAll(sm+"/Input", 0755))
+	w, err := computation.NewInputWriter(1, 1, sm)
+	assert.NoError(t, err)
+	in := &inputSink{w: w, width: 1, selected: "a,c"}
+	err = in.readShareFile(file, pubKeys[1], secKeys[1], nil, 1, header.Sharing, DefaultFixedPoint, noCheck)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, []string{"a", "c"}, in.cols)
+	assert.Equal(t, 2*rows, in.count)
+	input, err := ioutil.ReadFile(sm + "/Input/input_shares1.txt")
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(input)), "\n")
+	assert.Equal(t, 2*rows, len(lines))
+	assert.Equal(t, "1 "+shares[1][3].String(), lines[2])
+	err = in.readShareFile(file, pubKeys[1], secKeys[1], nil, 0, header.Sharing, DefaultFixedPoint, noCheck)
+	assert.Error(t, err)
+
+	// modified files and other signers are refused
+	otherKey, _ := key_management.GenerateSigningKeypair()
+	_, _, _, err = ReadShareFile(file, pubKeys[0], secKeys[0], otherKey, 0)
+	assert.Error(t, err)
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	for _, pos := range []int{20, len(data) / 2, len(data) - 10} {
+		modified := append([]byte{}, data...)
+		modified[pos] ^= 1
+		assert.NoError(t, ioutil.WriteFile(dir+"/modified.bin", modified, 0644))
+		for i := 0; i < 3; i++ {
+			_, _, _, err = ReadShareFile(dir+"/modified.bin", pubKeys[i], secKeys[i], nil, 0)
+			assert.Error(t, err)
+		}
+	}
+	assert.NoError(t, ioutil.WriteFile(dir+"/truncated.bin", data[:len(data)-100], 0644))
+	_, _, _, err = ReadShareFile(dir+"/truncated.bin", pubKeys[0], secKeys[0], nil, 0)
+	assert.Error(t, err)
+}
+
+func noCheck(*ShareHeader) error {
+	return nil
+}
+
+func TestShareFileProtocols(t *testing.T) {
+	nodeNames := []string{"Berlin_node", "Paris_node", "Ljubljana_node"}
+	pubKeys := make([][]byte, 3)
+	secKeys := make([][]byte, 3)
+	var err error
+	for i := 0; i < 3; i++ {
+		pubKeys[i], secKeys[i], _, err = key_management.LoadKeysFromCertKey("../key_management/keys_certificates", nodeNames[i])
+		assert.NoError(t, err)
+	}
+	csv := t.TempDir() + "/data.csv"
+	assert.NoError(t, ioutil.WriteFile(csv, []byte("a,b\n1,2.5\n-3,4\n5,6\n"), 0644))
+	vec, _, _, err := CsvToVecFixed(csv, DefaultFixedPoint)
+	assert.NoError(t, err)
+
+	// the sharing of the file is recorded in its header and the shares are joined with it
+	for _, protocol := range []string{ProtocolShamir, ProtocolReplicated, ProtocolFullThreshold} {
+		t.Run(protocol, func(t *testing.T) {
+			sharing := Sharing{Protocol: protocol, Parties: 3, Threshold: ProtocolThreshold(protocol, 3)}
+			dir := t.TempDir()
+			opts := ShareFileOptions{DatasetId: "tiny", NodesNames: nodeNames, Protocol: protocol}
+			_, _, _, err := SplitCsvFileWith(csv, dir+"/shares.txt", pubKeys, opts)
+			assert.NoError(t, err)
+			header, err := SplitCsvFileStream(csv, dir+"/shares.bin", pubKeys, opts)
+			assert.NoError(t, err)
+			assert.Equal(t, sharing, header.Sharing)
+
+			for _, file := range []string{dir + "/shares.txt", dir + "/shares.bin"} {
+				shares := make([][]*big.Int, 3)
+				for i := 0; i < 3; i++ {
+					var h *ShareHeader
+					shares[i], h, _, err = ReadShareFile(file, pubKeys[i], secKeys[i], nil, 0)
+					assert.NoError(t, err)
+					assert.Equal(t, sharing, h.Sharing)
+				}
+				b, _, err := JoinShares(shares, protocol, sharing.Threshold)
+				assert.NoError(t, err)
+				assert.Equal(t, len(vec), len(b))
+				for i := range vec {
+					assert.Equal(t, vec[i].Int64(), centered(b[i]).Int64())
+				}
+			}
+		})
+	}
+
+	_, _, err = NewShareFile(vec, []string{"a"}, pubKeys, ShareFileOptions{Protocol: "unknown"})
+	assert.Error(t, err)
+	_, _, err = NewShareFile(vec, []string{"a"}, pubKeys, ShareFileOptions{Protocol: ProtocolReplicated, Threshold: 2})
+	assert.Error(t, err)
+}
+
+func TestShareOrigin(t *testing.T) {
+	nodeNames := []string{"Berlin_node", "Paris_node", "Ljubljana_node"}
+	pubKeys := make([][]byte, 3)
+	secKeys := make([][]byte, 3)
+	var err error
+	for i := 0; i < 3; i++ {
+		pubKeys[i], secKeys[i], _, err = key_management.LoadKeysFromCertKey("../key_management/keys_certificates", nodeNames[i])
+		assert.NoError(t, err)
+	}
+	dir := t.TempDir()
+	roots := writeTestCerts(t, dir, "provider", "other")
+	cert, certKey, err := key_management.LoadCertKey(dir, "provider")
+	assert.NoError(t, err)
+	otherCert, otherKey, err := key_management.LoadCertKey(dir, "other")
+	assert.NoError(t, err)
+	source := ShareSource{Dataset: "tiny", Version: "v1", Provider: "provider"}
+
+	// encrypted shares are signed for a node
+	encVecs := make([]string, 2)
+	for i := range encVecs {
+		a, err := NewUniformRandomVector(10, MPCPrime)
+		assert.NoError(t, err)
+		encVecs[i], err = EncryptVec(a, pubKeys[i])
+		assert.NoError(t, err)
+	}
+	sig, err := SignShare(encVecs[1], ShareSignature{Dataset: "tiny", Version: "v1", Node: "Paris_node", Cert: cert},
+		certKey)
+	assert.NoError(t, err)
+	assert.NoError(t, sig.Verify(encVecs[1], "Paris_node", source, roots))
+	assert.NoError(t, sig.Verify(encVecs[1], "Paris_node", ShareSource{Dataset: "tiny", Provider: "provider"}, roots))
+	assert.Error(t, sig.Verify(encVecs[0], "Paris_node", source, roots))
+	assert.Error(t, sig.Verify(encVecs[1], "Berlin_node", source, roots))
+	assert.Error(t, sig.Verify(encVecs[1], "Paris_node", ShareSource{Dataset: "tiny", Version: "v2", Provider: "provider"}, roots))
+	assert.Error(t, sig.Verify(encVecs[1], "Paris_node", ShareSource{Dataset: "small", Version: "v1", Provider: "provider"}, roots))
+	assert.Error(t, sig.Verify(encVecs[1], "Paris_node", ShareSource{Dataset: "tiny", Version: "v1", Provider: "other"}, roots))
+	assert.Error(t, sig.Verify(encVecs[1], "Paris_node", source, x509.NewCertPool()))
+	assert.Error(t, sig.Verify(encVecs[1], "Paris_node", ShareSource{Dataset: "tiny", Version: "v1", Provider: "provider",
+		Commitment: strings.Repeat("0", 64)}, roots))
+	otherSig, err := SignShare(encVecs[1], ShareSignature{Dataset: "tiny", Version: "v1", Node: "Paris_node",
+		Cert: otherCert}, otherKey)
+	assert.NoError(t, err)
+	assert.Error(t, otherSig.Verify(encVecs[1], "Paris_node", source, roots))
+
+	verifier := &ShareVerifier{Roots: roots, Providers: []string{"provider"}, Node: "Paris_node",
+		VecSources: []ShareSource{source, {Dataset: "any"}}, VecSignatures: []ShareSignature{sig, {}}}
+	assert.NoError(t, verifier.checkVec(0, encVecs[1]))
+	assert.Error(t, verifier.checkVec(0, encVecs[0]))
+	// shares of a source without a provider are refused unless signed by a trusted provider
+	assert.EqualError(t, verifier.checkVec(1, encVecs[0]), "share of dataset any not signed by a trusted provider")
+	assert.Error(t, verifier.checkVec(2, encVecs[0]))
+	verifier.VecSources[0].Provider = ""
+	assert.NoError(t, verifier.checkVec(0, encVecs[1]))
+	verifier.VecSources[0].Provider = "other"
+	assert.Error(t, verifier.checkVec(0, encVecs[1]))
+	verifier.VecSources[0].Provider = "provider"
+	// the trusted providers are the ones of the node, not the ones of the request
+	verifier.Providers = []string{"other"}
+	assert.Error(t, verifier.checkVec(0, encVecs[1]))
+	verifier.Providers = nil
+	assert.Error(t, verifier.checkVec(0, encVecs[1]))
+	verifier.Providers = []string{"provider"}
+	verifier.AllowUnsigned = true
+	assert.NoError(t, verifier.checkVec(1, encVecs[0]))
+	verifier.VecSignatures = nil
+	assert.Error(t, verifier.checkVec(0, encVecs[1]))
+
+	// share files carry the endorsement of their signer key
+	for _, stream := range []bool{false, true} {
+		file := dir + "/shares"
+		opts := ShareFileOptions{DatasetId: "tiny", DatasetVersion: "v1", NodesNames: nodeNames, Cert: cert,
+			CertKey: certKey}
+		if stream {
+			_, err = SplitCsvFileStream("framingham_tiny.csv", file, pubKeys, opts)
+		} else {
+			_, _, _, err = SplitCsvFileWith("framingham_tiny.csv", file, pubKeys, opts)
+		}
+		assert.NoError(t, err)
+		_, header, _, err := ReadShareFile(file, pubKeys[1], secKeys[1], nil, 1)
+		assert.NoError(t, err)
+		verifier := &ShareVerifier{Roots: roots, Providers: []string{"provider"}, Node: "Paris_node",
+			LinkSources: []ShareSource{source}}
+		assert.NoError(t, verifier.checkLink(0, header))
+		verifier.LinkSources[0].Version = "v2"
+		assert.Error(t, verifier.checkLink(0, header))
+		verifier.LinkSources[0] = ShareSource{Dataset: "tiny"}
+		assert.NoError(t, verifier.checkLink(0, header))
+		verifier.LinkSources[0] = ShareSource{Dataset: "tiny", Provider: "other"}
+		assert.Error(t, verifier.checkLink(0, header))
+		verifier.LinkSources[0], verifier.Providers = source, []string{"other"}
+		assert.Error(t, verifier.checkLink(0, header))
+
+		// the endorsement is bound to the signer key
+		signerKey, _ := key_management.GenerateSigningKeypair()
+		header.SignerKey = signerKey
+		assert.Error(t, header.checkSource(source, roots))
+	}
+
+	// files without an endorsement are refused, if a provider is expected even when unsigned
+	// shares are allowed
+	_, header, _, err := ReadShareFile("framingham_small_enc.txt", pubKeys[1], secKeys[1], nil, 1)
+	assert.NoError(t, err)
+	verifier = &ShareVerifier{Roots: roots, Providers: []string{"provider"},
+		LinkSources: []ShareSource{source, {Dataset: "small"}}}
+	assert.Error(t, verifier.checkLink(0, header))
+	assert.EqualError(t, verifier.checkLink(1, header), "share of dataset small not signed by a trusted provider")
+	verifier.AllowUnsigned = true
+	assert.Error(t, verifier.checkLink(0, header))
+	assert.NoError(t, verifier.checkLink(1, header))
+	var noVerifier *ShareVerifier
+	assert.NoError(t, noVerifier.checkLink(0, header))
+}
+
+// writeTestCerts writes in dir certificates for the names signed by a throwaway CA and
+// returns the pool of the CA.
+func writeTestCerts(t *testing.T, dir string, names ...string) *x509.CertPool {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "RootCA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDer)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	for i, name := range names {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		template := &x509.Certificate{SerialNumber: big.NewInt(int64(i + 2)), Subject: pkix.Name{CommonName: name},
+			NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+			KeyUsage: x509.KeyUsageDigitalSignature}
+		der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+		assert.NoError(t, err)
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		assert.NoError(t, ioutil.WriteFile(dir+"/"+name+".crt", certPEM, 0644))
+		assert.NoError(t, ioutil.WriteFile(dir+"/"+name+".key", keyPEM, 0600))
+	}
+	return roots
+}
+
+func TestCommitment(t *testing.T) {
+	nodeNames := []string{"Berlin_node", "Paris_node", "Ljubljana_node"}
+	pubKeys := make([][]byte, 3)
+	secKeys := make([][]byte, 3)
+	var err error
+	for i := 0; i < 3; i++ {
+		pubKeys[i], secKeys[i], _, err = key_management.LoadKeysFromCertKey("../key_management/keys_certificates", nodeNames[i])
+		assert.NoError(t, err)
+	}
+	nonce, err := NewNonce()
+	assert.NoError(t, err)
+	commitment, err := CommitFile("framingham_tiny.csv", nonce)
+	assert.NoError(t, err)
+	assert.NoError(t, CheckCommitment(commitment))
+	assert.Error(t, CheckCommitment(commitment[:10]))
+	text, err := ioutil.ReadFile("framingham_tiny.csv")
+	assert.NoError(t, err)
+	textCommitment, err := CommitData(strings.NewReader(string(text)), nonce)
+	assert.NoError(t, err)
+	assert.Equal(t, commitment, textCommitment)
+
+	// the commitment is the hash of the nonce followed by the data, and without the nonce
+	// it is not the hash of the data
+	hash := sha256.Sum256(append(append([]byte{}, nonce...), text...))
+	assert.Equal(t, hex.EncodeToString(hash[:]), commitment)
+	other, err := NewNonce()
+	assert.NoError(t, err)
+	otherCommitment, err := CommitData(strings.NewReader(string(text)), other)
+	assert.NoError(t, err)
+	assert.NotEqual(t, commitment, otherCommitment)
+	hash = sha256.Sum256(text)
+	assert.NotEqual(t, hex.EncodeToString(hash[:]), commitment)
+	_, err = CommitData(strings.NewReader(string(text)), nil)
+	assert.Error(t, err)
+
+	// the commitment of a share does not depend on the chunks
+	a, err := NewUniformRandomVector(100, MPCPrime)
+	assert.NoError(t, err)
+	a[3] = new(big.Int).Sub(MPCPrime, big.NewInt(1))
+	c, err := CommitShare(a, "tiny", commitment, 1)
+	assert.NoError(t, err)
+	committer := NewShareCommitter("tiny", commitment, 1)
+	assert.NoError(t, committer.Write(a[:30]))
+	assert.NoError(t, committer.Write(a[30:]))
+	assert.Equal(t, c, committer.Sum())
+	for _, other := range []struct {
+		dataset, commitment string
+		node                int
+	}{{"small", commitment, 1}, {"tiny", textCommitment[:10], 1}, {"tiny", commitment, 2}} {
+		d, err := CommitShare(a, other.dataset, other.commitment, other.node)
+		assert.NoError(t, err)
+		assert.NotEqual(t, c, d)
+	}
+
+	// share files give the commitment of the data and of the shares
+	dir := t.TempDir()
+	for _, stream := range []bool{false, true} {
+		file := dir + "/shares"
+		opts := ShareFileOptions{DatasetId: "tiny", CommitmentNonce: nonce, NodesNames: nodeNames}
+		if stream {
+			_, err = SplitCsvFileStream("framingham_tiny.csv", file, pubKeys, opts)
+		} else {
+			_, _, _, err = SplitCsvFileWith("framingham_tiny.csv", file, pubKeys, opts)
+		}
+		assert.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			share, header, index, err := ReadShareFile(file, pubKeys[i], secKeys[i], nil, i)
+			assert.NoError(t, err)
+			assert.Equal(t, commitment, header.Commitment)
+			assert.Len(t, header.ShareCommitments, 3)
+			c, err := CommitShare(share, "tiny", commitment, i)
+			assert.NoError(t, err)
+			assert.Equal(t, c, header.ShareCommitments[i])
+			assert.NoError(t, header.checkShare(index, c))
+			assert.Error(t, header.checkShare((index+1)%3, c))
+		}
+
+		// the nodes check their shares while reading them
+		sm := dir + "/scale"
+		assert.NoError(t, os.MkdirAll(sm+"/Input", 0755))
+		w, err := computation.NewInputWriter(1, 1, sm)
+		assert.NoError(t, err)
+		in := &inputSink{w: w, width: 1}
+		var header *ShareHeader
+		err = in.readShareFile(file, pubKeys[1], secKeys[1], nil, 1, Sharing{Protocol: ProtocolShamir, Parties: 3,
+			Threshold: 1}, DefaultFixedPoint, func(h *ShareHeader) error {
+			header = h
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		assert.Equal(t, header.ShareCommitments[1], in.commit.Sum())
+
+		verifier := &ShareVerifier{AllowUnsigned: true,
+			LinkSources: []ShareSource{{Dataset: "tiny", Commitment: commitment}}}
+		assert.NoError(t, verifier.checkLink(0, header))
+		assert.Equal(t, InputCommitment{Dataset: "tiny", Commitment: commitment}, verifier.linkInput(0, header))
+		verifier.LinkSources[0].Commitment = textCommitment[:63] + "0"
+		assert.Error(t, verifier.checkLink(0, header))
+	}
+
+	// files giving the commitment of the data must give the ones of the shares
+	header := &ShareHeader{Version: ShareFileVersion, Commitment: commitment}
+	assert.Error(t, header.checkShare(0, c))
+	header.Commitment = ""
+	assert.NoError(t, header.checkShare(0, c))
+
+	// signed shares give their commitment
+	verifier := &ShareVerifier{VecSources: []ShareSource{{Dataset: "tiny", Version: "v1"}, {Dataset: "small"}},
+		VecSignatures: []ShareSignature{{Dataset: "tiny", Version: "v1", Commitment: commitment,
+			ShareCommitment: c, Signature: []byte{1}}, {}}}
+	committer, input, expected := verifier.vecCommitter(0, 1)
+	assert.Equal(t, InputCommitment{Dataset: "tiny", Version: "v1", Commitment: commitment}, input)
+	assert.Equal(t, c, expected)
+	assert.NoError(t, committer.Write(a))
+	assert.Equal(t, c, committer.Sum())
+	_, input, expected = verifier.vecCommitter(1, 1)
+	assert.Equal(t, InputCommitment{Dataset: "small"}, input)
+	assert.Equal(t, "", expected)
+}
@@ -0,0 +1,251 @@
+package data_management
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/krakenh2020/MPCService/key_management"
+)
+
+// ShareSource is the origin expected for the shares of a dataset: its name, its version if
+// it is not empty, and the common name of the certificate of its provider, signed by the
+// RootCA, if it is not empty. If Commitment is not empty, the shares must be made for the
+// data with this commitment, see CommitData.
+type ShareSource struct {
+	Dataset    string `json:"dataset"`
+	Version    string `json:"version,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	Commitment string `json:"commitment,omitempty"`
+}
+
+// ShareSignature is the signature by its provider of an encrypted share of a version of a
+// dataset for a node, made with the key of the certificate Cert, see SignShare. It also
+// covers the commitment of the data of the version and the commitment of the share, if
+// they are given.
+type ShareSignature struct {
+	Dataset         string `json:"dataset"`
+	Version         string `json:"version"`
+	Node            string `json:"node"`
+	Commitment      string `json:"commitment,omitempty"`
+	ShareCommitment string `json:"share_commitment,omitempty"`
+	Cert            []byte `json:"cert"`
+	Signature       []byte `json:"signature"`
+}
+
+// message returns the message signed for the encrypted share.
+func (s ShareSignature) message(encVec string) ([]byte, error) {
+	hash := sha256.Sum256([]byte(encVec))
+	return json.Marshal(struct {
+		Dataset         string `json:"dataset"`
+		Version         string `json:"version"`
+		Node            string `json:"node"`
+		Commitment      string `json:"commitment"`
+		ShareCommitment string `json:"share_commitment"`
+		Share           []byte `json:"share"`
+	}{s.Dataset, s.Version, s.Node, s.Commitment, s.ShareCommitment, hash[:]})
+}
+
+// SignShare signs the encrypted share described by s with the key of the PEM encoded
+// certificate s.Cert and returns s with the signature.
+func SignShare(encVec string, s ShareSignature, key *rsa.PrivateKey) (ShareSignature, error) {
+	msg, err := s.message(encVec)
+	if err != nil {
+		return ShareSignature{}, err
+	}
+	s.Signature, err = key_management.SignWithCert(msg, key)
+	if err != nil {
+		return ShareSignature{}, err
+	}
+	return s, nil
+}
+
+// Verify checks that the signature is made for the encrypted share of the node by the
+// provider of the source, with a certificate signed by the roots.
+func (s ShareSignature) Verify(encVec, node string, source ShareSource, roots *x509.CertPool) error {
+	if s.Dataset != source.Dataset || (source.Version != "" && s.Version != source.Version) {
+		return fmt.Errorf("share signed for dataset %s version %s, %s version %s expected", s.Dataset, s.Version,
+			source.Dataset, source.Version)
+	}
+	if source.Commitment != "" && s.Commitment != source.Commitment {
+		return fmt.Errorf("share signed for data with commitment %s, %s expected", s.Commitment, source.Commitment)
+	}
+	if s.Node != node {
+		return fmt.Errorf("share signed for node %s", s.Node)
+	}
+	msg, err := s.message(encVec)
+	if err != nil {
+		return err
+	}
+	return key_management.VerifyWithCert(msg, s.Signature, s.Cert, roots, source.Provider)
+}
+
+// signerMessage returns the message signed by the certificate of the creator of a share
+// file to endorse the key signing the file.
+func signerMessage(dataset, version string, signerKey []byte) ([]byte, error) {
+	return json.Marshal(struct {
+		Dataset   string `json:"dataset"`
+		Version   string `json:"version"`
+		SignerKey []byte `json:"signer_key"`
+	}{dataset, version, signerKey})
+}
+
+// endorse signs the signer key of the header with the key of the certificate.
+func (h *ShareHeader) endorse(cert []byte, key *rsa.PrivateKey) error {
+	msg, err := signerMessage(h.DatasetId, h.DatasetVersion, h.SignerKey)
+	if err != nil {
+		return err
+	}
+	h.SignerCert = cert
+	h.SignerEndorsement, err = key_management.SignWithCert(msg, key)
+	return err
+}
+
+// checkSource checks that the signer key of the share file is endorsed for the version of
+// the dataset by the provider of the source, with a certificate signed by the roots. The
+// signature of the file by the signer key is checked when reading it.
+func (h *ShareHeader) checkSource(source ShareSource, roots *x509.CertPool) error {
+	if h.Version == 1 {
+		return fmt.Errorf("share file not signed")
+	}
+	if h.DatasetId != source.Dataset || (source.Version != "" && h.DatasetVersion != source.Version) {
+		return fmt.Errorf("share file made for dataset %s version %s, %s version %s expected", h.DatasetId,
+			h.DatasetVersion, source.Dataset, source.Version)
+	}
+	msg, err := signerMessage(h.DatasetId, h.DatasetVersion, h.SignerKey)
+	if err != nil {
+		return err
+	}
+	return key_management.VerifyWithCert(msg, h.SignerEndorsement, h.SignerCert, roots, source.Provider)
+}
+
+// ShareVerifier checks the origin of the shares of a node in PrepareData. The node trusts
+// the data providers whose certificates are signed by Roots and issued to one of
+// Providers, which the node is configured with: the shares must be signed by one of them,
+// by the provider of their source if it is given. Shares that are not signed by a
+// provider, among them the share files of links only signed by a key given with the link,
+// are refused unless AllowUnsigned is set and their source has no provider. The shares of
+// a source with a commitment must be made for this commitment. LinkSources are the
+// sources of the share files given by links, VecSources and VecSignatures those of the
+// encrypted vectors and their signatures. PrepareData sets Inputs to the commitments of
+// the inputs, the links first.
+type ShareVerifier struct {
+	Roots         *x509.CertPool
+	Providers     []string
+	AllowUnsigned bool
+	Node          string
+	LinkSources   []ShareSource
+	VecSources    []ShareSource
+	VecSignatures []ShareSignature
+	Inputs        []InputCommitment
+}
+
+// provider returns the source with the provider that signed its share with the PEM
+// encoded certificate, or an error if the provider is not trusted by the node or is not
+// the provider of the source. The signature is not checked.
+func (v *ShareVerifier) provider(source ShareSource, cert []byte) (ShareSource, error) {
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		return source, fmt.Errorf("certificate of the provider of dataset %s cannot be read", source.Dataset)
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return source, err
+	}
+	name := parsed.Subject.CommonName
+	if source.Provider != "" && name != source.Provider {
+		return source, fmt.Errorf("share of dataset %s signed by %s, not by its provider %s", source.Dataset, name,
+			source.Provider)
+	}
+	for _, e := range v.Providers {
+		if e == name {
+			source.Provider = name
+			return source, nil
+		}
+	}
+	return source, fmt.Errorf("share of dataset %s signed by %s, which is not a trusted provider", source.Dataset,
+		name)
+}
+
+// unsigned returns an error unless shares of the source without a signature of a provider
+// are allowed.
+func (v *ShareVerifier) unsigned(source ShareSource) error {
+	if !v.AllowUnsigned || source.Provider != "" {
+		return fmt.Errorf("share of dataset %s not signed by a trusted provider", source.Dataset)
+	}
+	return nil
+}
+
+// checkLink checks the header of the share file of the i-th link.
+func (v *ShareVerifier) checkLink(i int, header *ShareHeader) error {
+	if v == nil {
+		return nil
+	}
+	source := ShareSource{Dataset: header.DatasetId}
+	if i < len(v.LinkSources) {
+		source = v.LinkSources[i]
+	}
+	if source.Commitment != "" && header.Commitment != source.Commitment {
+		return fmt.Errorf("share file made for data with commitment %s, %s expected", header.Commitment,
+			source.Commitment)
+	}
+	if header.SignerEndorsement == nil {
+		return v.unsigned(source)
+	}
+	source, err := v.provider(source, header.SignerCert)
+	if err != nil {
+		return err
+	}
+	return header.checkSource(source, v.Roots)
+}
+
+// checkVec checks the signature of the i-th encrypted vector.
+func (v *ShareVerifier) checkVec(i int, encVec string) error {
+	if v == nil {
+		return nil
+	}
+	source := ShareSource{}
+	if i < len(v.VecSources) {
+		source = v.VecSources[i]
+	}
+	if i >= len(v.VecSignatures) || v.VecSignatures[i].Signature == nil {
+		return v.unsigned(source)
+	}
+	source, err := v.provider(source, v.VecSignatures[i].Cert)
+	if err != nil {
+		return err
+	}
+	return v.VecSignatures[i].Verify(encVec, v.Node, source, v.Roots)
+}
+
+// vecCommitter returns the committer of the share of the node at position nodeId of the
+// i-th encrypted vector and the commitment expected for the share, empty if none is
+// signed.
+func (v *ShareVerifier) vecCommitter(i, nodeId int) (*ShareCommitter, InputCommitment, string) {
+	input := InputCommitment{}
+	expected := ""
+	if v != nil && i < len(v.VecSources) {
+		input.Dataset, input.Version = v.VecSources[i].Dataset, v.VecSources[i].Version
+	}
+	if v != nil && i < len(v.VecSignatures) && v.VecSignatures[i].Signature != nil {
+		input.Commitment = v.VecSignatures[i].Commitment
+		expected = v.VecSignatures[i].ShareCommitment
+	}
+	return NewShareCommitter(input.Dataset, input.Commitment, nodeId), input, expected
+}
+
+// linkInput returns the commitment of the input of the i-th link, without the commitment
+// of the share.
+func (v *ShareVerifier) linkInput(i int, header *ShareHeader) InputCommitment {
+	input := InputCommitment{Dataset: header.DatasetId, Version: header.DatasetVersion, Commitment: header.Commitment}
+	if v != nil && i < len(v.LinkSources) {
+		input.Dataset = v.LinkSources[i].Dataset
+		if input.Version == "" {
+			input.Version = v.LinkSources[i].Version
+		}
+	}
+	return input
+}
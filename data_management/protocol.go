@@ -0,0 +1,392 @@
+package data_management
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MPC protocols of SCALE-MAMBA that the nodes can evaluate. The inputs and the results
+// are shared with the sharing scheme of the protocol:
+//   - ProtocolShamir: Shamir sharing with threshold t and an honest majority, n > 2t;
+//   - ProtocolReplicated: replicated sharing with threshold t and an honest majority,
+//     each party holds the additive parts of all the sets of t parties it is not in;
+//   - ProtocolFullThreshold: additive sharing among all the parties, SPDZ with a
+//     dishonest majority, t = n-1.
+const (
+	ProtocolShamir        = "shamir"
+	ProtocolReplicated    = "replicated"
+	ProtocolFullThreshold = "full-threshold"
+)
+
+// Protocols lists the supported MPC protocols.
+var Protocols = []string{ProtocolShamir, ProtocolReplicated, ProtocolFullThreshold}
+
+// Sharing describes the protocol evaluated by Parties parties and the threshold of its
+// sharing. It is written as protocol:parties:threshold, for example shamir:3:1.
+type Sharing struct {
+	Protocol  string `json:"protocol"`
+	Parties   int    `json:"parties"`
+	Threshold int    `json:"threshold"`
+}
+
+// ParseSharing reads a sharing written as protocol:parties:threshold; if the threshold is
+// omitted, the one given by ProtocolThreshold is used.
+func ParseSharing(spec string) (Sharing, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Sharing{}, fmt.Errorf("sharing %s is not protocol:parties:threshold", spec)
+	}
+	s := Sharing{Protocol: parts[0]}
+	var err error
+	s.Parties, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return Sharing{}, fmt.Errorf("number of parties of sharing %s is not an integer", spec)
+	}
+	if len(parts) == 3 {
+		s.Threshold, err = strconv.Atoi(parts[2])
+		if err != nil {
+			return Sharing{}, fmt.Errorf("threshold of sharing %s is not an integer", spec)
+		}
+	} else {
+		s.Threshold = ProtocolThreshold(s.Protocol, s.Parties)
+	}
+
+	return s, s.Check()
+}
+
+// ParseSharings reads comma separated sharings, see ParseSharing.
+func ParseSharings(spec string) ([]Sharing, error) {
+	res := make([]Sharing, 0)
+	for _, e := range strings.Split(spec, ",") {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		s, err := ParseSharing(e)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func (s Sharing) String() string {
+	return s.Protocol + ":" + strconv.Itoa(s.Parties) + ":" + strconv.Itoa(s.Threshold)
+}
+
+// Check returns an error if the parties cannot evaluate the protocol with the threshold.
+func (s Sharing) Check() error {
+	return CheckProtocol(s.Protocol, s.Parties, s.Threshold)
+}
+
+// ProtocolThreshold returns the threshold used with the protocol among n parties if none
+// is chosen: the largest one allowed.
+func ProtocolThreshold(protocol string, n int) int {
+	if protocol == ProtocolFullThreshold {
+		return n - 1
+	}
+	return DefaultThreshold(n)
+}
+
+// CheckProtocol returns an error if n parties cannot evaluate the protocol with
+// threshold t.
+func CheckProtocol(protocol string, n, t int) error {
+	switch protocol {
+	case ProtocolShamir, ProtocolReplicated:
+		return CheckThreshold(n, t)
+	case ProtocolFullThreshold:
+		if n < 2 || t != n-1 {
+			return fmt.Errorf("threshold %d not supported for %d parties of protocol %s, t = n-1 is needed",
+				t, n, protocol)
+		}
+		return nil
+	default:
+		return fmt.Errorf("protocol %s not supported", protocol)
+	}
+}
+
+// SharesPerValue returns the number of field elements a party holds for each shared
+// value.
+func SharesPerValue(protocol string, n, t int) int {
+	if protocol == ProtocolReplicated {
+		return len(replicatedSets(n, t)) * (n - t) / n
+	}
+	return 1
+}
+
+// CreateShares splits a vector input into n shares of the protocol with threshold t. The
+// share of a party holds SharesPerValue field elements for each value, one after another.
+func CreateShares(input []*big.Int, protocol string, n, t int) ([][]*big.Int, error) {
+	err := CheckProtocol(protocol, n, t)
+	if err != nil {
+		return nil, err
+	}
+
+	switch protocol {
+	case ProtocolReplicated:
+		return createSharesReplicated(input, n, t)
+	case ProtocolFullThreshold:
+		return createSharesAdditive(input, n)
+	default:
+		return CreateSharesShamirN(input, n, t)
+	}
+}
+
+// JoinShares reconstructs the vector from the shares of all the parties of the protocol
+// with threshold t, returning the sorted indexes of the parties whose shares were found
+// wrong and corrected or are missing. A nil share is missing. Shamir and replicated
+// shares are corrected if at least t+1 of the n shares are given and at most
+// MaxCorrectable(m, t) of the m given shares are wrong; additive shares of full threshold
+// protocols cannot be checked by the receiver and must all be given.
+func JoinShares(input [][]*big.Int, protocol string, t int) ([]*big.Int, []int, error) {
+	err := CheckProtocol(protocol, len(input), t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch protocol {
+	case ProtocolReplicated:
+		return joinSharesReplicated(input, t)
+	case ProtocolFullThreshold:
+		res, err := joinSharesAdditive(input)
+		return res, nil, err
+	default:
+		return JoinSharesShamirCorrect(input, t)
+	}
+}
+
+// JoinSharesFloat is JoinShares returning the values in the fixed point representation fp
+// as floats.
+func JoinSharesFloat(input [][]*big.Int, protocol string, t int, fp FixedPoint) ([]float64, []int, error) {
+	joined, cheaters, err := JoinShares(input, protocol, t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := make([]float64, len(joined))
+	for j, e := range joined {
+		res[j] = fp.Decode(e.Int64())
+	}
+
+	return res, cheaters, nil
+}
+
+// fieldValue returns the input as an element of the field, checking its range.
+func fieldValue(x *big.Int) (*big.Int, error) {
+	if new(big.Int).Abs(x).Cmp(MPCPrimeHalf) > 0 {
+		return nil, fmt.Errorf("error: input value too big")
+	}
+	return new(big.Int).Mod(x, MPCPrime), nil
+}
+
+// centered returns the field element as a signed value.
+func centered(x *big.Int) *big.Int {
+	if x.Cmp(MPCPrimeHalf) > 0 {
+		x.Sub(x, MPCPrime)
+	}
+	return x
+}
+
+// additiveParts splits x into k random parts summing to x.
+func additiveParts(x *big.Int, k int) ([]*big.Int, error) {
+	parts, err := NewUniformRandomVector(k, MPCPrime)
+	if err != nil {
+		return nil, err
+	}
+	last := new(big.Int).Set(x)
+	for _, e := range parts[:k-1] {
+		last.Sub(last, e)
+	}
+	parts[k-1] = last.Mod(last, MPCPrime)
+
+	return parts, nil
+}
+
+// createSharesAdditive gives each of the n parties an additive part of each value.
+func createSharesAdditive(input []*big.Int, n int) ([][]*big.Int, error) {
+	res := make([][]*big.Int, n)
+	for i := range res {
+		res[i] = make([]*big.Int, len(input))
+	}
+	for j, e := range input {
+		val, err := fieldValue(e)
+		if err != nil {
+			return nil, err
+		}
+		parts, err := additiveParts(val, n)
+		if err != nil {
+			return nil, err
+		}
+		for i := range res {
+			res[i][j] = parts[i]
+		}
+	}
+
+	return res, nil
+}
+
+func joinSharesAdditive(input [][]*big.Int) ([]*big.Int, error) {
+	for i := range input {
+		if input[i] == nil {
+			return nil, fmt.Errorf("joining failed, share %d missing", i)
+		}
+		if len(input[i]) != len(input[0]) {
+			return nil, fmt.Errorf("joining failed, shares of different lengths")
+		}
+	}
+
+	res := make([]*big.Int, len(input[0]))
+	for j := range res {
+		sum := new(big.Int)
+		for i := range input {
+			sum.Add(sum, input[i][j])
+		}
+		res[j] = centered(sum.Mod(sum, MPCPrime))
+	}
+
+	return res, nil
+}
+
+// replicatedSets returns the maximal unqualified sets of the threshold access structure,
+// the sets of t of the n parties, in lexicographic order.
+func replicatedSets(n, t int) [][]int {
+	res := make([][]int, 0)
+	set := make([]int, t)
+	var next func(start, k int)
+	next = func(start, k int) {
+		if k == t {
+			res = append(res, append([]int(nil), set...))
+			return
+		}
+		for i := start; i < n; i++ {
+			set[k] = i
+			next(i+1, k+1)
+		}
+	}
+	next(0, 0)
+
+	return res
+}
+
+// holds returns the indexes of the sets whose parts are held by the party, the sets it
+// is not in, in the order of the sets.
+func holds(sets [][]int, party int) []int {
+	res := make([]int, 0, len(sets))
+	for k, set := range sets {
+		in := false
+		for _, e := range set {
+			in = in || e == party
+		}
+		if !in {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// createSharesReplicated splits each value into an additive part for each set of t
+// parties and gives every part to all the parties outside of the set.
+func createSharesReplicated(input []*big.Int, n, t int) ([][]*big.Int, error) {
+	sets := replicatedSets(n, t)
+	held := make([][]int, n)
+	for i := range held {
+		held[i] = holds(sets, i)
+	}
+
+	m := len(held[0])
+	res := make([][]*big.Int, n)
+	for i := range res {
+		res[i] = make([]*big.Int, len(input)*m)
+	}
+	for j, e := range input {
+		val, err := fieldValue(e)
+		if err != nil {
+			return nil, err
+		}
+		parts, err := additiveParts(val, len(sets))
+		if err != nil {
+			return nil, err
+		}
+		for i := range res {
+			for l, k := range held[i] {
+				res[i][j*m+l] = new(big.Int).Set(parts[k])
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// joinSharesReplicated sums the parts of each value; a part is taken from the given
+// parties holding it if at most MaxCorrectable(m, t) of them disagree, where m is the
+// number of given shares, which are then the wrong ones.
+func joinSharesReplicated(input [][]*big.Int, t int) ([]*big.Int, []int, error) {
+	n := len(input)
+	sets := replicatedSets(n, t)
+	holders := make([][]int, len(sets))
+	position := make([]map[int]int, n)
+	present := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		position[i] = make(map[int]int)
+		if input[i] == nil {
+			continue
+		}
+		present = append(present, i)
+		for l, k := range holds(sets, i) {
+			holders[k] = append(holders[k], i)
+			position[i][k] = l
+		}
+	}
+	if len(present) < t+1 {
+		return nil, nil, fmt.Errorf("joining failed, %d of %d shares cannot be joined with threshold %d",
+			len(present), n, t)
+	}
+	m := len(holds(sets, 0))
+	length := len(input[present[0]])
+	for _, i := range present {
+		if len(input[i]) != length || len(input[i])%m != 0 {
+			return nil, nil, fmt.Errorf("joining failed, shares of wrong lengths")
+		}
+	}
+
+	e := MaxCorrectable(len(present), t)
+	wrong := make(map[int]bool)
+	res := make([]*big.Int, length/m)
+	for j := range res {
+		sum := new(big.Int)
+		for k := range sets {
+			counts := make(map[string]int)
+			for _, i := range holders[k] {
+				counts[new(big.Int).Mod(input[i][j*m+position[i][k]], MPCPrime).String()]++
+			}
+			part, ok := "", false
+			for val, count := range counts {
+				if count >= len(holders[k])-e {
+					part, ok = val, true
+				}
+			}
+			if !ok {
+				if e == 0 {
+					return nil, nil, fmt.Errorf("%w: %d shares with threshold %d cannot identify the wrong ones",
+						ErrInconsistentShares, len(present), t)
+				}
+				return nil, nil, fmt.Errorf("%w: more than %d shares are wrong", ErrInconsistentShares, e)
+			}
+			for _, i := range holders[k] {
+				if new(big.Int).Mod(input[i][j*m+position[i][k]], MPCPrime).String() != part {
+					wrong[i] = true
+				}
+			}
+			val, _ := new(big.Int).SetString(part, 10)
+			sum.Add(sum, val)
+		}
+		res[j] = centered(sum.Mod(sum, MPCPrime))
+	}
+	if len(wrong) > e {
+		return nil, nil, fmt.Errorf("%w: more than %d shares are wrong", ErrInconsistentShares, e)
+	}
+
+	return res, cheaters(input, wrong), nil
+}
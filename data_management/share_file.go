@@ -0,0 +1,338 @@
+package data_management
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/krakenh2020/MPCService/key_management"
+)
+
+// ShareFileVersion is the version of the share files written by WriteShareFile. Files of
+// version 1 have no header: a line with the encrypted share of each node followed by a
+// line with the columns. Large datasets are written as streamed share files, see
+// ShareStreamVersion.
+const ShareFileVersion = 2
+
+// ShareRecipient is a node receiving a share of a share file, identified by the
+// fingerprint of its public key, see key_management.Fingerprint.
+type ShareRecipient struct {
+	Name           string `json:"name"`
+	KeyFingerprint string `json:"key_fingerprint"`
+}
+
+// ShareHeader describes the dataset shared in a share file. The shares are made with
+// Sharing for the nodes in the order of Nodes and the values are represented with
+// FixedPoint. SignerKey is the Ed25519 public key of the creator of the file; it may be
+// endorsed for the version of the dataset by the certificate SignerCert of the creator
+// with SignerEndorsement, see ShareSource. Commitment is the commitment of the data of the
+// version and ShareCommitments the commitments of the shares of the nodes, see
+// CommitData; a streamed share file gives the latter at its end.
+type ShareHeader struct {
+	Version           int              `json:"version"`
+	DatasetId         string           `json:"dataset_id"`
+	DatasetVersion    string           `json:"dataset_version,omitempty"`
+	Cols              []string         `json:"cols"`
+	Rows              int              `json:"rows"`
+	Nodes             []ShareRecipient `json:"nodes"`
+	Sharing           Sharing          `json:"sharing"`
+	FixedPoint        FixedPoint       `json:"fixed_point"`
+	Signer            string           `json:"signer,omitempty"`
+	SignerKey         []byte           `json:"signer_key"`
+	SignerCert        []byte           `json:"signer_cert,omitempty"`
+	SignerEndorsement []byte           `json:"signer_endorsement,omitempty"`
+	Commitment        string           `json:"commitment,omitempty"`
+	ShareCommitments  []string         `json:"share_commitments,omitempty"`
+}
+
+// ShareFile is a signed file holding the encrypted shares of a dataset, one for each node
+// of the header. The signature of the creator covers the header and the shares.
+type ShareFile struct {
+	Header    ShareHeader `json:"header"`
+	Shares    []string    `json:"shares"`
+	Signature []byte      `json:"signature"`
+}
+
+// ShareFileOptions describe a share file made by NewShareFile. The shares are made with
+// Protocol, by default Shamir sharing, and Threshold, by default the largest one the
+// protocol allows; FixedPoint takes its default value if it is not set. If SigningKey is nil, the file is signed
+// with a fresh key, which only protects its integrity unless the key is endorsed by the
+// PEM encoded certificate Cert with its key CertKey. Commitment is the commitment of the
+// data of the version of the dataset; the functions splitting a csv file compute it with
+// CommitmentNonce if it is not given, which the creator keeps for auditors, see CommitData.
+type ShareFileOptions struct {
+	DatasetId       string
+	DatasetVersion  string
+	Commitment      string
+	CommitmentNonce []byte
+	NodesNames      []string
+	Protocol        string
+	Threshold       int
+	FixedPoint      FixedPoint
+	Signer          string
+	SigningKey      []byte
+	Cert            []byte
+	CertKey         *rsa.PrivateKey
+}
+
+// NewShareFile splits the vector of fixed point values, rows of the columns, into shares
+// for the public keys of the nodes with the sharing of the options, recorded in the header,
+// and returns the signed share file together with the shares.
+func NewShareFile(vec []*big.Int, cols []string, pubKeys [][]byte, opts ShareFileOptions) (*ShareFile,
+	[][]*big.Int, error) {
+	n := len(pubKeys)
+	if len(opts.NodesNames) != 0 && len(opts.NodesNames) != n {
+		return nil, nil, fmt.Errorf("%d names given for %d nodes", len(opts.NodesNames), n)
+	}
+	if len(cols) == 0 || len(vec)%len(cols) != 0 {
+		return nil, nil, fmt.Errorf("%d values do not form rows of %d columns", len(vec), len(cols))
+	}
+	sharing, err := opts.sharing(n)
+	if err != nil {
+		return nil, nil, err
+	}
+	shares, err := CreateShares(vec, sharing.Protocol, n, sharing.Threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signingKey := opts.SigningKey
+	if signingKey == nil {
+		_, signingKey = key_management.GenerateSigningKeypair()
+	}
+	if len(signingKey) != ed25519.PrivateKeySize {
+		return nil, nil, fmt.Errorf("signing key of wrong length")
+	}
+	f := &ShareFile{Header: ShareHeader{Version: ShareFileVersion, DatasetId: opts.DatasetId,
+		DatasetVersion: opts.DatasetVersion, Cols: cols, Rows: len(vec) / len(cols), Nodes: make([]ShareRecipient, n),
+		Sharing: sharing, FixedPoint: opts.FixedPoint.OrDefault(), Signer: opts.Signer, SignerKey: signingKey[32:],
+		Commitment: opts.Commitment, ShareCommitments: make([]string, n)}, Shares: make([]string, n)}
+	if opts.CertKey != nil {
+		err = f.Header.endorse(opts.Cert, opts.CertKey)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for i := range pubKeys {
+		f.Header.Nodes[i].KeyFingerprint = key_management.Fingerprint(pubKeys[i])
+		if len(opts.NodesNames) != 0 {
+			f.Header.Nodes[i].Name = opts.NodesNames[i]
+		}
+		f.Shares[i], err = EncryptVec(shares[i], pubKeys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		f.Header.ShareCommitments[i], err = CommitShare(shares[i], opts.DatasetId, opts.Commitment, i)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	msg, err := f.signedBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	f.Signature, err = key_management.Sign(msg, signingKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, shares, nil
+}
+
+// sharing returns the sharing of the shares of the options among n nodes.
+func (opts ShareFileOptions) sharing(n int) (Sharing, error) {
+	s := Sharing{Protocol: opts.Protocol, Parties: n, Threshold: opts.Threshold}
+	if s.Protocol == "" {
+		s.Protocol = ProtocolShamir
+	}
+	if s.Threshold == 0 {
+		s.Threshold = ProtocolThreshold(s.Protocol, n)
+	}
+	return s, s.Check()
+}
+
+// signedBytes returns the content of the file covered by the signature.
+func (f *ShareFile) signedBytes() ([]byte, error) {
+	return json.Marshal(struct {
+		Header ShareHeader `json:"header"`
+		Shares []string    `json:"shares"`
+	}{f.Header, f.Shares})
+}
+
+// Verify checks that the header is consistent and the file is signed by the key in the
+// header, which must be signerKey if it is not nil.
+func (f *ShareFile) Verify(signerKey []byte) error {
+	h := f.Header
+	if h.Version != ShareFileVersion {
+		return fmt.Errorf("share file version %d not supported", h.Version)
+	}
+	if len(h.Nodes) != len(f.Shares) || h.Sharing.Parties != len(f.Shares) {
+		return fmt.Errorf("share file lists %d nodes and %d shares for %d parties", len(h.Nodes), len(f.Shares),
+			h.Sharing.Parties)
+	}
+	if len(h.ShareCommitments) != 0 && len(h.ShareCommitments) != len(f.Shares) {
+		return fmt.Errorf("share file has %d commitments for %d shares", len(h.ShareCommitments), len(f.Shares))
+	}
+	if signerKey != nil && !bytes.Equal(signerKey, h.SignerKey) {
+		return fmt.Errorf("share file not signed by the creator of the dataset")
+	}
+
+	msg, err := f.signedBytes()
+	if err != nil {
+		return err
+	}
+	err = key_management.Verify(msg, f.Signature, h.SignerKey)
+	if err != nil {
+		return fmt.Errorf("share file: %v", err)
+	}
+
+	return nil
+}
+
+// width returns the number of field elements of the share of a value, see SharesPerValue.
+func (h *ShareHeader) width() int {
+	return SharesPerValue(h.Sharing.Protocol, h.Sharing.Parties, h.Sharing.Threshold)
+}
+
+// elements returns the number of field elements of the share of a node.
+func (h *ShareHeader) elements() int {
+	return h.Rows * len(h.Cols) * h.width()
+}
+
+// checkShare checks the commitment of the share of the node at position index, if the
+// file gives the commitments of the shares; they must be given if the file gives the
+// commitment of the data.
+func (h *ShareHeader) checkShare(index int, shareCommitment string) error {
+	if len(h.ShareCommitments) == 0 {
+		if h.Commitment != "" {
+			return fmt.Errorf("share file has no commitments of the shares")
+		}
+		return nil
+	}
+	if index >= len(h.ShareCommitments) || h.ShareCommitments[index] != shareCommitment {
+		return fmt.Errorf("share does not match its commitment")
+	}
+	return nil
+}
+
+// Index returns the position of the node with the public key among the nodes of the
+// file.
+func (f *ShareFile) Index(pubKey []byte) (int, error) {
+	fingerprint := key_management.Fingerprint(pubKey)
+	for i, e := range f.Header.Nodes {
+		if e.KeyFingerprint == fingerprint {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("share file has no share for key %s", fingerprint)
+}
+
+// WriteShareFile writes the share file as JSON.
+func WriteShareFile(f *ShareFile, output string) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(output, append(b, '\n'), 0644)
+}
+
+// ReadShareFile reads the share of the node with the key pair from a share file, checking
+// its signature, which must be made with signerKey if it is not nil. It returns the share,
+// the header and the position of the node in the file. A file of version 1 has no header
+// nor signature; its share is found at position nodeId and only the version and the
+// columns of the returned header are set. The share of a streamed share file is read
+// chunk by chunk, see OpenShareFile.
+func ReadShareFile(file string, pubKey, secKey, signerKey []byte, nodeId int) ([]*big.Int, *ShareHeader, int,
+	error) {
+	r, err := OpenShareFile(file, pubKey, secKey, signerKey, nodeId)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer r.Close()
+	share := make([]*big.Int, 0)
+	for {
+		chunk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		share = append(share, chunk...)
+	}
+
+	return share, r.Header, r.Index, nil
+}
+
+// readShareFileAll reads the share from a share file of version 1 or 2, see ReadShareFile.
+func readShareFileAll(file string, pubKey, secKey, signerKey []byte, nodeId int) ([]*big.Int, *ShareHeader, int,
+	error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		if signerKey != nil {
+			return nil, nil, 0, fmt.Errorf("share file not signed")
+		}
+		share, cols, err := readShareLines(b, pubKey, secKey, nodeId)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return share, &ShareHeader{Version: 1, Cols: cols}, nodeId, nil
+	}
+
+	var f ShareFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("share file cannot be read: %v", err)
+	}
+	err = f.Verify(signerKey)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	index, err := f.Index(pubKey)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	share, err := DecVec(f.Shares[index], pubKey, secKey)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(share) != f.Header.elements() {
+		return nil, nil, 0, fmt.Errorf("share has %d elements, the header announces %d rows of %d columns of %d "+
+			"elements", len(share), f.Header.Rows, len(f.Header.Cols), f.Header.width())
+	}
+
+	return share, &f.Header, index, nil
+}
+
+// readShareLines reads the share at position nodeId from a file of version 1.
+func readShareLines(b []byte, pubKey, secKey []byte, nodeId int) ([]*big.Int, []string, error) {
+	lines := make([]string, 0)
+	for _, e := range strings.Split(string(b), "\n") {
+		if e = strings.TrimRight(e, "\r"); e != "" {
+			lines = append(lines, e)
+		}
+	}
+	if nodeId >= len(lines)-1 {
+		return nil, nil, fmt.Errorf("no share for node %d", nodeId)
+	}
+
+	decVec, err := DecVec(lines[nodeId], pubKey, secKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	// columns info
+	cols := strings.Split(lines[len(lines)-1], ",")
+
+	return decVec, cols, nil
+}
@@ -0,0 +1,547 @@
+package data_management
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/big"
+	"os"
+
+	"github.com/krakenh2020/MPCService/key_management"
+)
+
+// Streamed share files. Large datasets are split into a share file of version
+// ShareStreamVersion, which is written and read chunk by chunk so that the memory used does
+// not depend on the size of the dataset. It starts with
+//
+//	magic "MPCF" | version (1 byte) | length of the header (uint32) | header as JSON
+//
+// followed by frames holding the encrypted shares of the nodes, each given by
+//
+//	index of the node in the header (uint32) | length of the data (uint32) | data
+//
+// where the data of the frames of a node, in order, is its share encrypted by an
+// EncryptVecWriter. The frames of the nodes are interleaved. They may be followed by a
+// frame of index 0xFFFFFFFE whose data is the JSON array of the commitments of the shares
+// of the nodes (see ShareHeader.ShareCommitments). The file ends with a frame of index
+// 0xFFFFFFFF whose data is the Ed25519 signature of the creator of the SHA-256 hash of
+// everything before it. All the integers are big endian.
+const (
+	ShareStreamVersion = 3
+	maxHeaderSize      = 1 << 20
+	maxFrameSize       = 1 << 20
+	commitmentsFrame   = math.MaxUint32 - 1
+	endFrame           = math.MaxUint32
+)
+
+var shareStreamMagic = []byte("MPCF")
+
+// streamBatch is the number of values split at once by a ShareStreamWriter.
+const streamBatch = 1 << 12
+
+// streamEncoding is the encoding of the shares of streamed share files.
+var streamEncoding = Encoding{ChunkSize: 1 << 12}
+
+// ShareStreamWriter splits values into shares for the nodes and writes them to a streamed
+// share file as they are given.
+type ShareStreamWriter struct {
+	header     ShareHeader
+	w          *bufio.Writer
+	hash       hash.Hash
+	signingKey []byte
+	nodes      []*EncryptVecWriter
+	committers []*ShareCommitter
+	pending    []*big.Int
+}
+
+// NewShareStreamWriter writes the header of a streamed share file of rows of the columns
+// to w, see NewShareFile for the options, and returns a writer of the values.
+func NewShareStreamWriter(w io.Writer, cols []string, rows int, pubKeys [][]byte,
+	opts ShareFileOptions) (*ShareStreamWriter, error) {
+	n := len(pubKeys)
+	if len(opts.NodesNames) != 0 && len(opts.NodesNames) != n {
+		return nil, fmt.Errorf("%d names given for %d nodes", len(opts.NodesNames), n)
+	}
+	sharing, err := opts.sharing(n)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShareStreamWriter{w: bufio.NewWriter(w), hash: sha256.New(), signingKey: opts.SigningKey,
+		nodes: make([]*EncryptVecWriter, n), committers: make([]*ShareCommitter, n),
+		pending: make([]*big.Int, 0, streamBatch)}
+	if s.signingKey == nil {
+		_, s.signingKey = key_management.GenerateSigningKeypair()
+	}
+	if len(s.signingKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("signing key of wrong length")
+	}
+	s.header = ShareHeader{Version: ShareStreamVersion, DatasetId: opts.DatasetId,
+		DatasetVersion: opts.DatasetVersion, Cols: cols, Rows: rows, Nodes: make([]ShareRecipient, n),
+		Sharing: sharing, FixedPoint: opts.FixedPoint.OrDefault(), Signer: opts.Signer, SignerKey: s.signingKey[32:],
+		Commitment: opts.Commitment}
+	if opts.CertKey != nil {
+		err = s.header.endorse(opts.Cert, opts.CertKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range pubKeys {
+		s.header.Nodes[i].KeyFingerprint = key_management.Fingerprint(pubKeys[i])
+		if len(opts.NodesNames) != 0 {
+			s.header.Nodes[i].Name = opts.NodesNames[i]
+		}
+	}
+	headerBytes, err := json.Marshal(s.header)
+	if err != nil {
+		return nil, err
+	}
+	start := make([]byte, len(shareStreamMagic)+5)
+	copy(start, shareStreamMagic)
+	start[len(shareStreamMagic)] = ShareStreamVersion
+	binary.BigEndian.PutUint32(start[len(shareStreamMagic)+1:], uint32(len(headerBytes)))
+	err = s.write(append(start, headerBytes...))
+	if err != nil {
+		return nil, err
+	}
+
+	// each value is shared into SharesPerValue field elements
+	count := uint64(rows) * uint64(len(cols)) * uint64(SharesPerValue(sharing.Protocol, n, sharing.Threshold))
+	for i := range pubKeys {
+		s.nodes[i], err = NewEncryptVecWriter(&frameWriter{s: s, index: uint32(i)}, count, pubKeys[i],
+			streamEncoding)
+		if err != nil {
+			return nil, err
+		}
+		s.committers[i] = NewShareCommitter(opts.DatasetId, opts.Commitment, i)
+	}
+
+	return s, nil
+}
+
+// Header returns the header of the file, with the commitments of the shares after Close.
+func (s *ShareStreamWriter) Header() *ShareHeader {
+	return &s.header
+}
+
+// write writes data covered by the signature.
+func (s *ShareStreamWriter) write(b []byte) error {
+	s.hash.Write(b)
+	_, err := s.w.Write(b)
+	return err
+}
+
+// frameWriter writes the data of a node as frames.
+type frameWriter struct {
+	s     *ShareStreamWriter
+	index uint32
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		data := p[written:]
+		if len(data) > maxFrameSize {
+			data = data[:maxFrameSize]
+		}
+		var frameHeader [8]byte
+		binary.BigEndian.PutUint32(frameHeader[:], f.index)
+		binary.BigEndian.PutUint32(frameHeader[4:], uint32(len(data)))
+		err := f.s.write(frameHeader[:])
+		if err == nil {
+			err = f.s.write(data)
+		}
+		if err != nil {
+			return written, err
+		}
+		written += len(data)
+	}
+	return len(p), nil
+}
+
+// Write splits the fixed point values, following the ones written before, into shares and
+// writes them.
+func (s *ShareStreamWriter) Write(vec []*big.Int) error {
+	for len(vec) > 0 {
+		k := cap(s.pending) - len(s.pending)
+		if k > len(vec) {
+			k = len(vec)
+		}
+		s.pending = append(s.pending, vec[:k]...)
+		vec = vec[k:]
+		if len(s.pending) == cap(s.pending) {
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush writes the shares of the pending values.
+func (s *ShareStreamWriter) flush() error {
+	shares, err := CreateShares(s.pending, s.header.Sharing.Protocol, s.header.Sharing.Parties,
+		s.header.Sharing.Threshold)
+	if err != nil {
+		return err
+	}
+	for i, e := range s.nodes {
+		err = e.Write(shares[i])
+		if err == nil {
+			err = s.committers[i].Write(shares[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.pending = s.pending[:0]
+	return nil
+}
+
+// Close writes the remaining shares and the signature; it fails if fewer values than
+// announced in the header were written. It does not close the underlying writer.
+func (s *ShareStreamWriter) Close() error {
+	if len(s.pending) > 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	for _, e := range s.nodes {
+		if err := e.Close(); err != nil {
+			return err
+		}
+	}
+	s.header.ShareCommitments = make([]string, len(s.committers))
+	for i, c := range s.committers {
+		s.header.ShareCommitments[i] = c.Sum()
+	}
+	commitments, err := json.Marshal(s.header.ShareCommitments)
+	if err != nil {
+		return err
+	}
+	_, err = (&frameWriter{s: s, index: commitmentsFrame}).Write(commitments)
+	if err != nil {
+		return err
+	}
+
+	sig, err := key_management.Sign(s.hash.Sum(nil), s.signingKey)
+	if err != nil {
+		return err
+	}
+	var frameHeader [8]byte
+	binary.BigEndian.PutUint32(frameHeader[:], endFrame)
+	binary.BigEndian.PutUint32(frameHeader[4:], uint32(len(sig)))
+	_, err = s.w.Write(append(frameHeader[:], sig...))
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// SplitCsvFileStream splits the data in the csv file into shares, one for each of the
+// public keys, and writes them encrypted to the output file as a streamed share file
+// described by the options. The file is read twice, first to check the values and count
+// the rows, and only a bounded number of rows is held in memory. The commitment of the data
+// is the one of the file with opts.CommitmentNonce if it is not given. It returns the
+// header of the share file.
+func SplitCsvFileStream(file, output string, pubKeys [][]byte, opts ShareFileOptions) (*ShareHeader, error) {
+	fp := opts.FixedPoint.OrDefault()
+	err := fp.Check()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Commitment == "" && opts.CommitmentNonce != nil {
+		opts.Commitment, err = CommitFile(file, opts.CommitmentNonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+	max, rows := 0., 0
+	cols, err := ForEachCsvRow(file, func(vals []float64) error {
+		for _, e := range vals {
+			if math.IsNaN(e) || math.IsInf(e, 0) {
+				return fp.CheckRange([]float64{e})
+			}
+			max = math.Max(max, math.Abs(e))
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = fp.CheckRange([]float64{max})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := NewShareStreamWriter(f, cols, rows, pubKeys, opts)
+	if err != nil {
+		return nil, err
+	}
+	row := make([]*big.Int, len(cols))
+	_, err = ForEachCsvRow(file, func(vals []float64) error {
+		for i, e := range vals {
+			v, err := fp.Encode(e)
+			if err != nil {
+				return err
+			}
+			row[i] = big.NewInt(v)
+		}
+		return s.Write(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Header(), f.Close()
+}
+
+// ShareReader reads the share of a node from a share file chunk by chunk.
+type ShareReader struct {
+	Header *ShareHeader
+	Index  int // position of the node in Header.Nodes
+
+	next  func() ([]*big.Int, error)
+	close func() error
+}
+
+// Next returns the next chunk of the share, or io.EOF after the last one. The signature of
+// a streamed share file is only checked at its end, so the share must not be used before
+// Next returned io.EOF.
+func (r *ShareReader) Next() ([]*big.Int, error) {
+	return r.next()
+}
+
+// Close closes the file.
+func (r *ShareReader) Close() error {
+	return r.close()
+}
+
+// OpenShareFile opens the share file and returns a reader of the share of the node with the
+// key pair, see ReadShareFile. Streamed share files are read chunk by chunk, the shares of
+// the other versions at once.
+func OpenShareFile(file string, pubKey, secKey, signerKey []byte, nodeId int) (*ShareReader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(f, 1<<16)
+	start, _ := br.Peek(len(shareStreamMagic))
+	if !bytes.Equal(start, shareStreamMagic) {
+		f.Close()
+		share, header, index, err := readShareFileAll(file, pubKey, secKey, signerKey, nodeId)
+		if err != nil {
+			return nil, err
+		}
+		return &ShareReader{Header: header, Index: index, next: func() ([]*big.Int, error) {
+			if share == nil {
+				return nil, io.EOF
+			}
+			chunk := share
+			share = nil
+			return chunk, nil
+		}, close: func() error { return nil }}, nil
+	}
+
+	r, err := newStreamReader(br, pubKey, secKey, signerKey)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &ShareReader{Header: &r.header, Index: r.index, next: r.next, close: f.Close}, nil
+}
+
+// streamReader reads the share of a node from a streamed share file.
+type streamReader struct {
+	r         *bufio.Reader
+	hash      hash.Hash
+	header    ShareHeader
+	index     int
+	data      []byte // data of the current frame of the node not read yet
+	ended     bool   // the end frame was read
+	signature []byte
+	stream    io.Reader
+	decoder   *VecDecoder
+}
+
+func newStreamReader(r *bufio.Reader, pubKey, secKey, signerKey []byte) (*streamReader, error) {
+	s := &streamReader{r: r, hash: sha256.New()}
+	start := make([]byte, len(shareStreamMagic)+5)
+	_, err := io.ReadFull(r, start)
+	if err != nil {
+		return nil, fmt.Errorf("share file cannot be read: %v", err)
+	}
+	if start[len(shareStreamMagic)] != ShareStreamVersion {
+		return nil, fmt.Errorf("share file version %d not supported", start[len(shareStreamMagic)])
+	}
+	length := binary.BigEndian.Uint32(start[len(shareStreamMagic)+1:])
+	if length > maxHeaderSize {
+		return nil, fmt.Errorf("share file header too long")
+	}
+	headerBytes := make([]byte, length)
+	_, err = io.ReadFull(r, headerBytes)
+	if err != nil {
+		return nil, fmt.Errorf("share file cannot be read: %v", err)
+	}
+	s.hash.Write(start)
+	s.hash.Write(headerBytes)
+	err = json.Unmarshal(headerBytes, &s.header)
+	if err != nil {
+		return nil, fmt.Errorf("share file cannot be read: %v", err)
+	}
+
+	h := s.header
+	if h.Version != ShareStreamVersion || len(h.Nodes) != h.Sharing.Parties || len(h.Cols) == 0 || h.Rows < 0 {
+		return nil, fmt.Errorf("share file has a malformed header")
+	}
+	if signerKey != nil && !bytes.Equal(signerKey, h.SignerKey) {
+		return nil, fmt.Errorf("share file not signed by the creator of the dataset")
+	}
+	s.index, err = (&ShareFile{Header: h}).Index(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stream, err = key_management.NewDecryptReader(s, pubKey, secKey)
+	if err != nil {
+		return nil, err
+	}
+	s.decoder, err = NewVecDecoder(s.stream)
+	if err != nil {
+		return nil, err
+	}
+	if s.decoder.Count() != uint64(h.elements()) {
+		return nil, fmt.Errorf("share has %d elements, the header announces %d rows of %d columns of %d elements",
+			s.decoder.Count(), h.Rows, len(h.Cols), h.width())
+	}
+
+	return s, nil
+}
+
+// Read reads the data of the frames of the node, skipping the frames of the other nodes.
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.data) == 0 {
+		if s.ended {
+			return 0, io.EOF
+		}
+		_, err := s.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+// nextFrame reads the next frame; it returns true if it holds data of the node.
+func (s *streamReader) nextFrame() (bool, error) {
+	var frameHeader [8]byte
+	_, err := io.ReadFull(s.r, frameHeader[:])
+	if err != nil {
+		return false, fmt.Errorf("share file truncated")
+	}
+	index, length := binary.BigEndian.Uint32(frameHeader[:]), binary.BigEndian.Uint32(frameHeader[4:])
+	if length > maxFrameSize {
+		return false, fmt.Errorf("share file has a malformed frame")
+	}
+	if index == endFrame {
+		s.signature = make([]byte, length)
+		_, err = io.ReadFull(s.r, s.signature)
+		if err != nil {
+			return false, fmt.Errorf("share file truncated")
+		}
+		s.ended = true
+		return false, nil
+	}
+	if index == commitmentsFrame {
+		if s.header.ShareCommitments != nil {
+			return false, fmt.Errorf("share file has a malformed frame")
+		}
+		data := make([]byte, length)
+		_, err = io.ReadFull(s.r, data)
+		if err != nil {
+			return false, fmt.Errorf("share file truncated")
+		}
+		s.hash.Write(frameHeader[:])
+		s.hash.Write(data)
+		err = json.Unmarshal(data, &s.header.ShareCommitments)
+		if err != nil || len(s.header.ShareCommitments) != len(s.header.Nodes) {
+			return false, fmt.Errorf("share file has malformed commitments")
+		}
+		return false, nil
+	}
+	if index >= uint32(len(s.header.Nodes)) {
+		return false, fmt.Errorf("share file has a frame for node %d", index)
+	}
+
+	s.hash.Write(frameHeader[:])
+	if index != uint32(s.index) {
+		_, err = io.CopyN(s.hash, s.r, int64(length))
+		if err != nil {
+			return false, fmt.Errorf("share file truncated")
+		}
+		return false, nil
+	}
+	if cap(s.data) < int(length) {
+		s.data = make([]byte, length)
+	}
+	s.data = s.data[:length]
+	_, err = io.ReadFull(s.r, s.data)
+	if err != nil {
+		return false, fmt.Errorf("share file truncated")
+	}
+	s.hash.Write(s.data)
+	return true, nil
+}
+
+// next returns the next chunk of the share; after the last one, it reads the rest of the
+// file and checks the signature.
+func (s *streamReader) next() ([]*big.Int, error) {
+	chunk, err := s.decoder.Next()
+	if err != io.EOF {
+		return chunk, err
+	}
+
+	// the encrypted stream must end with the share
+	if n, err := s.stream.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("share file has trailing data")
+		}
+		return nil, err
+	}
+	for !s.ended {
+		own, err := s.nextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if own {
+			return nil, fmt.Errorf("share file has trailing data")
+		}
+	}
+	if _, err := s.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("share file has trailing data")
+	}
+	err = key_management.Verify(s.hash.Sum(nil), s.signature, s.header.SignerKey)
+	if err != nil {
+		return nil, fmt.Errorf("share file: %v", err)
+	}
+
+	return nil, io.EOF
+}
@@ -1,152 +1,265 @@
 package data_provider
 
 import (
+	"context"
 	"crypto"
 	"crypto/rsa"
-	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/krakenh2020/MPCService/authorization"
 	log "github.com/sirupsen/logrus"
 	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/key_management"
 	"github.com/krakenh2020/MPCService/logging"
+	"github.com/krakenh2020/MPCService/protocol"
 )
 
 // todo: error management
 // todo: share with selection
+
+// Dataset describes a dataset offered for MPC. Owner is the requester that added a dataset
+// given by a link and Expires the unix time after which it is withdrawn, if set. FixedPoint
+// and Sharing are the representation of the values and the sharing of the share file of a
+// dataset given by a link, recorded in its header; if they are not set, the default
+// representation and Shamir sharing. If Provider is set, the MPC nodes only use shares of
+// Version, if it is set, signed with the certificate of Provider, see
+// data_management.ShareSource; the manager sets it for datasets of data providers.
 type Dataset struct {
-	Name        string `json:"name"`
-	Size        string `json:"size"`
-	Cols        string `json:"cols"`
-	SharedWith  string `json:"shared_with"`
-	Link        string `json:"link"`
-	Description string `json:"description"`
+	Name        string                      `json:"name"`
+	Size        string                      `json:"size"`
+	Cols        string                      `json:"cols"`
+	SharedWith  string                      `json:"shared_with"`
+	Link        string                      `json:"link"`
+	Description string                      `json:"description"`
+	Owner       string                      `json:"owner,omitempty"`
+	Expires     int64                       `json:"expires,omitempty"`
+	FixedPoint  *data_management.FixedPoint `json:"fixed_point,omitempty"`
+	Sharing     *data_management.Sharing    `json:"sharing,omitempty"`
+	SignerKey   string                      `json:"signer_key,omitempty"` // base64 encoded key signing the share file of Link
+	Provider    string                      `json:"provider,omitempty"`   // common name of the certificate signing the shares
+	Version     string                      `json:"version,omitempty"`
+	Commitment  string                      `json:"commitment,omitempty"` // commitment of the data of the version
 }
 
 type DatasetRequest struct {
+	Requester              string // name of the authenticated requester of the computation
 	DatasetName            string
 	NodesNames             []string
+	Protocol               string                     // MPC protocol, see data_management.Protocols; if empty, Shamir sharing
+	Threshold              int                        // threshold of the sharing among the nodes; if 0, the largest one allowed
+	FixedPoint             data_management.FixedPoint // representation of the values; if not set, the default one
+	Program                string
 	Params                 string
+	Voucher                string
 	NodesPubKeys           [][]byte
 	NodesCerts             [][]byte
 	NodesPubKeysSignatures [][]byte
 }
 
+// DatasetReturn holds the encrypted shares of a dataset for the nodes of the request and
+// their signatures by the data provider.
 type DatasetReturn struct {
-	EncVecs []string
-	Cols    []string
+	EncVecs    []string
+	Signatures []data_management.ShareSignature
+	Cols       []string
+}
+
+// RunDatasetProvider offers the datasets in loc to the manager until the connection is lost.
+// The requests for datasets are approved by the authorizer given by authz, see
+// authorization.New. The errors are logged.
+func RunDatasetProvider(name string, loc string, logLevel, logFile, managerAddr, certFolder string, sharedWith []string,
+	authz string) {
+	err := ServeDatasetProvider(context.Background(), name, loc, logLevel, logFile, managerAddr, certFolder,
+		sharedWith, authz)
+	if err != nil {
+		log.Error("Data provider: ", err)
+	}
 }
 
-func RunDatasetProvider(name string, loc string, logLevel, logFile, managerAddr, certFolder string, sharedWith []string) {
+// ServeDatasetProvider is RunDatasetProvider running until ctx is done or the connection
+// to the manager is lost. It returns the errors of setting up the data provider and of
+// connecting to the manager, and nil once ctx is done and the requests are answered.
+func ServeDatasetProvider(ctx context.Context, name string, loc string, logLevel, logFile, managerAddr,
+	certFolder string, sharedWith []string, authz string) error {
 	// set up logging
 	logging.LogSetUp(logLevel, logFile)
 	log.Info("Dataset server "+name+", dataset location: ", loc, ", manager address: ", managerAddr)
-	datasets, locations := getDatasetsData(loc, sharedWith)
+	datasets, locations, err := getDatasetsData(loc, sharedWith)
+	if err != nil {
+		return err
+	}
 
-	managerConn(name, managerAddr, datasets, locations, certFolder, sharedWith)
+	authorizer, err := authorization.New(authz)
+	if err != nil {
+		return err
+	}
+
+	return managerConn(ctx, name, managerAddr, datasets, locations, certFolder, sharedWith, authorizer)
 }
 
-func managerConn(name, managerAddr string, datasets []Dataset, locations map[string]string,
-	certFolder string, sharedWith []string) {
+func managerConn(ctx context.Context, name, managerAddr string, datasets []Dataset, locations map[string]string,
+	certFolder string, sharedWith []string, authorizer authorization.Authorizer) error {
 	u := url.URL{Scheme: "wss", Host: managerAddr, Path: "/connect_data"}
-	cert, err := tls.LoadX509KeyPair(certFolder+"/"+name+".crt", certFolder+"/"+name+".key")
-
-	caCert, err := ioutil.ReadFile(certFolder + "/" + "/RootCA.crt")
-	caCertPool := x509.NewCertPool()
-	_ = caCertPool.AppendCertsFromPEM(caCert)
-
-	dialer := websocket.Dialer{
-		TLSClientConfig: &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			RootCAs:      caCertPool,
-		},
+	tlsConfig, err := key_management.ClientTLSConfig(certFolder, name)
+	if err != nil {
+		return fmt.Errorf("error loading the certificates: %s", err)
 	}
+	// the shares are signed with the key of the certificate
+	certPEM, certKey, err := key_management.LoadCertKey(certFolder, name)
+	if err != nil {
+		return fmt.Errorf("error loading the certificate: %s", err)
+	}
+
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig}
 
 	sharedWithMap := make(map[string]bool, 0)
 	for _, e := range sharedWith {
 		sharedWithMap[e] = true
 	}
 
-	conn, _, err := dialer.Dial(u.String(), nil)
+	ws, _, err := dialer.DialContext(ctx, u.String(), nil)
 	if err != nil {
-		log.Error("Data provider: error dialing the manager")
-		return
+		return fmt.Errorf("error dialing the manager: %s", err)
 	}
+	conn := protocol.NewConn(ws)
 	defer conn.Close()
 
-	err = conn.WriteJSON(datasets)
+	// the connection is closed when the provider stops, which ends the loop below; the
+	// requests still waiting for an authorization are denied
+	stopped := make(chan struct{})
+	var running sync.WaitGroup
+	defer running.Wait()
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stopped:
+		}
+	}()
+
+	err = conn.Hello(datasets)
 	if err != nil {
-		log.Error("Data provider: error sending info to the manager")
-		return
+		return fmt.Errorf("error registering with the manager: %s", err)
 	}
 
 	for {
-		var b []byte
-		err = conn.ReadJSON(&b)
+		msg, err := conn.Receive()
 		if err != nil {
-			log.Error("lost connection with the manager 1 ", err)
-			return
-		}
-		if string(b) == "ping" {
-			err = conn.WriteJSON([]byte("pong"))
-			if err != nil {
-				log.Error("lost connection with the manager 2")
-				return
+			if ctx.Err() != nil {
+				return nil
 			}
-			continue
+			return fmt.Errorf("lost connection with the manager: %s", err)
 		}
-
-		var msg DatasetRequest
-		err = json.Unmarshal(b, &msg)
-		log.Info("Data provider: received a request for dataset ", msg.DatasetName)
-
-		log.Debug(sharedWithMap)
-		log.Debug(msg)
-		check, err := checkIfAllowed(msg, sharedWithMap, caCertPool)
-		if check == false {
-			log.Info("Data provider: access denied ", err)
-			err = conn.WriteJSON([]byte{})
-			if err != nil {
-				log.Error("failed to return the response: ", err)
-			}
+		if msg.Type != protocol.TypeDataRequest {
 			continue
 		}
 
-		response, err := prepareDataset(msg, locations)
+		var req DatasetRequest
+		err = msg.Decode(&req)
 		if err != nil {
-			log.Fatal("error preparing data", err)
+			log.Error("failed to read the message: ", err)
+			_ = conn.SendError(msg.RequestId, "failed to read the message: "+err.Error())
+			continue
 		}
+		log.Info("Data provider: received a request for dataset ", req.DatasetName)
+
+		running.Add(1)
+		go func(requestId string) {
+			defer running.Done()
+			log.Debug(sharedWithMap)
+			log.Debug(req)
+			check, err := checkIfAllowed(req, sharedWithMap, tlsConfig.RootCAs)
+			if check == false {
+				log.Info("Data provider: access denied ", err)
+				err = conn.SendError(requestId, "access denied: "+fmt.Sprint(err))
+				if err != nil {
+					log.Error("failed to return the response: ", err)
+				}
+				return
+			}
 
-		err = conn.WriteJSON(response)
-		if err != nil {
-			log.Error("failed to return a response: ", err)
-		} else {
-			log.Info("Data provider: dataset provided")
-		}
+			decision, reason := authorization.WaitCancel(authorizer, authorization.Request{Requester: req.Requester,
+				Program: req.Program, Datasets: []string{req.DatasetName}, Params: req.Params, Voucher: req.Voucher},
+				approvalInterval, approvalTimeout, stopped)
+			if decision != authorization.Allow {
+				log.Info("Data provider: computation of ", req.Requester, " not authorized: ", reason)
+				err = conn.SendError(requestId, "not authorized: "+reason)
+				if err != nil {
+					log.Error("failed to return the response: ", err)
+				}
+				return
+			}
+
+			response, err := prepareDataset(req, datasets, locations, certPEM, certKey)
+			if err != nil {
+				log.Error("error preparing data ", err)
+				err = conn.SendError(requestId, "error preparing data: "+err.Error())
+				if err != nil {
+					log.Error("failed to return the response: ", err)
+				}
+				return
+			}
+
+			err = conn.Send(protocol.TypeResult, requestId, response)
+			if err != nil {
+				log.Error("failed to return a response: ", err)
+			} else {
+				log.Info("Data provider: dataset provided")
+			}
+		}(msg.RequestId)
 	}
 }
 
-func getDatasetsData(loc string, sharedWith []string) ([]Dataset, map[string]string) {
+// approvalInterval and approvalTimeout define how long a data provider waits for a pending
+// authorization of a computation.
+var approvalInterval = 5 * time.Second
+var approvalTimeout = time.Minute
+
+// noncesFile is the file in the folder of the datasets in which the data provider keeps the
+// hex encoded nonces of the commitments of the datasets by name, to give them to auditors
+// with the data, see data_management.CommitData.
+const noncesFile = ".commitment_nonces.json"
+
+func getDatasetsData(loc string, sharedWith []string) ([]Dataset, map[string]string, error) {
 	files, err := ioutil.ReadDir(loc)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), ".") {
+			names = append(names, file.Name())
+		}
+	}
+	nonces, err := loadNonces(loc, names)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	datasets := make([]Dataset, 0)
 	locations := make(map[string]string)
-	for _, file := range files {
-		name := file.Name()
-
-		_, cols, vec, err := data_management.CsvToVec(loc + "/" + name)
+	for _, name := range names {
+		vec, cols, err := data_management.CsvToFloats(loc + "/" + name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dataset %s: %s", name, err)
+		}
+		// the version is given by the commitment of the data
+		commitment, err := data_management.CommitFile(loc+"/"+name, nonces[name])
 		if err != nil {
-			log.Fatal(err)
+			return nil, nil, fmt.Errorf("dataset %s: %s", name, err)
 		}
 
 		dataset := Dataset{
@@ -154,32 +267,109 @@ func getDatasetsData(loc string, sharedWith []string) ([]Dataset, map[string]str
 			SharedWith: strings.Join(sharedWith, ","),
 			Cols:       strings.Join(cols, ","),
 			Size:       strconv.Itoa(len(vec)),
+			Version:    commitment[:16],
+			Commitment: commitment,
 		}
 		datasets = append(datasets, dataset)
 		locations[name] = loc + "/" + name
 	}
 
-	return datasets, locations
+	return datasets, locations, nil
+}
+
+// loadNonces returns the nonces of the commitments of the datasets with the names kept in
+// the nonces file of loc, choosing and keeping a fresh one for the datasets without one.
+func loadNonces(loc string, names []string) (map[string][]byte, error) {
+	encoded := make(map[string]string)
+	b, err := ioutil.ReadFile(loc + "/" + noncesFile)
+	if err == nil {
+		err = json.Unmarshal(b, &encoded)
+		if err != nil {
+			return nil, fmt.Errorf("nonces of the commitments: %s", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	nonces := make(map[string][]byte, len(names))
+	changed := false
+	for _, name := range names {
+		nonce, err := hex.DecodeString(encoded[name])
+		if err != nil || len(nonce) != data_management.NonceSize {
+			nonce, err = data_management.NewNonce()
+			if err != nil {
+				return nil, err
+			}
+			encoded[name] = hex.EncodeToString(nonce)
+			changed = true
+		}
+		nonces[name] = nonce
+	}
+	if changed {
+		b, err = json.MarshalIndent(encoded, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(loc+"/"+noncesFile, b, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nonces, nil
 }
 
-func prepareDataset(req DatasetRequest, locations map[string]string) (*DatasetReturn, error) {
-	vec, cols, _, err := data_management.CsvToVec(locations[req.DatasetName])
+// prepareDataset splits the dataset into shares for the nodes of the request, encrypts them
+// and signs them with the key of the certificate of the data provider.
+func prepareDataset(req DatasetRequest, datasets []Dataset, locations map[string]string, cert []byte,
+	certKey *rsa.PrivateKey) (*DatasetReturn, error) {
+	var dataset Dataset
+	for _, e := range datasets {
+		if e.Name == req.DatasetName {
+			dataset = e
+		}
+	}
+	// the values must fit the fixed point representation of the computation
+	vec, cols, _, err := data_management.CsvToVecFixed(locations[req.DatasetName], req.FixedPoint.OrDefault())
 	if err != nil {
 		return nil, err
 	}
 
-	shares, err := data_management.CreateSharesShamir(vec)
+	n := len(req.NodesNames)
+	if len(req.NodesPubKeys) != n {
+		return nil, fmt.Errorf("%d public keys given for %d nodes", len(req.NodesPubKeys), n)
+	}
+	protocol := req.Protocol
+	if protocol == "" {
+		protocol = data_management.ProtocolShamir
+	}
+	threshold := req.Threshold
+	if threshold == 0 {
+		threshold = data_management.ProtocolThreshold(protocol, n)
+	}
+	shares, err := data_management.CreateShares(vec, protocol, n, threshold)
 	if err != nil {
 		return nil, err
 	}
 
 	var response DatasetReturn
-	response.EncVecs = make([]string, 3)
-	for i := int64(0); i < 3; i++ {
+	response.EncVecs = make([]string, n)
+	response.Signatures = make([]data_management.ShareSignature, n)
+	for i := 0; i < n; i++ {
 		response.EncVecs[i], err = data_management.EncryptVec(shares[i], req.NodesPubKeys[i])
 		if err != nil {
 			return nil, err
 		}
+		shareCommitment, err := data_management.CommitShare(shares[i], req.DatasetName, dataset.Commitment, i)
+		if err != nil {
+			return nil, err
+		}
+		response.Signatures[i], err = data_management.SignShare(response.EncVecs[i],
+			data_management.ShareSignature{Dataset: req.DatasetName, Version: dataset.Version, Node: req.NodesNames[i],
+				Commitment: dataset.Commitment, ShareCommitment: shareCommitment, Cert: cert}, certKey)
+		if err != nil {
+			return nil, err
+		}
 	}
 	response.Cols = cols
 	return &response, nil
@@ -194,8 +384,8 @@ func checkIfAllowed(req DatasetRequest, sharedWith map[string]bool, caCertPool *
 	for _, e := range req.NodesNames {
 		nodesMap[e] = true
 	}
-	if len(nodesMap) != 3 {
-		return false, fmt.Errorf("dataset must be shared among 3 different nodes")
+	if len(nodesMap) != len(req.NodesNames) || len(nodesMap) < 3 {
+		return false, fmt.Errorf("dataset must be shared among at least 3 different nodes")
 	}
 
 	for i, e := range req.NodesNames {
@@ -1,21 +1,48 @@
 package data_provider_test
 
 import (
+	"context"
+	"io/ioutil"
 	"testing"
 	"time"
 
 	"github.com/krakenh2020/MPCService/manager"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/krakenh2020/MPCService/data_provider"
 )
 
 func TestRunDatasetProvider(t *testing.T) {
 	go manager.RunManager(5007, 5008, "../manager/assets", "info", "../logging/log.log",
-		"../key_management/keys_certificates")
+		"../key_management/keys_certificates", false, "", "", "")
 	time.Sleep(1 * time.Second)
 
 	go data_provider.RunDatasetProvider("Data_provider1", "../data_provider/datasets", "info",
 		"../logging/log.log", "localhost:5008", "../key_management/keys_certificates",
-		[]string{"all"})
+		[]string{"all"}, "")
 	time.Sleep(1 * time.Second)
 }
+
+func TestServeDatasetProviderCertificates(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		files []string
+	}{
+		{name: "missing RootCA", files: []string{"Data_provider1.crt", "Data_provider1.key"}},
+		{name: "missing certificate", files: []string{"Data_provider1.key", "RootCA.crt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			certs := t.TempDir()
+			for _, file := range test.files {
+				b, err := ioutil.ReadFile("../key_management/keys_certificates/" + file)
+				assert.NoError(t, err)
+				assert.NoError(t, ioutil.WriteFile(certs+"/"+file, b, 0600))
+			}
+			err := data_provider.ServeDatasetProvider(context.Background(), "Data_provider1", t.TempDir(), "info",
+				"../logging/log.log", "localhost:5008", certs, []string{"all"}, "")
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "error loading the certificates")
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package data_provider
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatasetsCommitments(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(dir+"/a.csv", []byte("age\n1\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(dir+"/b.csv", []byte("age\n1\n"), 0600))
+
+	datasets, locations, err := getDatasetsData(dir, []string{"all"})
+	assert.NoError(t, err)
+	assert.Len(t, datasets, 2)
+	assert.Equal(t, dir+"/a.csv", locations["a.csv"])
+
+	// the nonces are kept for auditors, who can check the commitments with them
+	b, err := ioutil.ReadFile(dir + "/" + noncesFile)
+	assert.NoError(t, err)
+	var nonces map[string]string
+	assert.NoError(t, json.Unmarshal(b, &nonces))
+	for _, dataset := range datasets {
+		nonce, err := hex.DecodeString(nonces[dataset.Name])
+		assert.NoError(t, err)
+		commitment, err := data_management.CommitFile(dir+"/"+dataset.Name, nonce)
+		assert.NoError(t, err)
+		assert.Equal(t, commitment, dataset.Commitment)
+		assert.Equal(t, commitment[:16], dataset.Version)
+	}
+	// the same data is committed with different nonces
+	assert.NotEqual(t, datasets[0].Commitment, datasets[1].Commitment)
+
+	// the nonces are reused, so that the versions do not change
+	again, _, err := getDatasetsData(dir, []string{"all"})
+	assert.NoError(t, err)
+	assert.Equal(t, datasets, again)
+
+	assert.NoError(t, ioutil.WriteFile(dir+"/"+noncesFile, []byte("not json"), 0600))
+	_, _, err = getDatasetsData(dir, []string{"all"})
+	assert.Error(t, err)
+}
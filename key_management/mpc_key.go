@@ -3,10 +3,14 @@ package key_management
 import (
 	"bytes"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -24,6 +28,90 @@ func GenerateKeypair() (publicKey, privateKey []byte) {
 	return
 }
 
+// Fingerprint returns the hex encoded SHA-256 hash of a public key, identifying it.
+func Fingerprint(pubKey []byte) string {
+	hash := sha256.Sum256(pubKey)
+	return hex.EncodeToString(hash[:])
+}
+
+// GenerateSigningKeypair returns a new Ed25519 key signing files.
+func GenerateSigningKeypair() (publicKey, privateKey []byte) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// Sign signs the message with an Ed25519 private key.
+func Sign(message, privateKey []byte) ([]byte, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("signing key of wrong length")
+	}
+	return ed25519.Sign(privateKey, message), nil
+}
+
+// Verify checks an Ed25519 signature of the message.
+func Verify(message, sig, publicKey []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, message, sig) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// LoadCertKey loads the PEM encoded certificate name and its RSA key from the folder.
+func LoadCertKey(certFolder, name string) ([]byte, *rsa.PrivateKey, error) {
+	cert, err := tls.LoadX509KeyPair(certFolder+"/"+name+".crt", certFolder+"/"+name+".key")
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("key of certificate %s is not an RSA key", name)
+	}
+	certPEM, err := ioutil.ReadFile(certFolder + "/" + name + ".crt")
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, key, nil
+}
+
+// SignWithCert signs the SHA-256 hash of the message with the RSA key of a certificate.
+func SignWithCert(message []byte, key *rsa.PrivateKey) ([]byte, error) {
+	hash := sha256.Sum256(message)
+	return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+}
+
+// VerifyWithCert checks that the PEM encoded certificate is signed by the roots and issued
+// to commonName, and that sig is its signature of the message, see SignWithCert.
+func VerifyWithCert(message, sig, certPEM []byte, roots *x509.CertPool, commonName string) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return fmt.Errorf("certificate cannot be read")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if cert.Subject.CommonName != commonName {
+		return fmt.Errorf("certificate issued to %s, not to %s", cert.Subject.CommonName, commonName)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return err
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("key of certificate of %s is not an RSA key", commonName)
+	}
+	hash := sha256.Sum256(message)
+	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], sig)
+	if err != nil {
+		return fmt.Errorf("signature of %s verification failed", commonName)
+	}
+	return nil
+}
+
 func KeysFromCertKey(cerKey []byte, key *rsa.PrivateKey) ([]byte, []byte, []byte, error) {
 	hash := sha256.New()
 	_, err := hash.Write(cerKey)
@@ -47,11 +135,35 @@ func LoadKeysFromCertKey(certFolder, name string) ([]byte, []byte, []byte, error
 		return nil, nil, nil, err
 	}
 	cert, err := tls.LoadX509KeyPair(certFolder+"/"+name+".crt", certFolder+"/"+name+".key")
-	privateKey := cert.PrivateKey.(*rsa.PrivateKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	privateKey, ok := cert.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("key of certificate %s is not an RSA key", name)
+	}
 
 	return KeysFromCertKey(certKey, privateKey)
 }
 
+// ClientTLSConfig returns the TLS configuration of a client authenticated with the
+// certificate name from the folder and trusting the servers certified by its RootCA.
+func ClientTLSConfig(certFolder, name string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFolder+"/"+name+".crt", certFolder+"/"+name+".key")
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := ioutil.ReadFile(certFolder + "/RootCA.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificate found in %s", certFolder+"/RootCA.crt")
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}, nil
+}
+
 func Encrypt(input, pubkey []byte) ([]byte, error) {
 	var key [32]byte
 	copy(key[:], pubkey)
@@ -1,8 +1,17 @@
 package key_management_test
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/krakenh2020/MPCService/key_management"
@@ -30,3 +39,120 @@ func TestKeyGenLoad(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, string(msg), string(msg2))
 }
+
+func TestSign(t *testing.T) {
+	pubKey, secKey := key_management.GenerateSigningKeypair()
+	msg := []byte("blabla")
+	sig, err := key_management.Sign(msg, secKey)
+	assert.NoError(t, err)
+	assert.NoError(t, key_management.Verify(msg, sig, pubKey))
+	assert.Error(t, key_management.Verify([]byte("blablabla"), sig, pubKey))
+
+	boxKey, _ := key_management.GenerateKeypair()
+	assert.Len(t, key_management.Fingerprint(boxKey), 64)
+	assert.NotEqual(t, key_management.Fingerprint(boxKey), key_management.Fingerprint(pubKey))
+}
+
+func TestEncryptStream(t *testing.T) {
+	pubKey, secKey := key_management.GenerateKeypair()
+	msg := make([]byte, 3*key_management.StreamChunkSize+5)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	var buf bytes.Buffer
+	w, err := key_management.NewEncryptWriter(&buf, pubKey)
+	assert.NoError(t, err)
+	_, err = w.Write(msg[:100])
+	assert.NoError(t, err)
+	_, err = w.Write(msg[100:])
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	enc := buf.Bytes()
+	assert.True(t, key_management.IsStream(enc))
+
+	r, err := key_management.NewDecryptReader(bytes.NewReader(enc), pubKey, secKey)
+	assert.NoError(t, err)
+	dec, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, dec)
+
+	// modified, truncated and reordered streams are refused
+	modified := append([]byte{}, enc...)
+	modified[len(modified)/2] ^= 1
+	truncated := enc[:len(enc)-key_management.StreamChunkSize/2]
+	recordLen := 4 + key_management.StreamChunkSize + 16
+	start := len(enc) - 3*recordLen - (4 + 5 + 16)
+	reordered := append(append(append(append([]byte{}, enc[:start]...), enc[start+recordLen:start+2*recordLen]...),
+		enc[start:start+recordLen]...), enc[start+2*recordLen:]...)
+	for _, e := range [][]byte{modified, truncated, reordered, enc[:start+3*recordLen]} {
+		r, err := key_management.NewDecryptReader(bytes.NewReader(e), pubKey, secKey)
+		assert.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		assert.Error(t, err)
+	}
+
+	otherPubKey, otherSecKey := key_management.GenerateKeypair()
+	_, err = key_management.NewDecryptReader(bytes.NewReader(enc), otherPubKey, otherSecKey)
+	assert.Error(t, err)
+}
+
+func TestSignWithCert(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCerts(t, dir, "RootCA", "provider")
+	roots := x509.NewCertPool()
+	caCert, err := ioutil.ReadFile(dir + "/RootCA.crt")
+	assert.NoError(t, err)
+	assert.True(t, roots.AppendCertsFromPEM(caCert))
+
+	cert, key, err := key_management.LoadCertKey(dir, "provider")
+	assert.NoError(t, err)
+	msg := []byte("blabla")
+	sig, err := key_management.SignWithCert(msg, key)
+	assert.NoError(t, err)
+	assert.NoError(t, key_management.VerifyWithCert(msg, sig, cert, roots, "provider"))
+	assert.Error(t, key_management.VerifyWithCert([]byte("blablabla"), sig, cert, roots, "provider"))
+	assert.Error(t, key_management.VerifyWithCert(msg, sig, cert, roots, "other"))
+	assert.Error(t, key_management.VerifyWithCert(msg, sig, cert, x509.NewCertPool(), "provider"))
+
+	// a certificate of another CA with the same name is refused
+	otherDir := t.TempDir()
+	writeTestCerts(t, otherDir, "RootCA", "provider")
+	otherCert, otherKey, err := key_management.LoadCertKey(otherDir, "provider")
+	assert.NoError(t, err)
+	sig, err = key_management.SignWithCert(msg, otherKey)
+	assert.NoError(t, err)
+	assert.Error(t, key_management.VerifyWithCert(msg, sig, otherCert, roots, "provider"))
+}
+
+// writeTestCerts writes in dir a throwaway CA with the common name ca and certificates
+// signed by it for the names, as ca.crt and name.crt with their keys.
+func writeTestCerts(t *testing.T, dir, ca string, names ...string) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: ca},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	writeTestCert(t, dir, ca, caDer, caKey)
+
+	for i, name := range names {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		template := &x509.Certificate{SerialNumber: big.NewInt(int64(i + 2)), Subject: pkix.Name{CommonName: name},
+			NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}
+		der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+		assert.NoError(t, err)
+		writeTestCert(t, dir, name, der, key)
+	}
+}
+
+func writeTestCert(t *testing.T, dir, name string, der []byte, key *rsa.PrivateKey) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	assert.NoError(t, ioutil.WriteFile(dir+"/"+name+".crt", certPEM, 0644))
+	assert.NoError(t, ioutil.WriteFile(dir+"/"+name+".key", keyPEM, 0600))
+}
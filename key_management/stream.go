@@ -0,0 +1,188 @@
+package key_management
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Encrypted streams. A stream starts with
+//
+//	magic "MPCS" | version (1 byte) | key sealed with Encrypt for the receiver (80 bytes)
+//
+// followed by records of at most StreamChunkSize bytes of plaintext, each given by
+//
+//	length of the ciphertext (uint32, big endian) | ciphertext
+//
+// where the ciphertext is the record sealed with secretbox under the key of the stream. The
+// nonce of a record is its index (uint64, big endian) followed by a byte set to 1 for the
+// last record, so that records cannot be reordered, dropped or truncated. The last record
+// may be empty.
+const (
+	StreamVersion   = 1
+	StreamChunkSize = 1 << 16
+)
+
+var streamMagic = []byte("MPCS")
+
+const sealedKeySize = 32 + box.AnonymousOverhead
+
+// IsStream returns true if the data starts like an encrypted stream.
+func IsStream(b []byte) bool {
+	return len(b) > len(streamMagic) && bytes.Equal(b[:len(streamMagic)], streamMagic) &&
+		b[len(streamMagic)] == StreamVersion
+}
+
+func streamNonce(index uint64, last bool) *[24]byte {
+	var nonce [24]byte
+	binary.BigEndian.PutUint64(nonce[:8], index)
+	if last {
+		nonce[8] = 1
+	}
+	return &nonce
+}
+
+type encryptWriter struct {
+	w     io.Writer
+	key   [32]byte
+	buf   []byte
+	index uint64
+	out   []byte
+}
+
+// NewEncryptWriter returns a writer encrypting what is written to it for the public key
+// as a stream written to w, holding at most a record in memory. The stream is only
+// complete after Close, which does not close w.
+func NewEncryptWriter(w io.Writer, pubKey []byte) (io.WriteCloser, error) {
+	e := &encryptWriter{w: w, buf: make([]byte, 0, StreamChunkSize)}
+	_, err := io.ReadFull(rand.Reader, e.key[:])
+	if err != nil {
+		return nil, err
+	}
+	sealedKey, err := Encrypt(e.key[:], pubKey)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(append(append(append([]byte{}, streamMagic...), StreamVersion), sealedKey...))
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		k := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+		n += k
+		if len(e.buf) == cap(e.buf) {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// seal writes the buffered plaintext as a record.
+func (e *encryptWriter) seal(last bool) error {
+	e.out = e.out[:0]
+	e.out = append(e.out, 0, 0, 0, 0)
+	e.out = secretbox.Seal(e.out, e.buf, streamNonce(e.index, last), &e.key)
+	binary.BigEndian.PutUint32(e.out[:4], uint32(len(e.out)-4))
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	if e.buf == nil {
+		return nil
+	}
+	err := e.seal(true)
+	e.buf = nil
+	return err
+}
+
+type decryptReader struct {
+	r     io.Reader
+	key   [32]byte
+	index uint64
+	in    []byte
+	plain []byte
+	rest  []byte
+	done  bool
+}
+
+// NewDecryptReader returns a reader of the plaintext of the stream read from r, encrypted
+// for the key pair. Reading fails if the stream was modified or is truncated.
+func NewDecryptReader(r io.Reader, pubKey, secKey []byte) (io.Reader, error) {
+	header := make([]byte, len(streamMagic)+1+sealedKeySize)
+	_, err := io.ReadFull(r, header)
+	if err != nil || !IsStream(header) {
+		return nil, fmt.Errorf("decryption failed, not an encrypted stream")
+	}
+	key, err := Decrypt(header[len(streamMagic)+1:], pubKey, secKey)
+	if err != nil {
+		return nil, err
+	}
+	d := &decryptReader{r: r}
+	copy(d.key[:], key)
+	return d, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.rest) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.rest)
+	d.rest = d.rest[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next record.
+func (d *decryptReader) open() error {
+	var length [4]byte
+	if _, err := io.ReadFull(d.r, length[:]); err != nil {
+		return fmt.Errorf("decryption failed, truncated stream")
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < secretbox.Overhead || size > StreamChunkSize+secretbox.Overhead {
+		return fmt.Errorf("decryption failed, record of wrong length")
+	}
+	if cap(d.in) < int(size) {
+		d.in = make([]byte, size)
+	}
+	d.in = d.in[:size]
+	if _, err := io.ReadFull(d.r, d.in); err != nil {
+		return fmt.Errorf("decryption failed, truncated stream")
+	}
+
+	var ok bool
+	for _, last := range []bool{false, true} {
+		d.plain, ok = secretbox.Open(d.plain[:0], d.in, streamNonce(d.index, last), &d.key)
+		if ok {
+			d.done = last
+			break
+		}
+	}
+	if !ok {
+		return fmt.Errorf("decryption failed")
+	}
+	d.index++
+	d.rest = d.plain
+	return nil
+}
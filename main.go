@@ -13,7 +13,7 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "MPC service"
 	app.Usage = `A CLI app for running the MPC service`
-	app.Commands = []cli.Command{cmd.MpcNodeCmd, cmd.ManagerCmd, cmd.DataProviderCMD}
+	app.Commands = []cli.Command{cmd.MpcNodeCmd, cmd.ManagerCmd, cmd.DataProviderCMD, cmd.ClientCmd}
 
 	err := app.Run(os.Args)
 	if err != nil {
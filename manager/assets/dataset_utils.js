@@ -20,7 +20,7 @@ function load_datasets_table() {
   let i = 0;
   // fetch("/datasets")
 
-  fetch("/datasets")
+  apiFetch("/datasets")
     .then((response) => response.json())
     .then((datasetsList) => {
       //Once we fetch the list, we iterate over it
@@ -66,7 +66,7 @@ async function add_datasets() {
 
   const dataToSend = JSON.stringify({ name: datasetName, link: datasetLink });
 
-  const rawResponse = await fetch("/datasets", {
+  const rawResponse = await apiFetch("/datasets", {
     headers: {
       Accept: "application/json",
       "Content-Type": "application/json",
@@ -75,13 +75,17 @@ async function add_datasets() {
     body: dataToSend,
   });
   // console.log(rawResponse)
+  if (!rawResponse.ok) {
+    alert("Error: " + (await apiError(rawResponse)).message);
+    return;
+  }
 
   load_datasets_table();
 }
 
 async function getDatasets() {
   let datasets = [];
-  await fetch("/datasets")
+  await apiFetch("/datasets")
     .then((response) => response.json())
     .then((nodesList) => {
       nodesList.forEach((dataset) => {
@@ -109,25 +113,24 @@ async function loadAndSplit() {
     // need to load public keys of selected MPC nodes
     let nodes = await getNodes();
     var selectedNodes = getSelectedIndexes("nodes");
-    if (selectedNodes.length != 3) {
-      console.log("select 3 nodes");
+    if (selectedNodes.length < 3) {
+      console.log("select at least 3 nodes");
       return;
     }
 
+    let datasetId = fileToLoad.name.substring(0, fileToLoad.name.length - 4);
     let res = SplitCsvText(
       data,
-      nodes[selectedNodes[0]][3],
-      nodes[selectedNodes[1]][3],
-      nodes[selectedNodes[2]][3]
+      datasetId,
+      selectedNodes.map((index) => nodes[index][0]).join(","),
+      ...selectedNodes.map((index) => nodes[index][3])
     );
-    // result is an array of 4 strings: share for node 0, share for node 1, share for node 2, and description of the columns
-    // this should be saved to a file with 4 lines corresponding to the returned values in respected order, see data_management/framingham_tiny_enc.txt
+    // result is a signed share file with a header describing the dataset and a share for
+    // each selected node, see data_management/share_file.go, and the nonce of the
+    // commitment of the data, which is kept for auditors
     // console.log("split result", res)
-    download(
-      res[0] + "\n" + res[1] + "\n" + res[2] + "\n" + res[3] + "\n",
-      fileToLoad.name.substring(0, fileToLoad.name.length - 4) +
-        "_encrypted_split_data.txt"
-    );
+    download(res[0], datasetId + "_encrypted_split_data.txt");
+    download(res[1], datasetId + "_commitment_nonce.txt");
   };
 
   fileReader.readAsText(fileToLoad, "UTF-8");
@@ -1,7 +1,37 @@
+// apiFetch calls the REST API of the manager, adding the API token of the requester if
+// one was given; if the manager requires authentication the user is asked for the token
+async function apiFetch(resource, options = {}) {
+  let headers = { ...(options.headers || {}) };
+  let token = localStorage.getItem("apiToken");
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  let response = await fetch(resource, { ...options, headers: headers });
+  if (response.status == 401) {
+    token = prompt("API token:");
+    if (token) {
+      localStorage.setItem("apiToken", token);
+      headers["Authorization"] = "Bearer " + token;
+      response = await fetch(resource, { ...options, headers: headers });
+    }
+  }
+  return response;
+}
+
+// apiError returns the error explained in the JSON body {"error": "..."} of a failed request
+async function apiError(response) {
+  let text = await response.text();
+  try {
+    return new Error(JSON.parse(text).error);
+  } catch (err) {
+    return new Error(text);
+  }
+}
+
 function load_nodes_table() {
   nodesTable = document.querySelector("#nodes");
   let i = 0;
-  fetch("/nodes")
+  apiFetch("/nodes")
     .then((response) => response.json())
     .then((nodesList) => {
       //Once we fetch the list, we iterate over it
@@ -38,7 +68,7 @@ function load_nodes_table() {
 
 async function getNodes() {
   let nodes = [];
-  await fetch("/nodes")
+  await apiFetch("/nodes")
     .then((response) => response.json())
     .then((nodesList) => {
       //Once we fetch the list, we iterate over it
@@ -85,21 +115,20 @@ function getSelectedValue(className) {
 // demo use of MPC computation
 async function mpc_computation() {
   document.getElementById("errorMsg").style.display = "none";
-  // get information about selected nodes
+  // get information about selected nodes;
+  // if no nodes are selected, the manager chooses them
   var selectedNodesIndexes = getSelectedIndexes("nodes");
-  if (selectedNodesIndexes.length != 3) {
-    document.getElementById("errorMsg").innerText = "Error: select 3 nodes.";
+  if (selectedNodesIndexes.length < 3 && selectedNodesIndexes.length != 0) {
+    document.getElementById("errorMsg").innerText =
+      "Error: select at least 3 nodes or none to let the manager choose them.";
     document.getElementById("errorMsg").style.display = "block";
-    console.log("select 3 nodes");
+    console.log("select at least 3 nodes or none");
     return;
   }
   let allNodes = await getNodes();
-  let nodes = [
-    allNodes[selectedNodesIndexes[0]],
-    allNodes[selectedNodesIndexes[1]],
-    allNodes[selectedNodesIndexes[2]],
-  ];
-  var nodesNames = nodes[0][0] + "," + nodes[1][0] + "," + nodes[2][0];
+  var nodesNames = selectedNodesIndexes
+    .map((index) => allNodes[index][0])
+    .join(",");
 
   // get information about selected nodes
   var selectedDatasets = getSelectedIndexes("datasets");
@@ -136,7 +165,7 @@ async function mpc_computation() {
     console.log("datasets incompatible");
     return;
   }
-  if (allowedNodes.length != 3) {
+  if (nodesNames != "" && allowedNodes.length != selectedNodesIndexes.length) {
     document.getElementById("errorMsg").innerText =
       "Error: a dataset not shared with the selected nodes.";
     document.getElementById("errorMsg").style.display = "block";
@@ -149,6 +178,9 @@ async function mpc_computation() {
   var funcName = getSelectedValue("function");
 
   var params = {};
+  if (selectedDatasets.length > 1) {
+    params["cols"] = columns.join(",");
+  }
   if (funcName == "k-means") {
     params["NUM_CLUSTERS"] = document.getElementById("num_clusters").value;
     if ((!(parseInt(params["NUM_CLUSTERS"]) > 1)) || (parseInt(params["NUM_CLUSTERS"]) > 5)) {
@@ -184,33 +216,42 @@ async function mpc_computation() {
     Params: JSON.stringify(params),
   };
 
-  // timeout 1h
-  let rawResponse
+  // the manager returns a job id at once; the results are polled
+  let job;
   try {
-    rawResponse = await fetchWithTimeout("/compute", msg, {
-      timeout: 60 * 60 * 1000,
-    });
-  }
-  catch (err) {
-    document.getElementById("errorMsg").innerText =
-        "Error: " + err.message;
+    let rawResponse = await fetchWithTimeout("/compute", msg);
+    if (!rawResponse.ok) {
+      throw await apiError(rawResponse);
+    }
+    let accepted = await rawResponse.json();
+    job = await waitForJob(accepted.job_id);
+  } catch (err) {
+    document.getElementById("errorMsg").innerText = "Error: " + err.message;
     document.getElementById("errorMsg").style.display = "block";
     document.getElementById("errorMsg").style.color = "red";
     console.log("error computing the function");
     return;
   }
 
-
-  let response = await rawResponse.json();
   console.log("Response obtained");
 
-  let res = JoinSharesShamir(
+  // the shares of all the nodes are joined with the threshold and the fixed point
+  // parameters of the job; the nodes that returned wrong or no shares are reported to the manager
+  let response = job.results;
+  let joined = JoinSharesShamir(
     pubKey,
     secKey,
-    response[0].Result,
-    response[1].Result,
-    response[2].Result
+    ...response.map((result) => result.Result),
+    job.threshold,
+    job.fixed_point.k,
+    job.fixed_point.f
   );
+  let res = joined[0];
+  let cheaters = Array.from(joined[1]).map((i) => job.nodes[i].name);
+  if (cheaters.length > 0) {
+    console.log("wrong shares returned by", cheaters);
+    await fetchWithTimeout("/jobs/" + job.id + "/cheaters", { nodes: cheaters });
+  }
 
   // interpret the result
   let csvText = VecToCsvText(res, response[0].Cols, funcName);
@@ -219,12 +260,46 @@ async function mpc_computation() {
   download(csvText, "result.csv");
 
   progressBar.value = 100;
-  document.getElementById("errorMsg").innerText =
-    "Success: see downloaded file.";
+  document.getElementById("errorMsg").innerText = "Success: see downloaded file.";
+  if (cheaters.length > 0) {
+    document.getElementById("errorMsg").innerText +=
+      "\nNodes " + cheaters.join(", ") + " returned wrong or no shares, the results were reconstructed without them.";
+  }
   document.getElementById("errorMsg").style.display = "block";
   document.getElementById("errorMsg").style.color = "green";
 }
 
+// waitForJob polls the state of the job until it is finished and returns it
+async function waitForJob(jobId) {
+  while (true) {
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+    let rawResponse = await apiFetch("/jobs/" + jobId);
+    if (!rawResponse.ok) {
+      throw await apiError(rawResponse);
+    }
+    let job = await rawResponse.json();
+    let stateMsg = "Computation " + job.state;
+    if (job.queue_position) {
+      stateMsg = stateMsg + ", position in queue " + job.queue_position;
+    }
+    if (job.nodes) {
+      stateMsg = stateMsg + " (" + job.nodes.map((node) => node.name + ": " + node.state).join(", ") + ")";
+    }
+    if (job.selection) {
+      stateMsg = stateMsg + "\nNodes " + job.selection;
+    }
+    document.getElementById("errorMsg").innerText = stateMsg;
+    document.getElementById("errorMsg").style.display = "block";
+    document.getElementById("errorMsg").style.color = "black";
+    if (job.state == "failed") {
+      throw new Error(job.error);
+    }
+    if (job.state == "done") {
+      return job;
+    }
+  }
+}
+
 function download(textToWrite, name) {
   var a = document.body.appendChild(document.createElement("a"));
   a.download = name;
@@ -239,7 +314,7 @@ async function fetchWithTimeout(resource, msg, options = {}) {
   const controller = new AbortController();
   const id = setTimeout(() => controller.abort(), timeout);
   try {
-    const response = await fetch(resource, {
+    const response = await apiFetch(resource, {
       ...options,
       signal: controller.signal,
       headers: {
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Roles that can be given to requesters using the GUI/REST port.
+const (
+	RoleList           = "list"
+	RoleAddDatasets    = "add_datasets"
+	RoleDeleteDatasets = "delete_datasets"
+	RoleCompute        = "compute"
+)
+
+var allRoles = []string{RoleList, RoleAddDatasets, RoleDeleteDatasets, RoleCompute}
+
+// Requester is an identity that can use the GUI/REST port. A requester authenticates
+// with an API token or with a client certificate, signed by the RootCA, whose common
+// name equals Name.
+type Requester struct {
+	Name  string   `json:"name"`
+	Token string   `json:"token,omitempty"`
+	Roles []string `json:"roles"`
+}
+
+func (r Requester) hasRole(role string) bool {
+	for _, e := range r.Roles {
+		if e == role {
+			return true
+		}
+	}
+	return false
+}
+
+func isRole(role string) bool {
+	for _, e := range allRoles {
+		if e == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Requesters holds the identities allowed to use the GUI/REST port. If no requesters are
+// configured, authentication is disabled and everyone has all the roles.
+type Requesters struct {
+	list []Requester
+}
+
+var requesters Requesters
+
+// anonymous is the requester used when authentication is disabled.
+var anonymous = Requester{Name: "anonymous", Roles: allRoles}
+
+// LoadRequesters reads the requesters from a JSON file with a list of requesters.
+func LoadRequesters(file string) ([]Requester, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Requester
+	err = json.Unmarshal(b, &list)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		if e.Name == "" {
+			return nil, fmt.Errorf("requester without a name in %s", file)
+		}
+		for _, role := range e.Roles {
+			if !isRole(role) {
+				return nil, fmt.Errorf("unknown role %s of requester %s", role, e.Name)
+			}
+		}
+	}
+
+	return list, nil
+}
+
+// authenticate returns the requester of the HTTP request, identified by a bearer token
+// or a verified client certificate.
+func (rs *Requesters) authenticate(r *http.Request) (Requester, bool) {
+	if len(rs.list) == 0 {
+		return anonymous, true
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		for _, e := range rs.list {
+			if e.Token != "" && subtle.ConstantTimeCompare([]byte(e.Token), []byte(token)) == 1 {
+				return e, true
+			}
+		}
+		return Requester{}, false
+	}
+
+	// the TLS layer verified the chain of the certificate against the RootCA
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, e := range rs.list {
+			if e.Name == name {
+				return e, true
+			}
+		}
+	}
+
+	return Requester{}, false
+}
+
+type requesterKey struct{}
+
+// requesterFrom returns the authenticated requester of the HTTP request.
+func requesterFrom(r *http.Request) Requester {
+	if val, ok := r.Context().Value(requesterKey{}).(Requester); ok {
+		return val
+	}
+	return Requester{}
+}
+
+// authorize wraps a handler so that it is only called for requesters with the given role.
+func authorize(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requester, ok := requesters.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if !requester.hasRole(role) {
+			log.Info("Manager: requester ", requester.Name, " denied the role ", role)
+			writeError(w, http.StatusForbidden, "not allowed")
+			return
+		}
+
+		h(w, r.WithContext(context.WithValue(r.Context(), requesterKey{}, requester)))
+	}
+}
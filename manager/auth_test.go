@@ -0,0 +1,130 @@
+package manager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// certState returns the state of a TLS connection whose client certificate with the common
+// name was verified, or only presented if verified is false.
+func certState(name string, verified bool) *tls.ConnectionState {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return state
+}
+
+func TestAuthorize(t *testing.T) {
+	list := []Requester{
+		{Name: "alice", Token: "alice-token", Roles: []string{RoleList, RoleCompute}},
+		{Name: "bob", Token: "bob-token", Roles: []string{RoleList}},
+		{Name: "carol", Roles: []string{RoleAddDatasets}},
+	}
+
+	for _, test := range []struct {
+		name       string
+		requesters []Requester
+		role       string
+		auth       string
+		tls        *tls.ConnectionState
+		status     int
+		requester  string
+	}{
+		{name: "authentication disabled", role: RoleCompute, status: http.StatusOK, requester: "anonymous"},
+		{name: "token", requesters: list, role: RoleCompute, auth: "Bearer alice-token", status: http.StatusOK,
+			requester: "alice"},
+		{name: "token without the role", requesters: list, role: RoleCompute, auth: "Bearer bob-token",
+			status: http.StatusForbidden},
+		{name: "invalid token", requesters: list, role: RoleList, auth: "Bearer mallory-token",
+			status: http.StatusUnauthorized},
+		{name: "empty token", requesters: list, role: RoleAddDatasets, auth: "Bearer ",
+			status: http.StatusUnauthorized},
+		{name: "not a bearer token", requesters: list, role: RoleList, auth: "Basic YWxpY2U6YWxpY2U=",
+			status: http.StatusUnauthorized},
+		{name: "missing credentials", requesters: list, role: RoleList, status: http.StatusUnauthorized},
+		{name: "client certificate", requesters: list, role: RoleAddDatasets, tls: certState("carol", true),
+			status: http.StatusOK, requester: "carol"},
+		{name: "client certificate without the role", requesters: list, role: RoleCompute,
+			tls: certState("carol", true), status: http.StatusForbidden},
+		{name: "unverified client certificate", requesters: list, role: RoleAddDatasets,
+			tls: certState("carol", false), status: http.StatusUnauthorized},
+		{name: "client certificate of an unknown requester", requesters: list, role: RoleList,
+			tls: certState("mallory", true), status: http.StatusUnauthorized},
+		{name: "invalid token with a client certificate", requesters: list, role: RoleAddDatasets,
+			auth: "Bearer mallory-token", tls: certState("carol", true), status: http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requesters.list = test.requesters
+			t.Cleanup(func() { requesters.list = nil })
+
+			var got string
+			h := authorize(test.role, func(w http.ResponseWriter, r *http.Request) {
+				got = requesterFrom(r).Name
+			})
+			req := httptest.NewRequest("GET", "/jobs", nil)
+			if test.auth != "" {
+				req.Header.Set("Authorization", test.auth)
+			}
+			req.TLS = test.tls
+			w := httptest.NewRecorder()
+			h(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.requester, got)
+			if test.status == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestLoadRequesters(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+		names   []string
+		err     string
+	}{
+		{name: "valid", content: `[{"name": "alice", "token": "t", "roles": ["list", "compute"]},
+			{"name": "carol", "roles": ["add_datasets", "delete_datasets"]}]`, names: []string{"alice", "carol"}},
+		{name: "empty list", content: `[]`, names: []string{}},
+		{name: "not JSON", content: `name: alice`, err: "invalid character"},
+		{name: "not a list", content: `{"name": "alice"}`, err: "cannot unmarshal object"},
+		{name: "missing name", content: `[{"token": "t", "roles": ["list"]}]`, err: "requester without a name"},
+		{name: "unknown role", content: `[{"name": "alice", "roles": ["admin"]}]`,
+			err: "unknown role admin of requester alice"},
+		{name: "roles not a list", content: `[{"name": "alice", "roles": "list"}]`, err: "cannot unmarshal string"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file := t.TempDir() + "/requesters.json"
+			assert.NoError(t, ioutil.WriteFile(file, []byte(test.content), 0600))
+
+			list, err := LoadRequesters(file)
+			if test.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			names := make([]string, len(list))
+			for i, e := range list {
+				names[i] = e.Name
+			}
+			assert.Equal(t, test.names, names)
+		})
+	}
+
+	_, err := LoadRequesters(t.TempDir() + "/missing.json")
+	assert.True(t, os.IsNotExist(err))
+}
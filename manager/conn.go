@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/krakenh2020/MPCService/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// pingPeriod defines how often the manager checks that a connected node or data provider
+// is alive; a peer not answering within pongWait is disconnected.
+var pingPeriod = 10 * time.Second
+var pongWait = 30 * time.Second
+
+// peerConn is a connection to an MPC node or a data provider. Requests can be sent
+// concurrently, replies are matched to the requests by their request ID.
+type peerConn struct {
+	conn    *protocol.Conn
+	mu      sync.Mutex
+	pending map[string]*pendingRequest
+	closed  bool
+}
+
+// pendingRequest delivers the replies to a request until done is closed, when the replies
+// are no longer read.
+type pendingRequest struct {
+	replies chan *protocol.Message
+	done    chan struct{}
+}
+
+func newPeerConn(conn *protocol.Conn) *peerConn {
+	return &peerConn{conn: conn, pending: make(map[string]*pendingRequest)}
+}
+
+// request sends the request and returns the channel on which the replies with the
+// given request ID are delivered. The channel is closed if the connection is lost.
+func (c *peerConn) request(msgType, requestId string, req interface{}) (<-chan *protocol.Message, error) {
+	p := &pendingRequest{replies: make(chan *protocol.Message, 16), done: make(chan struct{})}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("connection closed")
+	}
+	c.pending[requestId] = p
+	c.mu.Unlock()
+
+	err := c.conn.Send(msgType, requestId, req)
+	if err != nil {
+		c.done(requestId)
+		return nil, err
+	}
+
+	return p.replies, nil
+}
+
+// done stops delivering replies to the request with the given ID, also a reply that is
+// waiting for room in the channel of the replies.
+func (c *peerConn) done(requestId string) {
+	c.mu.Lock()
+	if p, ok := c.pending[requestId]; ok {
+		close(p.done)
+		delete(c.pending, requestId)
+	}
+	c.mu.Unlock()
+}
+
+// cancel asks the peer to abandon the request with the given ID.
+func (c *peerConn) cancel(requestId string) {
+	c.done(requestId)
+	err := c.conn.Send(protocol.TypeCancel, requestId, nil)
+	if err != nil {
+		log.Debug("Manager: failed to cancel request ", requestId, err)
+	}
+}
+
+// close closes the connection, which ends run.
+func (c *peerConn) close() {
+	_ = c.conn.Close()
+}
+
+// run reads the replies of the peer and pings it until the connection is lost.
+func (c *peerConn) run() {
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.conn.KeepAlive(pingPeriod, pongWait, stop)
+
+	for {
+		msg, err := c.conn.Receive()
+		if err != nil {
+			log.Debug("Manager: connection closed ", err)
+			break
+		}
+
+		c.mu.Lock()
+		p, ok := c.pending[msg.RequestId]
+		c.mu.Unlock()
+		if !ok {
+			log.Error("Manager: received a ", msg.Type, " message for an unknown request ", msg.RequestId)
+			continue
+		}
+		// the reader of the replies may be gone since it looked the request up
+		select {
+		case p.replies <- msg:
+		case <-p.done:
+			log.Debug("Manager: dropped a ", msg.Type, " message for the finished request ", msg.RequestId)
+		}
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	for id, p := range c.pending {
+		close(p.replies)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	_ = c.conn.Close()
+}
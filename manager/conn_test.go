@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/krakenh2020/MPCService/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPeerConnFinishedRequest checks that the replies to a request whose reader is gone do
+// not hold up the replies to the other requests.
+func TestPeerConnFinishedRequest(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn := protocol.NewConn(ws)
+		defer conn.Close()
+
+		// the first request gets more progress messages than a reader buffers
+		for {
+			msg, err := conn.Receive()
+			if err != nil {
+				return
+			}
+			n := 1
+			if msg.RequestId == "r1" {
+				n = 40
+			}
+			for i := 0; i < n; i++ {
+				_ = conn.Send(protocol.TypeProgress, msg.RequestId, protocol.Progress{Stage: "running"})
+			}
+		}
+	}))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	c := newPeerConn(protocol.NewConn(ws))
+	running := make(chan struct{})
+	go func() {
+		c.run()
+		close(running)
+	}()
+
+	r1, err := c.request(protocol.TypeCompute, "r1", nil)
+	assert.NoError(t, err)
+	<-r1
+	c.done("r1")
+
+	r2, err := c.request(protocol.TypeCompute, "r2", nil)
+	assert.NoError(t, err)
+	select {
+	case msg := <-r2:
+		assert.Equal(t, "r2", msg.RequestId)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reply to the second request not delivered")
+	}
+
+	// the replies of the pending requests end with the connection
+	c.close()
+	<-running
+	for range r2 {
+	}
+	_, err = c.request(protocol.TypeCompute, "r3", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,201 @@
+package manager
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/data_provider"
+	log "github.com/sirupsen/logrus"
+)
+
+// reindex rebuilds nameToIndex after entries were removed from the list; it is called
+// with d.mu held.
+func (d *Datasets) reindex() {
+	d.nameToIndex = make(map[string]int, len(d.list))
+	for i, e := range d.list {
+		d.nameToIndex[e.Name] = i
+	}
+}
+
+// add appends the dataset offered over conn, or given by a link if conn is nil; it
+// returns false if a dataset with the same name is already offered. A dataset offered
+// by a data provider replaces the one offered over an older connection, so that a
+// provider can reconnect before its old connection is found dead.
+func (d *Datasets) add(dataset data_provider.Dataset, conn *peerConn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if index, ok := d.nameToIndex[dataset.Name]; ok {
+		if conn == nil || d.conns[index] == nil {
+			return false
+		}
+		d.list[index] = dataset
+		d.conns[index] = conn
+		return true
+	}
+	d.list = append(d.list, dataset)
+	d.conns = append(d.conns, conn)
+	d.nameToIndex[dataset.Name] = len(d.list) - 1
+	return true
+}
+
+// remove deletes the dataset with the given name if it is offered over conn.
+func (d *Datasets) remove(name string, conn *peerConn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index, ok := d.nameToIndex[name]
+	if !ok || d.conns[index] != conn {
+		return
+	}
+	d.list = append(d.list[:index], d.list[index+1:]...)
+	d.conns = append(d.conns[:index], d.conns[index+1:]...)
+	d.reindex()
+}
+
+// removeExpiredDatasets periodically withdraws the link datasets whose expiry time passed, until stop is closed.
+func removeExpiredDatasets(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-stop:
+			return
+		case now = <-ticker.C:
+		}
+		expired := make([]string, 0)
+		datasets.mu.Lock()
+		for _, e := range datasets.list {
+			if e.Expires != 0 && now.Unix() > e.Expires {
+				expired = append(expired, e.Name)
+			}
+		}
+		datasets.mu.Unlock()
+
+		for _, name := range expired {
+			datasets.remove(name, nil)
+			deleteDataset(name)
+			log.Info("Manager: dataset ", name, " expired")
+		}
+	}
+}
+
+// ownedDataset returns the link dataset with the name given in the URL, writing an error
+// if it does not exist or the requester does not own it.
+func ownedDataset(w http.ResponseWriter, r *http.Request) (data_provider.Dataset, bool) {
+	name := mux.Vars(r)["name"]
+	dataset, conn, ok := datasets.get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "dataset "+name+" not found")
+		return dataset, false
+	}
+	if conn != nil {
+		writeError(w, http.StatusForbidden, "dataset "+name+" is offered by a data provider")
+		return dataset, false
+	}
+	if dataset.Owner != requesterFrom(r).Name {
+		writeError(w, http.StatusForbidden, "dataset "+name+" is owned by another requester")
+		return dataset, false
+	}
+	return dataset, true
+}
+
+// updateDatasetHandler replaces the description of a link dataset, for example its link
+// after the data was split into new shares. The owner of the dataset stays the same.
+func updateDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	old, ok := ownedDataset(w, r)
+	if !ok {
+		return
+	}
+
+	var dataset data_provider.Dataset
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "cannot read request")
+		return
+	}
+	err = json.Unmarshal(body, &dataset)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "dataset is not valid JSON: "+err.Error())
+		return
+	}
+	if dataset.Name != "" && dataset.Name != old.Name {
+		writeError(w, http.StatusBadRequest, "the name of a dataset cannot be changed")
+		return
+	}
+	if reqErr := checkDataset(dataset); reqErr != nil {
+		writeError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+	dataset.Name = old.Name
+	dataset.Owner = old.Owner
+
+	datasets.mu.Lock()
+	index, ok := datasets.nameToIndex[old.Name]
+	ok = ok && datasets.conns[index] == nil
+	if ok {
+		datasets.list[index] = dataset
+		saveDataset(dataset)
+	}
+	datasets.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "dataset "+old.Name+" not found")
+		return
+	}
+	log.Info("Manager: dataset ", dataset.Name, " updated by ", dataset.Owner)
+
+	writeJSON(w, http.StatusOK, dataset)
+}
+
+// deleteDatasetHandler withdraws a link dataset.
+func deleteDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := ownedDataset(w, r)
+	if !ok {
+		return
+	}
+
+	datasets.remove(dataset.Name, nil)
+	deleteDataset(dataset.Name)
+	log.Info("Manager: dataset ", dataset.Name, " deleted by ", dataset.Owner)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkDataset checks the fields of a link dataset given by a requester.
+func checkDataset(dataset data_provider.Dataset) *requestError {
+	if dataset.Link == "" {
+		return badRequest("dataset needs a link")
+	}
+	if dataset.Expires != 0 && dataset.Expires <= time.Now().Unix() {
+		return badRequest("expiry time of the dataset is in the past")
+	}
+	if dataset.FixedPoint != nil {
+		if err := dataset.FixedPoint.Check(); err != nil {
+			return badRequest("%v", err)
+		}
+	}
+	if dataset.Sharing != nil {
+		if err := dataset.Sharing.Check(); err != nil {
+			return badRequest("%v", err)
+		}
+	}
+	if dataset.SignerKey != "" {
+		key, err := base64.StdEncoding.DecodeString(dataset.SignerKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return badRequest("signer key of the dataset is not a base64 encoded Ed25519 public key")
+		}
+	}
+	if dataset.Commitment != "" {
+		if err := data_management.CheckCommitment(dataset.Commitment); err != nil {
+			return badRequest("%v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/krakenh2020/MPCService/data_management"
+	log "github.com/sirupsen/logrus"
+)
+
+// States of a computation job as reported by the manager.
+const (
+	JobPending      = "pending approval"
+	JobQueued       = "queued"
+	JobFetchingData = "fetching data"
+	JobCompiling    = "compiling"
+	JobRunning      = "running"
+	JobDone         = "done"
+	JobFailed       = "failed"
+)
+
+// jobTTL defines how long the results of a finished job stay retrievable.
+var jobTTL = 24 * time.Hour
+
+// NodeProgress describes the progress of a single MPC node participating in a job.
+type NodeProgress struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Job is a computation requested through the GUI/REST port. It is executed
+// independently of the HTTP connection that created it. QueuePosition is the
+// position of a queued job among the jobs waiting for the same nodes. Selection explains
+// the choice of the nodes if the manager chose them; they evaluate the computation with the
+// MPC Backend. The results are shared among the
+// nodes with the sharing of Protocol and Threshold and the values are represented with
+// FixedPoint. Cheaters are the nodes whose shares of the results the receiver
+// found wrong when joining them.
+type Job struct {
+	Id            string                     `json:"id"`
+	Requester     string                     `json:"requester"`
+	State         string                     `json:"state"`
+	Error         string                     `json:"error,omitempty"`
+	Program       string                     `json:"program"`
+	Datasets      string                     `json:"datasets"`
+	Nodes         []NodeProgress             `json:"nodes"`
+	Backend       string                     `json:"backend,omitempty"`
+	Protocol      string                     `json:"protocol"`
+	Threshold     int                        `json:"threshold"`
+	FixedPoint    data_management.FixedPoint `json:"fixed_point"`
+	Selection     string                     `json:"selection,omitempty"`
+	QueuePosition int                        `json:"queue_position,omitempty"`
+	Results       []ReturnMsg                `json:"results,omitempty"`
+	Cheaters      []string                   `json:"cheaters,omitempty"`
+	Created       time.Time                  `json:"created"`
+	Updated       time.Time                  `json:"updated"`
+	Expires       time.Time                  `json:"expires,omitempty"`
+}
+
+type Jobs struct {
+	mu   sync.Mutex
+	list map[string]*Job
+}
+
+var jobs = Jobs{list: make(map[string]*Job)}
+
+// newId returns a random identifier of a job or a request.
+func newId() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// newJob registers a new job for the given computation request.
+func newJob(req ComputationRequest, nodesNames []string, requester, selection string) (*Job, error) {
+	id, err := newId()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &Job{Id: id, Requester: requester, Selection: selection, State: JobQueued, Program: req.Program, Datasets: req.DatasetNames,
+		Nodes: make([]NodeProgress, len(nodesNames)), Backend: req.Backend, Protocol: req.Protocol, Threshold: req.Threshold,
+		FixedPoint: req.FixedPoint, Created: now, Updated: now}
+	for i, name := range nodesNames {
+		job.Nodes[i] = NodeProgress{Name: name, State: JobQueued}
+	}
+
+	jobs.mu.Lock()
+	jobs.list[id] = job
+	saveJob(job)
+	jobs.mu.Unlock()
+
+	return job, nil
+}
+
+// getJob returns a copy of the job, so that it can be read without holding the lock.
+func getJob(id string) (Job, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	job, ok := jobs.list[id]
+	if !ok {
+		return Job{}, false
+	}
+	ret := *job
+	ret.Nodes = append([]NodeProgress(nil), job.Nodes...)
+	ret.Results = append([]ReturnMsg(nil), job.Results...)
+	ret.Cheaters = append([]string(nil), job.Cheaters...)
+
+	return ret, true
+}
+
+func (job *Job) setState(state string) {
+	jobs.mu.Lock()
+	job.State = state
+	job.Updated = time.Now()
+	saveJob(job)
+	jobs.mu.Unlock()
+}
+
+func (job *Job) setQueuePosition(position int) {
+	jobs.mu.Lock()
+	job.QueuePosition = position
+	jobs.mu.Unlock()
+}
+
+// setNodeState updates the progress of the i-th node of the job; the state of the job
+// is the least advanced state of its nodes.
+func (job *Job) setNodeState(i int, state string) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	job.Nodes[i].State = state
+	job.Updated = time.Now()
+
+	order := map[string]int{JobQueued: 0, JobFetchingData: 1, JobCompiling: 2, JobRunning: 3, JobDone: 4}
+	min := JobDone
+	for _, e := range job.Nodes {
+		if val, ok := order[e.State]; ok && val < order[min] {
+			min = e.State
+		}
+	}
+	if job.State == JobDone || job.State == JobFailed {
+		return
+	}
+	if min != JobDone && order[min] > order[job.State] {
+		job.State = min
+	}
+}
+
+// finish marks the job as done or failed and sets its expiry time.
+func (job *Job) finish(results []ReturnMsg, errMsg string) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	job.finishLocked(results, errMsg)
+}
+
+// finishLocked is finish called with jobs.mu held.
+func (job *Job) finishLocked(results []ReturnMsg, errMsg string) {
+	job.Results = results
+	job.Error = errMsg
+	if errMsg != "" {
+		job.State = JobFailed
+	} else {
+		job.State = JobDone
+	}
+	job.Updated = time.Now()
+	job.Expires = job.Updated.Add(jobTTL)
+	saveJob(job)
+}
+
+// removeExpiredJobs periodically deletes finished jobs whose results expired, until stop is closed.
+func removeExpiredJobs(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-stop:
+			return
+		case now = <-ticker.C:
+		}
+		jobs.mu.Lock()
+		for id, job := range jobs.list {
+			if !job.Expires.IsZero() && now.After(job.Expires) {
+				delete(jobs.list, id)
+				deleteJob(id)
+				log.Debug("Manager: removed expired job ", id)
+			}
+		}
+		jobs.mu.Unlock()
+	}
+}
+
+// jobOf returns the job with the id given in the URL if it belongs to the requester.
+func jobOf(r *http.Request) (Job, bool) {
+	job, ok := getJob(mux.Vars(r)["id"])
+	if !ok || job.Requester != requesterFrom(r).Name {
+		return Job{}, false
+	}
+	return job, true
+}
+
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobOf(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func getJobResultsHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobOf(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if job.State != JobDone && job.State != JobFailed {
+		writeError(w, http.StatusConflict, "job not finished")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job.Results)
+}
+
+// CheatersReport names the nodes whose shares of the results of a job were found wrong.
+type CheatersReport struct {
+	Nodes []string `json:"nodes"`
+}
+
+// reportCheatersHandler records the nodes that the receiver of the results of a job found
+// returning wrong shares, so that operators can act on them. The manager cannot check
+// the shares since it cannot decrypt them, so only the requester of the job may report
+// and only nodes of the job, at most as many as the receiver can leave out of the
+// reconstruction, n-t-1 of the n nodes with threshold t.
+func reportCheatersHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobOf(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if job.State != JobDone {
+		writeError(w, http.StatusConflict, "job not done")
+		return
+	}
+
+	var report CheatersReport
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "cannot read request")
+		return
+	}
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "report is not valid JSON: "+err.Error())
+		return
+	}
+	if len(report.Nodes) == 0 {
+		writeError(w, http.StatusBadRequest, "no nodes reported")
+		return
+	}
+	names := make([]string, len(job.Nodes))
+	for i, e := range job.Nodes {
+		names[i] = e.Name
+	}
+	for _, name := range report.Nodes {
+		if !contains(names, name) {
+			writeError(w, http.StatusBadRequest, "node "+name+" did not participate in the job")
+			return
+		}
+	}
+	report.Nodes = uniqueNames(report.Nodes)
+	if len(report.Nodes) > maxCheaters(job) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d nodes of the job can be reported",
+			maxCheaters(job)))
+		return
+	}
+
+	jobs.mu.Lock()
+	stored, ok := jobs.list[job.Id]
+	if ok {
+		for _, name := range report.Nodes {
+			if !contains(stored.Cheaters, name) {
+				stored.Cheaters = append(stored.Cheaters, name)
+			}
+		}
+		saveJob(stored)
+	}
+	jobs.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	log.Warn("Manager: nodes ", report.Nodes, " returned wrong shares in job ", job.Id, ", reported by ",
+		job.Requester)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxCheaters returns the largest number of nodes of the job whose shares of the results
+// the receiver can find wrong or missing and still reconstruct the results.
+func maxCheaters(job Job) int {
+	protocol := job.Protocol
+	if protocol == "" {
+		protocol = data_management.ProtocolShamir
+	}
+	t := job.Threshold
+	if t == 0 {
+		t = data_management.ProtocolThreshold(protocol, len(job.Nodes))
+	}
+	if len(job.Nodes) < t+1 {
+		return 0
+	}
+	return len(job.Nodes) - t - 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, val interface{}) {
+	b, err := json.Marshal(val)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	if err != nil {
+		log.Error(err)
+	}
+}
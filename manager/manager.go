@@ -1,74 +1,135 @@
 package manager
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/krakenh2020/MPCService/authorization"
 	"github.com/krakenh2020/MPCService/logging"
 
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"github.com/krakenh2020/MPCService/data_management"
 	"github.com/krakenh2020/MPCService/data_provider"
 	"github.com/krakenh2020/MPCService/mpc_engine"
+	"github.com/krakenh2020/MPCService/protocol"
 
 	"github.com/gorilla/mux"
 )
 
+// MPCNode describes a connected MPC node. Protocols are the sharings for which the
+// backend of the node is set up; a node that does not advertise them supports
+// defaultSharing. Backend is the MPC engine of the node, see mpc_engine.NewBackend; a node
+// that does not advertise it runs mpc_engine.DefaultBackend.
 type MPCNode struct {
-	Name        string `json:"name"`
-	ScalePort   int    `json:"scale_port"`
-	Address     string `json:"address"`
-	MpcPubKey   []byte `json:"mpc_pub_key"`
-	ScaleCert   []byte `json:"scale_cert"`
-	SigPubKey   []byte `json:"sig_pub_key"`
-	Description string `json:"description"`
+	Name        string                    `json:"name"`
+	ScalePort   int                       `json:"scale_port"`
+	Address     string                    `json:"address"`
+	MpcPubKey   []byte                    `json:"mpc_pub_key"`
+	ScaleCert   []byte                    `json:"scale_cert"`
+	SigPubKey   []byte                    `json:"sig_pub_key"`
+	Description string                    `json:"description"`
+	Protocols   []data_management.Sharing `json:"protocols"`
+	Backend     string                    `json:"backend,omitempty"`
 }
 
 type MPCNodes struct {
 	mu          sync.Mutex
 	list        []MPCNode
 	nameToIndex map[string]int
-	reqChan     []chan mpc_engine.Request
-	outChan     []chan ReturnMsg
+	conns       []*peerConn
 }
 
+// Datasets holds the datasets offered by data providers or given by a link; conns
+// is nil for the latter.
 type Datasets struct {
 	mu          sync.Mutex
 	list        []data_provider.Dataset
 	nameToIndex map[string]int
-	reqChan     []chan data_provider.DatasetRequest
-	outChan     []chan data_provider.DatasetReturn
+	conns       []*peerConn
 }
 
+// ComputationRequest is a request for a computation. If NodesNames is empty, the manager
+// chooses NumNodes nodes, always choosing the nodes in RequireNodes and never the ones in
+// ExcludeNodes; all of these are comma separated lists of node names. The nodes evaluate
+// Protocol, see data_management.Protocols, by default Shamir sharing, and the data is
+// shared among them with Threshold; by default 3 nodes are used with the largest
+// threshold the protocol allows. The values are represented with FixedPoint, by default
+// the representation of the datasets given by a link or data_management.DefaultFixedPoint.
+// All the nodes run the MPC Backend; if it is empty, the manager chooses nodes running the
+// same backend, preferring mpc_engine.DefaultBackend.
 type ComputationRequest struct {
 	NodesNames     string
+	NumNodes       int
+	Protocol       string
+	Threshold      int
+	FixedPoint     data_management.FixedPoint
 	Program        string
 	DatasetNames   string
 	Params         string
 	ReceiverPubKey string
+	Voucher        string // optional, proves that the requester may request the computation
+	RequireNodes   string
+	ExcludeNodes   string
+	Backend        string
 }
 
 var mpcNodes MPCNodes
 var datasets Datasets
 
+// authorizer approves the computations before they are scheduled; a pending decision is
+// asked again every approvalInterval until approvalTimeout.
+var authorizer authorization.Authorizer = authorization.AllowAll{}
+var approvalInterval = 10 * time.Second
+var approvalTimeout = 30 * time.Minute
+
 // ReturnMsg is a struct defining how returns of the node server will
-// be structured
+// be structured; Inputs are the commitments of the inputs the node computed on.
 type ReturnMsg struct {
 	Error  string
 	Result string
 	Cols   string
+	Inputs []data_management.InputCommitment `json:",omitempty"`
+}
+
+// get returns the connected MPC node with the given name.
+func (n *MPCNodes) get(name string) (MPCNode, *peerConn, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	index, ok := n.nameToIndex[name]
+	if !ok {
+		return MPCNode{}, nil, false
+	}
+	return n.list[index], n.conns[index], true
+}
+
+// get returns the dataset with the given name and the connection to its provider.
+func (d *Datasets) get(name string) (data_provider.Dataset, *peerConn, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index, ok := d.nameToIndex[name]
+	if !ok {
+		return data_provider.Dataset{}, nil, false
+	}
+	return d.list[index], d.conns[index], true
 }
 
 func getMPCNodesHandler(w http.ResponseWriter, r *http.Request) {
+	mpcNodes.mu.Lock()
 	nodesListBytes, err := json.Marshal(mpcNodes.list)
+	mpcNodes.mu.Unlock()
 
 	if err != nil {
 		log.Error(fmt.Errorf("Error: %v", err))
@@ -88,26 +149,38 @@ func addDatasetHandler(w http.ResponseWriter, r *http.Request) {
 	dataset := data_provider.Dataset{}
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "cannot read request")
+		return
 	}
 	err = json.Unmarshal(body, &dataset)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "dataset is not valid JSON: "+err.Error())
+		return
+	}
+	if dataset.Name == "" {
+		writeError(w, http.StatusBadRequest, "dataset needs a name")
+		return
 	}
+	if reqErr := checkDataset(dataset); reqErr != nil {
+		writeError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+	dataset.Owner = requesterFrom(r).Name
 
 	// Append our existing list of datasets
-	datasets.mu.Lock()
-	datasets.list = append(datasets.list, dataset)
-	datasets.reqChan = append(datasets.reqChan, nil)
-	datasets.outChan = append(datasets.outChan, nil)
-	datasets.nameToIndex[dataset.Name] = len(datasets.list) - 1
-	datasets.mu.Unlock()
+	if !datasets.add(dataset, nil) {
+		writeError(w, http.StatusConflict, "dataset "+dataset.Name+" already exists")
+		return
+	}
+	saveDataset(dataset)
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
 func getDatasetsHandler(w http.ResponseWriter, r *http.Request) {
+	datasets.mu.Lock()
 	datasetsListBytes, err := json.Marshal(datasets.list)
+	datasets.mu.Unlock()
 	if err != nil {
 		log.Error(fmt.Errorf("Error: %v", err))
 		w.WriteHeader(http.StatusInternalServerError)
@@ -123,108 +196,320 @@ func getDatasetsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func requestComputation(w http.ResponseWriter, r *http.Request) {
-	// todo: columns management, make a queue for requests
-	var ret [3]ReturnMsg
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "cannot read request")
+		return
 	}
 
 	var req ComputationRequest
 	err = json.Unmarshal(body, &req)
 	if err != nil {
 		log.Error("cannot read request", err)
+		writeError(w, http.StatusBadRequest, "request is not valid JSON: "+err.Error())
 		return
 	}
 	log.Info("Manager: received a request for MPC computation")
 	log.Debug("Manager: request", req)
 
-	inputVecs := make([][]string, 3)
-	for i := 0; i < 3; i++ {
+	if reqErr := validateRequest(req); reqErr != nil {
+		log.Info("Manager: invalid request: ", reqErr)
+		writeError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	sharing, reqErr := sharingOf(req)
+	if reqErr != nil {
+		log.Info("Manager: invalid request: ", reqErr)
+		writeError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+	req.NumNodes, req.Protocol, req.Threshold = sharing.Parties, sharing.Protocol, sharing.Threshold
+	req.FixedPoint, reqErr = fixedPointOf(req)
+	if reqErr != nil {
+		log.Info("Manager: invalid request: ", reqErr)
+		writeError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	selection := ""
+	if req.NodesNames == "" {
+		chosen, backend, explanation, err := selectNodesAnyBackend(splitNames(req.DatasetNames),
+			splitNames(req.RequireNodes), splitNames(req.ExcludeNodes), sharing, req.Backend)
+		if err != nil {
+			log.Info("Manager: no valid choice of nodes: ", err)
+			writeError(w, http.StatusUnprocessableEntity, "no valid choice of nodes: "+err.Error())
+			return
+		}
+		req.NodesNames = strings.Join(chosen, ",")
+		req.Backend = backend
+		selection = explanation
+		log.Info("Manager: nodes ", req.NodesNames, " selected, ", explanation)
+	} else {
+		nodesNames := splitNames(req.NodesNames)
+		if req.Backend == "" && len(nodesNames) > 0 {
+			if node, _, ok := mpcNodes.get(nodesNames[0]); ok {
+				req.Backend = node.backend()
+			}
+		}
+		if reqErr := validateNodes(nodesNames, splitNames(req.DatasetNames), sharing, req.Backend); reqErr != nil {
+			log.Info("Manager: invalid request: ", reqErr)
+			writeError(w, reqErr.Status, reqErr.Message)
+			return
+		}
+	}
+	req.NodesNames = strings.Join(splitNames(req.NodesNames), ",")
+	req.DatasetNames = strings.Join(splitNames(req.DatasetNames), ",")
+
+	job, err := newJob(req, strings.Split(req.NodesNames, ","), requesterFrom(r).Name, selection)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	go runJob(job, req)
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"job_id": job.Id, "nodes": req.NodesNames,
+		"selection": selection})
+}
+
+// runJob waits until the chosen MPC nodes are free, collects the data needed for the
+// computation, sends the requests to the nodes and stores their responses in the job.
+func runJob(job *Job, req ComputationRequest) {
+	// todo: columns management
+	chosenNodes := strings.Split(req.NodesNames, ",")
+	n := len(chosenNodes)
+	ret := make([]ReturnMsg, n)
+	datasetNames := strings.Split(req.DatasetNames, ",")
+
+	authReq := authorization.Request{Requester: job.Requester, Program: req.Program, Datasets: datasetNames,
+		Params: req.Params, Voucher: req.Voucher}
+	decision, reason := authorizer.Authorize(authReq)
+	if decision == authorization.Pending {
+		job.setState(JobPending)
+		decision, reason = authorization.Wait(authorizer, authReq, approvalInterval, approvalTimeout)
+	}
+	if decision != authorization.Allow {
+		log.Info("Manager: computation of ", job.Requester, " not authorized: ", reason)
+		job.finish(ret, "not authorized: "+reason)
+		return
+	}
+
+	job.setState(JobQueued)
+	scheduler.acquire(job, chosenNodes)
+	defer scheduler.release(job, chosenNodes)
+
+	job.setState(JobFetchingData)
+	nodes := make([]MPCNode, n)
+	conns := make([]*peerConn, n)
+	for i := 0; i < n; i++ {
+		var ok bool
+		nodes[i], conns[i], ok = mpcNodes.get(chosenNodes[i])
+		if !ok {
+			job.finish(ret, "node "+chosenNodes[i]+" not connected")
+			return
+		}
+	}
+
+	inputVecs := make([][]string, n)
+	for i := 0; i < n; i++ {
 		inputVecs[i] = make([]string, 0)
 	}
+	inputSignatures := make([][]data_management.ShareSignature, n)
 	inputCols := make([][]string, 0)
+	inputVecSources := make([]data_management.ShareSource, 0)
 	inputLinks := make([]string, 0)
-	datasetNames := strings.Split(req.DatasetNames, ",")
-	chosenNodes := strings.Split(req.NodesNames, ",")
+	inputSigners := make([]string, 0)
+	inputLinkSources := make([]data_management.ShareSource, 0)
 	for _, dataName := range datasetNames {
-		dataIndex := datasets.nameToIndex[dataName]
-		pubKeys := make([][]byte, 3)
-		certs := make([][]byte, 3)
-		sigs := make([][]byte, 3)
-		for i := 0; i < 3; i++ {
-			nodeIndex := mpcNodes.nameToIndex[chosenNodes[i]]
-			pubKeys[i] = mpcNodes.list[nodeIndex].MpcPubKey
-			certs[i] = mpcNodes.list[nodeIndex].ScaleCert
-			sigs[i] = mpcNodes.list[nodeIndex].SigPubKey
+		dataset, conn, ok := datasets.get(dataName)
+		if !ok {
+			job.finish(ret, "dataset "+dataName+" not found")
+			return
+		}
+		pubKeys := make([][]byte, n)
+		certs := make([][]byte, n)
+		sigs := make([][]byte, n)
+		for i := 0; i < n; i++ {
+			pubKeys[i] = nodes[i].MpcPubKey
+			certs[i] = nodes[i].ScaleCert
+			sigs[i] = nodes[i].SigPubKey
 		}
-		if val := datasets.reqChan[dataIndex]; val != nil {
-			inChan := datasets.reqChan[dataIndex]
-			dataReq := data_provider.DatasetRequest{DatasetName: dataName, NodesNames: chosenNodes,
+		if conn != nil {
+			dataReq := data_provider.DatasetRequest{Requester: job.Requester, DatasetName: dataName,
+				NodesNames: chosenNodes, Protocol: req.Protocol, Threshold: req.Threshold, FixedPoint: req.FixedPoint,
+				Program: req.Program,
+				Params:  req.Params, Voucher: req.Voucher,
 				NodesPubKeys: pubKeys, NodesCerts: certs, NodesPubKeysSignatures: sigs}
-			inChan <- dataReq
-
-			outChan := datasets.outChan[dataIndex]
-			retData := <-outChan
-
-			if len(retData.EncVecs) == 0 {
-				ret[0].Error = "data provider denied access"
-				retBytes, err := json.Marshal(ret)
-				if err != nil {
-					log.Error(err)
-				}
-				_, err = w.Write(retBytes)
-				if err != nil {
-					log.Error(err)
-				}
+			retData, err := fetchDataset(conn, dataReq)
+			if err != nil {
+				ret[0].Error = err.Error()
+				job.finish(ret, ret[0].Error)
 				return
 			}
 
-			for i := 0; i < 3; i++ {
+			if len(retData.EncVecs) != n {
+				job.finish(ret, "data provider returned "+strconv.Itoa(len(retData.EncVecs))+" shares of "+dataName)
+				return
+			}
+			for i := 0; i < n; i++ {
 				inputVecs[i] = append(inputVecs[i], retData.EncVecs[i])
+				// unsigned shares are refused by the nodes
+				signature := data_management.ShareSignature{}
+				if len(retData.Signatures) == n {
+					signature = retData.Signatures[i]
+				}
+				inputSignatures[i] = append(inputSignatures[i], signature)
 			}
 			inputCols = append(inputCols, retData.Cols)
+			inputVecSources = append(inputVecSources, data_management.ShareSource{Dataset: dataName,
+				Version: dataset.Version, Provider: dataset.Provider, Commitment: dataset.Commitment})
 		} else {
-			inputLinks = append(inputLinks, datasets.list[dataIndex].Link)
+			inputLinks = append(inputLinks, dataset.Link)
+			inputSigners = append(inputSigners, dataset.SignerKey)
+			inputLinkSources = append(inputLinkSources, data_management.ShareSource{Dataset: dataName,
+				Version: dataset.Version, Provider: dataset.Provider, Commitment: dataset.Commitment})
 		}
 
 	}
 
-	nodesAddr := make([]string, 3)
-	nodesPorts := make([]string, 3)
-	scaleCerts := make([][]byte, 3)
-	for i := 0; i < 3; i++ {
-		nodesAddr[i] = mpcNodes.list[mpcNodes.nameToIndex[chosenNodes[i]]].Address
-		nodesPorts[i] = strconv.Itoa(mpcNodes.list[mpcNodes.nameToIndex[chosenNodes[i]]].ScalePort)
-		scaleCerts[i] = mpcNodes.list[mpcNodes.nameToIndex[chosenNodes[i]]].ScaleCert
+	nodesAddr := make([]string, n)
+	nodesPorts := make([]string, n)
+	scaleCerts := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		nodesAddr[i] = nodes[i].Address
+		nodesPorts[i] = strconv.Itoa(nodes[i].ScalePort)
+		scaleCerts[i] = nodes[i].ScaleCert
 	}
 	nodePortsString := strings.Join(nodesPorts, ",")
 
-	for i := 0; i < 3; i++ {
-		inChan := mpcNodes.reqChan[mpcNodes.nameToIndex[chosenNodes[i]]]
-		reqI := mpc_engine.Request{Program: req.Program, InputLinks: inputLinks, Params: req.Params,
-			NodeId: i, NodesNames: chosenNodes, NodesAddrs: nodesAddr, NodesPorts: nodePortsString,
-			ReceiverPubKey: req.ReceiverPubKey, InputVecs: inputVecs[i], InputCols: inputCols,
+	// the nodes report their progress before returning the result; if one of them fails
+	// the requests to the others are cancelled
+	var wg sync.WaitGroup
+	var failOnce sync.Once
+	failed := make(chan struct{})
+	for i := 0; i < n; i++ {
+		reqI := mpc_engine.Request{Requester: job.Requester, Backend: req.Backend, Program: req.Program,
+			Datasets:   datasetNames,
+			InputLinks: inputLinks, InputSigners: inputSigners, InputLinkSources: inputLinkSources,
+			Params: req.Params, Voucher: req.Voucher,
+			NodeId: i, NodesNames: chosenNodes, Protocol: req.Protocol, Threshold: req.Threshold,
+			FixedPoint: req.FixedPoint, NodesAddrs: nodesAddr,
+			NodesPorts: nodePortsString, ReceiverPubKey: req.ReceiverPubKey, InputVecs: inputVecs[i], InputCols: inputCols,
+			InputVecSources: inputVecSources, InputSignatures: inputSignatures[i],
 			ScaleCerts: scaleCerts}
-		inChan <- reqI
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ret[i] = requestNode(conns[i], reqI, failed, func(stage string) {
+				job.setNodeState(i, stage)
+			})
+			if ret[i].Error != "" {
+				job.setNodeState(i, JobFailed)
+				failOnce.Do(func() { close(failed) })
+			} else {
+				job.setNodeState(i, JobDone)
+			}
+		}(i)
 	}
 	log.Info("Manager: sent request for computation to ", req.NodesNames)
+	wg.Wait()
+	log.Info("Manager: computation response received")
 
-	for i := 0; i < 3; i++ {
-		outChan := mpcNodes.outChan[mpcNodes.nameToIndex[chosenNodes[i]]]
-		out := <-outChan
-		ret[i] = out
+	errMsg := ""
+	for i := 0; i < n; i++ {
+		if ret[i].Error != "" {
+			errMsg = chosenNodes[i] + ": " + ret[i].Error
+			break
+		}
 	}
-	log.Info("Manager: computation response received")
+	job.finish(ret, errMsg)
+}
 
-	retBytes, err := json.Marshal(ret)
+// fetchDataset requests the encrypted shares of a dataset from its provider.
+func fetchDataset(conn *peerConn, req data_provider.DatasetRequest) (data_provider.DatasetReturn, error) {
+	var ret data_provider.DatasetReturn
+	id, err := newId()
 	if err != nil {
-		log.Error(err)
+		return ret, err
 	}
 
-	_, err = w.Write(retBytes)
+	log.Info("Manager: sending request for data ", req.DatasetName)
+	replies, err := conn.request(protocol.TypeDataRequest, id, req)
 	if err != nil {
-		log.Error(err)
+		return ret, err
+	}
+	defer conn.done(id)
+
+	msg, ok := <-replies
+	if !ok {
+		return ret, fmt.Errorf("lost connection with the data provider")
+	}
+	switch msg.Type {
+	case protocol.TypeResult:
+		err = msg.Decode(&ret)
+		if err != nil {
+			return ret, err
+		}
+	case protocol.TypeError:
+		var e protocol.Error
+		_ = msg.Decode(&e)
+		log.Error("Manager: request for data denied: ", e.Message)
+		return ret, fmt.Errorf("data provider denied access: %s", e.Message)
+	default:
+		return ret, fmt.Errorf("unexpected %s message from the data provider", msg.Type)
+	}
+	log.Info("Manager: received data ", req.DatasetName)
+
+	return ret, nil
+}
+
+// requestNode sends the computation request to an MPC node and waits for its result,
+// passing the progress reported by the node to progress. The request is cancelled
+// when cancel is closed.
+func requestNode(conn *peerConn, req mpc_engine.Request, cancel <-chan struct{},
+	progress func(stage string)) ReturnMsg {
+	id, err := newId()
+	if err != nil {
+		return ReturnMsg{Error: err.Error()}
+	}
+
+	replies, err := conn.request(protocol.TypeCompute, id, req)
+	if err != nil {
+		return ReturnMsg{Error: "failed to send the request: " + err.Error()}
+	}
+	defer conn.done(id)
+
+	for {
+		select {
+		case <-cancel:
+			conn.cancel(id)
+			return ReturnMsg{Error: "cancelled"}
+		case msg, ok := <-replies:
+			if !ok {
+				return ReturnMsg{Error: "lost connection with the node"}
+			}
+			switch msg.Type {
+			case protocol.TypeProgress:
+				var p protocol.Progress
+				if msg.Decode(&p) == nil {
+					progress(p.Stage)
+				}
+			case protocol.TypeResult:
+				var ret ReturnMsg
+				err = msg.Decode(&ret)
+				if err != nil {
+					return ReturnMsg{Error: "failed to read the response: " + err.Error()}
+				}
+				return ret
+			case protocol.TypeError:
+				var e protocol.Error
+				_ = msg.Decode(&e)
+				return ReturnMsg{Error: e.Message}
+			default:
+				return ReturnMsg{Error: "unexpected " + msg.Type + " message from the node"}
+			}
+		}
 	}
 }
 
@@ -245,81 +530,37 @@ func datasetsConnection(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var newDatasets []data_provider.Dataset
-	err = ws.ReadJSON(&newDatasets)
+	pc := protocol.NewConn(ws)
+	err = pc.AcceptHello(&newDatasets)
 	if err != nil {
-		log.Fatal(err)
+		log.Error("Manager: rejected a data provider: ", err)
+		_ = pc.Close()
+		return
 	}
 
-	inChan := make(chan data_provider.DatasetRequest, 2)
-	outChan := make(chan data_provider.DatasetReturn, 2)
+	conn := newPeerConn(pc)
 
-	datasets.mu.Lock()
-	for _, data := range newDatasets {
-		datasets.list = append(datasets.list, data)
-		datasets.reqChan = append(datasets.reqChan, inChan)
-		datasets.outChan = append(datasets.outChan, outChan)
-		datasets.nameToIndex[data.Name] = len(datasets.list) - 1
+	// the shares of the datasets must be signed with the certificate of the provider
+	provider := ""
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		provider = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	for i := range newDatasets {
+		newDatasets[i].Provider = provider
 	}
-	datasets.mu.Unlock()
-
-	for {
-		if len(inChan) > 0 {
-			req := <-inChan
-			log.Info("Manager: sending request for data ", req.DatasetName)
-			msg, err := json.Marshal(req)
-			if err != nil {
-				log.Error(err)
-			}
-
-			err = ws.WriteJSON(msg)
-			if err != nil {
-				log.Error(err)
-			}
-			var ret data_provider.DatasetReturn
-			err = ws.ReadJSON(&ret)
-			if len(ret.EncVecs) == 0 {
-				log.Error("Manager: request for data denied")
-			} else {
-				log.Info("Manager: received data ", req.DatasetName)
-			}
-			outChan <- ret
 
-		} else {
-			// check if the engine is ready
-			err := ws.WriteJSON([]byte("ping"))
-			if err != nil {
-				goto removeDataset
-			}
-			var b []byte
-			err = ws.ReadJSON(&b)
-			if err != nil {
-				goto removeDataset
-			}
+	for _, data := range newDatasets {
+		if !datasets.add(data, conn) {
+			log.Error("Manager: dataset ", data.Name, " is already offered, ignoring it")
 		}
-
-		time.Sleep(200 * time.Millisecond)
 	}
-removeDataset:
-	datasets.mu.Lock()
+
+	// serve the requests until the provider disconnects
+	conn.run()
+
 	for _, data := range newDatasets {
-		index := 0
-		for i, e := range datasets.list {
-			if e == data {
-				index = i
-				break
-			}
-		}
-		datasets.list = append(datasets.list[:index], datasets.list[index+1:]...)
-		datasets.reqChan = append(datasets.reqChan[:index], datasets.reqChan[index+1:]...)
-		datasets.outChan = append(datasets.outChan[:index], datasets.outChan[index+1:]...)
-		for key, val := range datasets.nameToIndex {
-			if val > index {
-				datasets.nameToIndex[key] = val - 1
-			}
-		}
-		delete(datasets.nameToIndex, data.Name)
+		datasets.remove(data.Name, conn)
 	}
-	datasets.mu.Unlock()
 }
 
 func mpcNodeConnection(w http.ResponseWriter, r *http.Request) {
@@ -333,81 +574,56 @@ func mpcNodeConnection(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var msg MPCNode
-	err = ws.ReadJSON(&msg)
+	pc := protocol.NewConn(ws)
+	err = pc.AcceptHello(&msg)
 	if err != nil {
-		log.Fatal(err)
+		log.Error("Manager: rejected an MPC node: ", err)
+		_ = pc.Close()
+		return
 	}
 
-	inChan := make(chan mpc_engine.Request, 2)
-	outChan := make(chan ReturnMsg, 2)
+	conn := newPeerConn(pc)
 
+	// a reconnecting node replaces its old connection
 	mpcNodes.mu.Lock()
-	mpcNodes.list = append(mpcNodes.list, msg)
-	mpcNodes.reqChan = append(mpcNodes.reqChan, inChan)
-	mpcNodes.outChan = append(mpcNodes.outChan, outChan)
-	mpcNodes.nameToIndex[msg.Name] = len(mpcNodes.list) - 1
+	if index, ok := mpcNodes.nameToIndex[msg.Name]; ok {
+		mpcNodes.list[index] = msg
+		mpcNodes.conns[index] = conn
+	} else {
+		mpcNodes.list = append(mpcNodes.list, msg)
+		mpcNodes.conns = append(mpcNodes.conns, conn)
+		mpcNodes.nameToIndex[msg.Name] = len(mpcNodes.list) - 1
+	}
 	mpcNodes.mu.Unlock()
 
-	for {
-		if len(inChan) > 0 {
-			req := <-inChan
-			msg, err := json.Marshal(req)
-			if err != nil {
-				log.Error(err)
-			}
-
-			err = ws.WriteJSON(msg)
-			if err != nil {
-				log.Error(err)
-			}
-			var ret ReturnMsg
-			err = ws.ReadJSON(&ret)
-			outChan <- ret
+	// serve the requests until the node disconnects
+	conn.run()
 
-		} else {
-			// check if the engine is ready
-			err := ws.WriteJSON([]byte("ping"))
-			if err != nil {
-				goto removeMPCnode
-			}
-			var b []byte
-			err = ws.ReadJSON(&b)
-			if err != nil {
-				goto removeMPCnode
-			}
-		}
-
-		time.Sleep(200 * time.Millisecond)
-	}
-removeMPCnode:
 	mpcNodes.mu.Lock()
-	index := 0
-	for i, e := range mpcNodes.list {
-		if e.Name == msg.Name {
-			index = i
-			break
+	if index, ok := mpcNodes.nameToIndex[msg.Name]; ok && mpcNodes.conns[index] == conn {
+		mpcNodes.list = append(mpcNodes.list[:index], mpcNodes.list[index+1:]...)
+		mpcNodes.conns = append(mpcNodes.conns[:index], mpcNodes.conns[index+1:]...)
+		mpcNodes.nameToIndex = make(map[string]int, len(mpcNodes.list))
+		for i, e := range mpcNodes.list {
+			mpcNodes.nameToIndex[e.Name] = i
 		}
 	}
-	mpcNodes.list = append(mpcNodes.list[:index], mpcNodes.list[index+1:]...)
-	mpcNodes.reqChan = append(mpcNodes.reqChan[:index], mpcNodes.reqChan[index+1:]...)
-	mpcNodes.outChan = append(mpcNodes.outChan[:index], mpcNodes.outChan[index+1:]...)
-	for key, val := range mpcNodes.nameToIndex {
-		if val > index {
-			mpcNodes.nameToIndex[key] = val - 1
-		}
-	}
-	delete(mpcNodes.nameToIndex, msg.Name)
-
 	mpcNodes.mu.Unlock()
 }
 
 func newRouters(assets string) (*mux.Router, *mux.Router) {
 	r1 := mux.NewRouter()
 	r1.HandleFunc("/hello", handler).Methods("GET")
-	r1.HandleFunc("/nodes", getMPCNodesHandler).Methods("GET")
-	r1.HandleFunc("/datasets", getDatasetsHandler).Methods("GET")
-	r1.HandleFunc("/datasets", addDatasetHandler).Methods("POST")
-	r1.HandleFunc("/compute", requestComputation).Methods("POST")
+	r1.HandleFunc("/nodes", authorize(RoleList, getMPCNodesHandler)).Methods("GET")
+	r1.HandleFunc("/datasets", authorize(RoleList, getDatasetsHandler)).Methods("GET")
+	r1.HandleFunc("/datasets", authorize(RoleAddDatasets, addDatasetHandler)).Methods("POST")
+	r1.HandleFunc("/datasets/{name}", authorize(RoleAddDatasets, updateDatasetHandler)).Methods("PUT")
+	r1.HandleFunc("/datasets/{name}", authorize(RoleDeleteDatasets, deleteDatasetHandler)).Methods("DELETE")
+	r1.HandleFunc("/compute", authorize(RoleCompute, requestComputation)).Methods("POST")
+	r1.HandleFunc("/jobs/{id}", authorize(RoleCompute, getJobHandler)).Methods("GET")
+	r1.HandleFunc("/jobs/{id}/results", authorize(RoleCompute, getJobResultsHandler)).Methods("GET")
+	r1.HandleFunc("/jobs/{id}/cheaters", authorize(RoleCompute, reportCheatersHandler)).Methods("POST")
+	r1.HandleFunc("/history", authorize(RoleCompute, getHistoryHandler)).Methods("GET")
 
 	var staticFileDirectory http.Dir
 	if assets == "" {
@@ -425,36 +641,135 @@ func newRouters(assets string) (*mux.Router, *mux.Router) {
 	return r1, r2
 }
 
-func RunManager(guiPort, servicePort int, assets string, logLevel, logFile, caFolder string) {
+// RunManager starts the manager. The GUI/REST port is served over TLS with the manager's
+// certificate if guiTLS is set; requesters are loaded from authFile, if given. Computations
+// are approved by the authorizer given by authz, see authorization.New.
+func RunManager(guiPort, servicePort int, assets string, logLevel, logFile, caFolder string,
+	guiTLS bool, authFile string, authz string, storeDir string) {
+	guiListener, err := net.Listen("tcp", ":"+strconv.Itoa(guiPort))
+	if err != nil {
+		log.Fatal("Manager: ", err)
+	}
+	serviceListener, err := net.Listen("tcp", ":"+strconv.Itoa(servicePort))
+	if err != nil {
+		log.Fatal("Manager: ", err)
+	}
+	err = ServeManager(context.Background(), guiListener, serviceListener, assets, logLevel, logFile, caFolder,
+		guiTLS, authFile, authz, storeDir)
+	if err != nil {
+		log.Fatal("Manager: ", err)
+	}
+}
+
+// ServeManager is RunManager serving the GUI/REST API on guiListener and the nodes and the
+// data providers on serviceListener until ctx is done: the manager then closes the
+// listeners and the connections of the nodes and the providers. It returns the errors of
+// setting up the manager or of serving instead of stopping the process, and nil once ctx
+// is done. The state of the manager is kept in package variables, so only one manager
+// runs in a process at a time.
+func ServeManager(ctx context.Context, guiListener, serviceListener net.Listener, assets string, logLevel,
+	logFile, caFolder string, guiTLS bool, authFile string, authz string, storeDir string) error {
+	defer guiListener.Close()
+	defer serviceListener.Close()
 	// set up logging
 	logging.LogSetUp(logLevel, logFile)
 
-	mpcNodes.nameToIndex = make(map[string]int)
-	datasets.nameToIndex = make(map[string]int)
+	mpcNodes.mu.Lock()
+	mpcNodes.list, mpcNodes.conns, mpcNodes.nameToIndex = make([]MPCNode, 0), nil, make(map[string]int)
+	mpcNodes.mu.Unlock()
+	datasets.mu.Lock()
+	datasets.list, datasets.conns, datasets.nameToIndex = make([]data_provider.Dataset, 0), nil, make(map[string]int)
+	datasets.mu.Unlock()
+
+	var err error
+	db = nil
+	if storeDir != "" {
+		err = openStore(storeDir)
+		if err != nil {
+			return fmt.Errorf("error opening the store: %s", err)
+		}
+	} else {
+		log.Info("Manager: no store configured, datasets and jobs are lost on restart")
+	}
+
+	authorizer, err = authorization.New(authz)
+	if err != nil {
+		return fmt.Errorf("error setting up the authorizer: %s", err)
+	}
+
+	requesters.list = nil
+	if authFile != "" {
+		list, err := LoadRequesters(authFile)
+		if err != nil {
+			return fmt.Errorf("error loading requesters: %s", err)
+		}
+		requesters.list = list
+	} else {
+		log.Info("Manager: no requesters configured, authentication of requesters is disabled")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go removeExpiredJobs(time.Minute, stop)
+	go removeExpiredDatasets(time.Minute, stop)
+
 	// The router is now formed by calling the `newRouter` constructor function
 	// that we defined above. The rest of the code stays the same
-	log.Info("Manager running on localhost:", guiPort)
+	log.Info("Manager running on ", guiListener.Addr())
 	r1, r2 := newRouters(assets)
 
-	go http.ListenAndServe(":"+strconv.Itoa(guiPort), r1)
-
 	caCert, err := ioutil.ReadFile(caFolder + "/RootCA.crt")
 	caCertPool := x509.NewCertPool()
 	_ = caCertPool.AppendCertsFromPEM(caCert)
+
+	errs := make(chan error, 2)
+	guiServer := &http.Server{Handler: r1}
+	if guiTLS {
+		// requesters may authenticate with a client certificate signed by the RootCA
+		guiServer.TLSConfig = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: caCertPool}
+		go func() {
+			errs <- guiServer.ServeTLS(guiListener, caFolder+"/Manager.crt", caFolder+"/Manager.key")
+		}()
+	} else {
+		go func() {
+			errs <- guiServer.Serve(guiListener)
+		}()
+	}
+
 	server := &http.Server{
-		Addr:         ":" + strconv.Itoa(servicePort),
 		ReadTimeout:  5 * time.Minute,
 		WriteTimeout: 10 * time.Second,
 		TLSConfig: &tls.Config{ServerName: "manager", ClientAuth: tls.RequireAndVerifyClientCert,
 			ClientCAs: caCertPool},
 		Handler: r2,
 	}
+	go func() {
+		errs <- server.ServeTLS(serviceListener, caFolder+"/Manager.crt", caFolder+"/Manager.key")
+	}()
 
-	err = server.ListenAndServeTLS(caFolder+"/Manager.crt", caFolder+"/Manager.key")
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	_ = guiServer.Close()
+	_ = server.Close()
 
-	if err != nil {
-		panic(err.Error())
+	// the connections of the nodes and the providers are hijacked by websocket, so they are
+	// not closed with the servers
+	mpcNodes.mu.Lock()
+	conns := append([]*peerConn{}, mpcNodes.conns...)
+	mpcNodes.mu.Unlock()
+	datasets.mu.Lock()
+	conns = append(conns, datasets.conns...)
+	datasets.mu.Unlock()
+	for _, conn := range conns {
+		if conn != nil {
+			conn.close()
+		}
 	}
+	log.Info("Manager stopped")
+
+	return err
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
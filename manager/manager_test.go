@@ -16,6 +16,7 @@ import (
 
 	"github.com/krakenh2020/MPCService/data_management"
 	"github.com/krakenh2020/MPCService/manager"
+	"github.com/krakenh2020/MPCService/store"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
@@ -24,7 +25,7 @@ import (
 
 func TestRequestComputationWithManager(t *testing.T) {
 	go manager.RunManager(5007, 5008, "../manager/assets", "info", "../logging/log.log",
-		"../key_management/keys_certificates")
+		"../key_management/keys_certificates", false, "", "", "")
 	time.Sleep(1 * time.Second)
 
 	nodeNames := []string{"Berlin_node", "Paris_node", "Ljubljana_node", "Rome_node", "Leuven_node",
@@ -33,7 +34,7 @@ func TestRequestComputationWithManager(t *testing.T) {
 	trustedNodes := []string{"Berlin_node", "Paris_node", "Ljubljana_node", "Rome_node"}
 	// run data provider
 	go data_provider.RunDatasetProvider("Data_provider1", "../data_provider/datasets", "debug",
-		"../logging/log.log", "localhost:5008", "../key_management/keys_certificates", trustedNodes)
+		"../logging/log.log", "localhost:5008", "../key_management/keys_certificates", trustedNodes, "")
 	time.Sleep(1 * time.Second)
 
 	// run MPC nodes
@@ -41,7 +42,8 @@ func TestRequestComputationWithManager(t *testing.T) {
 		go mpc_node.RunNode(nodeNames[nodeId], "localhost", 5040+nodeId,
 			"../key_management/keys_certificates", os.Getenv("SCALE_MAMBA_PATH"),
 			"debug", "../logging/log.log",
-			"localhost:5008", "An MPC node deployed for tests.")
+			"localhost:5008", "An MPC node deployed for tests.", "", "shamir:3:1,shamir:5:2", "",
+			"Data_provider1", true)
 	}
 	time.Sleep(1 * time.Second)
 
@@ -57,7 +59,8 @@ func TestRequestComputationWithManager(t *testing.T) {
 		assert.NoError(t, err)
 	}
 
-	res := data_management.JoinSharesShamirFloat(resVecs)
+	res, err := data_management.JoinSharesShamirFloat(resVecs)
+	assert.NoError(t, err)
 	assert.Equal(t, []float64{1, 65, 225, 1}, res)
 }
 
@@ -96,9 +99,250 @@ func requestComputationToManager(program string, pubKey []byte) [3]manager.Retur
 
 	var resBytes []byte
 	resBytes, err = ioutil.ReadAll(response.Body)
+	var accepted map[string]string
+	err = json.Unmarshal(resBytes, &accepted)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// poll the job until the computation is finished
+	var job manager.Job
+	for job.State != manager.JobDone && job.State != manager.JobFailed {
+		time.Sleep(time.Second)
+		response, err = http.Get("http://localhost:5007/jobs/" + accepted["job_id"])
+		if err != nil {
+			log.Fatal(err)
+		}
+		resBytes, err = ioutil.ReadAll(response.Body)
+		err = json.Unmarshal(resBytes, &job)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	var res [3]manager.ReturnMsg
-	err = json.Unmarshal(resBytes, &res)
+	copy(res[:], job.Results)
 
 	return res
 }
+
+func TestRequestValidation(t *testing.T) {
+	go manager.RunManager(5027, 5028, "../manager/assets", "info", "../logging/log.log",
+		"../key_management/keys_certificates", false, "", "", "")
+	time.Sleep(1 * time.Second)
+
+	post := func(url string, body []byte) (int, map[string]string) {
+		response, err := http.Post("http://localhost:5027"+url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var res map[string]string
+		b, _ := ioutil.ReadAll(response.Body)
+		_ = json.Unmarshal(b, &res)
+		return response.StatusCode, res
+	}
+
+	dataset := data_provider.Dataset{Name: "linked.csv", Cols: "age,glucose", SharedWith: "all",
+		Link: "https://example.com/linked.csv"}
+	datasetBytes, err := json.Marshal(dataset)
+	assert.NoError(t, err)
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Post("http://localhost:5027/datasets", "application/json", bytes.NewReader(datasetBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, response.StatusCode)
+	status, res := post("/datasets", datasetBytes)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.NotEmpty(t, res["error"])
+	status, res = post("/datasets", []byte("{"))
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.NotEmpty(t, res["error"])
+
+	pubKey, _ := key_management.GenerateKeypair()
+	valid := manager.ComputationRequest{
+		NodesNames:     "Berlin_node,Paris_node,Ljubljana_node",
+		Program:        "avg",
+		DatasetNames:   "linked.csv",
+		ReceiverPubKey: base64.StdEncoding.EncodeToString(pubKey),
+	}
+	tests := []struct {
+		name   string
+		modify func(req *manager.ComputationRequest)
+		status int
+	}{
+		{"unknown dataset", func(req *manager.ComputationRequest) { req.DatasetNames = "unknown.csv" }, 422},
+		{"no dataset", func(req *manager.ComputationRequest) { req.DatasetNames = "" }, 400},
+		{"unknown program", func(req *manager.ComputationRequest) { req.Program = "rm -rf" }, 422},
+		{"bad params", func(req *manager.ComputationRequest) { req.Params = "[1]" }, 400},
+		{"unsupported param", func(req *manager.ComputationRequest) { req.Params = `{"X": "1"}` }, 422},
+		{"unknown column", func(req *manager.ComputationRequest) { req.Params = `{"cols": "age,male"}` }, 422},
+		{"bad key", func(req *manager.ComputationRequest) { req.ReceiverPubKey = "c2hvcnQ=" }, 400},
+		{"two nodes", func(req *manager.ComputationRequest) { req.NodesNames = "Berlin_node,Paris_node" }, 400},
+		{"repeated node", func(req *manager.ComputationRequest) {
+			req.NodesNames = "Berlin_node,Berlin_node,Paris_node"
+		}, 400},
+		{"unknown protocol", func(req *manager.ComputationRequest) { req.Protocol = "yao" }, 400},
+		{"bad threshold", func(req *manager.ComputationRequest) {
+			req.Protocol, req.Threshold = "full-threshold", 1
+		}, 400},
+		{"linked replicated", func(req *manager.ComputationRequest) { req.Protocol = "replicated" }, 422},
+		{"bad fixed point", func(req *manager.ComputationRequest) {
+			req.FixedPoint = data_management.FixedPoint{K: 10, F: 20}
+		}, 400},
+		{"linked fixed point", func(req *manager.ComputationRequest) {
+			req.FixedPoint = data_management.FixedPoint{K: 50, F: 20}
+		}, 422},
+		{"unknown node", func(req *manager.ComputationRequest) {}, 422},
+	}
+	for _, test := range tests {
+		req := valid
+		test.modify(&req)
+		reqBytes, err := json.Marshal(req)
+		assert.NoError(t, err)
+		status, res := post("/compute", reqBytes)
+		assert.Equal(t, test.status, status, test.name)
+		assert.NotEmpty(t, res["error"], test.name)
+	}
+
+	status, res = post("/compute", []byte("not json"))
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.NotEmpty(t, res["error"])
+}
+
+func TestDatasetLifecycle(t *testing.T) {
+	f, err := ioutil.TempFile("", "requesters*.json")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"name": "alice", "token": "alice-token", "roles": ["list", "add_datasets", "delete_datasets"]},
+		{"name": "bob", "token": "bob-token", "roles": ["list", "add_datasets", "delete_datasets"]}]`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	go manager.RunManager(5037, 5038, "../manager/assets", "info", "../logging/log.log",
+		"../key_management/keys_certificates", false, f.Name(), "", "")
+	time.Sleep(1 * time.Second)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	send := func(method, url, token string, val interface{}) *http.Response {
+		body, err := json.Marshal(val)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(method, "http://localhost:5037"+url, bytes.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		response, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	dataset := data_provider.Dataset{Name: "owned.csv", Link: "https://example.com/v1.csv",
+		Expires: time.Now().Add(time.Hour).Unix()}
+	assert.Equal(t, http.StatusFound, send("POST", "/datasets", "alice-token", dataset).StatusCode)
+	expired := data_provider.Dataset{Name: "expired.csv", Link: "https://example.com/old.csv",
+		Expires: time.Now().Add(-time.Hour).Unix()}
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/datasets", "alice-token", expired).StatusCode)
+	unsigned := data_provider.Dataset{Name: "unsigned.csv", Link: "https://example.com/unsigned.csv",
+		SignerKey: "bm90IGEga2V5"}
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/datasets", "alice-token", unsigned).StatusCode)
+	uncommitted := data_provider.Dataset{Name: "uncommitted.csv", Link: "https://example.com/uncommitted.csv",
+		Commitment: "not a hash"}
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/datasets", "alice-token", uncommitted).StatusCode)
+
+	response := send("GET", "/datasets", "bob-token", nil)
+	var list []data_provider.Dataset
+	b, err := ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(b, &list))
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "alice", list[0].Owner)
+
+	update := data_provider.Dataset{Link: "https://example.com/v2.csv", Description: "re-split"}
+	assert.Equal(t, http.StatusForbidden, send("PUT", "/datasets/owned.csv", "bob-token", update).StatusCode)
+	assert.Equal(t, http.StatusOK, send("PUT", "/datasets/owned.csv", "alice-token", update).StatusCode)
+	renamed := data_provider.Dataset{Name: "other.csv", Link: "https://example.com/v2.csv"}
+	assert.Equal(t, http.StatusBadRequest, send("PUT", "/datasets/owned.csv", "alice-token", renamed).StatusCode)
+	assert.Equal(t, http.StatusNotFound, send("PUT", "/datasets/missing.csv", "alice-token", update).StatusCode)
+
+	response = send("GET", "/datasets", "bob-token", nil)
+	b, err = ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(b, &list))
+	assert.Equal(t, "https://example.com/v2.csv", list[0].Link)
+	assert.Equal(t, "alice", list[0].Owner)
+
+	assert.Equal(t, http.StatusForbidden, send("DELETE", "/datasets/owned.csv", "bob-token", nil).StatusCode)
+	assert.Equal(t, http.StatusNoContent, send("DELETE", "/datasets/owned.csv", "alice-token", nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, send("DELETE", "/datasets/owned.csv", "alice-token", nil).StatusCode)
+	assert.Equal(t, http.StatusFound, send("POST", "/datasets", "bob-token", dataset).StatusCode)
+}
+
+func TestCheatersReport(t *testing.T) {
+	f, err := ioutil.TempFile("", "requesters*.json")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"name": "alice", "token": "alice-token", "roles": ["compute"]},
+		{"name": "bob", "token": "bob-token", "roles": ["compute"]}]`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	// a finished job of alice is loaded from the store
+	dir, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	assert.NoError(t, err)
+	now := time.Now()
+	job := manager.Job{Id: "job1", Requester: "alice", State: manager.JobDone, Program: "max", Threshold: 1,
+		Nodes: []manager.NodeProgress{{Name: "a", State: manager.JobDone}, {Name: "b", State: manager.JobDone},
+			{Name: "c", State: manager.JobDone}, {Name: "d", State: manager.JobDone}},
+		Created: now, Updated: now, Expires: now.Add(time.Hour)}
+	assert.NoError(t, s.Put("jobs", job.Id, job))
+
+	go manager.RunManager(5047, 5048, "../manager/assets", "info", "../logging/log.log",
+		"../key_management/keys_certificates", false, f.Name(), "", dir)
+	time.Sleep(1 * time.Second)
+
+	send := func(method, url, token string, val interface{}) *http.Response {
+		body, err := json.Marshal(val)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(method, "http://localhost:5047"+url, bytes.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	report := manager.CheatersReport{Nodes: []string{"c"}}
+	assert.Equal(t, http.StatusNotFound, send("POST", "/jobs/job1/cheaters", "bob-token", report).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/jobs/job1/cheaters", "alice-token",
+		manager.CheatersReport{Nodes: []string{"e"}}).StatusCode)
+	// 4 nodes with threshold 1 reconstruct the results without 2 of them, but not 3
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/jobs/job1/cheaters", "alice-token",
+		manager.CheatersReport{Nodes: []string{"a", "b", "c"}}).StatusCode)
+	assert.Equal(t, http.StatusNoContent, send("POST", "/jobs/job1/cheaters", "alice-token", report).StatusCode)
+	assert.Equal(t, http.StatusNoContent, send("POST", "/jobs/job1/cheaters", "alice-token",
+		manager.CheatersReport{Nodes: []string{"c", "c"}}).StatusCode)
+
+	response := send("GET", "/jobs/job1", "alice-token", nil)
+	b, err := ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	var stored manager.Job
+	assert.NoError(t, json.Unmarshal(b, &stored))
+	assert.Equal(t, []string{"c"}, stored.Cheaters)
+
+	response = send("GET", "/history", "alice-token", nil)
+	b, err = ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	var history []manager.HistoryEntry
+	assert.NoError(t, json.Unmarshal(b, &history))
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, []string{"c"}, history[0].Cheaters)
+}
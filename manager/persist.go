@@ -0,0 +1,204 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/krakenh2020/MPCService/data_provider"
+	"github.com/krakenh2020/MPCService/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// Buckets of the manager's store.
+const (
+	bucketDatasets = "datasets"
+	bucketJobs     = "jobs"
+	bucketHistory  = "history"
+)
+
+// db keeps the link datasets, the jobs and the history of computations across restarts;
+// if it is nil, the state of the manager is kept only in memory.
+var db *store.Store
+
+// HistoryEntry records a finished computation. Unlike jobs, the entries do not expire
+// and do not hold the results.
+type HistoryEntry struct {
+	JobId     string    `json:"job_id"`
+	Requester string    `json:"requester"`
+	Program   string    `json:"program"`
+	Datasets  string    `json:"datasets"`
+	Nodes     []string  `json:"nodes"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	Cheaters  []string  `json:"cheaters,omitempty"`
+	Created   time.Time `json:"created"`
+	Finished  time.Time `json:"finished"`
+}
+
+// openStore opens the store in the folder and loads the link datasets and the jobs saved
+// in it. Jobs that were not finished when the manager stopped are marked as failed.
+func openStore(dir string) error {
+	var err error
+	db, err = store.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	numDatasets := 0
+	err = db.ForEach(bucketDatasets, func(key string, val []byte) error {
+		var dataset data_provider.Dataset
+		err := json.Unmarshal(val, &dataset)
+		if err != nil {
+			return err
+		}
+		if datasets.add(dataset, nil) {
+			numDatasets++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	err = db.ForEach(bucketJobs, func(key string, val []byte) error {
+		job := &Job{}
+		err := json.Unmarshal(val, job)
+		if err != nil {
+			return err
+		}
+		jobs.list[job.Id] = job
+		if job.State != JobDone && job.State != JobFailed {
+			job.QueuePosition = 0
+			job.finishLocked(nil, "interrupted by a restart of the manager")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Manager: loaded ", numDatasets, " datasets and ", len(jobs.list), " jobs from ", dir)
+
+	return nil
+}
+
+// saveDataset stores a link dataset.
+func saveDataset(dataset data_provider.Dataset) {
+	if db == nil {
+		return
+	}
+	err := db.Put(bucketDatasets, dataset.Name, dataset)
+	if err != nil {
+		log.Error("Manager: saving dataset ", dataset.Name, " failed: ", err)
+	}
+}
+
+// deleteDataset removes a link dataset from the store.
+func deleteDataset(name string) {
+	if db == nil {
+		return
+	}
+	err := db.Delete(bucketDatasets, name)
+	if err != nil {
+		log.Error("Manager: deleting dataset ", name, " failed: ", err)
+	}
+}
+
+// saveJob stores the job and, if it is finished, its history entry; it is called with
+// jobs.mu held.
+func saveJob(job *Job) {
+	if db == nil {
+		return
+	}
+	err := db.Put(bucketJobs, job.Id, job)
+	if err != nil {
+		log.Error("Manager: saving job ", job.Id, " failed: ", err)
+	}
+	if job.State != JobDone && job.State != JobFailed {
+		return
+	}
+
+	entry := HistoryEntry{JobId: job.Id, Requester: job.Requester, Program: job.Program, Datasets: job.Datasets,
+		State: job.State, Error: job.Error, Cheaters: job.Cheaters, Created: job.Created, Finished: job.Updated}
+	for _, e := range job.Nodes {
+		entry.Nodes = append(entry.Nodes, e.Name)
+	}
+	err = db.Put(bucketHistory, job.Id, entry)
+	if err != nil {
+		log.Error("Manager: saving history of job ", job.Id, " failed: ", err)
+	}
+}
+
+// deleteJob removes an expired job from the store, keeping its history entry.
+func deleteJob(id string) {
+	if db == nil {
+		return
+	}
+	err := db.Delete(bucketJobs, id)
+	if err != nil {
+		log.Error("Manager: deleting job ", id, " failed: ", err)
+	}
+}
+
+// getHistoryHandler returns the finished computations of the requester.
+func getHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	history := make([]HistoryEntry, 0)
+	if db != nil {
+		requester := requesterFrom(r).Name
+		err := db.ForEach(bucketHistory, func(key string, val []byte) error {
+			var entry HistoryEntry
+			err := json.Unmarshal(val, &entry)
+			if err == nil && entry.Requester == requester {
+				history = append(history, entry)
+			}
+			return err
+		})
+		if err != nil {
+			log.Error("Manager: reading history failed: ", err)
+			writeError(w, http.StatusInternalServerError, "cannot read history")
+			return
+		}
+		sort.Slice(history, func(i, j int) bool { return history[i].Created.Before(history[j].Created) })
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// ExportState writes the state saved in the manager's store folder to a file, so that
+// it can be backed up or moved to another manager.
+func ExportState(dir, file string) error {
+	s, err := store.Open(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = s.Export(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// ImportState reads a file written by ExportState into the manager's store folder; the
+// manager using the folder should not be running.
+func ImportState(dir, file string) error {
+	s, err := store.Open(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.Import(f)
+}
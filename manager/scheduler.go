@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"sync"
+)
+
+// scheduledJob is a job waiting for its MPC nodes to become free.
+type scheduledJob struct {
+	job   *Job
+	nodes []string
+	ready chan struct{}
+}
+
+// Scheduler runs computations on disjoint sets of nodes in parallel. Each node processes
+// one computation at a time; the requests for a busy node wait in the order they came.
+type Scheduler struct {
+	mu    sync.Mutex
+	busy  map[string]string // node name -> id of the job using it
+	queue []*scheduledJob
+}
+
+var scheduler = Scheduler{busy: make(map[string]string)}
+
+// acquire blocks until all the nodes are free and reserved for the job.
+func (s *Scheduler) acquire(job *Job, nodes []string) {
+	sj := &scheduledJob{job: job, nodes: nodes, ready: make(chan struct{})}
+
+	s.mu.Lock()
+	s.queue = append(s.queue, sj)
+	s.dispatch()
+	s.mu.Unlock()
+
+	<-sj.ready
+}
+
+// release frees the nodes reserved by the job and starts the waiting jobs that can run.
+func (s *Scheduler) release(job *Job, nodes []string) {
+	s.mu.Lock()
+	for _, name := range nodes {
+		if s.busy[name] == job.Id {
+			delete(s.busy, name)
+		}
+	}
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// dispatch starts the queued jobs whose nodes are free and not claimed by a job earlier
+// in the queue, and updates the queue positions of the others. It must be called with
+// s.mu held.
+func (s *Scheduler) dispatch() {
+	claimed := make(map[string]bool)
+	waiting := s.queue[:0]
+	for _, sj := range s.queue {
+		free := true
+		for _, name := range sj.nodes {
+			if _, ok := s.busy[name]; ok || claimed[name] {
+				free = false
+				break
+			}
+		}
+
+		if free {
+			for _, name := range sj.nodes {
+				s.busy[name] = sj.job.Id
+			}
+			sj.job.setQueuePosition(0)
+			close(sj.ready)
+			continue
+		}
+
+		// the position counts the waiting jobs ahead of this one competing for its nodes
+		position := 1
+		for _, other := range waiting {
+			if sharesNode(other.nodes, sj.nodes) {
+				position++
+			}
+		}
+		sj.job.setQueuePosition(position)
+		for _, name := range sj.nodes {
+			claimed[name] = true
+		}
+		waiting = append(waiting, sj)
+	}
+	for i := len(waiting); i < len(s.queue); i++ {
+		s.queue[i] = nil
+	}
+	s.queue = waiting
+}
+
+func sharesNode(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scheduledAcquire acquires the nodes for the job in the background and returns a channel
+// closed once they are acquired. It returns once the job is scheduled.
+func scheduledAcquire(t *testing.T, s *Scheduler, job *Job, nodes ...string) <-chan struct{} {
+	s.mu.Lock()
+	queued := len(s.queue)
+	busy := len(s.busy)
+	s.mu.Unlock()
+
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire(job, nodes)
+		close(acquired)
+	}()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		scheduled := len(s.queue) > queued || len(s.busy) > busy
+		s.mu.Unlock()
+		if scheduled {
+			return acquired
+		}
+	}
+	t.Fatal("job ", job.Id, " not scheduled")
+	return nil
+}
+
+func isAcquired(acquired <-chan struct{}) bool {
+	select {
+	case <-acquired:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func queuePosition(job *Job) int {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	return job.QueuePosition
+}
+
+func TestSchedulerDisjointNodes(t *testing.T) {
+	s := &Scheduler{busy: make(map[string]string)}
+	j1, j2 := &Job{Id: "j1"}, &Job{Id: "j2"}
+
+	assert.True(t, isAcquired(scheduledAcquire(t, s, j1, "A", "B")))
+	assert.True(t, isAcquired(scheduledAcquire(t, s, j2, "C")))
+	assert.Equal(t, map[string]string{"A": "j1", "B": "j1", "C": "j2"}, s.busy)
+	assert.Equal(t, 0, queuePosition(j1))
+	assert.Equal(t, 0, queuePosition(j2))
+
+	s.release(j1, []string{"A", "B"})
+	s.release(j2, []string{"C"})
+	assert.Empty(t, s.busy)
+	assert.Empty(t, s.queue)
+}
+
+func TestSchedulerQueue(t *testing.T) {
+	s := &Scheduler{busy: make(map[string]string)}
+	j1, j2, j3, j4, j5 := &Job{Id: "j1"}, &Job{Id: "j2"}, &Job{Id: "j3"}, &Job{Id: "j4"}, &Job{Id: "j5"}
+
+	a1 := scheduledAcquire(t, s, j1, "A", "B")
+	assert.True(t, isAcquired(a1))
+	// j2 waits for B, j3 for C claimed by j2, j4 for D claimed by j3
+	a2 := scheduledAcquire(t, s, j2, "B", "C")
+	a3 := scheduledAcquire(t, s, j3, "C", "D")
+	a4 := scheduledAcquire(t, s, j4, "D")
+	// a job on free nodes is not held up by the queue
+	a5 := scheduledAcquire(t, s, j5, "E")
+	assert.True(t, isAcquired(a5))
+	assert.False(t, isAcquired(a2))
+	assert.False(t, isAcquired(a3))
+	assert.False(t, isAcquired(a4))
+	assert.Equal(t, 1, queuePosition(j2))
+	assert.Equal(t, 2, queuePosition(j3))
+	assert.Equal(t, 2, queuePosition(j4))
+
+	// releasing the nodes of another job does not free them
+	s.release(j2, []string{"A", "B"})
+	assert.False(t, isAcquired(a2))
+	assert.Equal(t, "j1", s.busy["B"])
+
+	s.release(j1, []string{"A", "B"})
+	assert.True(t, isAcquired(a2))
+	assert.False(t, isAcquired(a3))
+	assert.Equal(t, 0, queuePosition(j2))
+	assert.Equal(t, 1, queuePosition(j3))
+	assert.Equal(t, 2, queuePosition(j4))
+
+	s.release(j2, []string{"B", "C"})
+	assert.True(t, isAcquired(a3))
+	assert.False(t, isAcquired(a4))
+	assert.Equal(t, 1, queuePosition(j4))
+
+	s.release(j3, []string{"C", "D"})
+	assert.True(t, isAcquired(a4))
+	assert.Equal(t, 0, queuePosition(j4))
+	assert.Empty(t, s.queue)
+	assert.Equal(t, map[string]string{"D": "j4", "E": "j5"}, s.busy)
+}
+
+func TestSchedulerOneJobPerNode(t *testing.T) {
+	s := &Scheduler{busy: make(map[string]string)}
+	first := &Job{Id: "first"}
+	assert.True(t, isAcquired(scheduledAcquire(t, s, first, "A")))
+
+	// the jobs on a node run one after the other, in the order they came
+	waiting := make([]<-chan struct{}, 3)
+	queued := make([]*Job, 3)
+	for i := range waiting {
+		queued[i] = &Job{Id: string(rune('a' + i))}
+		waiting[i] = scheduledAcquire(t, s, queued[i], "A")
+		assert.Equal(t, i+1, queuePosition(queued[i]))
+	}
+	prev := first
+	for i := range waiting {
+		assert.False(t, isAcquired(waiting[i]))
+		s.release(prev, []string{"A"})
+		assert.True(t, isAcquired(waiting[i]))
+		assert.Equal(t, map[string]string{"A": queued[i].Id}, s.busy)
+		for k := i + 1; k < len(queued); k++ {
+			assert.Equal(t, k-i, queuePosition(queued[k]))
+		}
+		prev = queued[i]
+	}
+	s.release(prev, []string{"A"})
+	assert.Empty(t, s.busy)
+}
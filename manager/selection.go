@@ -0,0 +1,250 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/mpc_engine"
+)
+
+// defaultNumNodes is the number of MPC nodes evaluating a computation if the request
+// does not give it.
+const defaultNumNodes = 3
+
+// defaultSharing is supported by the nodes that do not advertise their protocols; it is
+// the set up of SCALE-MAMBA used by the MPC node's Dockerfile.
+var defaultSharing = data_management.Sharing{Protocol: data_management.ProtocolShamir, Parties: 3, Threshold: 1}
+
+// supports returns true if the node can evaluate the protocol with the number of parties
+// and the threshold of the sharing.
+func (node MPCNode) supports(sharing data_management.Sharing) bool {
+	protocols := node.Protocols
+	if len(protocols) == 0 {
+		protocols = []data_management.Sharing{defaultSharing}
+	}
+	for _, e := range protocols {
+		if e == sharing {
+			return true
+		}
+	}
+	return false
+}
+
+// backend returns the MPC backend of the node.
+func (node MPCNode) backend() string {
+	if node.Backend == "" {
+		return mpc_engine.DefaultBackend
+	}
+	return node.Backend
+}
+
+// connectedBackends returns the backends of the connected nodes, mpc_engine.DefaultBackend
+// first and the others sorted.
+func connectedBackends() []string {
+	mpcNodes.mu.Lock()
+	seen := make(map[string]bool)
+	for _, node := range mpcNodes.list {
+		seen[node.backend()] = true
+	}
+	mpcNodes.mu.Unlock()
+	backends := []string{mpc_engine.DefaultBackend}
+	others := make([]string, 0, len(seen))
+	for name := range seen {
+		if name != mpc_engine.DefaultBackend {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(backends, others...)
+}
+
+// splitNames splits a comma separated list of names, ignoring empty entries.
+func splitNames(list string) []string {
+	names := make([]string, 0)
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			names = append(names, e)
+		}
+	}
+	return names
+}
+
+// uniqueNames returns the names without repetitions, in the order of their first occurrence.
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(names))
+	for _, e := range names {
+		if !seen[e] {
+			seen[e] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// load returns the number of jobs running or waiting on the node.
+func (s *Scheduler) load(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	if _, ok := s.busy[name]; ok {
+		count++
+	}
+	for _, sj := range s.queue {
+		for _, e := range sj.nodes {
+			if e == name {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// selectNodesAnyBackend chooses MPC nodes like selectNodes; if backend is empty, the nodes
+// run the first of connectedBackends for which a valid choice exists. It also returns the
+// backend of the chosen nodes.
+func selectNodesAnyBackend(datasetNames, required, excluded []string, sharing data_management.Sharing,
+	backend string) ([]string, string, string, error) {
+	if backend != "" {
+		chosen, explanation, err := selectNodes(datasetNames, required, excluded, sharing, backend)
+		return chosen, backend, explanation, err
+	}
+	var firstErr error
+	for _, backend := range connectedBackends() {
+		chosen, explanation, err := selectNodes(datasetNames, required, excluded, sharing, backend)
+		if err == nil {
+			return chosen, backend, explanation, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, "", "", firstErr
+}
+
+// selectNodes chooses MPC nodes for a computation with the sharing on the given datasets, as
+// many as the parties of the sharing: the nodes must be connected, run the backend and
+// support the sharing, every dataset must be shared with them, the requester's required
+// nodes are always chosen, once even if they are repeated, and excluded nodes never. Idle
+// nodes are preferred. It returns the chosen nodes and an explanation of the choice, or an
+// error explaining why no valid choice exists.
+func selectNodes(datasetNames, required, excluded []string, sharing data_management.Sharing,
+	backend string) ([]string, string, error) {
+	numNodes := sharing.Parties
+	required = uniqueNames(required)
+	mpcNodes.mu.Lock()
+	candidates := make(map[string]bool)
+	unsupported := make(map[string]bool)
+	for _, node := range mpcNodes.list {
+		if node.backend() == backend && node.supports(sharing) {
+			candidates[node.Name] = true
+		} else {
+			unsupported[node.Name] = true
+		}
+	}
+	numConnected := len(mpcNodes.list)
+	mpcNodes.mu.Unlock()
+	if numConnected < numNodes {
+		return nil, "", fmt.Errorf("only %d MPC nodes are connected, %d are needed", numConnected, numNodes)
+	}
+	if len(candidates) < numNodes {
+		return nil, "", fmt.Errorf("only %d connected MPC nodes support %s with backend %s, %d are needed",
+			len(candidates), sharing, backend, numNodes)
+	}
+
+	for _, name := range excluded {
+		delete(candidates, name)
+	}
+
+	for _, dataName := range datasetNames {
+		dataset, _, ok := datasets.get(dataName)
+		if !ok {
+			return nil, "", fmt.Errorf("dataset %s not found", dataName)
+		}
+		if dataset.SharedWith == "" || dataset.SharedWith == "all" {
+			continue
+		}
+		sharedWith := make(map[string]bool)
+		for _, e := range splitNames(dataset.SharedWith) {
+			sharedWith[e] = true
+		}
+		for name := range candidates {
+			if !sharedWith[name] {
+				delete(candidates, name)
+			}
+		}
+		for _, name := range required {
+			if !sharedWith[name] {
+				return nil, "", fmt.Errorf("required node %s: dataset %s is not shared with it", name, dataName)
+			}
+		}
+	}
+
+	for _, name := range required {
+		if unsupported[name] {
+			return nil, "", fmt.Errorf("required node %s does not support %s with backend %s", name, sharing,
+				backend)
+		}
+		if !candidates[name] {
+			return nil, "", fmt.Errorf("required node %s is not connected or is excluded", name)
+		}
+	}
+	if len(required) > numNodes {
+		return nil, "", fmt.Errorf("%d nodes required, but a computation uses %d", len(required), numNodes)
+	}
+	if len(candidates) < numNodes {
+		names := make([]string, 0, len(candidates))
+		for name := range candidates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, "", fmt.Errorf("only %d connected nodes (%s) support %s with backend %s and are allowed "+
+			"by all the datasets and the requester's constraints, %d are needed", len(names),
+			strings.Join(names, ","), sharing, backend, numNodes)
+	}
+
+	// the required nodes come first, then the least loaded ones
+	loads := make(map[string]int)
+	others := make([]string, 0, len(candidates))
+	isRequired := make(map[string]bool)
+	for _, name := range required {
+		isRequired[name] = true
+	}
+	for name := range candidates {
+		loads[name] = scheduler.load(name)
+		if !isRequired[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if loads[others[i]] != loads[others[j]] {
+			return loads[others[i]] < loads[others[j]]
+		}
+		return others[i] < others[j]
+	})
+	chosen := append(append([]string{}, required...), others[:numNodes-len(required)]...)
+
+	reasons := make([]string, len(chosen))
+	for i, name := range chosen {
+		reason := name + " "
+		if isRequired[name] {
+			reason += "required by the requester, "
+		}
+		if loads[name] == 0 {
+			reason += "idle"
+		} else {
+			reason += "busy with " + strconv.Itoa(loads[name]) + " job(s)"
+		}
+		reasons[i] = reason
+	}
+	explanation := "chosen among " + strconv.Itoa(len(candidates)) + " connected nodes supporting " +
+		sharing.String() + " with backend " + backend + " and allowed by all the datasets and the requester's " +
+		"constraints: " + strings.Join(reasons, "; ")
+
+	return chosen, explanation, nil
+}
@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/data_provider"
+	"github.com/stretchr/testify/assert"
+)
+
+// resetSelection removes the connected nodes, the datasets and the jobs of the scheduler.
+func resetSelection() {
+	mpcNodes.mu.Lock()
+	mpcNodes.list, mpcNodes.conns, mpcNodes.nameToIndex = make([]MPCNode, 0), nil, make(map[string]int)
+	mpcNodes.mu.Unlock()
+	datasets.mu.Lock()
+	datasets.list, datasets.conns, datasets.nameToIndex = make([]data_provider.Dataset, 0), nil,
+		make(map[string]int)
+	datasets.mu.Unlock()
+	scheduler.mu.Lock()
+	scheduler.busy, scheduler.queue = make(map[string]string), nil
+	scheduler.mu.Unlock()
+}
+
+// setUpSelection replaces the connected nodes and the datasets of the manager for the test.
+func setUpSelection(t *testing.T, nodes []MPCNode, list []data_provider.Dataset) {
+	resetSelection()
+	t.Cleanup(resetSelection)
+
+	mpcNodes.mu.Lock()
+	for i, node := range nodes {
+		mpcNodes.list = append(mpcNodes.list, node)
+		mpcNodes.conns = append(mpcNodes.conns, nil)
+		mpcNodes.nameToIndex[node.Name] = i
+	}
+	mpcNodes.mu.Unlock()
+	for _, dataset := range list {
+		datasets.add(dataset, nil)
+	}
+}
+
+func TestSelectNodes(t *testing.T) {
+	shamir5 := data_management.Sharing{Protocol: data_management.ProtocolShamir, Parties: 5, Threshold: 2}
+	nodes := []MPCNode{{Name: "A"}, {Name: "B"}, {Name: "C"}, {Name: "D"},
+		{Name: "E", Protocols: []data_management.Sharing{shamir5}},
+		{Name: "G", Backend: "other"}}
+	setUpSelection(t, nodes, []data_provider.Dataset{
+		{Name: "all", SharedWith: "all"},
+		{Name: "abc", SharedWith: "A,B,C"},
+		{Name: "ab", SharedWith: "A, B"},
+	})
+	scheduler.busy["A"] = "job"
+
+	for _, test := range []struct {
+		name     string
+		datasets []string
+		required []string
+		excluded []string
+		sharing  data_management.Sharing
+		backend  string
+		chosen   []string
+		err      string
+	}{
+		{name: "idle nodes preferred", datasets: []string{"all"}, chosen: []string{"B", "C", "D"}},
+		{name: "required first", datasets: []string{"all"}, required: []string{"D", "A"},
+			chosen: []string{"D", "A", "B"}},
+		{name: "repeated required node chosen once", datasets: []string{"all"}, required: []string{"D", "D"},
+			chosen: []string{"D", "B", "C"}},
+		{name: "excluded", datasets: []string{"all"}, excluded: []string{"B", "C"},
+			err: "only 2 connected nodes (A,D) support shamir:3:1 with backend " +
+				"scale-mamba and are allowed by all the datasets and the requester's constraints, 3 are needed"},
+		{name: "shared datasets", datasets: []string{"all", "abc"}, chosen: []string{"B", "C", "A"}},
+		{name: "required not shared", datasets: []string{"abc"}, required: []string{"D"},
+			err: "required node D: dataset abc is not shared with it"},
+		{name: "too few shared", datasets: []string{"abc", "ab"},
+			err: "only 2 connected nodes (A,B) support shamir:3:1 with backend scale-mamba and are allowed " +
+				"by all the datasets and the requester's constraints, 3 are needed"},
+		{name: "required excluded", datasets: []string{"all"}, required: []string{"B"}, excluded: []string{"B"},
+			err: "required node B is not connected or is excluded"},
+		{name: "required not connected", datasets: []string{"all"}, required: []string{"Z"},
+			err: "required node Z is not connected or is excluded"},
+		{name: "required unsupported", datasets: []string{"all"}, required: []string{"E"},
+			err: "required node E does not support shamir:3:1 with backend scale-mamba"},
+		{name: "too many required", datasets: []string{"all"}, required: []string{"A", "B", "C", "D"},
+			err: "4 nodes required, but a computation uses 3"},
+		{name: "unknown dataset", datasets: []string{"none"}, err: "dataset none not found"},
+		{name: "unsupported sharing", datasets: []string{"all"}, sharing: shamir5,
+			err: "only 1 connected MPC nodes support shamir:5:2 with backend scale-mamba, 5 are needed"},
+		{name: "other backend", datasets: []string{"all"}, backend: "other",
+			err: "only 1 connected MPC nodes support shamir:3:1 with backend other, 3 are needed"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sharing := test.sharing
+			if sharing.Parties == 0 {
+				sharing = defaultSharing
+			}
+			backend := test.backend
+			if backend == "" {
+				backend = "scale-mamba"
+			}
+			chosen, explanation, err := selectNodes(test.datasets, test.required, test.excluded, sharing, backend)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.chosen, chosen)
+			assert.Contains(t, explanation, "chosen among")
+		})
+	}
+}
+
+func TestSelectNodesAnyBackend(t *testing.T) {
+	setUpSelection(t, []MPCNode{{Name: "A"}, {Name: "B"}, {Name: "X", Backend: "other"},
+		{Name: "Y", Backend: "other"}, {Name: "Z", Backend: "other"}},
+		[]data_provider.Dataset{{Name: "all", SharedWith: "all"}})
+
+	chosen, backend, _, err := selectNodesAnyBackend([]string{"all"}, nil, nil, defaultSharing, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", backend)
+	assert.Equal(t, []string{"X", "Y", "Z"}, chosen)
+
+	_, _, _, err = selectNodesAnyBackend([]string{"all"}, []string{"A"}, nil, defaultSharing, "")
+	assert.EqualError(t, err, "only 2 connected MPC nodes support shamir:3:1 with backend scale-mamba, 3 are needed")
+}
+
+func TestUniqueNames(t *testing.T) {
+	assert.Equal(t, []string{"A", "B"}, uniqueNames(splitNames("A, B,A,,B")))
+	assert.Equal(t, []string{}, uniqueNames(splitNames("")))
+}
+
+func TestValidateRequiredExcluded(t *testing.T) {
+	setUpSelection(t, nil, []data_provider.Dataset{{Name: "all", SharedWith: "all"}})
+	req := ComputationRequest{Program: "avg", DatasetNames: "all",
+		ReceiverPubKey: base64.StdEncoding.EncodeToString(make([]byte, pubKeyLen)),
+		RequireNodes:   "A,B", ExcludeNodes: "C, B"}
+
+	reqErr := validateRequest(req)
+	if assert.NotNil(t, reqErr) {
+		assert.Equal(t, http.StatusBadRequest, reqErr.Status)
+		assert.Equal(t, "node B is both required and excluded", reqErr.Message)
+	}
+
+	req.ExcludeNodes = "C"
+	reqErr = validateRequest(req)
+	if reqErr != nil {
+		assert.NotContains(t, reqErr.Message, "required and excluded")
+	}
+}
@@ -0,0 +1,304 @@
+package manager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/krakenh2020/MPCService/computation"
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/data_provider"
+)
+
+// pubKeyLen is the length of the receiver's public key of the box encryption.
+const pubKeyLen = 32
+
+// requestError is an error of a request to the REST API together with the HTTP status
+// that is returned for it.
+type requestError struct {
+	Status  int
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, a ...interface{}) *requestError {
+	return &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, a...)}
+}
+
+func unprocessable(format string, a ...interface{}) *requestError {
+	return &requestError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf(format, a...)}
+}
+
+// writeError writes the error as a JSON body {"error": "..."} with the given status.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// validateRequest checks the fields of a computation request that do not depend on the
+// chosen nodes: the datasets, the program with its parameters, the receiver's key and the
+// required and excluded nodes.
+// Malformed fields give a 400 error, well formed but unusable ones a 422 error.
+func validateRequest(req ComputationRequest) *requestError {
+	if req.Program == "" {
+		return badRequest("Program is missing")
+	}
+	params := map[string]string{}
+	if req.Params != "" {
+		err := json.Unmarshal([]byte(req.Params), &params)
+		if err != nil {
+			return badRequest("Params is not a JSON object of strings: %v", err)
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(req.ReceiverPubKey)
+	if err != nil || len(key) != pubKeyLen {
+		return badRequest("ReceiverPubKey is not a base64 encoded %d byte key", pubKeyLen)
+	}
+
+	excluded := make(map[string]bool)
+	for _, name := range splitNames(req.ExcludeNodes) {
+		excluded[name] = true
+	}
+	for _, name := range splitNames(req.RequireNodes) {
+		if excluded[name] {
+			return badRequest("node %s is both required and excluded", name)
+		}
+	}
+
+	dataNames := splitNames(req.DatasetNames)
+	if len(dataNames) == 0 {
+		return badRequest("DatasetNames is missing")
+	}
+	list := make([]data_provider.Dataset, len(dataNames))
+	seen := make(map[string]bool)
+	for i, name := range dataNames {
+		if seen[name] {
+			return badRequest("dataset %s listed more than once", name)
+		}
+		seen[name] = true
+		dataset, _, ok := datasets.get(name)
+		if !ok {
+			return unprocessable("dataset %s not found", name)
+		}
+		list[i] = dataset
+	}
+
+	// the columns to compute on must be in all the datasets; without the cols parameter
+	// all the datasets must have the same columns
+	cols, selected := params["cols"]
+	delete(params, "cols")
+	for _, dataset := range list {
+		if dataset.Cols == "" {
+			continue
+		}
+		if selected {
+			have := make(map[string]bool)
+			for _, c := range splitNames(dataset.Cols) {
+				have[c] = true
+			}
+			for _, c := range splitNames(cols) {
+				if !have[c] {
+					return unprocessable("dataset %s has no column %s", dataset.Name, c)
+				}
+			}
+		} else if !sameNames(splitNames(dataset.Cols), splitNames(list[0].Cols)) {
+			return unprocessable("datasets %s and %s have different columns, select the common ones "+
+				"with the cols parameter", list[0].Name, dataset.Name)
+		}
+	}
+	if selected && len(splitNames(cols)) == 0 {
+		return badRequest("the cols parameter lists no columns")
+	}
+
+	err = computation.CheckProgram(req.Program, params)
+	if err != nil {
+		return unprocessable("program %s: %v", req.Program, err)
+	}
+
+	return nil
+}
+
+// sharingOf returns the protocol, the number of nodes and the threshold of the
+// computation: Protocol, the number of given nodes or NumNodes and Threshold. If they are
+// not set, those of the share files of the datasets given by a link are used, otherwise
+// Shamir sharing among 3 nodes with the largest threshold the protocol allows. The shares of
+// the datasets given by a link must be made with the sharing of the computation.
+func sharingOf(req ComputationRequest) (data_management.Sharing, *requestError) {
+	s := data_management.Sharing{Protocol: req.Protocol, Parties: req.NumNodes, Threshold: req.Threshold}
+	linked := linkSharings(req)
+	if len(linked) > 0 {
+		if s.Protocol == "" {
+			s.Protocol = linked[0].sharing.Protocol
+		}
+		if s.Parties == 0 && len(splitNames(req.NodesNames)) == 0 {
+			s.Parties = linked[0].sharing.Parties
+		}
+	}
+	if s.Protocol == "" {
+		s.Protocol = data_management.ProtocolShamir
+	}
+	if names := splitNames(req.NodesNames); len(names) > 0 {
+		if s.Parties != 0 && s.Parties != len(names) {
+			return s, badRequest("NumNodes is %d, but %d nodes are given", s.Parties, len(names))
+		}
+		s.Parties = len(names)
+	}
+	if s.Parties == 0 {
+		s.Parties = defaultNumNodes
+	}
+	if s.Parties < 3 {
+		return s, badRequest("%d nodes given, a computation uses at least 3", s.Parties)
+	}
+
+	if s.Threshold == 0 && len(linked) > 0 && s.Protocol == linked[0].sharing.Protocol &&
+		s.Parties == linked[0].sharing.Parties {
+		s.Threshold = linked[0].sharing.Threshold
+	}
+	if s.Threshold == 0 {
+		s.Threshold = data_management.ProtocolThreshold(s.Protocol, s.Parties)
+	}
+	err := s.Check()
+	if err != nil {
+		return s, badRequest("%v", err)
+	}
+
+	for _, e := range linked {
+		if e.sharing.Protocol != s.Protocol {
+			return s, unprocessable("dataset %s is given by a link to %s shares and cannot be used "+
+				"with protocol %s", e.name, e.sharing.Protocol, s.Protocol)
+		}
+		if (e.sharing.Parties != 0 && e.sharing.Parties != s.Parties) ||
+			(e.sharing.Threshold != 0 && e.sharing.Threshold != s.Threshold) {
+			return s, unprocessable("dataset %s is given by a link to shares of sharing %s, the computation "+
+				"uses %s", e.name, e.sharing, s)
+		}
+	}
+
+	return s, nil
+}
+
+// linkSharing is the sharing of the share file of a dataset given by a link.
+type linkSharing struct {
+	name    string
+	sharing data_management.Sharing
+}
+
+// linkSharings returns the sharings of the datasets of the request given by a link, Shamir
+// sharing with unknown parties and threshold if a dataset does not give it.
+func linkSharings(req ComputationRequest) []linkSharing {
+	var linked []linkSharing
+	for _, name := range splitNames(req.DatasetNames) {
+		dataset, conn, ok := datasets.get(name)
+		if !ok || conn != nil {
+			continue
+		}
+		e := linkSharing{name: dataset.Name, sharing: data_management.Sharing{Protocol: data_management.ProtocolShamir}}
+		if dataset.Sharing != nil {
+			e.sharing = *dataset.Sharing
+		}
+		linked = append(linked, e)
+	}
+	return linked
+}
+
+// fixedPointOf returns the fixed point representation of the values of the computation:
+// FixedPoint if it is set, otherwise the one of the datasets given by a link or the default
+// one. The shares of the datasets given by a link must use the same representation.
+func fixedPointOf(req ComputationRequest) (data_management.FixedPoint, *requestError) {
+	fp := req.FixedPoint
+	if fp != (data_management.FixedPoint{}) {
+		err := fp.Check()
+		if err != nil {
+			return fp, badRequest("%v", err)
+		}
+	}
+
+	source := "" // the dataset whose representation is used if FixedPoint is not set
+	for _, name := range splitNames(req.DatasetNames) {
+		dataset, conn, ok := datasets.get(name)
+		if !ok || conn != nil {
+			continue
+		}
+		datasetFp := data_management.DefaultFixedPoint
+		if dataset.FixedPoint != nil {
+			datasetFp = *dataset.FixedPoint
+		}
+		switch {
+		case fp == (data_management.FixedPoint{}):
+			fp, source = datasetFp, dataset.Name
+		case fp != datasetFp && source == "":
+			return fp, unprocessable("dataset %s is shared with fixed point parameters k=%d, f=%d",
+				dataset.Name, datasetFp.K, datasetFp.F)
+		case fp != datasetFp:
+			return fp, unprocessable("datasets %s and %s are shared with different fixed point parameters",
+				source, dataset.Name)
+		}
+	}
+
+	return fp.OrDefault(), nil
+}
+
+// validateNodes checks that the chosen nodes are distinct connected nodes running the backend
+// and supporting the sharing, as many as its parties, with which all the datasets are shared.
+func validateNodes(nodesNames []string, datasetNames []string, sharing data_management.Sharing,
+	backend string) *requestError {
+	if len(nodesNames) != sharing.Parties {
+		return badRequest("%d nodes given, the computation uses %d", len(nodesNames), sharing.Parties)
+	}
+	seen := make(map[string]bool)
+	for _, name := range nodesNames {
+		if seen[name] {
+			return badRequest("node %s listed more than once", name)
+		}
+		seen[name] = true
+	}
+	for _, name := range nodesNames {
+		node, _, ok := mpcNodes.get(name)
+		if !ok {
+			return unprocessable("node %s not connected", name)
+		}
+		if node.backend() != backend {
+			return unprocessable("node %s runs backend %s, not %s", name, node.backend(), backend)
+		}
+		if !node.supports(sharing) {
+			return unprocessable("node %s does not support %s", name, sharing)
+		}
+	}
+
+	for _, dataName := range datasetNames {
+		dataset, _, ok := datasets.get(dataName)
+		if !ok {
+			return unprocessable("dataset %s not found", dataName)
+		}
+		if dataset.SharedWith == "" || dataset.SharedWith == "all" {
+			continue
+		}
+		sharedWith := splitNames(dataset.SharedWith)
+		for _, name := range nodesNames {
+			if !contains(sharedWith, name) {
+				return unprocessable("dataset %s is not shared with node %s", dataName, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameNames returns true if the lists contain the same names in the same order.
+func sameNames(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+func contains(list []string, val string) bool {
+	for _, e := range list {
+		if e == val {
+			return true
+		}
+	}
+	return false
+}
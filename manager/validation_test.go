@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/data_provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharingOf(t *testing.T) {
+	replicated := data_management.Sharing{Protocol: data_management.ProtocolReplicated, Parties: 3, Threshold: 1}
+	shamir5 := data_management.Sharing{Protocol: data_management.ProtocolShamir, Parties: 5, Threshold: 2}
+	setUpSelection(t, nil, []data_provider.Dataset{
+		{Name: "shamir.csv", Link: "https://example.com/shamir.shares"},
+		{Name: "replicated.csv", Link: "https://example.com/replicated.shares", Sharing: &replicated},
+		{Name: "shamir5.csv", Link: "https://example.com/shamir5.shares", Sharing: &shamir5},
+	})
+	datasets.add(data_provider.Dataset{Name: "provided.csv"}, &peerConn{})
+
+	for _, test := range []struct {
+		name    string
+		req     ComputationRequest
+		sharing data_management.Sharing
+		status  int
+	}{
+		{name: "default", req: ComputationRequest{DatasetNames: "provided.csv"},
+			sharing: data_management.Sharing{Protocol: data_management.ProtocolShamir, Parties: 3, Threshold: 1}},
+		{name: "requested", req: ComputationRequest{DatasetNames: "provided.csv", Protocol: "replicated"},
+			sharing: replicated},
+		{name: "link without sharing", req: ComputationRequest{DatasetNames: "shamir.csv", NumNodes: 5},
+			sharing: shamir5},
+		{name: "sharing of the link", req: ComputationRequest{DatasetNames: "replicated.csv,provided.csv"},
+			sharing: replicated},
+		{name: "parties of the link", req: ComputationRequest{DatasetNames: "shamir5.csv"}, sharing: shamir5},
+		{name: "same sharing", req: ComputationRequest{DatasetNames: "shamir5.csv", Protocol: "shamir",
+			NodesNames: "a,b,c,d,e", Threshold: 2}, sharing: shamir5},
+		{name: "other protocol", req: ComputationRequest{DatasetNames: "shamir.csv", Protocol: "replicated"},
+			status: http.StatusUnprocessableEntity},
+		{name: "other protocol of the link", req: ComputationRequest{DatasetNames: "replicated.csv",
+			Protocol: "full-threshold"}, status: http.StatusUnprocessableEntity},
+		{name: "other parties", req: ComputationRequest{DatasetNames: "shamir5.csv", NumNodes: 3},
+			status: http.StatusUnprocessableEntity},
+		{name: "other threshold", req: ComputationRequest{DatasetNames: "shamir5.csv", Threshold: 1},
+			status: http.StatusUnprocessableEntity},
+		{name: "links of different sharings", req: ComputationRequest{DatasetNames: "replicated.csv,shamir.csv"},
+			status: http.StatusUnprocessableEntity},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sharing, reqErr := sharingOf(test.req)
+			if test.status != 0 {
+				if assert.NotNil(t, reqErr) {
+					assert.Equal(t, test.status, reqErr.Status)
+				}
+				return
+			}
+			assert.Nil(t, reqErr)
+			assert.Equal(t, test.sharing, sharing)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package mpc_engine
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/krakenh2020/MPCService/data_management"
+)
+
+// Backend is an MPC engine evaluating the computations of a node together with the
+// backends of the other nodes of the request. For each request, Engine calls SetUp, writes
+// the shares of the inputs to the writer returned by Inputs, then calls Prepare with the
+// parameters of the program, Run and Outputs, which returns the shares of the results of
+// the node, SharesPerValue field elements for each value. CleanUp is called after each
+// request once SetUp was called, whether the computation succeeded or not. The calls for
+// a computation are given requests with the same Id.
+type Backend interface {
+	// Name returns the name of the backend advertised to the manager.
+	Name() string
+	// SetUp prepares the backend for the nodes and the sharing of the request.
+	SetUp(req Request) error
+	// Inputs returns a writer of the inputs of the node.
+	Inputs(req Request) (data_management.InputWriter, error)
+	// Prepare prepares the program of the request with the parameters; it returns an
+	// error ErrProgramNotSupported if the backend cannot evaluate the program.
+	Prepare(req Request, params map[string]string) error
+	// Run evaluates the program with the other nodes; it returns an error
+	// ErrComputationFailed if the computation failed, for example because another node
+	// failed. Its other errors are failures of the node, which are reported for the
+	// request too.
+	Run(req Request) error
+	// Outputs returns the shares of the results of the node.
+	Outputs(req Request) ([]*big.Int, error)
+	// CleanUp removes the data of the computation kept by the backend.
+	CleanUp(req Request) error
+}
+
+// ErrProgramNotSupported is returned by Backend.Prepare for programs that the backend
+// cannot evaluate.
+var ErrProgramNotSupported = fmt.Errorf("function not supported")
+
+// ErrComputationFailed is returned by Backend.Prepare and Backend.Run for failures of a
+// computation after which the backend can serve other requests.
+var ErrComputationFailed = fmt.Errorf("computation failed")
+
+// DefaultBackend is the backend of the nodes that do not choose one.
+const DefaultBackend = "scale-mamba"
+
+// BackendConfig gives a backend the settings of its node: the name of the node, the
+// folder of its certificates and the folder of the backend, for example the location of
+// SCALE-MAMBA.
+type BackendConfig struct {
+	Name     string
+	CertLoc  string
+	Location string
+}
+
+var backendsMu sync.Mutex
+var backends = map[string]func(BackendConfig) (Backend, error){}
+
+// RegisterBackend makes a backend available to NewBackend under the name.
+func RegisterBackend(name string, newBackend func(BackendConfig) (Backend, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = newBackend
+}
+
+// Backends returns the names of the registered backends.
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackend returns the backend with the name, DefaultBackend if it is empty.
+func NewBackend(name string, config BackendConfig) (Backend, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+	backendsMu.Lock()
+	newBackend, ok := backends[name]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("backend %s not supported, use one of %s", name, strings.Join(Backends(), ","))
+	}
+	return newBackend(config)
+}
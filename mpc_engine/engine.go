@@ -1,14 +1,17 @@
 package mpc_engine
 
 import (
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/krakenh2020/MPCService/computation"
 	"github.com/krakenh2020/MPCService/data_management"
 )
 
@@ -17,100 +20,268 @@ import (
 // Request is a struct defining how request to the node servers should
 // be given.
 type Request struct {
-	Program        string
-	InputLinks     []string
-	InputVecs      []string
-	InputCols      [][]string
-	Params         string
-	NodeId         int
-	NodesNames     []string
-	NodesAddrs     []string
-	NodesPorts     string
-	ScaleCerts     [][]byte
-	ReceiverPubKey string // only used outside of engine
+	Id           string `json:"-"` // identifies the computation among those of the node; Engine sets it if empty
+	Requester    string // name of the authenticated requester of the computation
+	Backend      string // MPC backend of the nodes, see Backend; if empty, the one of the node
+	Program      string
+	Datasets     []string // names of the datasets, only used outside of engine
+	InputLinks   []string
+	InputSigners []string // base64 encoded keys signing the share files of InputLinks, empty if not known
+	InputVecs    []string
+	InputCols    [][]string
+	// expected origins of InputLinks and InputVecs and the signatures of InputVecs by their
+	// providers, see data_management.ShareVerifier
+	InputLinkSources []data_management.ShareSource
+	InputVecSources  []data_management.ShareSource
+	InputSignatures  []data_management.ShareSignature
+	Params           string
+	NodeId           int
+	NodesNames       []string
+	Protocol         string                     // MPC protocol, see data_management.Protocols; if empty, Shamir sharing
+	Threshold        int                        // threshold of the sharing among the nodes; if 0, the largest one allowed
+	FixedPoint       data_management.FixedPoint // representation of the values; if not set, the default one
+	NodesAddrs       []string
+	NodesPorts       string
+	ScaleCerts       [][]byte
+	ReceiverPubKey   string        // only used outside of engine
+	Voucher          string        // only used outside of engine
+	Progress         chan string   `json:"-"` // optional, receives the stages of the computation
+	Cancel           chan struct{} `json:"-"` // optional, closed if the computation is cancelled
+}
+
+// Sharing returns the protocol, the number of parties and the threshold of the
+// computation, filling in the defaults.
+func (req Request) Sharing() data_management.Sharing {
+	s := data_management.Sharing{Protocol: req.Protocol, Parties: len(req.NodesNames), Threshold: req.Threshold}
+	if s.Protocol == "" {
+		s.Protocol = data_management.ProtocolShamir
+	}
+	if s.Threshold == 0 {
+		s.Threshold = data_management.ProtocolThreshold(s.Protocol, s.Parties)
+	}
+	return s
+}
+
+// Stages of the computation reported on Request.Progress.
+const (
+	StageFetchingData = "fetching data"
+	StageCompiling    = "compiling"
+	StageRunning      = "running"
+)
+
+// cancelled returns true if the requester cancelled the computation.
+func (req Request) cancelled() bool {
+	select {
+	case <-req.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// report sends the stage of the computation to the requester if it asked for it.
+func (req Request) report(stage string) {
+	if req.Progress == nil {
+		return
+	}
+	select {
+	case req.Progress <- stage:
+	default:
+	}
 }
 
 type Response struct {
-	Vec  []*big.Int
-	Cols []string
-	Msg  string
+	Vec    []*big.Int
+	Cols   []string
+	Inputs []data_management.InputCommitment // commitments of the inputs used
+	Msg    string
 }
 
+// Trust configures the data providers whose shares a node uses, see
+// data_management.ShareVerifier: the shares must be signed with a certificate of the
+// RootCA issued to one of Providers, unless AllowUnsigned is set and the dataset has no
+// provider.
+type Trust struct {
+	Providers     []string
+	AllowUnsigned bool
+}
+
+// verifier returns the verifier of the origin of the inputs of the node.
+func (req Request) verifier(roots *x509.CertPool, trust Trust) *data_management.ShareVerifier {
+	node := ""
+	if req.NodeId < len(req.NodesNames) {
+		node = req.NodesNames[req.NodeId]
+	}
+	return &data_management.ShareVerifier{Roots: roots, Providers: trust.Providers,
+		AllowUnsigned: trust.AllowUnsigned, Node: node, LinkSources: req.InputLinkSources,
+		VecSources: req.InputVecSources, VecSignatures: req.InputSignatures}
+}
+
+// ScaleEngine serves the requests of tasksBacklog with SCALE-MAMBA installed in sm, see
+// Engine and NewScaleBackend.
 func ScaleEngine(sm string, tasksBacklog chan Request, output chan Response,
-	pubKey, secKey []byte, scalePort int, privateCert, certLoc string) {
-	var err error
-	var response Response
-	for {
-		req := <-tasksBacklog
+	pubKey, secKey []byte, scalePort int, privateCert, certLoc string, trust Trust) {
+	Engine(NewScaleBackend(sm, privateCert, certLoc), tasksBacklog, output, pubKey, secKey, scalePort, certLoc,
+		trust)
+}
 
-		// prepare settings of SCALE-MAMBA
-		err = computation.SetUpScale(req.NodeId, req.NodesNames, req.NodesAddrs, sm, req.ScaleCerts, privateCert, certLoc)
-		if err != nil {
-			log.Fatal("Error preparing SCALE: ", err)
-		}
+// Engine serves the requests of tasksBacklog one after the other with the backend and
+// sends their responses to output, until tasksBacklog is closed. The node decrypts its shares with its key pair and
+// evaluates the computations with the other nodes on mpcPort; the inputs must come from
+// the providers trusted by the node, verified with the RootCA in certLoc.
+func Engine(backend Backend, tasksBacklog chan Request, output chan Response,
+	pubKey, secKey []byte, mpcPort int, certLoc string, trust Trust) {
+	// the providers of the inputs are verified against the RootCA
+	caCert, _ := ioutil.ReadFile(certLoc + "/RootCA.crt")
+	roots := x509.NewCertPool()
+	_ = roots.AppendCertsFromPEM(caCert)
+	for req := range tasksBacklog {
+		serve(backend, req, output, roots, trust, pubKey, secKey, mpcPort)
+	}
+}
 
-		// load parameters of the computation
-		var params map[string]string
-		if req.Params != "" {
-			err = json.Unmarshal([]byte(req.Params), &params)
-			if err != nil {
-				e := "error, computation failed, parameters error "
-				log.Error(e, err)
-				response.Msg = e
-				output <- response
-				continue
-			}
-		} else {
-			params = map[string]string{}
-		}
+// requestCount numbers the requests served without Id.
+var requestCount uint64
 
-		// download, read and prepare data for SCALE
-		_, numCols, numInput, cols, e := data_management.PrepareData(req.InputLinks, req.InputVecs, req.InputCols, req.NodeId, sm, params, pubKey, secKey)
-		if e != "" {
-			response.Msg = e
-			output <- response
-			continue
-		}
+// serve evaluates the request with the backend and sends its response to output; the
+// backend cleans up after the computation.
+func serve(backend Backend, req Request, output chan Response, roots *x509.CertPool, trust Trust,
+	pubKey, secKey []byte, mpcPort int) {
+	var err error
+	if req.Id == "" {
+		req.Id = "engine-" + strconv.FormatUint(atomic.AddUint64(&requestCount, 1), 10)
+	}
+	response := Response{}
+	if req.cancelled() {
+		response.Msg = "error, computation cancelled"
+		output <- response
+		return
+	}
 
-		// set up the parameters
-		params["COLS"] = strconv.Itoa(numCols)
-		params["LEN"] = strconv.Itoa(numInput)
-		if _, ok := params["cols"]; ok {
-			delete(params, "cols")
-		}
+	// the backend must be set up for the same protocol, number of parties and threshold;
+	// the fixed point parameters must be supported by the prime
+	sharing := req.Sharing()
+	err = sharing.Check()
+	if err == nil {
+		err = req.FixedPoint.OrDefault().Check()
+	}
+	if err == nil && req.Backend != "" && req.Backend != backend.Name() {
+		err = fmt.Errorf("backend %s requested, the node uses %s", req.Backend, backend.Name())
+	}
+	if err == nil {
+		defer cleanUp(backend, req)
+		err = backend.SetUp(req)
+	}
+	if err != nil {
+		e := "error, computation failed, " + err.Error()
+		log.Error(e)
+		response.Msg = e
+		output <- response
+		return
+	}
 
-		// execute the computation of the node
-		if strconv.Itoa(scalePort) != strings.Split(req.NodesPorts, ",")[req.NodeId] {
-			log.Error(fmt.Errorf("error in port specification " + strconv.Itoa(scalePort) + " " + strings.Split(req.NodesPorts, ",")[req.NodeId]))
-			continue
-		}
-		err = computation.RunScale(req.NodeId, req.Program, params, req.NodesPorts, sm)
+	// load parameters of the computation
+	var params map[string]string
+	if req.Params != "" {
+		err = json.Unmarshal([]byte(req.Params), &params)
 		if err != nil {
-			e := "error, computation failed, node trigger error"
+			e := "error, computation failed, parameters error "
+			log.Error(e, err)
 			response.Msg = e
 			output <- response
-			if err.Error() == "function not supported" {
-				log.Error(e, err)
-				continue
-			} else {
-				log.Fatal(e, err)
-			}
+			return
 		}
+	} else {
+		params = map[string]string{}
+	}
 
-		// load result
-		res, err := computation.LoadResultShares(req.NodeId, sm)
-		if err != nil {
-			e := "error, computation failed, error reading result"
-			response.Msg = e
-			output <- response
-			log.Error(err)
-			continue
-		}
+	// download, read and prepare data for the backend
+	req.report(StageFetchingData)
+	verifier := req.verifier(roots, trust)
+	var numCols, numInput int
+	var cols []string
+	e := ""
+	w, err := backend.Inputs(req)
+	if err == nil {
+		_, numCols, numInput, cols, e = data_management.PrepareDataWith(w, req.InputLinks, req.InputSigners,
+			req.InputVecs, req.InputCols, req.NodeId, params, pubKey, secKey, sharing, req.FixedPoint, verifier)
+	} else {
+		e = "error, computation failed, input error "
+		log.Error(e, err)
+	}
+	if e != "" {
+		response.Msg = e
+		output <- response
+		return
+	}
 
-		response.Vec = res
-		response.Cols = cols
+	// set up the parameters
+	params["COLS"] = strconv.Itoa(numCols)
+	params["LEN"] = strconv.Itoa(numInput)
+	fp := req.FixedPoint.OrDefault()
+	params["FIX_K"] = strconv.Itoa(fp.K)
+	params["FIX_F"] = strconv.Itoa(fp.F)
+	if _, ok := params["cols"]; ok {
+		delete(params, "cols")
+	}
 
-		// todo clean data
+	// execute the computation of the node
+	if strconv.Itoa(mpcPort) != strings.Split(req.NodesPorts, ",")[req.NodeId] {
+		response.Msg = "error, computation failed, port specification"
 		output <- response
+		log.Error(fmt.Errorf("error in port specification " + strconv.Itoa(mpcPort) + " " + strings.Split(req.NodesPorts, ",")[req.NodeId]))
+		return
+	}
+	if req.cancelled() {
+		response.Msg = "error, computation cancelled"
+		output <- response
+		return
+	}
+	req.report(StageCompiling)
+	err = backend.Prepare(req, params)
+	if err == nil {
+		req.report(StageRunning)
+		err = backend.Run(req)
+	}
+	if err != nil && req.cancelled() {
+		response.Msg = "error, computation cancelled"
+		output <- response
+		log.Info("MPC engine: computation ", req.Id, " cancelled: ", err)
+		return
+	}
+	if err != nil {
+		e := "error, computation failed, node trigger error"
+		response.Msg = e
+		output <- response
+		if errors.Is(err, ErrProgramNotSupported) || errors.Is(err, ErrComputationFailed) {
+			log.Error(e, err)
+		} else {
+			log.Error(e, "failure of the backend ", backend.Name(), ": ", err)
+		}
+		return
+	}
+
+	// load result
+	res, err := backend.Outputs(req)
+	if err != nil {
+		e := "error, computation failed, error reading result"
+		response.Msg = e
+		output <- response
+		log.Error(err)
+		return
+	}
+
+	response.Vec = res
+	response.Cols = cols
+	response.Inputs = verifier.Inputs
+
+	output <- response
+}
+
+// cleanUp lets the backend remove the data of the computation.
+func cleanUp(backend Backend, req Request) {
+	err := backend.CleanUp(req)
+	if err != nil {
+		log.Error("error cleaning up the computation: ", err)
 	}
 }
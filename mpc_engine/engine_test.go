@@ -2,9 +2,12 @@ package mpc_engine_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/krakenh2020/MPCService/key_management"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/krakenh2020/MPCService/computation"
 	"github.com/krakenh2020/MPCService/data_management"
 	"github.com/krakenh2020/MPCService/mpc_engine"
 )
@@ -32,7 +36,7 @@ func TestEngine(t *testing.T) {
 		queue[nodeId] = make(chan mpc_engine.Request, 1)
 		out[nodeId] = make(chan mpc_engine.Response, 1)
 		go mpc_engine.ScaleEngine(os.Getenv("SCALE_MAMBA_PATH"), queue[nodeId], out[nodeId], pubKey, secKey, 5012+nodeId,
-			nodeNames[nodeId], "../key_management/keys_certificates")
+			nodeNames[nodeId], "../key_management/keys_certificates", mpc_engine.Trust{AllowUnsigned: true})
 	}
 
 	time.Sleep(1 * time.Second)
@@ -51,17 +55,15 @@ func TestEngine(t *testing.T) {
 		log.Fatal(err)
 	}
 	for nodeId := 0; nodeId < 3; nodeId++ {
-		req := mpc_engine.Request{"k-means",
-			[]string{"https://unilj-my.sharepoint.com/:t:/g/personal/tilen_marc_fmf_uni-lj_si/ERIeB11IHdNEm6XZJIDIO_gB5frXkd70ygnaJJUYboUnJw?e=vxSOoV&download=1"},
-			nil,
-			nil,
-			string(paramsBytes),
-			nodeId,
-			nodeNames,
-			[]string{"localhost", "localhost", "localhost"},
-			"5012,5013,5014",
-			scaleCerts,
-			"",
+		req := mpc_engine.Request{
+			Program:    "k-means",
+			InputLinks: []string{"https://unilj-my.sharepoint.com/:t:/g/personal/tilen_marc_fmf_uni-lj_si/ERIeB11IHdNEm6XZJIDIO_gB5frXkd70ygnaJJUYboUnJw?e=vxSOoV&download=1"},
+			Params:     string(paramsBytes),
+			NodeId:     nodeId,
+			NodesNames: nodeNames,
+			NodesAddrs: []string{"localhost", "localhost", "localhost"},
+			NodesPorts: "5012,5013,5014",
+			ScaleCerts: scaleCerts,
 		}
 		queue[nodeId] <- req
 	}
@@ -73,12 +75,285 @@ func TestEngine(t *testing.T) {
 		shares[nodeId] = ret[nodeId].Vec
 	}
 
-	res := data_management.JoinSharesShamirFloat(shares)
+	res, err := data_management.JoinSharesShamirFloat(shares)
+	assert.NoError(t, err)
 	fmt.Println("Result", res)
 	//assert.Equal(t, []float64{1, 63, 4, 1, 30, 0, 0, 1, 0, 313, 180, 110, 33.109999656677246, 95, 103, 1}, res)
 	assert.Equal(t, 4*3+3, len(res))
 }
 
+// fakeBackend evaluates the maximum of the inputs of a node without the other nodes.
+type fakeBackend struct {
+	shares   []*big.Int
+	params   map[string]string
+	cleanUps int
+	runErr   error
+	cancel   chan struct{} // closed by Run, as if the request was cancelled while running
+}
+
+func (b *fakeBackend) Name() string                       { return "fake" }
+func (b *fakeBackend) SetUp(req mpc_engine.Request) error { return nil }
+
+func (b *fakeBackend) Inputs(req mpc_engine.Request) (data_management.InputWriter, error) {
+	return b, nil
+}
+
+func (b *fakeBackend) WriteShares(shares []*big.Int) error {
+	b.shares = append(b.shares, shares...)
+	return nil
+}
+
+func (b *fakeBackend) WritePrivate(privateIn []*big.Int) error { return nil }
+func (b *fakeBackend) Close() error                            { return nil }
+
+func (b *fakeBackend) Prepare(req mpc_engine.Request, params map[string]string) error {
+	if req.Program != "max" {
+		return mpc_engine.ErrProgramNotSupported
+	}
+	b.params = params
+	return nil
+}
+
+func (b *fakeBackend) Run(req mpc_engine.Request) error {
+	if b.cancel != nil {
+		close(b.cancel)
+		b.cancel = nil
+	}
+	return b.runErr
+}
+
+func (b *fakeBackend) Outputs(req mpc_engine.Request) ([]*big.Int, error) {
+	return b.shares[:1], nil
+}
+
+func (b *fakeBackend) CleanUp(req mpc_engine.Request) error {
+	b.cleanUps++
+	return nil
+}
+
+func TestBackend(t *testing.T) {
+	_, err := mpc_engine.NewBackend("unknown", mpc_engine.BackendConfig{})
+	assert.Error(t, err)
+	assert.Contains(t, mpc_engine.Backends(), mpc_engine.DefaultBackend)
+
+	pubKey, secKey := key_management.GenerateKeypair()
+	backend := &fakeBackend{}
+	queue := make(chan mpc_engine.Request, 1)
+	out := make(chan mpc_engine.Response, 1)
+	go mpc_engine.Engine(backend, queue, out, pubKey, secKey, 5030, t.TempDir(), mpc_engine.Trust{AllowUnsigned: true})
+
+	shares := []*big.Int{big.NewInt(4), big.NewInt(7), big.NewInt(1), big.NewInt(2)}
+	enc, err := data_management.EncryptVec(shares, pubKey)
+	assert.NoError(t, err)
+	req := mpc_engine.Request{Program: "max", InputVecs: []string{enc}, InputCols: [][]string{{"age", "male"}},
+		NodeId: 0, NodesNames: []string{"a", "b", "c"}, NodesPorts: "5030,5031,5032"}
+	queue <- req
+	res := <-out
+	assert.Equal(t, "", res.Msg)
+	assert.Equal(t, []*big.Int{big.NewInt(4)}, res.Vec)
+	assert.Equal(t, []string{"age", "male"}, res.Cols)
+	assert.Equal(t, shares, backend.shares)
+	assert.Equal(t, "2", backend.params["COLS"])
+	assert.Equal(t, "4", backend.params["LEN"])
+
+	// the node refuses requests for other backends and unsupported programs
+	req.Backend = mpc_engine.DefaultBackend
+	queue <- req
+	res = <-out
+	assert.Contains(t, res.Msg, "error")
+	req.Backend, req.Program = "fake", "unknown"
+	queue <- req
+	res = <-out
+	assert.Contains(t, res.Msg, "error")
+
+	// the backend cleans up after the computations it was set up for, once they are answered
+	req.Cancel = make(chan struct{})
+	close(req.Cancel)
+	queue <- req
+	res = <-out
+	assert.Contains(t, res.Msg, "cancelled")
+	assert.Equal(t, 2, backend.cleanUps)
+
+	// a failure of the backend fails the computation, but the node serves the next requests
+	req.Program, req.Cancel = "max", nil
+	for _, runErr := range []error{fmt.Errorf("%w: peer dropped out", mpc_engine.ErrComputationFailed),
+		errors.New("backend broken"), nil} {
+		backend.runErr = runErr
+		queue <- req
+		res = <-out
+		if runErr != nil {
+			assert.Contains(t, res.Msg, "error, computation failed")
+		} else {
+			assert.Equal(t, "", res.Msg)
+		}
+	}
+
+	// a node is answered even if it is not given its own port
+	req.NodesPorts = "5031,5030,5032"
+	queue <- req
+	res = <-out
+	assert.Equal(t, "error, computation failed, port specification", res.Msg)
+	req.NodesPorts = "5030,5031,5032"
+
+	// a computation cancelled while running is answered as cancelled
+	req.Cancel = make(chan struct{})
+	backend.cancel, backend.runErr = req.Cancel, errors.New("player killed")
+	queue <- req
+	res = <-out
+	assert.Equal(t, "error, computation cancelled", res.Msg)
+	backend.runErr = nil
+
+	// by default a node refuses shares that are not signed by a trusted provider
+	strict := make(chan mpc_engine.Request, 1)
+	go mpc_engine.Engine(backend, strict, out, pubKey, secKey, 5033, t.TempDir(), mpc_engine.Trust{})
+	req.Cancel = nil
+	strict <- req
+	res = <-out
+	assert.Contains(t, res.Msg, "origin of input not verified")
+}
+
+func TestShamirBackend(t *testing.T) {
+	nodeNames := []string{"Ljubljana_node", "Berlin_node", "Paris_node"}
+	certLoc := "../key_management/keys_certificates"
+	queue := make([]chan mpc_engine.Request, 3)
+	out := make([]chan mpc_engine.Response, 3)
+	pubKeys := make([][]byte, 3)
+	scaleCerts := make([][]byte, 3)
+	for nodeId := 0; nodeId < 3; nodeId++ {
+		var secKey []byte
+		var err error
+		pubKeys[nodeId], secKey, _, err = key_management.LoadKeysFromCertKey(certLoc, nodeNames[nodeId])
+		assert.NoError(t, err)
+		scaleCerts[nodeId], err = key_management.LoadCertificate(nodeNames[nodeId], certLoc)
+		assert.NoError(t, err)
+		backend, err := mpc_engine.NewBackend(mpc_engine.ShamirBackendName,
+			mpc_engine.BackendConfig{Name: nodeNames[nodeId], CertLoc: certLoc})
+		assert.NoError(t, err)
+		queue[nodeId] = make(chan mpc_engine.Request, 1)
+		out[nodeId] = make(chan mpc_engine.Response, 1)
+		go mpc_engine.Engine(backend, queue[nodeId], out[nodeId], pubKeys[nodeId], secKey, 5040+nodeId, certLoc,
+			mpc_engine.Trust{AllowUnsigned: true})
+	}
+
+	fp := data_management.FixedPoint{}.OrDefault()
+	values, err := fp.EncodeVec([]float64{1, 2.5, -3, 4, 2, -1.5})
+	assert.NoError(t, err)
+	shares, err := data_management.CreateSharesShamirN(values, 3, 1)
+	assert.NoError(t, err)
+	compute := func(program string, params string) [][]*big.Int {
+		for nodeId := 0; nodeId < 3; nodeId++ {
+			enc, err := data_management.EncryptVec(shares[nodeId], pubKeys[nodeId])
+			assert.NoError(t, err)
+			queue[nodeId] <- mpc_engine.Request{Backend: mpc_engine.ShamirBackendName, Program: program,
+				InputVecs: []string{enc}, InputCols: [][]string{{"a", "b"}}, Params: params, NodeId: nodeId,
+				NodesNames: nodeNames, NodesAddrs: []string{"localhost", "localhost", "localhost"},
+				NodesPorts: "5040,5041,5042", ScaleCerts: scaleCerts}
+		}
+		results := make([][]*big.Int, 3)
+		for nodeId := 0; nodeId < 3; nodeId++ {
+			res := <-out[nodeId]
+			assert.Equal(t, "", res.Msg)
+			results[nodeId] = res.Vec
+		}
+		return results
+	}
+
+	joined, err := data_management.JoinSharesShamirN(compute("max", ""), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(joined))
+	assert.Equal(t, 2., fp.Decode(joined[0].Int64()))
+	assert.Equal(t, 4., fp.Decode(joined[1].Int64()))
+
+	joined, err = data_management.JoinSharesShamirN(compute("avg", ""), 1)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, fp.Decode(joined[0].Int64()), 1e-5)
+	assert.InDelta(t, 5./3, fp.Decode(joined[1].Int64()), 1e-5)
+
+	// the programs of SCALE-MAMBA only are refused without stopping the nodes
+	for nodeId := 0; nodeId < 3; nodeId++ {
+		enc, err := data_management.EncryptVec(shares[nodeId], pubKeys[nodeId])
+		assert.NoError(t, err)
+		queue[nodeId] <- mpc_engine.Request{Program: "linear_regression", InputVecs: []string{enc},
+			InputCols: [][]string{{"a", "b"}}, NodeId: nodeId, NodesNames: nodeNames,
+			NodesAddrs: []string{"localhost", "localhost", "localhost"}, NodesPorts: "5040,5041,5042",
+			ScaleCerts: scaleCerts}
+		assert.Contains(t, (<-out[nodeId]).Msg, "error")
+	}
+	joined, err = data_management.JoinSharesShamirN(compute("max", ""), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4., fp.Decode(joined[1].Int64()))
+}
+
+func TestScaleBackend(t *testing.T) {
+	sm := t.TempDir()
+	assert.NoError(t, os.Mkdir(sm+"/Data", 0700))
+	certLoc := "../key_management/keys_certificates"
+	req := mpc_engine.Request{Program: "max", NodeId: 0, NodesNames: []string{"a", "b", "c"},
+		NodesAddrs: []string{"localhost", "localhost", "localhost"},
+		ScaleCerts: [][]byte{[]byte("a"), []byte("b"), []byte("c")}}
+
+	// a node without its key cannot set up SCALE, but keeps running
+	backend := mpc_engine.NewScaleBackend(sm, "Unknown_node", certLoc)
+	assert.Error(t, backend.SetUp(req))
+	assert.NoError(t, backend.CleanUp(req))
+
+	// each computation has its own sandbox, removed when it is cleaned up
+	computation.SandboxRoot = t.TempDir()
+	defer func() { computation.SandboxRoot = "" }()
+	sandboxes := func() int {
+		files, err := ioutil.ReadDir(computation.SandboxRoot)
+		assert.NoError(t, err)
+		return len(files)
+	}
+	backend = mpc_engine.NewScaleBackend(sm, "Ljubljana_node", certLoc)
+	reqA, reqB := req, req
+	reqA.Id, reqB.Id = "a", "b"
+	assert.NoError(t, backend.SetUp(reqA))
+	assert.Error(t, backend.SetUp(reqA))
+	assert.NoError(t, backend.SetUp(reqB))
+	assert.Equal(t, 2, sandboxes())
+	for _, r := range []mpc_engine.Request{reqA, reqB} {
+		w, err := backend.Inputs(r)
+		assert.NoError(t, err)
+		assert.NoError(t, w.WriteShares([]*big.Int{big.NewInt(1)}))
+		assert.NoError(t, w.Close())
+	}
+	assert.NoError(t, backend.CleanUp(reqA))
+	assert.Equal(t, 1, sandboxes())
+	_, err := backend.Inputs(reqA)
+	assert.Error(t, err)
+	w, err := backend.Inputs(reqB)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, backend.CleanUp(reqB))
+	assert.Equal(t, 0, sandboxes())
+
+	// a player failing, for example because a peer dropped out, fails the computation
+	assert.NoError(t, ioutil.WriteFile(sm+"/Player.x", []byte("#!/bin/sh\nexit 1\n"), 0700))
+	err = backend.Run(reqA)
+	assert.True(t, errors.Is(err, mpc_engine.ErrComputationFailed))
+	assert.NoError(t, backend.SetUp(reqA))
+	err = backend.Run(reqA)
+	assert.True(t, errors.Is(err, mpc_engine.ErrComputationFailed))
+	assert.NoError(t, backend.CleanUp(reqA))
+
+	// computations set up and cleaned up at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(r mpc_engine.Request) {
+			defer wg.Done()
+			assert.NoError(t, backend.SetUp(r))
+			_, err := backend.Inputs(r)
+			assert.NoError(t, err)
+			assert.NoError(t, backend.CleanUp(r))
+		}(mpc_engine.Request{Id: fmt.Sprint(i), NodeId: 0, NodesNames: req.NodesNames, NodesAddrs: req.NodesAddrs,
+			ScaleCerts: req.ScaleCerts})
+	}
+	wg.Wait()
+	assert.Equal(t, 0, sandboxes())
+}
+
 //func TestEngineErrors(t *testing.T) {
 //	time.Sleep(5 * time.Second)
 //	//run server
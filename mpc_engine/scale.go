@@ -0,0 +1,159 @@
+package mpc_engine
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krakenh2020/MPCService/computation"
+	"github.com/krakenh2020/MPCService/data_management"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	RegisterBackend(DefaultBackend, func(config BackendConfig) (Backend, error) {
+		return NewScaleBackend(config.Location, config.Name, config.CertLoc), nil
+	})
+}
+
+// ScaleBackend evaluates the computations with SCALE-MAMBA installed in sm; the node
+// connects to the others with the certificate privateCert in certLoc. Each computation
+// runs in its own sandbox, see computation.NewSandbox, so that the backend can serve
+// several requests with different Id at the same time.
+type ScaleBackend struct {
+	sm          string
+	privateCert string
+	certLoc     string
+	mu          sync.Mutex
+	sandboxes   map[string]string // sandboxes of the computations by the Id of their request
+}
+
+// NewScaleBackend returns the SCALE-MAMBA backend of a node.
+func NewScaleBackend(sm, privateCert, certLoc string) *ScaleBackend {
+	return &ScaleBackend{sm: sm, privateCert: privateCert, certLoc: certLoc, sandboxes: map[string]string{}}
+}
+
+// sandbox returns the sandbox of the computation of the request; a computation that is
+// not set up fails.
+func (b *ScaleBackend) sandbox(req Request) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dir, ok := b.sandboxes[req.Id]
+	if !ok {
+		return "", fmt.Errorf("%w: computation %s not set up", ErrComputationFailed, req.Id)
+	}
+	return dir, nil
+}
+
+func (b *ScaleBackend) Name() string {
+	return DefaultBackend
+}
+
+// SetUp creates the sandbox of the computation, selects the set up of SCALE-MAMBA for the
+// sharing, which must be prepared for the same protocol, number of parties and threshold,
+// and writes the addresses and the certificates of the nodes.
+func (b *ScaleBackend) SetUp(req Request) error {
+	dir, err := computation.NewSandbox(req.NodeId, b.sm)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	_, ok := b.sandboxes[req.Id]
+	if !ok {
+		b.sandboxes[req.Id] = dir
+	}
+	b.mu.Unlock()
+	if ok {
+		_ = computation.RemoveSandbox(dir)
+		return fmt.Errorf("computation %s already set up", req.Id)
+	}
+
+	sharing := req.Sharing()
+	err = computation.SetUpProtocolIn(sharing.Protocol, sharing.Parties, sharing.Threshold, b.sm, dir)
+	if err != nil {
+		return err
+	}
+	err = computation.SetUpScale(req.NodeId, req.NodesNames, req.NodesAddrs, dir, req.ScaleCerts,
+		b.privateCert, b.certLoc)
+	if err != nil {
+		return fmt.Errorf("error preparing SCALE: %s", err)
+	}
+	return nil
+}
+
+// Inputs returns a writer of the input files of SCALE.
+func (b *ScaleBackend) Inputs(req Request) (data_management.InputWriter, error) {
+	dir, err := b.sandbox(req)
+	if err != nil {
+		return nil, err
+	}
+	sharing := req.Sharing()
+	return computation.NewInputWriter(req.NodeId,
+		data_management.SharesPerValue(sharing.Protocol, sharing.Parties, sharing.Threshold), dir)
+}
+
+// Prepare checks the parameters against the ones declared by the MAMBA program, writes
+// them to it and compiles it; the additive shares of full threshold protocols are given by
+// all the nodes as private inputs. A failure of the compilation fails the computation.
+func (b *ScaleBackend) Prepare(req Request, params map[string]string) error {
+	sharing := req.Sharing()
+	params["INPUT_PARTIES"] = "0"
+	if sharing.Protocol == data_management.ProtocolFullThreshold {
+		params["INPUT_PARTIES"] = strconv.Itoa(sharing.Parties)
+	}
+	err := computation.CheckProgram(req.Program, params)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProgramNotSupported, err)
+	}
+	dir, err := b.sandbox(req)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = computation.PrepareMambaProgramIn(req.NodeId, req.Program, params, b.sm, dir)
+	log.Info("Mamba: Compiling took ", time.Since(start).Seconds(), " seconds")
+	if err != nil {
+		return fmt.Errorf("%w: compiling the program: %s", ErrComputationFailed, err)
+	}
+	return nil
+}
+
+// Run runs the SCALE player of the node; the player fails, for example, if another node
+// fails or drops out, which fails the computation. The player is killed if the request is
+// cancelled.
+func (b *ScaleBackend) Run(req Request) error {
+	dir, err := b.sandbox(req)
+	if err != nil {
+		return err
+	}
+	err = computation.RunPlayerInCancel(req.NodeId, req.NodesPorts, b.sm, dir, req.Cancel)
+	if err != nil {
+		return fmt.Errorf("%w: SCALE: %s", ErrComputationFailed, err)
+	}
+	return nil
+}
+
+// Outputs loads the shares of the results written by SCALE.
+func (b *ScaleBackend) Outputs(req Request) ([]*big.Int, error) {
+	dir, err := b.sandbox(req)
+	if err != nil {
+		return nil, err
+	}
+	sharing := req.Sharing()
+	return computation.LoadResultShares(req.NodeId, dir,
+		data_management.SharesPerValue(sharing.Protocol, sharing.Parties, sharing.Threshold))
+}
+
+// CleanUp removes the sandbox of the computation of the request securely.
+func (b *ScaleBackend) CleanUp(req Request) error {
+	b.mu.Lock()
+	dir, ok := b.sandboxes[req.Id]
+	delete(b.sandboxes, req.Id)
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return computation.RemoveSandbox(dir)
+}
@@ -0,0 +1,175 @@
+package mpc_engine
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/shamir_mpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// ShamirBackendName is the name of the pure Go backend, see ShamirBackend.
+const ShamirBackendName = "go-shamir"
+
+// ShamirTimeout bounds the time a node running ShamirBackend waits for the other nodes.
+var ShamirTimeout = 10 * time.Minute
+
+func init() {
+	RegisterBackend(ShamirBackendName, func(config BackendConfig) (Backend, error) {
+		return NewShamirBackend(config.Name, config.CertLoc)
+	})
+}
+
+// ShamirBackend evaluates the programs of shamir_mpc among honest majority nodes holding
+// Shamir shares, without other software; the node connects to the others with the
+// certificate privateCert in certLoc, the one it advertises to the manager.
+type ShamirBackend struct {
+	cert    tls.Certificate
+	inputs  []*big.Int
+	program shamir_mpc.Program
+	params  shamir_mpc.Params
+	cols    int
+	results []*big.Int
+}
+
+// NewShamirBackend returns the pure Go backend of a node.
+func NewShamirBackend(privateCert, certLoc string) (*ShamirBackend, error) {
+	cert, err := tls.LoadX509KeyPair(certLoc+"/"+privateCert+".crt", certLoc+"/"+privateCert+".key")
+	if err != nil {
+		return nil, err
+	}
+	return &ShamirBackend{cert: cert}, nil
+}
+
+func (b *ShamirBackend) Name() string {
+	return ShamirBackendName
+}
+
+// SetUp checks that the inputs are Shamir shares and forgets the previous computation.
+func (b *ShamirBackend) SetUp(req Request) error {
+	sharing := req.Sharing()
+	if sharing.Protocol != data_management.ProtocolShamir {
+		return fmt.Errorf("protocol %s not supported by backend %s", sharing.Protocol, ShamirBackendName)
+	}
+	if len(req.NodesAddrs) != sharing.Parties || len(req.ScaleCerts) != sharing.Parties {
+		return fmt.Errorf("%d addresses and %d certificates given for %d nodes", len(req.NodesAddrs),
+			len(req.ScaleCerts), sharing.Parties)
+	}
+	b.inputs, b.program, b.results = nil, nil, nil
+	return nil
+}
+
+// shareCollector keeps the shares of the inputs in memory.
+type shareCollector struct {
+	b *ShamirBackend
+}
+
+func (c shareCollector) WriteShares(shares []*big.Int) error {
+	for _, e := range shares {
+		c.b.inputs = append(c.b.inputs, new(big.Int).Set(e))
+	}
+	return nil
+}
+
+func (c shareCollector) WritePrivate(privateIn []*big.Int) error {
+	return fmt.Errorf("private inputs not supported by backend %s", ShamirBackendName)
+}
+
+func (c shareCollector) Close() error {
+	return nil
+}
+
+// Inputs returns a writer keeping the shares of the inputs in memory.
+func (b *ShamirBackend) Inputs(req Request) (data_management.InputWriter, error) {
+	return shareCollector{b: b}, nil
+}
+
+// Prepare selects the program and checks that the nodes can evaluate it on the inputs with
+// the parameters.
+func (b *ShamirBackend) Prepare(req Request, params map[string]string) error {
+	program, ok := shamir_mpc.Programs[req.Program]
+	if !ok {
+		return ErrProgramNotSupported
+	}
+	cols, err := strconv.Atoi(params["COLS"])
+	if err == nil && (cols < 1 || len(b.inputs)%cols != 0) {
+		err = fmt.Errorf("%d values cannot be split in rows of %d values", len(b.inputs), cols)
+	}
+	b.params = shamir_mpc.Params{FixedPoint: req.FixedPoint}
+	if val, ok := params["NUM_CLUSTERS"]; ok && err == nil {
+		b.params.Clusters, err = strconv.Atoi(val)
+	}
+	if err == nil {
+		err = shamir_mpc.CheckParams(req.Program, len(b.inputs)/cols, cols, req.Sharing().Parties, b.params)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProgramNotSupported, err)
+	}
+	b.program, b.cols = program, cols
+	return nil
+}
+
+// Run connects to the other nodes on the addresses and ports of the request and evaluates
+// the program with them; the failures of the computation do not affect the node. The
+// connections are closed if the request is cancelled, which ends the computation.
+func (b *ShamirBackend) Run(req Request) error {
+	sharing := req.Sharing()
+	ports := strings.Split(req.NodesPorts, ",")
+	if len(ports) != sharing.Parties {
+		return fmt.Errorf("%w: %d ports given for %d nodes", ErrComputationFailed, len(ports), sharing.Parties)
+	}
+	addrs := make([]string, sharing.Parties)
+	for i := range addrs {
+		addrs[i] = net.JoinHostPort(req.NodesAddrs[i], ports[i])
+	}
+
+	start := time.Now()
+	nw, err := shamir_mpc.Connect(req.NodeId, addrs, b.cert, req.ScaleCerts, ShamirTimeout)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrComputationFailed, err)
+	}
+	defer nw.Close()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-req.Cancel:
+			_ = nw.Close()
+		case <-finished:
+		}
+	}()
+	p, err := shamir_mpc.NewParty(req.NodeId, sharing.Parties, sharing.Threshold, nw)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrComputationFailed, err)
+	}
+	x := make([][]*big.Int, len(b.inputs)/b.cols)
+	for r := range x {
+		x[r] = b.inputs[r*b.cols : (r+1)*b.cols]
+	}
+	b.results, err = b.program(p, x, b.params)
+	log.Info("Shamir: computation took ", time.Since(start).Seconds(), " seconds")
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrComputationFailed, err)
+	}
+	return nil
+}
+
+// Outputs returns the shares of the results of the last computation.
+func (b *ShamirBackend) Outputs(req Request) ([]*big.Int, error) {
+	if b.results == nil {
+		return nil, fmt.Errorf("no results computed")
+	}
+	return b.results, nil
+}
+
+// CleanUp forgets the shares of the inputs and of the results.
+func (b *ShamirBackend) CleanUp(req Request) error {
+	b.inputs, b.program, b.results = nil, nil, nil
+	return nil
+}
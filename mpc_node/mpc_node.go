@@ -1,72 +1,151 @@
 package mpc_node
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
-	"encoding/json"
-	"io/ioutil"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/krakenh2020/MPCService/authorization"
 	log "github.com/sirupsen/logrus"
 	"github.com/krakenh2020/MPCService/data_management"
 	"github.com/krakenh2020/MPCService/key_management"
 	"github.com/krakenh2020/MPCService/logging"
 	"github.com/krakenh2020/MPCService/manager"
 	"github.com/krakenh2020/MPCService/mpc_engine"
+	"github.com/krakenh2020/MPCService/protocol"
 )
 
-// RunNode starts a node server at localhost.
+// RunNode starts a node server at localhost. The requests for computations are approved by
+// the authorizer given by authz, see authorization.New. The node advertises that it can
+// evaluate the comma separated sharings in protocols, see data_management.ParseSharings;
+// if it is empty, Shamir sharing among 3 nodes with threshold 1. The computations are
+// evaluated with the MPC backend of the given name, see mpc_engine.NewBackend, installed in
+// sm; the node listens to the other nodes on scalePort. The node only uses shares signed by
+// the data providers named in the comma separated providers, with certificates of the
+// RootCA in certFolder, and shares of datasets without a provider that are not signed by
+// one if allowUnsigned is set, see mpc_engine.Trust.
 func RunNode(name string, myAddr string, scalePort int, certFolder, sm string, logLevel, logFile string,
-	managerAddr string, description string) {
+	managerAddr string, description string, authz string, protocols string, backendName string, providers string,
+	allowUnsigned bool) {
+	err := ServeNode(context.Background(), name, myAddr, scalePort, certFolder, sm, logLevel, logFile, managerAddr,
+		description, authz, protocols, backendName, providers, allowUnsigned)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ServeNode is RunNode running until ctx is done: the node then closes its connection to
+// the manager, cancels its computations and returns once they are answered. It returns
+// the errors of setting up the node instead of stopping the process.
+func ServeNode(ctx context.Context, name string, myAddr string, scalePort int, certFolder, sm string, logLevel,
+	logFile string, managerAddr string, description string, authz string, protocols string, backendName string,
+	providers string, allowUnsigned bool) error {
 	// set up logging
 	logging.LogSetUp(logLevel, logFile)
 	log.Info("MPC "+name+" is running with scale port ", scalePort, "; address ", myAddr,
 		"; key name: ", "; certificate location: ", certFolder,
-		"; using SCALE-MAMBA ", sm, "; connecting to manager on address ", managerAddr)
+		"; using backend ", backendName, " in ", sm, "; connecting to manager on address ", managerAddr)
 
 	// make a queue for MPC computation requests
-	queue := make(chan mpc_engine.Request, 100)
-	out := make(chan mpc_engine.Response, 100)
+	eng := &engine{queue: make(chan mpc_engine.Request, 100), out: make(chan mpc_engine.Response, 100)}
 
 	pubKey, secKey, sig, err := key_management.LoadKeysFromCertKey(certFolder, name)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
+	// the node authenticates itself to the manager with its certificate
+	var tlsConfig *tls.Config
 	if managerAddr != "" {
-		go managerConn(name, myAddr, managerAddr, pubKey, certFolder, sig, scalePort, queue, out, description)
+		tlsConfig, err = key_management.ClientTLSConfig(certFolder, name)
+		if err != nil {
+			return err
+		}
 	}
 
-	mpc_engine.ScaleEngine(sm, queue, out, pubKey, secKey, scalePort, name, certFolder)
-}
-
-func managerConn(name, myAddr, managerAddr string, pubKey []byte, certFolder string, sig []byte,
-	scalePort int, queue chan mpc_engine.Request, out chan mpc_engine.Response, description string) {
-	u := url.URL{Scheme: "wss", Host: managerAddr, Path: "/connect_mpc"}
+	authorizer, err := authorization.New(authz)
+	if err != nil {
+		return err
+	}
 
-	cert, err := tls.LoadX509KeyPair(certFolder+"/"+name+".crt", certFolder+"/"+name+".key")
+	sharings, err := data_management.ParseSharings(protocols)
+	if err != nil {
+		return err
+	}
+	if len(sharings) == 0 {
+		sharings = []data_management.Sharing{{Protocol: data_management.ProtocolShamir, Parties: 3, Threshold: 1}}
+	}
 
-	caCert, err := ioutil.ReadFile(certFolder + "/" + "/RootCA.crt")
-	caCertPool := x509.NewCertPool()
-	_ = caCertPool.AppendCertsFromPEM(caCert)
+	backend, err := mpc_engine.NewBackend(backendName, mpc_engine.BackendConfig{Name: name, CertLoc: certFolder,
+		Location: sm})
+	if err != nil {
+		return err
+	}
 
-	dialer := websocket.Dialer{
-		TLSClientConfig: &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			RootCAs:      caCertPool,
-		},
+	trust := mpc_engine.Trust{AllowUnsigned: allowUnsigned}
+	for _, e := range strings.Split(providers, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			trust.Providers = append(trust.Providers, e)
+		}
 	}
+	if len(trust.Providers) == 0 && !allowUnsigned {
+		log.Warn("MPC " + name + " trusts no data provider, the computations on datasets will fail")
+	}
+
+	connDone := make(chan struct{})
+	go func() {
+		defer close(connDone)
+		if managerAddr != "" {
+			managerConn(ctx, name, myAddr, managerAddr, tlsConfig, pubKey, certFolder, sig, scalePort, eng, description,
+				authorizer, sharings, backend.Name())
+		}
+	}()
+	engineDone := make(chan struct{})
+	go func() {
+		defer close(engineDone)
+		mpc_engine.Engine(backend, eng.queue, eng.out, pubKey, secKey, scalePort, certFolder, trust)
+	}()
+
+	// the queue is closed once the computations requested by the manager are answered
+	<-ctx.Done()
+	<-connDone
+	close(eng.queue)
+	<-engineDone
+	log.Info("MPC " + name + " stopped")
+
+	return nil
+}
+
+func managerConn(ctx context.Context, name, myAddr, managerAddr string, tlsConfig *tls.Config, pubKey []byte,
+	certFolder string, sig []byte, scalePort int, eng *engine, description string,
+	authorizer authorization.Authorizer, sharings []data_management.Sharing, backend string) {
+	u := url.URL{Scheme: "wss", Host: managerAddr, Path: "/connect_mpc"}
 
-	conn, _, err := dialer.Dial(u.String(), nil)
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig}
+
+	ws, _, err := dialer.DialContext(ctx, u.String(), nil)
 	if err != nil {
 		log.Error("Data provider: error dialing the manager")
 		return
 	}
+	conn := protocol.NewConn(ws)
 	defer conn.Close()
 
+	// the connection is closed when the node stops, which ends the loop below
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stopped:
+		}
+	}()
+
 	// todo: double load
 	scaleCrt, err := key_management.LoadCertificate(name, certFolder)
 	if err != nil {
@@ -74,86 +153,207 @@ func managerConn(name, myAddr, managerAddr string, pubKey []byte, certFolder str
 		return
 	}
 
-	msg := manager.MPCNode{Name: name, Address: myAddr,
+	info := manager.MPCNode{Name: name, Address: myAddr,
 		ScaleCert:   scaleCrt,
 		MpcPubKey:   pubKey,
 		SigPubKey:   sig,
 		Description: description,
 		ScalePort:   scalePort,
+		Protocols:   sharings,
+		Backend:     backend,
 	}
-	err = conn.WriteJSON(msg)
+	err = conn.Hello(info)
 	if err != nil {
-		log.Error("error sending info to the manager")
+		log.Error("error registering with the manager: ", err)
 		return
 	}
 
+	// requests are served concurrently with the manager's messages, so that they can be cancelled
+	var cancelMu sync.Mutex
+	cancels := make(map[string]chan struct{})
+	// once the connection is lost, the computations still running are cancelled and answered
+	var running sync.WaitGroup
+	defer func() {
+		cancelMu.Lock()
+		for id, cancel := range cancels {
+			close(cancel)
+			delete(cancels, id)
+		}
+		cancelMu.Unlock()
+		running.Wait()
+	}()
+
 	for {
-		var b []byte
-		err = conn.ReadJSON(&b)
+		msg, err := conn.Receive()
 		if err != nil {
-			log.Error("lost connection with the manager 1 ", err)
+			if ctx.Err() == nil {
+				log.Error("lost connection with the manager ", err)
+			}
 			return
 		}
-		if string(b) == "ping" {
-			err = conn.WriteJSON([]byte("pong"))
-			if err != nil {
-				log.Error("lost connection with the manager 2")
-				return
+
+		switch msg.Type {
+		case protocol.TypeCancel:
+			cancelMu.Lock()
+			if cancel, ok := cancels[msg.RequestId]; ok {
+				close(cancel)
+				delete(cancels, msg.RequestId)
+				log.Info("Server: request ", msg.RequestId, " cancelled")
 			}
+			cancelMu.Unlock()
+			continue
+		case protocol.TypeCompute:
+		default:
+			log.Error("unexpected message ", msg.Type)
 			continue
 		}
 
-		var msg mpc_engine.Request
-		err = json.Unmarshal(b, &msg)
-
-		errMsg := manager.ReturnMsg{Error: "",
-			Result: ""}
+		var req mpc_engine.Request
+		err = msg.Decode(&req)
 		if err != nil {
 			log.Error("failed to read the message: ", err)
-			errMsg.Error = "failed to read the message: " + err.Error()
-			err = conn.WriteJSON(errMsg)
+			err = conn.SendError(msg.RequestId, "failed to read the message: "+err.Error())
 			if err != nil {
 				log.Error("failed to return a response:", err)
 			} else {
 				log.Info("Error response sent")
 			}
-			return
+			continue
 		}
 
-		log.Info("Server: Received a request to start computation of "+msg.Program+" from ", conn.RemoteAddr())
-		retMsg, err := RequestComputation(msg, queue, out)
-
-		if err != nil {
-			log.Error("failed to do a computation: ", err)
-			errMsg.Error = "failed to do a computation: " + err.Error()
-			err = conn.WriteJSON(errMsg)
+		if req.Backend != "" && req.Backend != backend {
+			log.Error("backend ", req.Backend, " not supported")
+			err = conn.SendError(msg.RequestId, "backend "+req.Backend+" not supported by node "+name)
 			if err != nil {
 				log.Error("failed to return a response:", err)
-			} else {
-				log.Info("Error response sent")
 			}
-			return
+			continue
+		}
+		// the setup of the backend of the node must be prepared for the sharing
+		if sharing := req.Sharing(); !supports(sharings, sharing) {
+			log.Error("sharing ", sharing, " not supported")
+			err = conn.SendError(msg.RequestId, "sharing "+sharing.String()+" not supported by node "+name)
+			if err != nil {
+				log.Error("failed to return a response:", err)
+			}
+			continue
 		}
-		log.Info("Server: Computation successful")
 
-		log.Debug("return of computation:", retMsg)
-		err = conn.WriteJSON(retMsg)
-		if err != nil {
-			log.Error("failed to return a response: ", err)
-		} else {
-			log.Info("Server: Return message sent")
+		req.Id = msg.RequestId
+		cancel := make(chan struct{})
+		cancelMu.Lock()
+		cancels[msg.RequestId] = cancel
+		cancelMu.Unlock()
+		req.Cancel = cancel
+
+		log.Info("Server: Received a request to start computation of "+req.Program+" from ", conn.RemoteAddr())
+		running.Add(1)
+		go func(requestId string) {
+			defer running.Done()
+			defer func() {
+				cancelMu.Lock()
+				delete(cancels, requestId)
+				cancelMu.Unlock()
+			}()
+
+			// every node checks on its own that the computation is allowed
+			decision, reason := authorization.WaitCancel(authorizer, authorization.Request{Requester: req.Requester,
+				Program: req.Program, Datasets: req.Datasets, Params: req.Params, Voucher: req.Voucher},
+				approvalInterval, approvalTimeout, cancel)
+			if decision != authorization.Allow {
+				log.Info("Server: computation of ", req.Requester, " not authorized: ", reason)
+				err := conn.SendError(requestId, "not authorized: "+reason)
+				if err != nil {
+					log.Error("failed to return a response:", err)
+				}
+				return
+			}
+
+			retMsg, err := eng.compute(req, func(stage string) {
+				err := conn.Send(protocol.TypeProgress, requestId, protocol.Progress{Stage: stage})
+				if err != nil {
+					log.Error("failed to report progress: ", err)
+				}
+			})
+
+			if err != nil {
+				log.Error("failed to do a computation: ", err)
+				err = conn.SendError(requestId, "failed to do a computation: "+err.Error())
+				if err != nil {
+					log.Error("failed to return a response:", err)
+				} else {
+					log.Info("Error response sent")
+				}
+				return
+			}
+			log.Info("Server: Computation successful")
+
+			log.Debug("return of computation:", retMsg)
+			err = conn.Send(protocol.TypeResult, requestId, retMsg)
+			if err != nil {
+				log.Error("failed to return a response: ", err)
+			} else {
+				log.Info("Server: Return message sent")
+			}
+		}(msg.RequestId)
+	}
+}
+
+// supports checks whether the sharing is one of the sharings of the node.
+func supports(sharings []data_management.Sharing, sharing data_management.Sharing) bool {
+	for _, e := range sharings {
+		if e == sharing {
+			return true
 		}
 	}
+	return false
 }
 
-func RequestComputation(msg mpc_engine.Request, queue chan mpc_engine.Request, out chan mpc_engine.Response) (manager.ReturnMsg, error) {
-	// TODO: concurrency, it assumes that the computation requests come in the right order
+// approvalInterval and approvalTimeout define how long a node waits for a pending
+// authorization of a computation.
+var approvalInterval = 5 * time.Second
+var approvalTimeout = time.Minute
+
+// engine holds the queue and the outputs of the MPC engine of a node, with the lock making
+// sure that the engine gets one request at a time, so that its outputs are matched to the
+// right requests.
+type engine struct {
+	mu    sync.Mutex
+	queue chan mpc_engine.Request
+	out   chan mpc_engine.Response
+}
+
+// compute requests the computation with RequestComputation once the engine answered the
+// previous requests.
+func (e *engine) compute(msg mpc_engine.Request, report func(stage string)) (manager.ReturnMsg, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return RequestComputation(msg, e.queue, e.out, report)
+}
+
+// RequestComputation passes the request to the engine and encrypts its output. The stages
+// of the computation are passed to report, if it is not nil. The engine must not be
+// given other requests until it answers.
+func RequestComputation(msg mpc_engine.Request, queue chan mpc_engine.Request, out chan mpc_engine.Response,
+	report func(stage string)) (manager.ReturnMsg, error) {
+	progress := make(chan string, 10)
+	msg.Progress = progress
 	queue <- msg
 	// get output
 	var resEnc = ""
 	var errMsg = ""
-	res := <-out
-	if res.Msg != "exit" && (len(res.Msg) != 5 || res.Msg[:5] != "error") {
+	var res mpc_engine.Response
+	for done := false; !done; {
+		select {
+		case stage := <-progress:
+			if report != nil {
+				report(stage)
+			}
+		case res = <-out:
+			done = true
+		}
+	}
+	if res.Msg != "exit" && !strings.HasPrefix(res.Msg, "error") {
 		// encrypt output
 		pubKey, err := base64.StdEncoding.DecodeString(msg.ReceiverPubKey)
 		if err != nil {
@@ -167,11 +367,11 @@ func RequestComputation(msg mpc_engine.Request, queue chan mpc_engine.Request, o
 			return manager.ReturnMsg{}, err
 		}
 
-	} else if len(res.Msg) >= 5 && res.Msg[:5] == "error" {
+	} else if strings.HasPrefix(res.Msg, "error") {
 		errMsg = res.Msg
 	}
 
-	ret := manager.ReturnMsg{Error: errMsg, Result: resEnc, Cols: strings.Join(res.Cols, ",")}
+	ret := manager.ReturnMsg{Error: errMsg, Result: resEnc, Cols: strings.Join(res.Cols, ","), Inputs: res.Inputs}
 
 	return ret, nil
 }
@@ -1,17 +1,20 @@
 package mpc_node_test
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/krakenh2020/MPCService/manager"
 	"github.com/krakenh2020/MPCService/mpc_node"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestRunNode(t *testing.T) {
 	go manager.RunManager(5007, 5008, "../manager/assets", "info", "../logging/log.log",
-		"../key_management/keys_certificates")
+		"../key_management/keys_certificates", false, "", "", "")
 	time.Sleep(1 * time.Second)
 
 	// run servers
@@ -20,7 +23,36 @@ func TestRunNode(t *testing.T) {
 	for nodeId := 0; nodeId < len(nodeNames); nodeId++ {
 		go mpc_node.RunNode(nodeNames[nodeId], "localhost", 5040+nodeId, "../key_management/keys_certificates",
 			os.Getenv("SCALE_MAMBA_PATH"), "info", "../logging/log.log",
-			"localhost:5008", "some description")
+			"localhost:5008", "some description", "", "shamir:3:1,shamir:5:2", "",
+			"Data_provider1", true)
 	}
 	time.Sleep(1 * time.Second)
 }
+
+// copyCerts copies the files from the folder of the test certificates to a new folder.
+func copyCerts(t *testing.T, files ...string) string {
+	dir := t.TempDir()
+	for _, file := range files {
+		b, err := ioutil.ReadFile("../key_management/keys_certificates/" + file)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(dir+"/"+file, b, 0600))
+	}
+	return dir
+}
+
+func TestServeNodeCertificates(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		files []string
+	}{
+		{name: "missing RootCA", files: []string{"Berlin_node.crt", "Berlin_node.key"}},
+		{name: "missing certificate", files: []string{"Berlin_node.key", "RootCA.crt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := mpc_node.ServeNode(context.Background(), "Berlin_node", "localhost", 5060,
+				copyCerts(t, test.files...), "", "info", "../logging/log.log", "localhost:5008", "", "", "", "",
+				"", true)
+			assert.Error(t, err)
+		})
+	}
+}
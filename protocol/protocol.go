@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Version is the version of the protocol between the manager, the MPC nodes and the
+// data providers. Peers speaking a version lower than MinVersion are rejected.
+const Version = 1
+const MinVersion = 1
+
+// Types of messages.
+const (
+	TypeHello       = "hello"
+	TypePing        = "ping"
+	TypePong        = "pong"
+	TypeCompute     = "compute"
+	TypeDataRequest = "data_request"
+	TypeProgress    = "progress"
+	TypeResult      = "result"
+	TypeError       = "error"
+	TypeCancel      = "cancel"
+)
+
+// Message is the envelope of all the messages exchanged over a websocket connection.
+// The payload is decoded according to the type of the message; replies carry the
+// request ID of the request they belong to.
+type Message struct {
+	Version   int             `json:"version"`
+	Type      string          `json:"type"`
+	RequestId string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Decode unmarshals the payload of the message into val.
+func (m *Message) Decode(val interface{}) error {
+	if len(m.Payload) == 0 {
+		return fmt.Errorf("empty payload of %s message", m.Type)
+	}
+	return json.Unmarshal(m.Payload, val)
+}
+
+// Progress is the payload of a progress message, reporting the stage of a computation.
+type Progress struct {
+	Stage string `json:"stage"`
+}
+
+// Error is the payload of an error message.
+type Error struct {
+	Message string `json:"message"`
+}
+
+// Conn is a websocket connection exchanging Message envelopes. Send can be called
+// concurrently; Receive must be called from a single goroutine.
+type Conn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+}
+
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{ws: ws}
+}
+
+// Send writes a message of the given type with the payload to the peer.
+func (c *Conn) Send(msgType, requestId string, payload interface{}) error {
+	msg := Message{Version: Version, Type: msgType, RequestId: requestId}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = b
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(msg)
+}
+
+// SendError writes an error message to the peer.
+func (c *Conn) SendError(requestId, errMsg string) error {
+	return c.Send(TypeError, requestId, Error{Message: errMsg})
+}
+
+// Receive reads the next message. Ping messages are answered with a pong message and
+// skipped, pong messages are skipped without an answer, so that a ping ends with its
+// pong; messages of incompatible versions are rejected. The liveness of the peer is
+// checked with websocket control frames by KeepAlive.
+func (c *Conn) Receive() (*Message, error) {
+	for {
+		var msg Message
+		err := c.ws.ReadJSON(&msg)
+		if err != nil {
+			return nil, err
+		}
+		if err = CheckVersion(msg.Version); err != nil {
+			return nil, err
+		}
+		switch msg.Type {
+		case TypePing:
+			if err = c.Send(TypePong, msg.RequestId, nil); err != nil {
+				return nil, err
+			}
+			continue
+		case TypePong:
+			continue
+		}
+
+		return &msg, nil
+	}
+}
+
+// KeepAlive pings the peer with websocket control frames every period until stop is
+// closed. Receive fails if the peer does not answer within wait.
+func (c *Conn) KeepAlive(period, wait time.Duration, stop <-chan struct{}) {
+	_ = c.ws.SetReadDeadline(time.Now().Add(wait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(wait))
+	})
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wait))
+			if err != nil {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close closes the underlying connection.
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() string {
+	return c.ws.RemoteAddr().String()
+}
+
+// CheckVersion returns an error if a peer speaking the given version is not supported.
+func CheckVersion(version int) error {
+	if version < MinVersion || version > Version {
+		return fmt.Errorf("incompatible protocol version %d, supported versions are %d to %d",
+			version, MinVersion, Version)
+	}
+	return nil
+}
+
+// Hello sends the hello message with the information about the peer and waits for the
+// other side to accept it.
+func (c *Conn) Hello(info interface{}) error {
+	err := c.Send(TypeHello, "", info)
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.Receive()
+	if err != nil {
+		return err
+	}
+	switch msg.Type {
+	case TypeHello:
+		return nil
+	case TypeError:
+		var e Error
+		_ = msg.Decode(&e)
+		return fmt.Errorf("rejected: %s", e.Message)
+	default:
+		return fmt.Errorf("unexpected %s message during handshake", msg.Type)
+	}
+}
+
+// AcceptHello reads the hello message of a peer into info and accepts it; a peer with an
+// incompatible version is sent an error.
+func (c *Conn) AcceptHello(info interface{}) error {
+	var msg Message
+	err := c.ws.ReadJSON(&msg)
+	if err != nil {
+		return err
+	}
+	if err = CheckVersion(msg.Version); err != nil {
+		_ = c.SendError("", err.Error())
+		return err
+	}
+	if msg.Type != TypeHello {
+		err = fmt.Errorf("expected hello message, got %s", msg.Type)
+		_ = c.SendError("", err.Error())
+		return err
+	}
+	if err = msg.Decode(info); err != nil {
+		_ = c.SendError("", err.Error())
+		return err
+	}
+
+	return c.Send(TypeHello, "", nil)
+}
@@ -0,0 +1,125 @@
+package protocol_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/krakenh2020/MPCService/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshake(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn := protocol.NewConn(ws)
+		defer conn.Close()
+
+		var name string
+		err = conn.AcceptHello(&name)
+		if err != nil {
+			received <- ""
+			return
+		}
+		received <- name
+
+		msg, err := conn.Receive()
+		if err != nil {
+			return
+		}
+		_ = conn.Send(protocol.TypeResult, msg.RequestId, "done")
+	}))
+	defer server.Close()
+	u := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// compatible peer
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	conn := protocol.NewConn(ws)
+	assert.NoError(t, conn.Hello("Berlin_node"))
+	assert.Equal(t, "Berlin_node", <-received)
+
+	assert.NoError(t, conn.Send(protocol.TypeCompute, "42", nil))
+	msg, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeResult, msg.Type)
+	assert.Equal(t, "42", msg.RequestId)
+	var res string
+	assert.NoError(t, msg.Decode(&res))
+	assert.Equal(t, "done", res)
+	_ = conn.Close()
+
+	// peer with an incompatible version
+	ws, _, err = websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	err = ws.WriteJSON(protocol.Message{Version: protocol.Version + 1, Type: protocol.TypeHello,
+		Payload: []byte(`"Paris_node"`)})
+	assert.NoError(t, err)
+	var reply protocol.Message
+	assert.NoError(t, ws.ReadJSON(&reply))
+	assert.Equal(t, protocol.TypeError, reply.Type)
+	assert.Equal(t, "", <-received)
+	_ = ws.Close()
+}
+
+func TestPing(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	replies := make(chan protocol.Message, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn := protocol.NewConn(ws)
+		defer conn.Close()
+
+		// the server pings and reads what the client sends back without answering it
+		_ = conn.Send(protocol.TypePing, "p", nil)
+		for {
+			var msg protocol.Message
+			_ = ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			if err := ws.ReadJSON(&msg); err != nil {
+				close(replies)
+				return
+			}
+			replies <- msg
+			if msg.Type == protocol.TypePong {
+				// the pong is echoed to check that the client does not answer it
+				_ = conn.Send(protocol.TypePong, msg.RequestId, nil)
+				_ = conn.Send(protocol.TypeResult, "r", "done")
+			}
+		}
+	}))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	conn := protocol.NewConn(ws)
+	defer conn.Close()
+
+	// the client answers the ping and skips the pong
+	msg, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeResult, msg.Type)
+	assert.Equal(t, "r", msg.RequestId)
+
+	// the exchange ended with the single pong of the client
+	var received []protocol.Message
+	for msg := range replies {
+		received = append(received, msg)
+	}
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, protocol.TypePong, received[0].Type)
+		assert.Equal(t, "p", received[0].RequestId)
+	}
+}
@@ -0,0 +1,339 @@
+package shamir_mpc
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// StatisticalSecurity is the statistical security parameter of the masks of the values
+// opened by the comparisons and the truncations.
+const StatisticalSecurity = 40
+
+// MaxBits returns the largest bit length of the signed values that n parties can compare
+// or truncate: the values are masked with StatisticalSecurity more bits, which must not
+// exceed MPCPrime.
+func MaxBits(n int) int {
+	return prime.BitLen() - 2 - StatisticalSecurity - big.NewInt(int64(n)).BitLen()
+}
+
+// prefixOr replaces the shares of the bits of each row by the shares of the or of the
+// bits up to them, with the parallel prefix of Brent and Kung: 2m products of bits of rows
+// of m bits in 2 log(m) rounds.
+func (p *Party) prefixOr(rows [][]*big.Int) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	m := len(rows[0])
+	// or of the bits i-s and i for the i of each level
+	level := func(first, s int) error {
+		var x, y []*big.Int
+		for _, row := range rows {
+			for i := first; i < m; i += 2 * s {
+				x, y = append(x, row[i-s]), append(y, row[i])
+			}
+		}
+		if len(x) == 0 {
+			return nil
+		}
+		prod, err := p.Mul(x, y)
+		if err != nil {
+			return err
+		}
+		e := 0
+		for _, row := range rows {
+			for i := first; i < m; i += 2 * s {
+				row[i] = new(big.Int).Add(x[e], y[e])
+				row[i].Sub(row[i], prod[e])
+				row[i].Mod(row[i], prime)
+				e++
+			}
+		}
+		return nil
+	}
+	s := 1
+	for ; s < m; s *= 2 {
+		err := level(2*s-1, s)
+		if err != nil {
+			return err
+		}
+	}
+	for s /= 2; s >= 1; s /= 2 {
+		err := level(3*s-1, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bitLessThan returns the shares of [c < r] for the public values c and the values r
+// given by the shares of their m bits, least significant first. The first bit in which
+// they differ, from the most significant one, is found with a prefix or of their
+// exclusive or.
+func (p *Party) bitLessThan(cs []*big.Int, bits [][]*big.Int, m int) ([]*big.Int, error) {
+	oneF := big.NewInt(1)
+	// d_i = c_i xor r_i, from the most significant bit
+	or := make([][]*big.Int, len(cs))
+	for e, c := range cs {
+		or[e] = make([]*big.Int, m)
+		for i := 0; i < m; i++ {
+			if c.Bit(i) == 0 {
+				or[e][m-1-i] = bits[e][i]
+			} else {
+				or[e][m-1-i] = new(big.Int).Sub(oneF, bits[e][i])
+				or[e][m-1-i].Mod(or[e][m-1-i], prime)
+			}
+		}
+	}
+	err := p.prefixOr(or)
+	if err != nil {
+		return nil, err
+	}
+
+	// r is larger if c has a 0 bit where they first differ
+	res := make([]*big.Int, len(cs))
+	for e, c := range cs {
+		res[e] = new(big.Int)
+		for i := 0; i < m; i++ {
+			if c.Bit(i) == 0 {
+				res[e].Add(res[e], or[e][m-1-i])
+				if i < m-1 {
+					res[e].Sub(res[e], or[e][m-2-i])
+				}
+			}
+		}
+		res[e].Mod(res[e], prime)
+	}
+	return res, nil
+}
+
+// mod2m returns the shares of a mod 2^m for the values a with |a| < 2^(k-1). The value
+// a + 2^(k-1) + r is opened for a random r whose m lowest bits are shared bitwise and
+// whose other bits statistically hide a.
+func (p *Party) mod2m(a []*big.Int, k, m int) ([]*big.Int, error) {
+	if k > MaxBits(p.N) {
+		return nil, fmt.Errorf("values of %d bits cannot be compared, at most %d bits are supported", k,
+			MaxBits(p.N))
+	}
+	if m < 1 || m >= k {
+		return nil, fmt.Errorf("modulo 2^%d of values of %d bits not supported", m, k)
+	}
+	values, err := p.randomInts(len(a), k+StatisticalSecurity-m)
+	if err != nil {
+		return nil, err
+	}
+	bits, high, err := p.randomShares(len(a)*m, values)
+	if err != nil {
+		return nil, err
+	}
+	shift := pow2(k - 1)
+	bound := pow2(m)
+	masked := make([]*big.Int, len(a))
+	rBits := make([][]*big.Int, len(a))
+	rLow := make([]*big.Int, len(a))
+	for e := range a {
+		rBits[e] = bits[e*m : (e+1)*m]
+		rLow[e] = new(big.Int)
+		for i := m - 1; i >= 0; i-- {
+			rLow[e].Lsh(rLow[e], 1)
+			rLow[e].Add(rLow[e], rBits[e][i])
+		}
+		masked[e] = new(big.Int).Lsh(high[e], uint(m))
+		masked[e].Add(masked[e], rLow[e])
+		masked[e].Add(masked[e], a[e])
+		masked[e].Add(masked[e], shift)
+		masked[e].Mod(masked[e], prime)
+	}
+	c, err := p.Open(masked)
+	if err != nil {
+		return nil, err
+	}
+	for e := range c {
+		c[e].Mod(c[e], bound)
+	}
+	u, err := p.bitLessThan(c, rBits, m)
+	if err != nil {
+		return nil, err
+	}
+
+	// a mod 2^m = c mod 2^m - r mod 2^m + 2^m [c mod 2^m < r mod 2^m]
+	res := make([]*big.Int, len(a))
+	for e := range a {
+		res[e] = new(big.Int).Sub(c[e], rLow[e])
+		res[e].Add(res[e], new(big.Int).Mul(u[e], bound))
+		res[e].Mod(res[e], prime)
+	}
+	return res, nil
+}
+
+// Trunc returns the shares of floor(a / 2^m) for the values a with |a| < 2^(k-1).
+func (p *Party) Trunc(a []*big.Int, k, m int) ([]*big.Int, error) {
+	rem, err := p.mod2m(a, k, m)
+	if err != nil {
+		return nil, err
+	}
+	return Scale(Sub(a, rem), new(big.Int).ModInverse(pow2(m), prime)), nil
+}
+
+// LessThanZero returns the shares of [a < 0] for the values a with |a| < 2^(k-1).
+func (p *Party) LessThanZero(a []*big.Int, k int) ([]*big.Int, error) {
+	// floor(a / 2^(k-1)) is -1 for negative values and 0 otherwise
+	res, err := p.Trunc(a, k, k-1)
+	if err != nil {
+		return nil, err
+	}
+	return Scale(res, Field(-1)), nil
+}
+
+// LessThan returns the shares of [x < y] for the values with |x - y| < 2^(k-1).
+func (p *Party) LessThan(x, y []*big.Int, k int) ([]*big.Int, error) {
+	return p.LessThanZero(Sub(x, y), k)
+}
+
+// TruncPr returns the shares of floor(a / 2^m) + e for the values a with |a| < 2^(k-1),
+// where the error 0 <= e <= N is random. The value a + 2^(k-1) + r is opened for the sum r
+// of random values of all the parties, whose m lowest bits are shared too; their sum may
+// carry into the higher bits.
+func (p *Party) TruncPr(a []*big.Int, k, m int) ([]*big.Int, error) {
+	if k > MaxBits(p.N) {
+		return nil, fmt.Errorf("values of %d bits cannot be truncated, at most %d bits are supported", k,
+			MaxBits(p.N))
+	}
+	if m < 1 || m >= k {
+		return nil, fmt.Errorf("truncation by %d bits of values of %d bits not supported", m, k)
+	}
+	full, err := p.randomInts(len(a), k+StatisticalSecurity)
+	if err != nil {
+		return nil, err
+	}
+	bound := pow2(m)
+	values := make([]*big.Int, 0, 2*len(a))
+	for _, r := range full {
+		values = append(values, r, new(big.Int).Mod(r, bound))
+	}
+	_, r, err := p.randomShares(0, values)
+	if err != nil {
+		return nil, err
+	}
+	masked := make([]*big.Int, len(a))
+	shift := pow2(k - 1)
+	for e := range a {
+		masked[e] = new(big.Int).Add(a[e], r[2*e])
+		masked[e].Add(masked[e], shift)
+		masked[e].Mod(masked[e], prime)
+	}
+	c, err := p.Open(masked)
+	if err != nil {
+		return nil, err
+	}
+
+	// (a + 2^(k-1) - c mod 2^m + r mod 2^m) / 2^m - 2^(k-1-m)
+	inv := new(big.Int).ModInverse(bound, prime)
+	res := make([]*big.Int, len(a))
+	for e := range a {
+		res[e] = new(big.Int).Add(a[e], shift)
+		res[e].Sub(res[e], c[e].Mod(c[e], bound))
+		res[e].Add(res[e], r[2*e+1])
+		res[e].Mul(res[e], inv)
+		res[e].Sub(res[e], pow2(k-1-m))
+		res[e].Mod(res[e], prime)
+	}
+	return res, nil
+}
+
+// FixMul returns the shares of the products of the fixed point values with f fractional
+// bits whose products are below 2^(k-1), truncated with TruncPr as the fixed point numbers
+// of SCALE-MAMBA.
+func (p *Party) FixMul(x, y []*big.Int, k, f int) ([]*big.Int, error) {
+	prod, err := p.Mul(x, y)
+	if err != nil {
+		return nil, err
+	}
+	return p.TruncPr(prod, k+f, f)
+}
+
+// Div returns the shares of floor(a / b) for the values a and 0 < b < 2^bBits with
+// |a| < b 2^(qBits-1). The bits of the quotient are found one after the other, from the
+// most significant one, by comparing a with the product of b and the quotient so far.
+// If b is 0 the result is meaningless.
+func (p *Party) Div(a, b []*big.Int, qBits, bBits int) ([]*big.Int, error) {
+	return p.div(a, b, nil, qBits, bBits)
+}
+
+// DivPublic returns the shares of floor(a / d) for the public d > 0 and the values a with
+// |a| < d 2^(qBits-1).
+func (p *Party) DivPublic(a []*big.Int, d int64, qBits int) ([]*big.Int, error) {
+	if d <= 0 {
+		return nil, fmt.Errorf("division by %d", d)
+	}
+	return p.div(a, nil, big.NewInt(d), qBits, big.NewInt(d).BitLen())
+}
+
+// div divides by the shared b or, if it is nil, by the public d.
+func (p *Party) div(a, b []*big.Int, d *big.Int, qBits, bBits int) ([]*big.Int, error) {
+	if b == nil {
+		b = make([]*big.Int, len(a))
+		for e := range b {
+			b[e] = d
+		}
+	}
+	// the quotient of A = a + b 2^(qBits-1) >= 0 is below 2^qBits and A and the products
+	// of b with it are below 2^(qBits+bBits)
+	k := qBits + bBits + 2
+	shifted := Add(a, Scale(b, pow2(qBits-1)))
+	q := make([]*big.Int, len(a))
+	prod := make([]*big.Int, len(a))
+	for e := range a {
+		q[e], prod[e] = new(big.Int), new(big.Int)
+	}
+	for i := qBits - 1; i >= 0; i-- {
+		step := Scale(b, pow2(i))
+		less, err := p.LessThan(shifted, Add(prod, step), k)
+		if err != nil {
+			return nil, err
+		}
+		// the bit is set if (q + 2^i) b <= A
+		bit := AddConst(Scale(less, Field(-1)), one)
+		q = Add(q, Scale(bit, pow2(i)))
+		if d != nil {
+			prod = Add(prod, Scale(bit, new(big.Int).Lsh(d, uint(i))))
+			continue
+		}
+		inc, err := p.Mul(bit, step)
+		if err != nil {
+			return nil, err
+		}
+		prod = Add(prod, inc)
+	}
+	return AddConst(q, new(big.Int).Neg(pow2(qBits-1))), nil
+}
+
+// FixSqrt returns the shares of the square roots of the fixed point values 0 <= v < 2^(k-1)
+// with f fractional bits, floor(sqrt(v 2^f)). The bits of the root are found one after the
+// other, from the most significant one, by comparing v 2^f with the square of the root so
+// far.
+func (p *Party) FixSqrt(v []*big.Int, k, f int) ([]*big.Int, error) {
+	h := (k + f) / 2
+	w := Scale(v, pow2(f))
+	s := make([]*big.Int, len(v))
+	sq := make([]*big.Int, len(v))
+	for e := range v {
+		s[e], sq[e] = new(big.Int), new(big.Int)
+	}
+	for i := h - 1; i >= 0; i-- {
+		// (s + 2^i)^2 = s^2 + 2^(i+1) s + 2^(2i)
+		inc := AddConst(Scale(s, pow2(i+1)), pow2(2*i))
+		less, err := p.LessThan(w, Add(sq, inc), k+f+2)
+		if err != nil {
+			return nil, err
+		}
+		bit := AddConst(Scale(less, Field(-1)), one)
+		s = Add(s, Scale(bit, pow2(i)))
+		prod, err := p.Mul(bit, inc)
+		if err != nil {
+			return nil, err
+		}
+		sq = Add(sq, prod)
+	}
+	return s, nil
+}
@@ -0,0 +1,300 @@
+package shamir_mpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/krakenh2020/MPCService/data_management"
+)
+
+// Network exchanges vectors of field elements among the parties of a computation.
+type Network interface {
+	// Exchange sends msgs[j] to the party j and returns the messages received from each
+	// party; the entry of the party itself is not used.
+	Exchange(msgs [][]*big.Int) ([][]*big.Int, error)
+	Close() error
+}
+
+// localNetwork connects parties of the same process with channels.
+type localNetwork struct {
+	id    int
+	chans [][]chan []*big.Int // chans[i][j] carries the messages from i to j
+}
+
+// NewLocalNetworks returns the networks of n parties of the same process.
+func NewLocalNetworks(n int) []Network {
+	chans := make([][]chan []*big.Int, n)
+	for i := range chans {
+		chans[i] = make([]chan []*big.Int, n)
+		for j := range chans[i] {
+			chans[i][j] = make(chan []*big.Int, 1)
+		}
+	}
+	res := make([]Network, n)
+	for i := range res {
+		res[i] = &localNetwork{id: i, chans: chans}
+	}
+	return res
+}
+
+func (l *localNetwork) Exchange(msgs [][]*big.Int) ([][]*big.Int, error) {
+	for j := range l.chans {
+		if j != l.id {
+			msg := make([]*big.Int, len(msgs[j]))
+			for e, x := range msgs[j] {
+				msg[e] = new(big.Int).Set(x)
+			}
+			l.chans[l.id][j] <- msg
+		}
+	}
+	recv := make([][]*big.Int, len(l.chans))
+	for i := range l.chans {
+		if i != l.id {
+			recv[i] = <-l.chans[i][l.id]
+		}
+	}
+	return recv, nil
+}
+
+func (l *localNetwork) Close() error {
+	return nil
+}
+
+// maxMessage bounds the length of the messages read from the other parties.
+const maxMessage = 1 << 30
+
+// tlsNetwork connects the parties with mutually authenticated TLS connections.
+type tlsNetwork struct {
+	id      int
+	conns   []*tls.Conn
+	readers []*bufio.Reader
+	timeout time.Duration
+}
+
+// Connect connects the party id to the other parties listening on addrs, given as
+// host:port, with TLS: the party listens on the port of its address for the parties with
+// larger indexes and connects to the ones with smaller indexes. It authenticates with
+// cert and accepts only the PEM encoded certificates peers of the other parties. A
+// computation fails if it waits for another party longer than timeout.
+func Connect(id int, addrs []string, cert tls.Certificate, peers [][]byte, timeout time.Duration) (Network, error) {
+	n := len(addrs)
+	if len(peers) != n || id < 0 || id >= n {
+		return nil, fmt.Errorf("%d addresses and %d certificates given for party %d", n, len(peers), id)
+	}
+	peerCerts := make([][]byte, n)
+	for i, e := range peers {
+		block, _ := pem.Decode(e)
+		if block == nil {
+			return nil, fmt.Errorf("certificate of party %d cannot be read", i)
+		}
+		peerCerts[i] = block.Bytes
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAnyClientCert,
+		// the certificates are pinned instead of verified against a root
+		InsecureSkipVerify: true, MinVersion: tls.VersionTLS12}
+
+	_, port, err := net.SplitHostPort(addrs[id])
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	deadline := time.Now().Add(timeout)
+
+	nw := &tlsNetwork{id: id, conns: make([]*tls.Conn, n), readers: make([]*bufio.Reader, n), timeout: timeout}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	// the parties with smaller indexes listen
+	for j := 0; j < id; j++ {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			conn, err := dial(addrs[j], config, deadline)
+			if err == nil {
+				err = conn.SetDeadline(deadline)
+			}
+			if err == nil {
+				err = checkPeer(conn, peerCerts[j])
+			}
+			if err == nil {
+				_, err = conn.Write([]byte{byte(id >> 8), byte(id)})
+			}
+			if err != nil {
+				errs <- fmt.Errorf("connecting to party %d: %s", j, err)
+				return
+			}
+			mu.Lock()
+			nw.conns[j] = conn
+			mu.Unlock()
+		}(j)
+	}
+	// the parties with larger indexes connect
+	for accepted := id + 1; accepted < n; accepted++ {
+		err = listener.(*net.TCPListener).SetDeadline(deadline)
+		if err != nil {
+			break
+		}
+		var raw net.Conn
+		raw, err = listener.Accept()
+		if err != nil {
+			break
+		}
+		conn := tls.Server(raw, config)
+		err = conn.SetDeadline(deadline)
+		var index [2]byte
+		if err == nil {
+			_, err = io.ReadFull(conn, index[:])
+		}
+		j := int(index[0])<<8 | int(index[1])
+		if err == nil && (j <= id || j >= n || nw.conns[j] != nil) {
+			err = fmt.Errorf("unexpected party %d", j)
+		}
+		if err == nil {
+			err = checkPeer(conn, peerCerts[j])
+		}
+		if err != nil {
+			_ = conn.Close()
+			accepted--
+			if time.Now().Before(deadline) {
+				continue
+			}
+			break
+		}
+		mu.Lock()
+		nw.conns[j] = conn
+		mu.Unlock()
+	}
+	wg.Wait()
+	close(errs)
+	if err == nil {
+		err = <-errs
+	}
+	if err != nil {
+		_ = nw.Close()
+		return nil, err
+	}
+	for j, conn := range nw.conns {
+		if conn != nil {
+			nw.readers[j] = bufio.NewReader(conn)
+		}
+	}
+
+	return nw, nil
+}
+
+// dial connects to the address until the deadline, while the party there is not listening.
+func dial(addr string, config *tls.Config, deadline time.Time) (*tls.Conn, error) {
+	for {
+		dialer := &net.Dialer{Deadline: deadline}
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+		if err == nil || time.Now().Add(100*time.Millisecond).After(deadline) {
+			return conn, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// checkPeer completes the handshake and checks that the peer has the certificate.
+func checkPeer(conn *tls.Conn, cert []byte) error {
+	err := conn.Handshake()
+	if err != nil {
+		return err
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 || !bytes.Equal(certs[0].Raw, cert) {
+		return fmt.Errorf("unexpected certificate")
+	}
+	return nil
+}
+
+func (nw *tlsNetwork) Exchange(msgs [][]*big.Int) ([][]*big.Int, error) {
+	recv := make([][]*big.Int, len(nw.conns))
+	errs := make(chan error, 2*len(nw.conns))
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(nw.timeout)
+	for j, conn := range nw.conns {
+		if conn == nil {
+			continue
+		}
+		err := conn.SetDeadline(deadline)
+		if err != nil {
+			return nil, err
+		}
+		wg.Add(2)
+		go func(j int, conn *tls.Conn) {
+			defer wg.Done()
+			errs <- writeMessage(conn, msgs[j])
+		}(j, conn)
+		go func(j int) {
+			defer wg.Done()
+			var err error
+			recv[j], err = readMessage(nw.readers[j])
+			errs <- err
+		}(j)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return recv, nil
+}
+
+// writeMessage writes the length of the encoded vector followed by its encoding, see
+// data_management.EncodeVec.
+func writeMessage(w io.Writer, msg []*big.Int) error {
+	b, err := data_management.EncodeVec(msg, data_management.DefaultEncoding)
+	if err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(b)))
+	_, err = w.Write(append(length[:], b...))
+	return err
+}
+
+// readMessage reads a vector written by writeMessage.
+func readMessage(r io.Reader) ([]*big.Int, error) {
+	var length [4]byte
+	_, err := io.ReadFull(r, length[:])
+	if err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(length[:])
+	if l > maxMessage {
+		return nil, fmt.Errorf("message of %d bytes too long", l)
+	}
+	b := make([]byte, l)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+	return data_management.DecodeVec(b)
+}
+
+func (nw *tlsNetwork) Close() error {
+	var err error
+	for _, conn := range nw.conns {
+		if conn != nil {
+			if e := conn.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
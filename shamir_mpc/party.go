@@ -0,0 +1,333 @@
+// Package shamir_mpc evaluates computations among n parties holding Shamir shares with
+// threshold t of the inputs, secure against t < n/2 semi-honest parties. The shares are
+// the ones of data_management: f(i+1) at the party with index i of a polynomial f of
+// degree t over MPCPrime. It is a reference implementation of the programs of
+// SCALE-MAMBA that needs no other software.
+package shamir_mpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/krakenh2020/MPCService/data_management"
+)
+
+var prime = data_management.MPCPrime
+var one = big.NewInt(1)
+
+// Party is a party of a computation with index Id among N parties sharing with
+// threshold T; it exchanges the messages of the protocols over its network.
+type Party struct {
+	Id  int
+	N   int
+	T   int
+	net Network
+	// Lagrange coefficients at 0 of the shares of the first T+1 and 2T+1 parties
+	openCoefs []*big.Int
+	mulCoefs  []*big.Int
+	rand      io.Reader
+}
+
+// NewParty returns the party with the index id of a computation among n parties sharing
+// with threshold t over the network.
+func NewParty(id, n, t int, net Network) (*Party, error) {
+	err := data_management.CheckThreshold(n, t)
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= n {
+		return nil, fmt.Errorf("party %d of %d parties does not exist", id, n)
+	}
+
+	return &Party{Id: id, N: n, T: t, net: net, openCoefs: lagrange(t + 1), mulCoefs: lagrange(2*t + 1),
+		rand: bufio.NewReaderSize(rand.Reader, 1<<16)}, nil
+}
+
+// lagrange returns the Lagrange coefficients at 0 of the points 1, ..., n.
+func lagrange(n int) []*big.Int {
+	points := make([]int64, n)
+	for i := range points {
+		points[i] = int64(i + 1)
+	}
+	return data_management.LagrangeCoefficients(points, 0)
+}
+
+// Field returns the field element representing the value.
+func Field(v int64) *big.Int {
+	return new(big.Int).Mod(big.NewInt(v), prime)
+}
+
+// Signed returns the value in (-MPCPrime/2, MPCPrime/2] represented by the field element.
+func Signed(x *big.Int) *big.Int {
+	res := new(big.Int).Mod(x, prime)
+	if res.Cmp(data_management.MPCPrimeHalf) > 0 {
+		res.Sub(res, prime)
+	}
+	return res
+}
+
+// pow2 returns 2^e.
+func pow2(e int) *big.Int {
+	return new(big.Int).Lsh(one, uint(e))
+}
+
+// Add returns the shares of the sums of the shared values.
+func Add(x, y []*big.Int) []*big.Int {
+	res := make([]*big.Int, len(x))
+	for i := range x {
+		res[i] = new(big.Int).Add(x[i], y[i])
+		res[i].Mod(res[i], prime)
+	}
+	return res
+}
+
+// Sub returns the shares of the differences of the shared values.
+func Sub(x, y []*big.Int) []*big.Int {
+	res := make([]*big.Int, len(x))
+	for i := range x {
+		res[i] = new(big.Int).Sub(x[i], y[i])
+		res[i].Mod(res[i], prime)
+	}
+	return res
+}
+
+// AddConst returns the shares of the shared values plus the public value c.
+func AddConst(x []*big.Int, c *big.Int) []*big.Int {
+	res := make([]*big.Int, len(x))
+	for i := range x {
+		res[i] = new(big.Int).Add(x[i], c)
+		res[i].Mod(res[i], prime)
+	}
+	return res
+}
+
+// Scale returns the shares of the shared values multiplied by the public value c.
+func Scale(x []*big.Int, c *big.Int) []*big.Int {
+	res := make([]*big.Int, len(x))
+	for i := range x {
+		res[i] = new(big.Int).Mul(x[i], c)
+		res[i].Mod(res[i], prime)
+	}
+	return res
+}
+
+// Sum returns the share of the sum of the shared values.
+func Sum(x []*big.Int) *big.Int {
+	res := new(big.Int)
+	for _, e := range x {
+		res.Add(res, e)
+	}
+	return res.Mod(res, prime)
+}
+
+// random returns a uniformly random value below bound, a field element if bound is nil.
+func (p *Party) random(bound *big.Int) (*big.Int, error) {
+	if bound == nil {
+		bound = prime
+	}
+	return rand.Int(p.rand, bound)
+}
+
+// deal returns the shares of the values for each party.
+func (p *Party) deal(values []*big.Int) ([][]*big.Int, error) {
+	// f(x) = value + a_1 x + ... + a_t x^t
+	coefs := make([]*big.Int, p.T)
+	res := make([][]*big.Int, p.N)
+	for j := range res {
+		res[j] = make([]*big.Int, len(values))
+	}
+	var err error
+	for e, val := range values {
+		for k := range coefs {
+			coefs[k], err = p.random(nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for j := 0; j < p.N; j++ {
+			// Horner's rule
+			x := big.NewInt(int64(j + 1))
+			y := new(big.Int)
+			for k := p.T - 1; k >= 0; k-- {
+				y.Add(y, coefs[k])
+				y.Mul(y, x)
+				y.Mod(y, prime)
+			}
+			y.Add(y, val)
+			res[j][e] = y.Mod(y, prime)
+		}
+	}
+	return res, nil
+}
+
+// exchange sends msgs[j] to the party j and returns the messages of all the parties, its
+// own message at its index; it checks that the messages of the parties in from have the
+// length.
+func (p *Party) exchange(msgs [][]*big.Int, from, length int) ([][]*big.Int, error) {
+	recv, err := p.net.Exchange(msgs)
+	if err != nil {
+		return nil, err
+	}
+	recv[p.Id] = msgs[p.Id]
+	for i := 0; i < from; i++ {
+		if len(recv[i]) != length {
+			return nil, fmt.Errorf("party %d sent %d values, %d expected", i, len(recv[i]), length)
+		}
+	}
+	return recv, nil
+}
+
+// Open reveals the shared values, as field elements, to all the parties.
+func (p *Party) Open(x []*big.Int) ([]*big.Int, error) {
+	msgs := make([][]*big.Int, p.N)
+	for j := range msgs {
+		msgs[j] = x
+	}
+	recv, err := p.exchange(msgs, p.T+1, len(x))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*big.Int, len(x))
+	for e := range x {
+		res[e] = new(big.Int)
+		for i, c := range p.openCoefs {
+			res[e].Add(res[e], new(big.Int).Mul(c, recv[i][e]))
+		}
+		res[e].Mod(res[e], prime)
+	}
+	return res, nil
+}
+
+// Mul returns the shares of the products of the shared values. The products of the shares
+// lie on polynomials of degree 2t, which the first 2t+1 parties share again with degree t
+// and the parties interpolate at 0.
+func (p *Party) Mul(x, y []*big.Int) ([]*big.Int, error) {
+	if len(x) != len(y) {
+		return nil, fmt.Errorf("multiplying %d values with %d values", len(x), len(y))
+	}
+	msgs := make([][]*big.Int, p.N)
+	if p.Id < len(p.mulCoefs) {
+		prod := make([]*big.Int, len(x))
+		for e := range x {
+			prod[e] = new(big.Int).Mul(x[e], y[e])
+			prod[e].Mod(prod[e], prime)
+		}
+		var err error
+		msgs, err = p.deal(prod)
+		if err != nil {
+			return nil, err
+		}
+	}
+	recv, err := p.exchange(msgs, len(p.mulCoefs), len(x))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*big.Int, len(x))
+	for e := range x {
+		res[e] = new(big.Int)
+		for i, c := range p.mulCoefs {
+			res[e].Add(res[e], new(big.Int).Mul(c, recv[i][e]))
+		}
+		res[e].Mod(res[e], prime)
+	}
+	return res, nil
+}
+
+// Broadcast sends the public values of the party from to all the parties and returns them.
+func (p *Party) Broadcast(from int, values []*big.Int) ([]*big.Int, error) {
+	msgs := make([][]*big.Int, p.N)
+	if p.Id == from {
+		for j := range msgs {
+			msgs[j] = values
+		}
+	}
+	recv, err := p.net.Exchange(msgs)
+	if err != nil {
+		return nil, err
+	}
+	if p.Id == from {
+		return values, nil
+	}
+	return recv[from], nil
+}
+
+// randomInts returns count random integers below 2^bits.
+func (p *Party) randomInts(count, bits int) ([]*big.Int, error) {
+	bound := pow2(bits)
+	res := make([]*big.Int, count)
+	for e := range res {
+		var err error
+		res[e], err = p.random(bound)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// randomShares returns the shares of numBits random bits and of the sums of the values of
+// all the parties, which each party chooses at random. Each of the first t+1 parties deals
+// random bits, which are joined with exclusive or so that no t parties know them.
+func (p *Party) randomShares(numBits int, values []*big.Int) ([]*big.Int, []*big.Int, error) {
+	dealt := make([]*big.Int, 0, numBits+len(values))
+	if p.Id <= p.T {
+		for e := 0; e < numBits; e++ {
+			b, err := p.random(big.NewInt(2))
+			if err != nil {
+				return nil, nil, err
+			}
+			dealt = append(dealt, b)
+		}
+	}
+	dealt = append(dealt, values...)
+	msgs, err := p.deal(dealt)
+	if err != nil {
+		return nil, nil, err
+	}
+	recv, err := p.net.Exchange(msgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	recv[p.Id] = msgs[p.Id]
+
+	sums := make([]*big.Int, len(values))
+	for e := range sums {
+		sums[e] = new(big.Int)
+	}
+	var bits []*big.Int
+	for i := 0; i < p.N; i++ {
+		expected := len(values)
+		if i <= p.T {
+			expected += numBits
+		}
+		if len(recv[i]) != expected {
+			return nil, nil, fmt.Errorf("party %d sent %d random values, %d expected", i, len(recv[i]), expected)
+		}
+		offset := 0
+		if i <= p.T {
+			offset = numBits
+			if bits == nil {
+				bits = recv[i][:numBits]
+			} else {
+				// b xor c = b + c - 2bc
+				prod, err := p.Mul(bits, recv[i][:numBits])
+				if err != nil {
+					return nil, nil, err
+				}
+				bits = Sub(Add(bits, recv[i][:numBits]), Scale(prod, big.NewInt(2)))
+			}
+		}
+		for e := range sums {
+			sums[e].Add(sums[e], recv[i][offset+e])
+		}
+	}
+	for e := range sums {
+		sums[e].Mod(sums[e], prime)
+	}
+	return bits, sums, nil
+}
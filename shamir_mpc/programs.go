@@ -0,0 +1,370 @@
+package shamir_mpc
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/krakenh2020/MPCService/data_management"
+)
+
+// Params are the parameters of a program: the fixed point representation of the values
+// and, for k-means, the number of clusters.
+type Params struct {
+	FixedPoint data_management.FixedPoint
+	Clusters   int
+}
+
+// Program evaluates a function on the shares of a matrix of fixed point values, given by
+// rows, and returns the shares of its results in the layout of the results of the MAMBA
+// program of the same name, see data_management.ResultsToCsvText.
+type Program func(p *Party, x [][]*big.Int, params Params) ([]*big.Int, error)
+
+// Programs are the programs that can be evaluated, by name.
+var Programs = map[string]Program{
+	"avg":     Avg,
+	"max":     Max,
+	"stats":   Stats,
+	"k-means": KMeans,
+}
+
+// KMeansIterations is the number of iterations of k-means.
+const KMeansIterations = 10
+
+// ProgramNames returns the names of the programs.
+func ProgramNames() []string {
+	names := make([]string, 0, len(Programs))
+	for name := range Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckParams returns an error if n parties cannot evaluate the program on rows of cols
+// values with the parameters: the comparisons of the program must fit MaxBits.
+func CheckParams(program string, rows, cols, n int, params Params) error {
+	if _, ok := Programs[program]; !ok {
+		return fmt.Errorf("program %s not supported", program)
+	}
+	if rows < 1 || cols < 1 {
+		return fmt.Errorf("%d rows of %d values cannot be computed on", rows, cols)
+	}
+	fp := params.FixedPoint.OrDefault()
+	err := fp.Check()
+	if err != nil {
+		return err
+	}
+	rowBits := big.NewInt(int64(rows)).BitLen()
+	bits := fp.K + 1
+	switch program {
+	case "avg":
+		bits = fp.K + rowBits + 2
+	case "stats":
+		bits = maxInt(fp.K+rowBits+2, fp.K+fp.F+2)
+	case "k-means":
+		if params.Clusters < 1 || params.Clusters > rows {
+			return fmt.Errorf("%d clusters of %d rows cannot be computed", params.Clusters, rows)
+		}
+		bits = maxInt(fp.K+rowBits+2, fp.K+fp.F+big.NewInt(int64(cols)).BitLen())
+	}
+	if bits > MaxBits(n) {
+		return fmt.Errorf("values of %d bits needed, at most %d bits are supported", bits, MaxBits(n))
+	}
+	return nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// column returns the shares of the j-th values of the rows.
+func column(x [][]*big.Int, j int) []*big.Int {
+	res := make([]*big.Int, len(x))
+	for i, row := range x {
+		res[i] = row[j]
+	}
+	return res
+}
+
+// columnSums returns the shares of the sums of the columns.
+func columnSums(x [][]*big.Int) []*big.Int {
+	res := make([]*big.Int, len(x[0]))
+	for j := range res {
+		res[j] = Sum(column(x, j))
+	}
+	return res
+}
+
+// Avg returns the averages of the columns.
+func Avg(p *Party, x [][]*big.Int, params Params) ([]*big.Int, error) {
+	fp := params.FixedPoint.OrDefault()
+	return p.DivPublic(columnSums(x), int64(len(x)), fp.K)
+}
+
+// minMax returns the minima and the maxima of the columns, comparing the values in pairs.
+func minMax(p *Party, x [][]*big.Int, k int, withMin bool) ([]*big.Int, []*big.Int, error) {
+	cols := len(x[0])
+	maxima := make([][]*big.Int, cols)
+	minima := make([][]*big.Int, cols)
+	for j := range maxima {
+		maxima[j] = column(x, j)
+		minima[j] = maxima[j]
+	}
+	for len(maxima[0]) > 1 {
+		half := len(maxima[0]) / 2
+		var a, b []*big.Int
+		for j := range maxima {
+			a = append(a, maxima[j][:half]...)
+			b = append(b, maxima[j][half:2*half]...)
+			if withMin {
+				a = append(a, minima[j][:half]...)
+				b = append(b, minima[j][half:2*half]...)
+			}
+		}
+		less, err := p.LessThan(a, b, k+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		// max(a, b) = a + [a < b] (b - a), min(a, b) = b - [a < b] (b - a)
+		prod, err := p.Mul(less, Sub(b, a))
+		if err != nil {
+			return nil, nil, err
+		}
+		larger := Add(a, prod)
+		smaller := Sub(b, prod)
+		offset := 0
+		for j := range maxima {
+			rest := maxima[j][2*half:]
+			maxima[j] = append(append([]*big.Int{}, larger[offset:offset+half]...), rest...)
+			offset += half
+			if withMin {
+				rest = minima[j][2*half:]
+				minima[j] = append(append([]*big.Int{}, smaller[offset:offset+half]...), rest...)
+				offset += half
+			}
+		}
+	}
+	resMax := make([]*big.Int, cols)
+	resMin := make([]*big.Int, cols)
+	for j := range maxima {
+		resMax[j], resMin[j] = maxima[j][0], minima[j][0]
+	}
+	return resMin, resMax, nil
+}
+
+// Max returns the maxima of the columns.
+func Max(p *Party, x [][]*big.Int, params Params) ([]*big.Int, error) {
+	_, res, err := minMax(p, x, params.FixedPoint.OrDefault().K, false)
+	return res, err
+}
+
+// Stats returns the average, the standard deviation, the minimum and the maximum of each
+// column.
+func Stats(p *Party, x [][]*big.Int, params Params) ([]*big.Int, error) {
+	fp := params.FixedPoint.OrDefault()
+	rows, cols := len(x), len(x[0])
+	avg, err := p.DivPublic(columnSums(x), int64(rows), fp.K)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]*big.Int, 0, rows*cols)
+	for j := 0; j < cols; j++ {
+		diffs = append(diffs, AddConst(column(x, j), new(big.Int).Neg(avg[j]))...)
+	}
+	squares, err := p.FixMul(diffs, diffs, fp.K, fp.F)
+	if err != nil {
+		return nil, err
+	}
+	sums := make([]*big.Int, cols)
+	for j := range sums {
+		sums[j] = Sum(squares[j*rows : (j+1)*rows])
+	}
+	variance, err := p.DivPublic(sums, int64(rows), fp.K)
+	if err != nil {
+		return nil, err
+	}
+	std, err := p.FixSqrt(variance, fp.K, fp.F)
+	if err != nil {
+		return nil, err
+	}
+	minima, maxima, err := minMax(p, x, fp.K, true)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*big.Int, 0, 4*cols)
+	for j := 0; j < cols; j++ {
+		res = append(res, avg[j], std[j], minima[j], maxima[j])
+	}
+	return res, nil
+}
+
+// KMeans returns the centers of params.Clusters clusters of the rows, one after the other,
+// followed by the sizes of the clusters. The centers start at rows chosen at random by the
+// first party and are updated KMeansIterations times; a cluster that becomes empty keeps
+// its center.
+func KMeans(p *Party, x [][]*big.Int, params Params) ([]*big.Int, error) {
+	fp := params.FixedPoint.OrDefault()
+	rows, cols, k := len(x), len(x[0]), params.Clusters
+	if k < 1 || k > rows {
+		return nil, fmt.Errorf("%d clusters of %d rows cannot be computed", k, rows)
+	}
+	rowBound := big.NewInt(int64(rows))
+	indexes := make([]*big.Int, k)
+	for i := range indexes {
+		var err error
+		indexes[i], err = p.random(rowBound)
+		if err != nil {
+			return nil, err
+		}
+	}
+	indexes, err := p.Broadcast(0, indexes)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) != k {
+		return nil, fmt.Errorf("%d initial centers received, %d expected", len(indexes), k)
+	}
+	centers := make([][]*big.Int, k)
+	for c, e := range indexes {
+		if !e.IsInt64() || e.Int64() < 0 || e.Int64() >= int64(rows) {
+			return nil, fmt.Errorf("initial center %s is not a row", e)
+		}
+		centers[c] = append([]*big.Int{}, x[e.Int64()]...)
+	}
+
+	var sizes []*big.Int
+	for iter := 0; iter < KMeansIterations; iter++ {
+		// squared distances of the rows to the centers
+		diffs := make([]*big.Int, 0, rows*k*cols)
+		for i := 0; i < rows; i++ {
+			for c := 0; c < k; c++ {
+				diffs = append(diffs, Sub(x[i], centers[c])...)
+			}
+		}
+		squares, err := p.Mul(diffs, diffs)
+		if err != nil {
+			return nil, err
+		}
+		dist := make([]*big.Int, rows*k)
+		for e := range dist {
+			dist[e] = Sum(squares[e*cols : (e+1)*cols])
+		}
+		dist, err = p.TruncPr(dist, fp.K+fp.F+big.NewInt(int64(cols)).BitLen(), fp.F)
+		if err != nil {
+			return nil, err
+		}
+
+		// indicators of the closest center of each row
+		indicators := make([][]*big.Int, rows)
+		minDist := make([]*big.Int, rows)
+		for i := range indicators {
+			indicators[i] = make([]*big.Int, k)
+			indicators[i][0] = big.NewInt(1)
+			for c := 1; c < k; c++ {
+				indicators[i][c] = new(big.Int)
+			}
+			minDist[i] = dist[i*k]
+		}
+		for c := 1; c < k; c++ {
+			cand := make([]*big.Int, rows)
+			for i := range cand {
+				cand[i] = dist[i*k+c]
+			}
+			closer, err := p.LessThan(cand, minDist, fp.K+1)
+			if err != nil {
+				return nil, err
+			}
+			// the new minimum and the indicators of the previous centers are multiplied by it
+			a := make([]*big.Int, 0, rows*(c+1))
+			b := make([]*big.Int, 0, rows*(c+1))
+			for i := 0; i < rows; i++ {
+				a = append(a, closer[i])
+				b = append(b, new(big.Int).Sub(cand[i], minDist[i]))
+				for l := 0; l < c; l++ {
+					a = append(a, closer[i])
+					b = append(b, indicators[i][l])
+				}
+			}
+			prod, err := p.Mul(a, b)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < rows; i++ {
+				offset := i * (c + 1)
+				minDist[i] = Add([]*big.Int{minDist[i]}, prod[offset:offset+1])[0]
+				for l := 0; l < c; l++ {
+					indicators[i][l] = Sub(indicators[i][l:l+1], prod[offset+1+l:offset+2+l])[0]
+				}
+				indicators[i][c] = closer[i]
+			}
+		}
+
+		// sums and sizes of the clusters
+		a := make([]*big.Int, 0, rows*k*cols)
+		b := make([]*big.Int, 0, rows*k*cols)
+		for i := 0; i < rows; i++ {
+			for c := 0; c < k; c++ {
+				for j := 0; j < cols; j++ {
+					a = append(a, x[i][j])
+					b = append(b, indicators[i][c])
+				}
+			}
+		}
+		prod, err := p.Mul(a, b)
+		if err != nil {
+			return nil, err
+		}
+		sums := make([]*big.Int, k*cols)
+		divisors := make([]*big.Int, k*cols)
+		sizes = make([]*big.Int, k)
+		for c := 0; c < k; c++ {
+			sizes[c] = Sum(column(indicators, c))
+			for j := 0; j < cols; j++ {
+				e := c*cols + j
+				sums[e] = new(big.Int)
+				for i := 0; i < rows; i++ {
+					sums[e].Add(sums[e], prod[(i*k+c)*cols+j])
+				}
+				sums[e].Mod(sums[e], prime)
+				divisors[e] = sizes[c]
+			}
+		}
+		means, err := p.Div(sums, divisors, fp.K, rowBound.BitLen())
+		if err != nil {
+			return nil, err
+		}
+		empty, err := p.LessThanZero(AddConst(sizes, Field(-1)), rowBound.BitLen()+2)
+		if err != nil {
+			return nil, err
+		}
+
+		// center = mean + [empty] (center - mean)
+		old := make([]*big.Int, 0, k*cols)
+		emptyCols := make([]*big.Int, 0, k*cols)
+		for c := 0; c < k; c++ {
+			old = append(old, centers[c]...)
+			for j := 0; j < cols; j++ {
+				emptyCols = append(emptyCols, empty[c])
+			}
+		}
+		keep, err := p.Mul(emptyCols, Sub(old, means))
+		if err != nil {
+			return nil, err
+		}
+		updated := Add(means, keep)
+		for c := 0; c < k; c++ {
+			centers[c] = updated[c*cols : (c+1)*cols]
+		}
+	}
+
+	res := make([]*big.Int, 0, k*cols+k)
+	for c := 0; c < k; c++ {
+		res = append(res, centers[c]...)
+	}
+	return append(res, Scale(sizes, pow2(fp.F))...), nil
+}
@@ -0,0 +1,184 @@
+package shamir_mpc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/shamir_mpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// run evaluates the program among n parties sharing the rows with threshold t over the
+// networks and returns the joined results.
+func run(t *testing.T, program string, rows [][]float64, n, th int, params shamir_mpc.Params,
+	nets []shamir_mpc.Network) []float64 {
+	fp := params.FixedPoint.OrDefault()
+	vals := make([]float64, 0)
+	for _, row := range rows {
+		vals = append(vals, row...)
+	}
+	encoded, err := fp.EncodeVec(vals)
+	assert.NoError(t, err)
+	shares, err := data_management.CreateSharesShamirN(encoded, n, th)
+	assert.NoError(t, err)
+
+	results := make([][]*big.Int, n)
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			p, err := shamir_mpc.NewParty(i, n, th, nets[i])
+			if err != nil {
+				errs <- err
+				return
+			}
+			x := make([][]*big.Int, len(rows))
+			cols := len(rows[0])
+			for r := range x {
+				x[r] = shares[i][r*cols : (r+1)*cols]
+			}
+			results[i], err = shamir_mpc.Programs[program](p, x, params)
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	joined, err := data_management.JoinSharesShamirN(results, th)
+	assert.NoError(t, err)
+	res := make([]float64, len(joined))
+	for i, e := range joined {
+		res[i] = fp.Decode(e.Int64())
+	}
+	return res
+}
+
+func assertClose(t *testing.T, expected, actual []float64, delta float64) {
+	assert.Equal(t, len(expected), len(actual))
+	for i := range expected {
+		assert.InDelta(t, expected[i], actual[i], delta, "value %d", i)
+	}
+}
+
+func TestPrograms(t *testing.T) {
+	rows := [][]float64{{1, -2.5}, {4, 3}, {-7.25, 0.5}, {2, 10}, {0.5, -1}}
+	params := shamir_mpc.Params{}
+
+	res := run(t, "avg", rows, 3, 1, params, shamir_mpc.NewLocalNetworks(3))
+	assertClose(t, []float64{0.05, 2}, res, 1e-5)
+
+	res = run(t, "max", rows, 3, 1, params, shamir_mpc.NewLocalNetworks(3))
+	assertClose(t, []float64{4, 10}, res, 0)
+
+	// average, standard deviation, min and max of each column
+	res = run(t, "stats", rows, 3, 1, params, shamir_mpc.NewLocalNetworks(3))
+	std := func(col int, avg float64) float64 {
+		sum := 0.
+		for _, row := range rows {
+			sum += (row[col] - avg) * (row[col] - avg)
+		}
+		return math.Sqrt(sum / float64(len(rows)))
+	}
+	assertClose(t, []float64{0.05, std(0, 0.05), -7.25, 4, 2, std(1, 2), -2.5, 10}, res, 1e-4)
+
+	// 5 parties with threshold 2
+	res = run(t, "max", rows, 5, 2, params, shamir_mpc.NewLocalNetworks(5))
+	assertClose(t, []float64{4, 10}, res, 0)
+
+	fp := data_management.FixedPoint{K: 50, F: 24}
+	res = run(t, "avg", rows, 3, 1, shamir_mpc.Params{FixedPoint: fp}, shamir_mpc.NewLocalNetworks(3))
+	assertClose(t, []float64{0.05, 2}, res, 1e-6)
+}
+
+func TestKMeans(t *testing.T) {
+	rows := [][]float64{{0, 0.5}, {10, 10}, {0.5, 0}, {10.5, 9.5}, {0, 0}, {9.5, 10.5}}
+	res := run(t, "k-means", rows, 3, 1, shamir_mpc.Params{Clusters: 2}, shamir_mpc.NewLocalNetworks(3))
+	// the centers followed by the sizes, in any order of the clusters
+	assert.Equal(t, 6, len(res))
+	if res[0] > 5 {
+		res = []float64{res[2], res[3], res[0], res[1], res[5], res[4]}
+	}
+	assertClose(t, []float64{1. / 6, 1. / 6, 10, 10, 3, 3}, res, 1e-5)
+}
+
+func TestCheckParams(t *testing.T) {
+	assert.NoError(t, shamir_mpc.CheckParams("stats", 4000, 4, 3, shamir_mpc.Params{}))
+	assert.Error(t, shamir_mpc.CheckParams("linear_regression", 10, 2, 3, shamir_mpc.Params{}))
+	assert.Error(t, shamir_mpc.CheckParams("k-means", 10, 2, 3, shamir_mpc.Params{Clusters: 11}))
+	assert.Error(t, shamir_mpc.CheckParams("stats", 10, 2, 3,
+		shamir_mpc.Params{FixedPoint: data_management.FixedPoint{K: 63, F: 24}}))
+}
+
+// testCert returns a self-signed certificate and its PEM encoding.
+func testCert(t *testing.T, name string) (tls.Certificate, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// freeAddrs returns n addresses with free ports.
+func freeAddrs(t *testing.T, n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		l, err := net.Listen("tcp", "localhost:0")
+		assert.NoError(t, err)
+		addrs[i] = fmt.Sprint("localhost:", l.Addr().(*net.TCPAddr).Port)
+		assert.NoError(t, l.Close())
+	}
+	return addrs
+}
+
+func TestConnect(t *testing.T) {
+	certs := make([]tls.Certificate, 3)
+	peers := make([][]byte, 3)
+	for i := range certs {
+		certs[i], peers[i] = testCert(t, fmt.Sprint("node", i))
+	}
+	addrs := freeAddrs(t, 3)
+
+	nets := make([]shamir_mpc.Network, 3)
+	errs := make(chan error, 3)
+	for i := range nets {
+		go func(i int) {
+			var err error
+			nets[i], err = shamir_mpc.Connect(i, addrs, certs[i], peers, 10*time.Second)
+			errs <- err
+		}(i)
+	}
+	for range nets {
+		assert.NoError(t, <-errs)
+	}
+	res := run(t, "max", [][]float64{{1, 2}, {3, -4}}, 3, 1, shamir_mpc.Params{}, nets)
+	assertClose(t, []float64{3, 2}, res, 0)
+	for _, nw := range nets {
+		assert.NoError(t, nw.Close())
+	}
+
+	// a party with another certificate is refused
+	other, _ := testCert(t, "other")
+	addrs = freeAddrs(t, 2)
+	go func() {
+		nw, err := shamir_mpc.Connect(1, addrs, other, peers[:2], 2*time.Second)
+		if err == nil {
+			_ = nw.Close()
+		}
+	}()
+	_, err := shamir_mpc.Connect(0, addrs, certs[0], peers[:2], 2*time.Second)
+	assert.Error(t, err)
+}
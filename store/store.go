@@ -0,0 +1,227 @@
+// Package store is a small embedded key-value store keeping JSON records in files. Records
+// are grouped in buckets; every bucket is a folder and every record a file in it, written
+// atomically so that a crash never leaves a partially written record.
+package store
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Version is the version of the export format.
+const Version = 1
+
+type Store struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// Export is the content of a store written by Export and read by Import: for every bucket
+// the records by their keys.
+type Export struct {
+	Version int                                   `json:"version"`
+	Buckets map[string]map[string]json.RawMessage `json:"buckets"`
+}
+
+// Open opens the store in the given folder, creating it if it does not exist.
+func Open(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// fileName returns the file of the record; keys are hex encoded so that any key is a
+// valid file name.
+func (s *Store) fileName(bucket, key string) string {
+	return filepath.Join(s.dir, bucket, hex.EncodeToString([]byte(key))+".json")
+}
+
+// Put saves the value as JSON under the key in the bucket, replacing the previous one.
+func (s *Store) Put(bucket, key string, val interface{}) error {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(bucket, key, b)
+}
+
+func (s *Store) put(bucket, key string, b []byte) error {
+	err := os.MkdirAll(filepath.Join(s.dir, bucket), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Join(s.dir, bucket), ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.fileName(bucket, key))
+}
+
+// Get reads the value under the key in the bucket into val; it returns false if there
+// is no such record.
+func (s *Store) Get(bucket, key string, val interface{}) (bool, error) {
+	s.mu.Lock()
+	b, err := ioutil.ReadFile(s.fileName(bucket, key))
+	s.mu.Unlock()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(b, val)
+}
+
+// Delete removes the record under the key in the bucket if it exists.
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.fileName(bucket, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ForEach calls f with the key and the JSON value of every record in the bucket, in the
+// order of the keys, until f returns an error.
+func (s *Store) ForEach(bucket string, f func(key string, val []byte) error) error {
+	s.mu.Lock()
+	records, err := s.records(bucket)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err = f(key, records[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// records reads all the records in the bucket.
+func (s *Store) records(bucket string) (map[string]json.RawMessage, error) {
+	records := make(map[string]json.RawMessage)
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, bucket))
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		key, err := hex.DecodeString(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(s.dir, bucket, name))
+		if err != nil {
+			return nil, err
+		}
+		records[string(key)] = b
+	}
+
+	return records, nil
+}
+
+// Export writes all the records of the store to w as a single JSON document.
+func (s *Store) Export(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	exp := Export{Version: Version, Buckets: make(map[string]map[string]json.RawMessage)}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		exp.Buckets[file.Name()], err = s.records(file.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(exp)
+}
+
+// Import reads records written by Export from r and saves them in the store, replacing
+// records with the same keys.
+func (s *Store) Import(r io.Reader) error {
+	var exp Export
+	err := json.NewDecoder(r).Decode(&exp)
+	if err != nil {
+		return err
+	}
+	if exp.Version != Version {
+		return fmt.Errorf("unsupported export version %d", exp.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for bucket, records := range exp.Buckets {
+		if bucket == "" || bucket != filepath.Base(bucket) || strings.HasPrefix(bucket, ".") {
+			return fmt.Errorf("invalid bucket %q", bucket)
+		}
+		for key, val := range records {
+			if !json.Valid(val) {
+				return fmt.Errorf("invalid record %s in bucket %s", key, bucket)
+			}
+			err = s.put(bucket, key, val)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
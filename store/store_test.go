@@ -0,0 +1,90 @@
+package store_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/krakenh2020/MPCService/store"
+	"github.com/stretchr/testify/assert"
+)
+
+type record struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := store.Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put("datasets", "b/../weird name.csv", record{"b", 2}))
+	assert.NoError(t, s.Put("datasets", "a.csv", record{"a", 1}))
+	assert.NoError(t, s.Put("datasets", "a.csv", record{"a", 3}))
+
+	// the records survive reopening the store
+	s, err = store.Open(dir)
+	assert.NoError(t, err)
+	var r record
+	ok, err := s.Get("datasets", "a.csv", &r)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, record{"a", 3}, r)
+	ok, err = s.Get("datasets", "missing.csv", &r)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	keys := make([]string, 0)
+	assert.NoError(t, s.ForEach("datasets", func(key string, val []byte) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal(t, []string{"a.csv", "b/../weird name.csv"}, keys)
+
+	assert.NoError(t, s.Delete("datasets", "a.csv"))
+	assert.NoError(t, s.Delete("datasets", "a.csv"))
+	ok, err = s.Get("datasets", "a.csv", &r)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, s.ForEach("empty", func(key string, val []byte) error {
+		t.Error("record in an empty bucket")
+		return nil
+	}))
+}
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir2, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir2)
+
+	s, err := store.Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put("datasets", "a.csv", record{"a", 1}))
+	assert.NoError(t, s.Put("jobs", "0123", record{"job", 2}))
+
+	var buf bytes.Buffer
+	assert.NoError(t, s.Export(&buf))
+
+	s2, err := store.Open(dir2)
+	assert.NoError(t, err)
+	assert.NoError(t, s2.Import(&buf))
+	var r record
+	ok, err := s2.Get("jobs", "0123", &r)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, record{"job", 2}, r)
+	ok, err = s2.Get("datasets", "a.csv", &r)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Error(t, s2.Import(strings.NewReader(`{"version": 1, "buckets": {"../x": {"a": 1}}}`)))
+	assert.Error(t, s2.Import(strings.NewReader(`{"version": 2, "buckets": {}}`)))
+}
@@ -0,0 +1,406 @@
+// Package testbed runs a whole MPC service in one process for end-to-end tests: a manager,
+// MPC nodes and a data provider on ephemeral ports of localhost, with certificates of a
+// throwaway RootCA, and a local HTTP server standing in for the links of datasets. The nodes
+// evaluate the computations with a backend that needs no other software, the pure Go one
+// by default, so that whole computations run in go test without SCALE-MAMBA or network.
+package testbed
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krakenh2020/MPCService/client"
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/data_provider"
+	"github.com/krakenh2020/MPCService/key_management"
+	"github.com/krakenh2020/MPCService/manager"
+	"github.com/krakenh2020/MPCService/mpc_engine"
+	"github.com/krakenh2020/MPCService/mpc_node"
+)
+
+// Config describes the service of a testbed. The zero value runs 3 nodes with the pure Go
+// backend and a data provider without datasets.
+type Config struct {
+	Nodes    int    // number of MPC nodes, 3 if 0
+	Backend  string // backend of the nodes, mpc_engine.ShamirBackendName if empty
+	Datasets string // folder of the csv files offered by the data provider, none if empty
+	LogLevel string // level of the logs of the services, error if empty
+	Timeout  time.Duration
+}
+
+// Testbed is a running MPC service. Dir holds the certificates and the keys of the RootCA,
+// the manager, the nodes and the provider, named as in key_management/keys_certificates.
+type Testbed struct {
+	Dir        string
+	ManagerURL string // REST API of the manager, http://localhost:port
+	Nodes      []string
+	Provider   string
+	// Files serves the files added with Serve
+	Files *httptest.Server
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	stop     context.CancelFunc
+	services sync.WaitGroup
+	errs     chan error
+}
+
+// ProviderName is the name of the data provider of a testbed.
+const ProviderName = "Data_provider"
+
+// Start starts the service described by the config and waits until the nodes and the
+// datasets of the provider are registered with the manager; if a service fails to start,
+// the others are stopped and its error is returned. The services run until Close. The
+// manager keeps its state in package variables, hence the testbeds of a process must not
+// run at the same time.
+func Start(config Config) (*Testbed, error) {
+	if config.Nodes == 0 {
+		config.Nodes = 3
+	}
+	if config.Backend == "" {
+		config.Backend = mpc_engine.ShamirBackendName
+	}
+	if config.LogLevel == "" {
+		config.LogLevel = "error"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	dir, err := ioutil.TempDir("", "testbed")
+	if err != nil {
+		return nil, err
+	}
+	tb := &Testbed{Dir: dir, Provider: ProviderName, files: make(map[string][]byte), stop: func() {},
+		errs: make(chan error, 2+config.Nodes)}
+	for i := 0; i < config.Nodes; i++ {
+		tb.Nodes = append(tb.Nodes, "Node"+strconv.Itoa(i))
+	}
+	err = tb.createCertificates()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	datasets := config.Datasets
+	if datasets == "" {
+		datasets = dir + "/datasets"
+		err = os.Mkdir(datasets, 0700)
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	files, err := ioutil.ReadDir(datasets)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	tb.Files = httptest.NewServer(http.HandlerFunc(tb.serveFile))
+	err = tb.start(config, datasets)
+	if err == nil {
+		c := tb.Client()
+		err = tb.waitFor(config.Timeout, func() bool {
+			nodes, err := c.Nodes(context.Background())
+			if err != nil || len(nodes) != config.Nodes {
+				return false
+			}
+			offered, err := c.Datasets(context.Background())
+			return err == nil && len(offered) == len(files)
+		})
+		if err != nil {
+			err = fmt.Errorf("nodes and datasets not registered: %s", err)
+		}
+	}
+	if err != nil {
+		_ = tb.Close()
+		return nil, err
+	}
+
+	return tb, nil
+}
+
+// start runs the manager, waits until it accepts connections and runs the nodes and the
+// data provider.
+func (tb *Testbed) start(config Config, datasets string) error {
+	// the manager listens on ports chosen by the system; the nodes only listen to each other
+	// during computations, so their ports are chosen ahead
+	guiListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	serviceListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		_ = guiListener.Close()
+		return err
+	}
+	ports, err := freePorts(config.Nodes)
+	if err != nil {
+		_ = guiListener.Close()
+		_ = serviceListener.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.stop = cancel
+	tb.ManagerURL = "http://" + guiListener.Addr().String()
+	managerAddr := serviceListener.Addr().String()
+	tb.run("manager", func() error {
+		return manager.ServeManager(ctx, guiListener, serviceListener, "", config.LogLevel, "", tb.Dir, false, "",
+			"", "")
+	})
+	// the manager is ready once it completes a handshake with a certificate of the RootCA
+	cert, err := tls.LoadX509KeyPair(tb.Dir+"/Manager.crt", tb.Dir+"/Manager.key")
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	caCert, err := ioutil.ReadFile(tb.Dir + "/RootCA.crt")
+	if err != nil {
+		return err
+	}
+	_ = roots.AppendCertsFromPEM(caCert)
+	err = tb.waitFor(config.Timeout, func() bool {
+		conn, err := tls.Dial("tcp", managerAddr, &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots})
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("manager not listening: %s", err)
+	}
+
+	protocols := fmt.Sprintf("%s:%d:%d", data_management.ProtocolShamir, config.Nodes,
+		data_management.DefaultThreshold(config.Nodes))
+	for i, name := range tb.Nodes {
+		name, port := name, ports[i]
+		tb.run(name, func() error {
+			return mpc_node.ServeNode(ctx, name, "localhost", port, tb.Dir, "", config.LogLevel, "", managerAddr,
+				"An MPC node of a testbed.", "", protocols, config.Backend, ProviderName, false)
+		})
+	}
+	tb.run(ProviderName, func() error {
+		return data_provider.ServeDatasetProvider(ctx, ProviderName, datasets, config.LogLevel, "", managerAddr,
+			tb.Dir, tb.Nodes, "")
+	})
+
+	return nil
+}
+
+// run runs the service in the background until Close; if it fails, waitFor returns its
+// error.
+func (tb *Testbed) run(name string, serve func() error) {
+	tb.services.Add(1)
+	go func() {
+		defer tb.services.Done()
+		err := serve()
+		if err != nil {
+			tb.errs <- fmt.Errorf("%s: %s", name, err)
+		}
+	}()
+}
+
+// Client returns a client of the manager of the testbed.
+func (tb *Testbed) Client() *client.Client {
+	c := client.New(tb.ManagerURL, "")
+	c.PollInterval = 100 * time.Millisecond
+	return c
+}
+
+// Close stops the services of the testbed and waits until they return, then stops serving
+// the files and removes the certificates.
+func (tb *Testbed) Close() error {
+	tb.stop()
+	tb.services.Wait()
+	tb.Files.Close()
+	return os.RemoveAll(tb.Dir)
+}
+
+// Serve serves the content at the returned URL of Files.
+func (tb *Testbed) Serve(name string, content []byte) string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.files["/"+name] = content
+	return tb.Files.URL + "/" + name
+}
+
+func (tb *Testbed) serveFile(w http.ResponseWriter, r *http.Request) {
+	tb.mu.Lock()
+	content, ok := tb.files[r.URL.Path]
+	tb.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write(content)
+}
+
+// AddLinkDataset splits the csv file into shares for all the nodes of the testbed, endorsed
+// by the data provider, serves the share file and adds it to the manager as a dataset
+// given by a link with the name.
+func (tb *Testbed) AddLinkDataset(name, file string) error {
+	cert, certKey, err := key_management.LoadCertKey(tb.Dir, ProviderName)
+	if err != nil {
+		return err
+	}
+	pubKeys := make([][]byte, len(tb.Nodes))
+	for i, node := range tb.Nodes {
+		var err error
+		pubKeys[i], _, _, err = key_management.LoadKeysFromCertKey(tb.Dir, node)
+		if err != nil {
+			return err
+		}
+	}
+	nonce, err := data_management.NewNonce()
+	if err != nil {
+		return err
+	}
+	output := tb.Dir + "/" + name + ".shares"
+	_, _, cols, err := data_management.SplitCsvFileWith(file, output, pubKeys, data_management.ShareFileOptions{
+		DatasetId: name, DatasetVersion: "1", CommitmentNonce: nonce, NodesNames: tb.Nodes,
+		Threshold: data_management.DefaultThreshold(len(tb.Nodes)), Cert: cert, CertKey: certKey})
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(output)
+	if err != nil {
+		return err
+	}
+
+	dataset := data_provider.Dataset{Name: name, Cols: strings.Join(cols, ","),
+		SharedWith: strings.Join(tb.Nodes, ","), Link: tb.Serve(name, content), Provider: ProviderName, Version: "1"}
+	b, err := json.Marshal(dataset)
+	if err != nil {
+		return err
+	}
+	// the manager redirects to the GUI once the dataset is added
+	httpClient := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := httpClient.Post(tb.ManagerURL+"/datasets", "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		msg, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("manager returned %d: %s", response.StatusCode, msg)
+	}
+	return nil
+}
+
+// createCertificates creates the RootCA and the certificates of the manager, the nodes
+// and the provider signed by it, each named by its common name.
+func (tb *Testbed) createCertificates() error {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	ca := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "RootCA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(24 * time.Hour), IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature}
+	err = writeCertificate(tb.Dir, "RootCA", ca, ca, caKey, caKey)
+	if err != nil {
+		return err
+	}
+
+	names := append([]string{"Manager", ProviderName}, tb.Nodes...)
+	for i, name := range names {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		cert := &x509.Certificate{SerialNumber: big.NewInt(int64(i + 2)), Subject: pkix.Name{CommonName: name},
+			NotBefore: ca.NotBefore, NotAfter: ca.NotAfter, DNSNames: []string{"localhost"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}
+		err = writeCertificate(tb.Dir, name, cert, ca, key, caKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCertificate signs the certificate with the key of the parent and writes it and its
+// key as PEM files name.crt and name.key to the folder.
+func writeCertificate(dir, name string, cert, parent *x509.Certificate, key, parentKey *rsa.PrivateKey) error {
+	der, err := x509.CreateCertificate(rand.Reader, cert, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(dir+"/"+name+".crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	err = ioutil.WriteFile(dir+"/"+name+".key", keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	// the certificate must be usable by the services
+	_, err = tls.LoadX509KeyPair(dir+"/"+name+".crt", dir+"/"+name+".key")
+	return err
+}
+
+// freePorts returns n ports of localhost that are free when it returns; another process
+// may take them before they are used.
+func freePorts(n int) ([]int, error) {
+	ports := make([]int, n)
+	listeners := make([]net.Listener, n)
+	defer func() {
+		for _, l := range listeners {
+			if l != nil {
+				_ = l.Close()
+			}
+		}
+	}()
+	for i := range ports {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return nil, err
+		}
+		listeners[i] = l
+		ports[i] = l.Addr().(*net.TCPAddr).Port
+	}
+	return ports, nil
+}
+
+// waitFor calls ready until it returns true, a service of the testbed fails or the timeout
+// passes.
+func (tb *Testbed) waitFor(timeout time.Duration, ready func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !ready() {
+		select {
+		case err := <-tb.errs:
+			return err
+		default:
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout after %s", timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package testbed_test
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/krakenh2020/MPCService/manager"
+	"github.com/krakenh2020/MPCService/testbed"
+	"github.com/stretchr/testify/assert"
+)
+
+var rows = [][]float64{{63, 1.5}, {45, -2}, {52, 0.25}, {70, 3}, {38, 1}}
+
+const csvText = "age,dose\n63,1.5\n45,-2\n52,0.25\n70,3\n38,1\n"
+
+// expectedStats returns the average, standard deviation, minimum and maximum of each
+// column of rows, in the layout of the results of stats.
+func expectedStats() []float64 {
+	res := make([]float64, 0)
+	for j := range rows[0] {
+		sum, min, max := 0., math.Inf(1), math.Inf(-1)
+		for _, row := range rows {
+			sum += row[j]
+			min = math.Min(min, row[j])
+			max = math.Max(max, row[j])
+		}
+		avg := sum / float64(len(rows))
+		variance := 0.
+		for _, row := range rows {
+			variance += (row[j] - avg) * (row[j] - avg)
+		}
+		res = append(res, avg, math.Sqrt(variance/float64(len(rows))), min, max)
+	}
+	return res
+}
+
+func assertClose(t *testing.T, expected, actual []float64, delta float64) {
+	assert.Equal(t, len(expected), len(actual))
+	for i := range expected {
+		if i < len(actual) {
+			assert.InDelta(t, expected[i], actual[i], delta, "value %d", i)
+		}
+	}
+}
+
+func TestTestbed(t *testing.T) {
+	datasets := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(datasets+"/patients.csv", []byte(csvText), 0600))
+	tb, err := testbed.Start(testbed.Config{Datasets: datasets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Close()
+
+	c := tb.Client()
+	ctx := context.Background()
+	nodes, err := c.Nodes(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(nodes))
+
+	// datasets of the provider
+	stats := expectedStats()
+	res, err := c.Compute(ctx, manager.ComputationRequest{Program: "stats", DatasetNames: "patients.csv"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"age", "dose"}, res.Cols)
+	assertClose(t, stats, res.Values, 1e-4)
+	assert.Equal(t, 1, len(res.Inputs))
+	assert.Equal(t, "patients.csv", res.Inputs[0].Dataset)
+
+	res, err = c.Compute(ctx, manager.ComputationRequest{Program: "max", DatasetNames: "patients.csv",
+		NodesNames: strings.Join(tb.Nodes, ","), Params: `{"cols": "dose"}`})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dose"}, res.Cols)
+	assertClose(t, []float64{3}, res.Values, 0)
+
+	// datasets given by a link to the share file, served by the testbed
+	file := t.TempDir() + "/linked.csv"
+	assert.NoError(t, ioutil.WriteFile(file, []byte(csvText), 0600))
+	assert.NoError(t, tb.AddLinkDataset("linked.csv", file))
+	res, err = c.Compute(ctx, manager.ComputationRequest{Program: "avg", DatasetNames: "linked.csv",
+		NodesNames: strings.Join(tb.Nodes, ",")})
+	assert.NoError(t, err)
+	assertClose(t, []float64{stats[0], stats[4]}, res.Values, 1e-5)
+
+	// both datasets together
+	res, err = c.Compute(ctx, manager.ComputationRequest{Program: "k-means", DatasetNames: "patients.csv,linked.csv",
+		NodesNames: strings.Join(tb.Nodes, ","), Params: `{"cols": "age", "NUM_CLUSTERS": "1"}`})
+	assert.NoError(t, err)
+	assertClose(t, []float64{stats[0], 10}, res.Values, 1e-4)
+
+	// the programs of SCALE-MAMBA only fail
+	_, err = c.Compute(ctx, manager.ComputationRequest{Program: "linear_regression", DatasetNames: "patients.csv"})
+	assert.Error(t, err)
+
+	_, err = os.Stat(tb.Dir + "/RootCA.crt")
+	assert.NoError(t, err)
+}
+
+func TestTestbedClose(t *testing.T) {
+	tb, err := testbed.Start(testbed.Config{Nodes: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := tb.Client().Nodes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(nodes))
+	assert.NoError(t, tb.Close())
+
+	// the services are stopped, so that another testbed can start
+	_, err = http.Get(tb.ManagerURL + "/nodes")
+	assert.Error(t, err)
+	_, err = os.Stat(tb.Dir)
+	assert.True(t, os.IsNotExist(err))
+	tb, err = testbed.Start(testbed.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err = tb.Client().Nodes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(nodes))
+	assert.NoError(t, tb.Close())
+
+	// the errors of the services starting are returned
+	_, err = testbed.Start(testbed.Config{Backend: "unknown"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Node")
+}
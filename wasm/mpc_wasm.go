@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/big"
@@ -26,8 +27,13 @@ func main() {
 	<-c
 }
 
-// Generates a keypair for the MPC Nodes, only for testing
-// args pubKey, secKey, share0, share1, share2
+// Joins the encrypted shares of the results of the MPC nodes, correcting wrong shares
+// args pubKey, secKey, share0, share1, ..., optionally followed by the threshold and the
+// fixed point parameters k and f; if the threshold is missing or 0, the largest one for the
+// number of shares is used, if k and f are missing, the default ones
+// returns the joined results and the indexes of the nodes that returned wrong shares or
+// shares that cannot be decrypted, for example empty ones of nodes that failed, which are
+// left out of the reconstruction
 func JoinSharesShamir(this js.Value, args []js.Value) interface{} {
 	pubKey, err := base64.StdEncoding.DecodeString(args[0].String())
 	if err != nil {
@@ -38,19 +44,30 @@ func JoinSharesShamir(this js.Value, args []js.Value) interface{} {
 		panic("Error in JoinSharesShamir decoding secKey")
 	}
 
-	numNodes := 3
+	args, threshold, fp := numberArgs(args[2:])
+	numNodes := len(args)
+	if threshold == 0 {
+		threshold = data_management.DefaultThreshold(numNodes)
+	}
+	if data_management.CheckThreshold(numNodes, threshold) != nil {
+		panic("Error in JoinSharesShamir threshold")
+	}
 	sharesArray := make([][]*big.Int, numNodes)
 	//var encVec data_management.VecEnc
 	//var shareBytesEnc []byte
-	for i := 2; i < numNodes+2; i++ {
-		sharesArray[i-2], err = data_management.DecVec(args[i].String(), pubKey, secKey)
+	for i := 0; i < numNodes; i++ {
+		sharesArray[i], err = data_management.DecVec(args[i].String(), pubKey, secKey)
 		if err != nil {
-			fmt.Println("Error", err)
-			panic("Error in JoinSharesShamir decrypting")
+			fmt.Println("Error decrypting share", i, err)
+			sharesArray[i] = nil
 		}
 	}
 	//fmt.Println("shares", sharesArray)
-	res := data_management.JoinSharesShamirFloat(sharesArray)
+	res, cheaters, err := data_management.JoinSharesFloat(sharesArray, data_management.ProtocolShamir, threshold, fp)
+	if err != nil {
+		fmt.Println("Error", err)
+		panic("Error in JoinSharesShamir joining")
+	}
 	//fmt.Println("result", res)
 
 	ret, err := json.Marshal(res)
@@ -58,8 +75,31 @@ func JoinSharesShamir(this js.Value, args []js.Value) interface{} {
 	if err != nil {
 		panic("Error in JoinSharesShamir marshalling")
 	}
+	cheatersArray := make([]interface{}, len(cheaters))
+	for i, e := range cheaters {
+		cheatersArray[i] = e
+	}
 
-	return retString
+	return []interface{}{retString, cheatersArray}
+}
+
+// numberArgs splits the optional trailing threshold and fixed point parameters k and f
+// from the arguments.
+func numberArgs(args []js.Value) ([]js.Value, int, data_management.FixedPoint) {
+	numbers := make([]int, 0, 3)
+	for len(args) > 0 && len(numbers) < 3 && args[len(args)-1].Type() == js.TypeNumber {
+		numbers = append([]int{args[len(args)-1].Int()}, numbers...)
+		args = args[:len(args)-1]
+	}
+
+	threshold, fp := 0, data_management.DefaultFixedPoint
+	if len(numbers) > 0 {
+		threshold = numbers[0]
+	}
+	if len(numbers) == 3 {
+		fp = data_management.FixedPoint{K: numbers[1], F: numbers[2]}.OrDefault()
+	}
+	return args, threshold, fp
 }
 
 func GenerateKeypair(this js.Value, args []js.Value) interface{} {
@@ -71,44 +111,52 @@ func GenerateKeypair(this js.Value, args []js.Value) interface{} {
 }
 
 // Splits the txt into shares
-// args txt, pubKey0, pubKey1, pubKey2
+// args txt, datasetId, nodesNames, pubKey0, pubKey1, ..., optionally followed by the
+// threshold and the fixed point parameters k and f; nodesNames are comma separated
+// returns the text of a share file with the encrypted share of each node, signed with a
+// fresh key, and the hex encoded nonce of the commitment of the data, to be kept for
+// auditors
 func SplitCsvText(this js.Value, args []js.Value) interface{} {
-	numNodes := 3
+	keyArgs, threshold, fp := numberArgs(args[3:])
+	numNodes := len(keyArgs)
 	pubKeys := make([][]byte, numNodes)
 	var err error
 	for i := 0; i < numNodes; i++ {
-		pubKeys[i], err = base64.StdEncoding.DecodeString(args[1+i].String())
+		pubKeys[i], err = base64.StdEncoding.DecodeString(keyArgs[i].String())
 		if err != nil {
 			panic("Error in SplitCsvText decoding pubKey")
 		}
 	}
 
 	txt := args[0].String()
-	vec, cols, err := data_management.CsvTxtToVec(txt)
+	vec, cols, err := data_management.CsvTxtToVecFixed(txt, fp)
 	if err != nil {
+		fmt.Println("Error", err)
 		panic("Error in SplitCsvText reading")
 	}
-	shares, err := data_management.CreateSharesShamir(vec)
+	opts := data_management.ShareFileOptions{DatasetId: args[1].String(), Threshold: threshold, FixedPoint: fp}
+	if args[2].String() != "" {
+		opts.NodesNames = strings.Split(args[2].String(), ",")
+	}
+	nonce, err := data_management.NewNonce()
+	if err != nil {
+		panic("Error in SplitCsvText choosing the nonce")
+	}
+	opts.Commitment, err = data_management.CommitData(strings.NewReader(txt), nonce)
 	if err != nil {
+		panic("Error in SplitCsvText committing")
+	}
+	f, _, err := data_management.NewShareFile(vec, cols, pubKeys, opts)
+	if err != nil {
+		fmt.Println("Error", err)
 		panic("Error in SplitCsvText splitting")
 	}
-
-	encShares := make([]string, 3)
-	for i := int64(0); i < 3; i++ {
-		encShares[i], err = data_management.EncryptVec(shares[i], pubKeys[i])
-		if err != nil {
-			panic("Error in SplitCsvText encrypting")
-		}
-		//encBytes, err := json.Marshal(enc)
-		//encShares[i] = string(encBytes)
-
-		if err != nil {
-			panic("Error in SplitCsvText marshalling")
-		}
+	b, err := json.Marshal(f)
+	if err != nil {
+		panic("Error in SplitCsvText encoding")
 	}
-	colsString := strings.Join(cols, ",")
 
-	return []interface{}{encShares[0], encShares[1], encShares[2], colsString}
+	return []interface{}{string(b) + "\n", hex.EncodeToString(nonce)}
 }
 
 func VecToCsvText(this js.Value, args []js.Value) interface{} {
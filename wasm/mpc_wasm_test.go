@@ -3,12 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"strings"
 	"syscall/js"
 	"testing"
 	"time"
 
+	"github.com/krakenh2020/MPCService/data_management"
+	"github.com/krakenh2020/MPCService/key_management"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,50 +46,89 @@ func TestGenerateKeypair(t *testing.T) {
 	assert.False(t, bytes.Equal(pk, sk))
 }
 
+const csvText = "age,dose\n63,1.5\n45,-2\n"
+
+// splitArgs returns the arguments of SplitCsvText for the nodes with the public keys, with
+// threshold 1 and the fixed point parameters k = 50 and f = 20.
+func splitArgs(names string, pubKeys ...[]byte) []js.Value {
+	args := []js.Value{js.ValueOf(csvText), js.ValueOf("patients"), js.ValueOf(names)}
+	for _, pk := range pubKeys {
+		args = append(args, js.ValueOf(base64.StdEncoding.EncodeToString(pk)))
+	}
+	return append(args, js.ValueOf(1), js.ValueOf(50), js.ValueOf(20))
+}
+
 func TestSplitCsvText(t *testing.T) {
-	keypair1 := GenerateKeypair(js.Null(), []js.Value{})
-	_, pk1 := spreadTwoEncodedStrings(keypair1)
-	keypair2 := GenerateKeypair(js.Null(), []js.Value{})
-	_, pk2 := spreadTwoEncodedStrings(keypair2)
-	keypair3 := GenerateKeypair(js.Null(), []js.Value{})
-	_, pk3 := spreadTwoEncodedStrings(keypair3)
-	csvStringBytes := []byte("Test,CSV,moreTest")
-	encodedString := base64.StdEncoding.EncodeToString(csvStringBytes)
-	arguments := []js.Value{js.ValueOf(encodedString), js.ValueOf(base64.StdEncoding.EncodeToString(pk1)),
-		js.ValueOf(base64.StdEncoding.EncodeToString(pk2)), js.ValueOf(base64.StdEncoding.EncodeToString(pk3))}
-	retVal := js.ValueOf(SplitCsvText(js.Null(), arguments))
-	share1 := retVal.Index(0).String()
-	share2 := retVal.Index(1).String()
-	share3 := retVal.Index(2).String()
-	colsString := retVal.Index(3).String()
-	colsStringBytes, err := base64.StdEncoding.DecodeString(colsString)
-	if err != nil {
-		fmt.Println("error decoding colsString")
+	pubKeys := make([][]byte, 3)
+	for i := range pubKeys {
+		_, pubKeys[i] = spreadTwoEncodedStrings(GenerateKeypair(js.Null(), []js.Value{}))
+	}
+	retVal := SplitCsvText(js.Null(), splitArgs("n0,n1,n2", pubKeys...)).([]interface{})
+
+	var f data_management.ShareFile
+	assert.NoError(t, json.Unmarshal([]byte(retVal[0].(string)), &f))
+	assert.Equal(t, "patients", f.Header.DatasetId)
+	assert.Equal(t, []string{"age", "dose"}, f.Header.Cols)
+	assert.Equal(t, 2, f.Header.Rows)
+	assert.Equal(t, 3, len(f.Header.Nodes))
+	for i, node := range f.Header.Nodes {
+		assert.Equal(t, fmt.Sprintf("n%d", i), node.Name)
+		assert.Equal(t, key_management.Fingerprint(pubKeys[i]), node.KeyFingerprint)
 	}
-	assert.True(t, len(share1) > 0)
-	assert.True(t, len(share2) > 0)
-	assert.True(t, len(share3) > 0)
-	assert.True(t, bytes.Equal(colsStringBytes, csvStringBytes))
+	assert.Equal(t, data_management.Sharing{Protocol: data_management.ProtocolShamir, Parties: 3, Threshold: 1},
+		f.Header.Sharing)
+	assert.Equal(t, data_management.FixedPoint{K: 50, F: 20}, f.Header.FixedPoint)
+	assert.Equal(t, 3, len(f.Shares))
+
+	// the commitment is salted with the returned nonce
+	nonce, err := hex.DecodeString(retVal[1].(string))
+	assert.NoError(t, err)
+	commitment, err := data_management.CommitData(strings.NewReader(csvText), nonce)
+	assert.NoError(t, err)
+	assert.Equal(t, commitment, f.Header.Commitment)
 }
 
 func TestJoinSharesShamir(t *testing.T) {
-	keypair1 := GenerateKeypair(js.Null(), []js.Value{})
-	sk1, pk1 := spreadTwoEncodedStrings(keypair1)
-	csvStringBytes := []byte("Test,CSV,moreTest")
-	encodedString := base64.StdEncoding.EncodeToString(csvStringBytes)
-	arguments := []js.Value{js.ValueOf(encodedString), js.ValueOf(base64.StdEncoding.EncodeToString(pk1)),
-		js.ValueOf(base64.StdEncoding.EncodeToString(pk1)), js.ValueOf(base64.StdEncoding.EncodeToString(pk1))}
-	retVal := js.ValueOf(SplitCsvText(js.Null(), arguments))
-	share1 := retVal.Index(0).String()
-	share2 := retVal.Index(1).String()
-	share3 := retVal.Index(2).String()
-
-	arguments = []js.Value{js.ValueOf(base64.StdEncoding.EncodeToString(pk1)), js.ValueOf(base64.StdEncoding.EncodeToString(sk1)),
-		js.ValueOf(share1), js.ValueOf(share2), js.ValueOf(share3)}
-
-	_ = JoinSharesShamir(js.Null(), arguments)
-	//fmt.Println(js.ValueOf(joinedShares).Get("length").Int())
+	sk, pk := spreadTwoEncodedStrings(GenerateKeypair(js.Null(), []js.Value{}))
+	retVal := SplitCsvText(js.Null(), splitArgs("n0,n1,n2,n3", pk, pk, pk, pk)).([]interface{})
+	var f data_management.ShareFile
+	assert.NoError(t, json.Unmarshal([]byte(retVal[0].(string)), &f))
+
+	join := func(shares []string) ([]float64, []interface{}) {
+		args := []js.Value{js.ValueOf(base64.StdEncoding.EncodeToString(pk)),
+			js.ValueOf(base64.StdEncoding.EncodeToString(sk))}
+		for _, share := range shares {
+			args = append(args, js.ValueOf(share))
+		}
+		args = append(args, js.ValueOf(1), js.ValueOf(50), js.ValueOf(20))
+		ret := JoinSharesShamir(js.Null(), args).([]interface{})
+		b, err := base64.StdEncoding.DecodeString(ret[0].(string))
+		assert.NoError(t, err)
+		var res []float64
+		assert.NoError(t, json.Unmarshal(b, &res))
+		return res, ret[1].([]interface{})
+	}
+	expected := []float64{63, 1.5, 45, -2}
+	res, cheaters := join(f.Shares)
+	assert.InDeltaSlice(t, expected, res, 1e-5)
+	assert.Equal(t, []interface{}{}, cheaters)
 
+	// with 4 nodes and threshold 1, a wrong share is corrected and its node reported
+	wrong, err := data_management.DecVec(f.Shares[2], pk, sk)
+	assert.NoError(t, err)
+	wrong[0] = new(big.Int).Add(wrong[0], big.NewInt(1))
+	f.Shares[2], err = data_management.EncryptVec(wrong, pk)
+	assert.NoError(t, err)
+	res, cheaters = join(f.Shares)
+	assert.InDeltaSlice(t, expected, res, 1e-5)
+	assert.Equal(t, []interface{}{2}, cheaters)
+
+	// the empty share of a node that failed is left out, leaving 3 shares that still
+	// reconstruct the results
+	f.Shares[2] = ""
+	res, cheaters = join(f.Shares)
+	assert.InDeltaSlice(t, expected, res, 1e-5)
+	assert.Equal(t, []interface{}{2}, cheaters)
 }
 
 func arrayToJs(input []byte) interface{} {